@@ -0,0 +1,478 @@
+// mirror-bench is a load generator for tf-mirror
+//
+// It simulates concurrent `terraform init` runs against a running mirror: every init
+// requests index.json, {version}.json and one archive of each provider in the matrix.
+// Latency percentiles are reported per request kind, and the upstream requests the
+// mirror made during the run are read from GET /admin/upstream.
+//
+//	mirror-bench -mirror http://localhost:8080 -providers hashicorp/aws,hashicorp/random@3.6.0 -concurrency 50 -inits 500
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// Request kinds of a simulated init
+const (
+	kindIndex   = "index.json"
+	kindVersion = "{version}.json"
+	kindArchive = "archive"
+	kindInit    = "init"
+)
+
+var kinds = []string{kindIndex, kindVersion, kindArchive, kindInit}
+
+// provider is one entry of the provider matrix; an empty version is resolved to the latest
+type provider struct {
+	namespace string
+	name      string
+	version   string
+}
+
+// options are the command line settings of a run
+type options struct {
+	mirror      string
+	hostname    string
+	providers   []provider
+	platforms   []string
+	concurrency int
+	inits       int
+	archives    bool
+	token       string
+	adminURL    string
+	adminToken  string
+	timeout     time.Duration
+	jsonOutput  bool
+}
+
+// result is the outcome of one request or init
+type result struct {
+	kind    string
+	latency time.Duration
+	bytes   int64
+	err     error
+}
+
+// summary is the report of one request kind
+type summary struct {
+	Kind     string  `json:"kind"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Bytes    int64   `json:"bytes"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+// report is the outcome of a run, printed as a table or JSON
+type report struct {
+	Inits            int            `json:"inits"`
+	Concurrency      int            `json:"concurrency"`
+	DurationSeconds  float64        `json:"duration_seconds"`
+	InitsPerSecond   float64        `json:"inits_per_second"`
+	Requests         []summary      `json:"requests"`
+	UpstreamRequests map[string]int `json:"upstream_requests,omitempty"` // host -> requests during the run
+	FirstErrors      []string       `json:"first_errors,omitempty"`
+}
+
+// maxReportedErrors is how many distinct errors are listed in the report
+const maxReportedErrors = 5
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+func run(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("mirror-bench", flag.ContinueOnError)
+	mirror := fs.String("mirror", "http://localhost:8080", "mirror URL, including TF_MIRROR_BASE_PATH")
+	hostname := fs.String("hostname", "registry.terraform.io", "registry hostname in mirror paths")
+	providers := fs.String("providers", "hashicorp/random,hashicorp/null", "comma-separated providers, namespace/name[@version]")
+	platforms := fs.String("platforms", "linux_amd64", "comma-separated platforms, assigned to inits in turn")
+	concurrency := fs.Int("concurrency", 10, "concurrent inits")
+	inits := fs.Int("inits", 100, "total inits")
+	archives := fs.Bool("archives", true, "download archives (false requests the JSON documents only)")
+	token := fs.String("token", os.Getenv("TF_MIRROR_BENCH_TOKEN"), "bearer token for mirror requests (default $TF_MIRROR_BENCH_TOKEN)")
+	adminURL := fs.String("admin-url", "", "URL of the admin API for upstream request counts (default: -mirror)")
+	adminToken := fs.String("admin-token", os.Getenv("TF_MIRROR_ADMIN_TOKEN"), "admin token (default $TF_MIRROR_ADMIN_TOKEN)")
+	timeout := fs.Duration("timeout", 5*time.Minute, "limit for a single request")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := options{
+		mirror:      strings.TrimSuffix(*mirror, "/"),
+		hostname:    *hostname,
+		platforms:   splitList(*platforms),
+		concurrency: *concurrency,
+		inits:       *inits,
+		archives:    *archives,
+		token:       *token,
+		adminURL:    strings.TrimSuffix(*adminURL, "/"),
+		adminToken:  *adminToken,
+		timeout:     *timeout,
+		jsonOutput:  *jsonOutput,
+	}
+	if opts.adminURL == "" {
+		opts.adminURL = opts.mirror
+	}
+	for _, p := range splitList(*providers) {
+		parsed, err := parseProvider(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 2
+		}
+		opts.providers = append(opts.providers, parsed)
+	}
+	if len(opts.providers) == 0 || len(opts.platforms) == 0 || opts.concurrency < 1 || opts.inits < 1 {
+		fmt.Fprintln(os.Stderr, "error: -providers, -platforms, -concurrency and -inits must not be empty or zero")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// An interrupted run still reports the requests made so far
+	rep := bench(ctx, opts)
+
+	if opts.jsonOutput {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+	} else {
+		printReport(stdout, rep)
+	}
+
+	for _, s := range rep.Requests {
+		if s.Errors > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// bench runs the inits and collects the report
+func bench(ctx context.Context, opts options) *report {
+	client := &http.Client{
+		Timeout: opts.timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: opts.concurrency,
+		},
+	}
+
+	before, upstreamErr := upstreamRequests(ctx, client, opts)
+	if upstreamErr != nil {
+		fmt.Fprintln(os.Stderr, "warning: upstream request counts unavailable:", upstreamErr)
+	}
+
+	results := make(chan result, opts.concurrency*4)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				simulateInit(ctx, client, opts, opts.platforms[n%len(opts.platforms)], results)
+			}
+		}()
+	}
+
+	start := time.Now()
+	go func() {
+		defer close(jobs)
+		for n := 0; n < opts.inits; n++ {
+			select {
+			case jobs <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	byKind := make(map[string][]result)
+	var firstErrors []string
+	seen := make(map[string]bool)
+	for res := range results {
+		byKind[res.kind] = append(byKind[res.kind], res)
+		if res.err != nil && !seen[res.err.Error()] && len(firstErrors) < maxReportedErrors {
+			seen[res.err.Error()] = true
+			firstErrors = append(firstErrors, res.err.Error())
+		}
+	}
+	elapsed := time.Since(start)
+
+	rep := &report{
+		Inits:           len(byKind[kindInit]),
+		Concurrency:     opts.concurrency,
+		DurationSeconds: elapsed.Seconds(),
+		InitsPerSecond:  float64(len(byKind[kindInit])) / elapsed.Seconds(),
+		FirstErrors:     firstErrors,
+	}
+	for _, kind := range kinds {
+		if len(byKind[kind]) > 0 {
+			rep.Requests = append(rep.Requests, summarize(kind, byKind[kind]))
+		}
+	}
+
+	if upstreamErr == nil {
+		after, err := upstreamRequests(context.WithoutCancel(ctx), client, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "warning: upstream request counts unavailable:", err)
+		} else {
+			rep.UpstreamRequests = make(map[string]int)
+			for host, n := range after {
+				if d := n - before[host]; d > 0 {
+					rep.UpstreamRequests[host] = d
+				}
+			}
+		}
+	}
+	return rep
+}
+
+// simulateInit requests what `terraform init` requests from a network mirror for every provider
+func simulateInit(ctx context.Context, client *http.Client, opts options, platform string, results chan<- result) {
+	start := time.Now()
+	var initErr error
+	for _, p := range opts.providers {
+		if err := installProvider(ctx, client, opts, p, platform, results); err != nil {
+			initErr = err
+			break
+		}
+	}
+	results <- result{kind: kindInit, latency: time.Since(start), err: initErr}
+}
+
+func installProvider(ctx context.Context, client *http.Client, opts options, p provider, platform string, results chan<- result) error {
+	base := opts.mirror + "/v1/providers/" + opts.hostname + "/" + p.namespace + "/" + p.name + "/"
+
+	var index struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err := fetch(ctx, client, opts, kindIndex, base+"index.json", &index, results); err != nil {
+		return err
+	}
+	version := p.version
+	if version == "" {
+		version = latest(index.Versions)
+	}
+	if _, ok := index.Versions[version]; !ok {
+		return fmt.Errorf("%s/%s: version %q not in index.json", p.namespace, p.name, version)
+	}
+
+	versionURL := base + version + ".json"
+	var doc struct {
+		Archives map[string]struct {
+			URL string `json:"url"`
+		} `json:"archives"`
+	}
+	if err := fetch(ctx, client, opts, kindVersion, versionURL, &doc, results); err != nil {
+		return err
+	}
+	if !opts.archives {
+		return nil
+	}
+	archive, ok := doc.Archives[platform]
+	if !ok {
+		return fmt.Errorf("%s/%s %s: no archive for %s", p.namespace, p.name, version, platform)
+	}
+
+	// Archive URLs are relative to {version}.json unless the mirror has an external URL
+	ref, err := url.Parse(archive.URL)
+	if err != nil {
+		return err
+	}
+	u, _ := url.Parse(versionURL)
+	return fetch(ctx, client, opts, kindArchive, u.ResolveReference(ref).String(), nil, results)
+}
+
+// fetch requests a mirror URL, decodes JSON into v (or discards the body when nil) and reports the result
+func fetch(ctx context.Context, client *http.Client, opts options, kind, rawURL string, v any, results chan<- result) error {
+	start := time.Now()
+	n, err := get(ctx, client, rawURL, opts.token, v)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", rawURL, err)
+	}
+	results <- result{kind: kind, latency: time.Since(start), bytes: n, err: err}
+	return err
+}
+
+func get(ctx context.Context, client *http.Client, rawURL, token string, v any) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		n, _ := io.Copy(io.Discard, resp.Body)
+		return n, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if v == nil {
+		return io.Copy(io.Discard, resp.Body)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	return int64(len(data)), json.Unmarshal(data, v)
+}
+
+// upstreamRequests returns the requests the mirror has made per upstream host (GET /admin/upstream)
+func upstreamRequests(ctx context.Context, client *http.Client, opts options) (map[string]int, error) {
+	var status struct {
+		Hosts []struct {
+			Host     string `json:"host"`
+			Requests int    `json:"requests"`
+		} `json:"hosts"`
+	}
+	if _, err := get(ctx, client, opts.adminURL+"/admin/upstream", opts.adminToken, &status); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, h := range status.Hosts {
+		counts[h.Host] = h.Requests
+	}
+	return counts, nil
+}
+
+// summarize computes the latency percentiles of one request kind
+func summarize(kind string, results []result) summary {
+	s := summary{Kind: kind, Requests: len(results)}
+	var latencies []time.Duration
+	for _, r := range results {
+		s.Bytes += r.bytes
+		if r.err != nil {
+			s.Errors++
+			continue
+		}
+		latencies = append(latencies, r.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s.P50Ms = percentile(latencies, 50)
+	s.P90Ms = percentile(latencies, 90)
+	s.P99Ms = percentile(latencies, 99)
+	s.MaxMs = percentile(latencies, 100)
+	return s
+}
+
+// percentile returns the p-th percentile of sorted latencies in milliseconds (nearest rank)
+func percentile(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
+
+func printReport(w io.Writer, rep *report) {
+	fmt.Fprintf(w, "%d inits, concurrency %d, %.1fs (%.1f inits/s)\n\n", rep.Inits, rep.Concurrency, rep.DurationSeconds, rep.InitsPerSecond)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "request\trequests\terrors\tp50 ms\tp90 ms\tp99 ms\tmax ms\tMiB\t")
+	for _, s := range rep.Requests {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n", s.Kind, s.Requests, s.Errors, s.P50Ms, s.P90Ms, s.P99Ms, s.MaxMs, float64(s.Bytes)/(1<<20))
+	}
+	tw.Flush()
+
+	if rep.UpstreamRequests != nil {
+		fmt.Fprintln(w, "\nupstream requests:")
+		hosts := make([]string, 0, len(rep.UpstreamRequests))
+		for host := range rep.UpstreamRequests {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			fmt.Fprintf(w, "  %s\t%d\n", host, rep.UpstreamRequests[host])
+		}
+		if len(hosts) == 0 {
+			fmt.Fprintln(w, "  none")
+		}
+	}
+
+	if len(rep.FirstErrors) > 0 {
+		fmt.Fprintln(w, "\nerrors:")
+		for _, e := range rep.FirstErrors {
+			fmt.Fprintln(w, "  "+e)
+		}
+	}
+}
+
+// parseProvider parses namespace/name[@version]
+func parseProvider(s string) (provider, error) {
+	source, version, _ := strings.Cut(s, "@")
+	namespace, name, ok := strings.Cut(source, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return provider{}, errors.New("invalid provider " + s + ": expected namespace/name[@version]")
+	}
+	return provider{namespace: namespace, name: name, version: version}, nil
+}
+
+// latest returns the highest release version of index.json (or the highest pre-release without one)
+func latest(versions map[string]json.RawMessage) string {
+	var best, bestPre string
+	for v := range versions {
+		if !semver.IsValid("v" + v) {
+			continue
+		}
+		if semver.Prerelease("v"+v) != "" {
+			if bestPre == "" || semver.Compare("v"+v, "v"+bestPre) > 0 {
+				bestPre = v
+			}
+			continue
+		}
+		if best == "" || semver.Compare("v"+v, "v"+best) > 0 {
+			best = v
+		}
+	}
+	if best == "" {
+		return bestPre
+	}
+	return best
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,232 @@
+// terraform-credentials-mirror is a Terraform credentials helper for tf-mirror
+//
+// It stores tokens received from `terraform login` and refreshes mirror-issued
+// tokens before they expire, so no long-lived token has to be written into CLI configs.
+//
+//	credentials_helper "mirror" {
+//	  args = []
+//	}
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
+)
+
+// refreshTimeout limits discovery and refresh requests so Terraform is never blocked for long
+const refreshTimeout = 10 * time.Second
+
+// credentials is the stored credentials object of one host, as passed by Terraform
+type credentials map[string]any
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) int {
+	fs := flag.NewFlagSet("terraform-credentials-mirror", flag.ContinueOnError)
+	file := fs.String("file", defaultFile(), "credentials file")
+	plainHTTP := fs.Bool("http", false, "reach the mirror over plain HTTP (development only)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: terraform-credentials-mirror [-file path] [-http] get|store|forget HOST")
+		return 2
+	}
+	command, host := fs.Arg(0), fs.Arg(1)
+
+	store, err := load(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	switch command {
+	case "get":
+		creds := store[host]
+		if creds == nil {
+			creds = credentials{}
+		} else if refreshed := refresh(host, creds, *plainHTTP); refreshed != nil {
+			creds = refreshed
+			store[host] = creds
+			if err := save(*file, store); err != nil {
+				fmt.Fprintln(os.Stderr, "warning: saving refreshed token:", err)
+			}
+		}
+		return writeJSON(stdout, creds)
+
+	case "store":
+		var creds credentials
+		if err := json.NewDecoder(stdin).Decode(&creds); err != nil {
+			fmt.Fprintln(os.Stderr, "error: reading credentials:", err)
+			return 1
+		}
+		store[host] = creds
+	case "forget":
+		delete(store, host)
+	default:
+		fmt.Fprintln(os.Stderr, "error: unknown command", command)
+		return 2
+	}
+
+	if err := save(*file, store); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// refresh returns new credentials when a mirror token is past half of its lifetime
+// Failures are reported on stderr and the stored token is used as it is
+func refresh(host string, creds credentials, plainHTTP bool) credentials {
+	current, _ := creds["token"].(string)
+	claims, err := token.Parse(current)
+	if err != nil {
+		return nil
+	}
+	lifetime := claims.ExpiresAt.Sub(claims.IssuedAt)
+	if time.Until(claims.ExpiresAt) > lifetime/2 {
+		return nil
+	}
+
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+	client := &http.Client{Timeout: refreshTimeout}
+
+	endpoint, err := tokensEndpoint(client, scheme+"://"+host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: token refresh:", err)
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+"refresh", nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Authorization", "Bearer "+current)
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: token refresh:", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "warning: token refresh: status", resp.StatusCode)
+		return nil
+	}
+
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil || issued.Token == "" {
+		fmt.Fprintln(os.Stderr, "warning: token refresh: invalid response")
+		return nil
+	}
+
+	refreshed := credentials{}
+	for k, v := range creds {
+		refreshed[k] = v
+	}
+	refreshed["token"] = issued.Token
+	return refreshed
+}
+
+// tokensEndpoint reads tf-mirror.tokens.v1 from the mirror's service discovery document
+func tokensEndpoint(client *http.Client, base string) (string, error) {
+	discoveryURL := base + "/.well-known/terraform.json"
+	resp, err := client.Get(discoveryURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("service discovery: status %d", resp.StatusCode)
+	}
+
+	var services map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&services); err != nil {
+		return "", fmt.Errorf("service discovery: %w", err)
+	}
+	endpoint, ok := services["tf-mirror.tokens.v1"].(string)
+	if !ok {
+		return "", errors.New("mirror does not issue tokens")
+	}
+
+	// Relative URLs are resolved against the discovery document
+	ref, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u, _ := url.Parse(discoveryURL)
+	return u.ResolveReference(ref).String(), nil
+}
+
+// defaultFile is ~/.terraform.d/tf-mirror-credentials.json
+func defaultFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "tf-mirror-credentials.json"
+	}
+	return filepath.Join(home, ".terraform.d", "tf-mirror-credentials.json")
+}
+
+func load(path string) (map[string]credentials, error) {
+	store := make(map[string]credentials)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// save writes the credentials file readable by the owner only
+func save(path string, store map[string]credentials) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeJSON(w io.Writer, v any) int {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
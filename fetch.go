@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/config"
+	"github.com/scinfra-pro/terraform-mirror/internal/fetcher"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/prefetch"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/scan"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// runFetch implements `tf-mirror fetch`:
+// downloads provider archives into the cache directory ahead of time
+func runFetch(args []string) int {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	file := fs.String("file", cfg.PrefetchFile, "read providers from this file (one per line, or a terraform-bundle or required_providers manifest ending in .hcl or .tf)")
+	cacheDir := fs.String("cache-dir", cfg.CacheDir, "cache directory to fill")
+	concurrency := fs.Int("concurrency", cfg.FetchConcurrency, "parallel downloads")
+	retries := fs.Int("retries", cfg.FetchRetries, "retries per archive")
+	var platforms stringList
+	fs.Var(&platforms, "platform", "target platform, e.g. linux_amd64 (repeatable; default: TF_MIRROR_PREFETCH_PLATFORMS or all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tf-mirror fetch [flags] [[hostname/]namespace/type[@version]...]")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(platforms) == 0 {
+		platforms = cfg.PrefetchPlatforms
+	}
+
+	var entries []prefetch.Entry
+	if *file != "" {
+		fromFile, err := prefetch.ParseFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		entries = append(entries, fromFile...)
+	}
+	for _, arg := range fs.Args() {
+		e, err := prefetch.ParseEntry(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 2
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	client, err := upstream.New(upstream.Options{
+		BaseURL:          cfg.UpstreamURL,
+		Timeout:          cfg.UpstreamTimeout,
+		DownloadTimeout:  cfg.DownloadTimeout,
+		SOCKS5Addr:       cfg.SOCKS5Addr,
+		IPFamily:         cfg.UpstreamIPFamily,
+		DialTimeout:      cfg.UpstreamDialTimeout,
+		FallbackDelay:    cfg.UpstreamFallback,
+		MaxConns:         cfg.UpstreamMaxConns,
+		MaxIdleConns:     cfg.UpstreamMaxIdle,
+		TLSSessions:      cfg.UpstreamTLSSessions,
+		HTTP2:            cfg.UpstreamHTTP2,
+		DownloadHosts:    cfg.DownloadAllowedHosts,
+		UserAgent:        cfg.UserAgent,
+		Headers:          cfg.UpstreamHeaders,
+		Token:            cfg.UpstreamToken,
+		Username:         cfg.UpstreamUsername,
+		Password:         cfg.UpstreamPassword,
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+		RateLimit:        cfg.UpstreamRateLimit,
+		RateBurst:        cfg.UpstreamRateBurst,
+		RateLimitWait:    cfg.UpstreamRateWait,
+		RecordDir:        cfg.UpstreamRecordDir,
+		ReplayDir:        cfg.UpstreamReplayDir,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	cache.SetFsync(cfg.CacheFsync)
+	hashCache := cache.NewHashCache(*cacheDir)
+	defer cache.CloseMetadata(*cacheDir)
+	archiveCache := cache.NewArchiveCache(*cacheDir)
+	archiveCache.SetLimits(cfg.CacheMaxSize, cfg.NamespaceQuotas)
+	archiveCache.SetMinFree(cfg.CacheMinFree)
+	if cfg.CacheEncryptionKey != "" || cfg.CacheKeyCommand != "" {
+		key, err := cache.LoadEncryptionKey(cfg.CacheEncryptionKey, cfg.CacheKeyCommand)
+		if err == nil {
+			err = archiveCache.SetEncryption(key, cfg.CacheEncryption, logger)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+	}
+	reg := registry.New(client, hashCache, cache.NewArtifactCache(*cacheDir), cfg.ProviderAliases, logger)
+	if err := reg.UseUpstreamType(cfg.UpstreamType, cfg.UpstreamRepo); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	reg.UseGitHubReleases(cfg.GitHubAPIURL, cfg.GitHubToken, cfg.GitHubProviders)
+	if err := reg.UseOCIRepositories(cfg.OCIProviders, cfg.OCIUsername, cfg.OCIPassword); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	reg.SetDownloadCacheTTL(cfg.DownloadURLTTL)
+	reg.SetNormalized(cfg.NormalizeArchives)
+	hash.SetConcurrency(cfg.HashConcurrency)
+
+	// Archives added to the cache are scanned as by the server, with the same quarantine
+	var scanner *scan.Scanner
+	var scans *scan.Store
+	if cfg.ScanCommand != "" || cfg.ScanURL != "" {
+		scanner, err = scan.New(scan.Options{
+			Command: strings.Fields(cfg.ScanCommand),
+			URL:     cfg.ScanURL,
+			Timeout: cfg.ScanTimeout,
+			TempDir: cfg.TmpDir,
+		})
+		if err == nil {
+			scans, err = scan.NewStore(filepath.Join(*cacheDir, "scans"))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+	}
+	f := fetcher.New(client, reg, hashCache, archiveCache, fetcher.Options{
+		Concurrency:      *concurrency,
+		Retries:          *retries,
+		SpoolDir:         cfg.TmpDir,
+		SpoolMemoryLimit: cfg.SpoolMemoryLimit,
+		SpoolMinFree:     cfg.TmpMinFree,
+		RequireHash:      cfg.RequireHash,
+		CheckArchives:    cfg.CheckArchives,
+		JobTimeout:       cfg.DownloadTimeout,
+		Scanner:          scanner,
+		ScanResults:      scans,
+	}, logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var invalid int
+	jobs, err := prefetch.Jobs(ctx, reg, entries, platforms, func(e prefetch.Entry, err error) {
+		invalid++
+		fmt.Printf("skipped  %s: %v\n", e, err)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	start := time.Now()
+	var fetched, skipped, failed int
+	f.Run(ctx, jobs, func(r fetcher.Result) {
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Printf("failed   %s: %v\n", r.Job, r.Err)
+		case r.Skipped:
+			skipped++
+			fmt.Printf("cached   %s\n", r.Job)
+		default:
+			fetched++
+			fmt.Printf("fetched  %s\n", r.Job)
+		}
+	})
+
+	// The fetcher enforces limits in the background, which would not run before exit
+	if _, err := archiveCache.Enforce(); err != nil {
+		fmt.Fprintln(os.Stderr, "error: enforcing cache limits:", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d archives: %d fetched, %d already cached, %d failed in %s\n",
+		len(jobs), fetched, skipped, failed, time.Since(start).Round(time.Second))
+
+	if failed > 0 || invalid > 0 {
+		return 1
+	}
+	return 0
+}
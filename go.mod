@@ -5,6 +5,13 @@ go 1.22.0
 toolchain go1.22.2
 
 require (
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/mod v0.21.0
 	golang.org/x/net v0.33.0
+	golang.org/x/sync v0.10.0
+)
+
+require (
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
@@ -0,0 +1,75 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version, Commit and Date describe the build, set at build time:
+// go build -ldflags "-X github.com/scinfra-pro/terraform-mirror/internal/buildinfo.Version=v1.2.3
+// -X github.com/scinfra-pro/terraform-mirror/internal/buildinfo.Commit=$(git rev-parse HEAD)
+// -X github.com/scinfra-pro/terraform-mirror/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+// Commit and Date fall back to the VCS stamp of the Go toolchain when not set
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if info.Commit != "" && info.Date != "" {
+		return info
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	modified := false
+	for _, s := range build.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && Commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+// ShortCommit returns the first 12 characters of the commit ("" when unknown)
+func (i Info) ShortCommit() string {
+	if len(i.Commit) > 12 {
+		return i.Commit[:12]
+	}
+	return i.Commit
+}
+
+// UserAgent returns the default User-Agent sent to upstream,
+// e.g. "terraform-mirror/v1.2.3 (commit 0123456789ab; go1.22.5)"
+func UserAgent() string {
+	info := Get()
+	if commit := info.ShortCommit(); commit != "" {
+		return "terraform-mirror/" + info.Version + " (commit " + commit + "; " + info.GoVersion + ")"
+	}
+	return "terraform-mirror/" + info.Version + " (" + info.GoVersion + ")"
+}
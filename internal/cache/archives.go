@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ArchiveCache stores provider ZIP archives in files
+type ArchiveCache struct {
+	baseDir string
+
+	// Limits enforced by Enforce (see quota.go); enforcePending is set while a
+	// ScheduleEnforce run is waiting
+	mu             sync.Mutex
+	maxSize        int64
+	quotas         map[string]int64
+	enforcePending atomic.Bool
+
+	// Archive owners and tenant quotas (see owners.go); db is nil without tenants
+	db           *metadataDB
+	tenantQuotas map[string]int64
+
+	// Free space kept on the cache volume (see space.go)
+	minFree atomic.Int64
+
+	// Shared object store tier (see tiers.go); nil keeps archives on local disk only
+	remote     ObjectStore
+	logger     *slog.Logger
+	promotions singleflight.Group
+
+	// Encryption at rest (see encryption.go); nil stores archives as downloaded
+	enc *encryption
+}
+
+// NewArchiveCache creates a new archive cache
+func NewArchiveCache(baseDir string) *ArchiveCache {
+	return &ArchiveCache{baseDir: baseDir}
+}
+
+// keyToPath converts key to file path
+// Key: "hashicorp/random/3.6.0/terraform-provider-random_3.6.0_linux_amd64.zip"
+// Path: cache/archives/hashicorp/random/3.6.0/terraform-provider-random_3.6.0_linux_amd64.zip
+func (c *ArchiveCache) keyToPath(namespace, name, version, filename string) string {
+	return filepath.Join(c.baseDir, "archives", namespace, name, version, filename)
+}
+
+// archiveKey returns the cache key of an archive, e.g.
+// "hashicorp/random/3.6.0/terraform-provider-random_3.6.0_linux_amd64.zip"
+func archiveKey(namespace, name, version, filename string) string {
+	return namespace + "/" + name + "/" + version + "/" + filename
+}
+
+// Open returns a cached archive and its size
+// An archive only in the object store is promoted to local disk first; an encrypted
+// archive is authenticated completely, and one that fails to decrypt counts as missing
+// The caller must close the file
+func (c *ArchiveCache) Open(namespace, name, version, filename string) (ArchiveFile, int64, bool) {
+	path := c.keyToPath(namespace, name, version, filename)
+	f, size, err := c.openFile(namespace, name, version, filename)
+	if errors.Is(err, fs.ErrNotExist) && c.remote != nil && c.promote(namespace, name, version, filename) {
+		f, size, err = c.openFile(namespace, name, version, filename)
+	}
+	if err == nil {
+		if err = authenticate(f); err != nil {
+			f.Close()
+		}
+	}
+	if (errors.Is(err, ErrEncrypted) || errors.Is(err, ErrCorrupt)) && c.logger != nil {
+		c.logger.Warn("cached archive cannot be decrypted, treating it as missing", "error", err)
+	}
+	if err != nil {
+		return nil, 0, false
+	}
+
+	// Mark as recently used for eviction
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return f, size, true
+}
+
+// Has reports whether an archive is cached locally or in the object store
+func (c *ArchiveCache) Has(namespace, name, version, filename string) bool {
+	if _, err := os.Stat(c.keyToPath(namespace, name, version, filename)); err == nil {
+		return true
+	}
+	return c.remote != nil && c.remoteHas(namespace, name, version, filename)
+}
+
+// OpenLocal returns an archive cached on local disk and its size, without promoting it
+// from the object store or marking it as used
+// The caller must close the file
+func (c *ArchiveCache) OpenLocal(namespace, name, version, filename string) (ArchiveFile, int64, error) {
+	return c.openFile(namespace, name, version, filename)
+}
+
+// HasVersion reports whether any archive of a provider version is cached locally or in the object store
+func (c *ArchiveCache) HasVersion(namespace, name, version string) bool {
+	entries, _ := os.ReadDir(c.keyToPath(namespace, name, version, ""))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".zip") {
+			return true
+		}
+	}
+	return c.remote != nil && c.remoteHasVersion(namespace, name, version)
+}
+
+// Set saves an archive to cache, encrypted if its namespace is (see SetEncryption)
+// Data is written to a temporary file and renamed, so readers never see partial archives
+// Returns ErrLowSpace without writing when the cache volume is below its minimum free space
+// With an object store the archive is uploaded before Set returns; a failed upload is
+// logged and leaves the archive cached locally
+func (c *ArchiveCache) Set(namespace, name, version, filename string, r io.Reader) error {
+	if c.LowSpace() {
+		return ErrLowSpace
+	}
+	if c.encrypts(namespace) {
+		encrypted, err := c.enc.encrypt(r, archiveKey(namespace, name, version, filename))
+		if err != nil {
+			return err
+		}
+		r = encrypted
+	}
+	if err := writeFile(c.keyToPath(namespace, name, version, filename), r); err != nil {
+		return err
+	}
+	if c.remote != nil {
+		if err := c.upload(namespace, name, version, filename); err != nil {
+			c.logger.Error("failed to upload archive to object store", "key", objectKey(namespace, name, version, filename), "error", err)
+		}
+	}
+	return nil
+}
+
+// ArchiveInfo describes a cached archive; Size is that of the archive as served
+// LastUsed is updated whenever the archive is served from the cache
+type ArchiveInfo struct {
+	Namespace string
+	Name      string
+	Version   string
+	Filename  string
+	Size      int64
+	LastUsed  time.Time
+}
+
+// List returns all archives cached on local disk
+func (c *ArchiveCache) List() ([]ArchiveInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ArchiveInfo, 0, len(entries))
+	for _, e := range entries {
+		parts := strings.Split(e.key, "/")
+		if len(parts) != 4 {
+			continue
+		}
+		result = append(result, ArchiveInfo{
+			Namespace: parts[0],
+			Name:      parts[1],
+			Version:   parts[2],
+			Filename:  parts[3],
+			Size:      c.archiveSize(c.keyToPath(parts[0], parts[1], parts[2], parts[3]), e.size),
+			LastUsed:  e.modTime,
+		})
+	}
+	return result, nil
+}
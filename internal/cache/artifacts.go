@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ArtifactCache stores per-version release artifacts (SHA256SUMS, .sig) in files
+type ArtifactCache struct {
+	baseDir string
+}
+
+// NewArtifactCache creates a new artifact cache
+func NewArtifactCache(baseDir string) *ArtifactCache {
+	return &ArtifactCache{baseDir: baseDir}
+}
+
+// keyToPath converts key to file path
+// Key: "hashicorp/random/3.6.0/terraform-provider-random_3.6.0_SHA256SUMS"
+// Path: cache/artifacts/hashicorp/random/3.6.0/terraform-provider-random_3.6.0_SHA256SUMS
+func (c *ArtifactCache) keyToPath(namespace, name, version, filename string) string {
+	return filepath.Join(c.baseDir, "artifacts", namespace, name, version, filename)
+}
+
+// Get returns artifact contents from cache
+func (c *ArtifactCache) Get(namespace, name, version, filename string) ([]byte, bool) {
+	data, err := os.ReadFile(c.keyToPath(namespace, name, version, filename))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set saves artifact contents to cache
+// The file is replaced atomically, so a crash never leaves a truncated SHA256SUMS
+func (c *ArtifactCache) Set(namespace, name, version, filename string, data []byte) error {
+	return writeFileBytes(c.keyToPath(namespace, name, version, filename), data)
+}
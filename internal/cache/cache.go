@@ -1,85 +1,316 @@
 package cache
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	bolt "go.etcd.io/bbolt"
 )
 
-// HashCache stores h1 hashes of providers in files
+// HashCache stores hashes of provider archives in the cache metadata database:
+// h1 and any other local scheme registered in internal/hash
+// An in-memory index is built on first use and kept up to date by Set,
+// so lookups never touch the disk
 type HashCache struct {
 	baseDir string
+	db      *metadataDB
+
+	loadOnce sync.Once
+	loadErr  error
+	migrated int
+	dropped  int
+
+	mu    sync.RWMutex
+	index map[string]map[string][]HashEntry // "namespace/name/version" -> platform -> entry per scheme
+	count int
+
+	// Called after Set stores a hash (e.g. to drop rendered responses)
+	listeners []func(namespace, name, version string)
 }
 
 // NewHashCache creates a new hash cache
 func NewHashCache(baseDir string) *HashCache {
-	return &HashCache{baseDir: baseDir}
+	return &HashCache{baseDir: baseDir, db: newMetadataDB(baseDir)}
+}
+
+func versionKey(namespace, name, version string) string {
+	return namespace + "/" + name + "/" + version
+}
+
+// Load builds the in-memory index from the metadata database,
+// importing hash files of earlier releases on first use
+// It runs once; later calls return the first result
+func (c *HashCache) Load() error {
+	c.loadOnce.Do(func() {
+		var entries []HashEntry
+		err := c.db.update(func(tx *bolt.Tx) error {
+			if err := c.migrateHashFiles(tx); err != nil {
+				return err
+			}
+			var err error
+			entries, err = readHashes(tx)
+			return err
+		})
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.index = make(map[string]map[string][]HashEntry)
+		for _, e := range entries {
+			c.add(e)
+		}
+		c.loadErr = err
+	})
+	return c.loadErr
 }
 
-// keyToPath converts key to file path
-// Key: "hashicorp/random/3.6.0/linux_amd64"
-// Path: cache/hashes/hashicorp/random/3.6.0_linux_amd64.h1
-func (c *HashCache) keyToPath(namespace, name, version, platform string) string {
-	filename := version + "_" + platform + ".h1"
-	return filepath.Join(c.baseDir, "hashes", namespace, name, filename)
+// add puts an entry into the index, replacing one of the same scheme; c.mu must be held
+func (c *HashCache) add(e HashEntry) {
+	key := versionKey(e.Namespace, e.Name, e.Version)
+	platforms, ok := c.index[key]
+	if !ok {
+		platforms = make(map[string][]HashEntry)
+		c.index[key] = platforms
+	}
+	entries := platforms[e.Platform]
+	for i, existing := range entries {
+		if existing.Scheme() == e.Scheme() {
+			entries[i] = e
+			return
+		}
+	}
+	platforms[e.Platform] = append(entries, e)
+	c.count++
 }
 
-// Get returns h1 hash from cache
+// Get returns the h1 hash of an archive; an archive counts as hashed once it has one
 func (c *HashCache) Get(namespace, name, version, platform string) (string, bool) {
-	path := c.keyToPath(namespace, name, version, platform)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return "", false
+	_ = c.Load()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return h1Of(c.index[versionKey(namespace, name, version)][platform])
+}
+
+// h1Of returns the h1 hash among the entries of a platform
+func h1Of(entries []HashEntry) (string, bool) {
+	for _, e := range entries {
+		if e.Scheme() == hash.H1.ID {
+			return e.Hash, true
+		}
 	}
-	return strings.TrimSpace(string(data)), true
+	return "", false
 }
 
-// Set saves h1 hash to cache
+// Set saves a hash of any local scheme (e.g. "h1:...") to cache
 func (c *HashCache) Set(namespace, name, version, platform, hash string) error {
-	path := c.keyToPath(namespace, name, version, platform)
+	_ = c.Load()
 
-	// Create directories
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	e := HashEntry{
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		Platform:  platform,
+		Hash:      hash,
+		Stored:    time.Now(),
+	}
+	if err := c.db.update(func(tx *bolt.Tx) error { return putHash(tx, e) }); err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, []byte(hash), 0644)
+	c.mu.Lock()
+	c.add(e)
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(namespace, name, version)
+	}
+	return nil
 }
 
-// GetAll returns all hashes for a provider version
+// OnSet registers fn to be called whenever a hash of a provider version is stored
+func (c *HashCache) OnSet(fn func(namespace, name, version string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// GetAll returns the h1 hashes of a provider version by platform
 func (c *HashCache) GetAll(namespace, name, version string) map[string]string {
-	result := make(map[string]string)
+	_ = c.Load()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	dir := filepath.Join(c.baseDir, "hashes", namespace, name)
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return result
+	platforms := c.index[versionKey(namespace, name, version)]
+	result := make(map[string]string, len(platforms))
+	for platform, entries := range platforms {
+		if h1, ok := h1Of(entries); ok {
+			result[platform] = h1
+		}
 	}
+	return result
+}
 
-	prefix := version + "_"
-	suffix := ".h1"
+// Hashes returns every stored hash of a provider version by platform, in scheme registration order
+func (c *HashCache) Hashes(namespace, name, version string) map[string][]string {
+	_ = c.Load()
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+	c.mu.RLock()
+	platforms := c.index[versionKey(namespace, name, version)]
+	result := make(map[string][]string, len(platforms))
+	for platform, entries := range platforms {
+		for _, e := range entries {
+			result[platform] = append(result[platform], e.Hash)
 		}
+	}
+	c.mu.RUnlock()
+
+	order := make(map[string]int)
+	for i, s := range hash.Schemes() {
+		order[s.ID] = i
+	}
+	for _, hashes := range result {
+		sort.SliceStable(hashes, func(i, j int) bool {
+			return order[hash.SchemeOf(hashes[i])] < order[hash.SchemeOf(hashes[j])]
+		})
+	}
+	return result
+}
 
-		filename := entry.Name()
-		if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, suffix) {
-			continue
+// Migrated returns the number of hash files imported by Load
+func (c *HashCache) Migrated() int {
+	_ = c.Load()
+	return c.migrated
+}
+
+// Dropped returns the number of invalid hashes (e.g. truncated .h1 files) discarded by Load
+func (c *HashCache) Dropped() int {
+	_ = c.Load()
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.dropped + c.db.dropped
+}
+
+// Count returns the number of stored hashes of all schemes
+func (c *HashCache) Count() int {
+	_ = c.Load()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.count
+}
+
+// HashEntry is a stored hash of an archive
+type HashEntry struct {
+	Namespace string
+	Name      string
+	Version   string
+	Platform  string
+	Hash      string
+	Stored    time.Time
+}
+
+// Scheme returns the hash scheme of the entry, e.g. "h1"
+func (e HashEntry) Scheme() string {
+	return hash.SchemeOf(e.Hash)
+}
+
+// List returns all stored hashes sorted by provider, version, platform and hash
+func (c *HashCache) List() ([]HashEntry, error) {
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	result := make([]HashEntry, 0, c.count)
+	for _, platforms := range c.index {
+		for _, entries := range platforms {
+			result = append(result, entries...)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
 		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		return a.Hash < b.Hash
+	})
+	return result, nil
+}
 
-		// Extract platform from filename
-		platform := strings.TrimSuffix(strings.TrimPrefix(filename, prefix), suffix)
+// scan reads the hash files of earlier releases
+// Layout: hashes/{namespace}/{name}/{version}_{os}_{arch}.h1
+func (c *HashCache) scan() ([]HashEntry, error) {
+	root := filepath.Join(c.baseDir, "hashes")
 
-		data, err := os.ReadFile(filepath.Join(dir, filename))
+	var result []HashEntry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			continue
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".h1") {
+			return nil
 		}
 
-		result[platform] = strings.TrimSpace(string(data))
-	}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			return nil
+		}
 
-	return result
+		// {version}_{os}_{arch}.h1
+		fields := strings.Split(strings.TrimSuffix(parts[2], ".h1"), "_")
+		if len(fields) < 3 {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		result = append(result, HashEntry{
+			Namespace: parts[0],
+			Name:      parts[1],
+			Version:   strings.Join(fields[:len(fields)-2], "_"),
+			Platform:  fields[len(fields)-2] + "_" + fields[len(fields)-1],
+			Hash:      strings.TrimSpace(string(data)),
+			Stored:    info.ModTime(),
+		})
+		return nil
+	})
+	return result, err
 }
 
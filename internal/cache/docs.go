@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DocCache stores provider documentation pages fetched from the registry docs API
+type DocCache struct {
+	baseDir string
+}
+
+// NewDocCache creates a new documentation cache
+func NewDocCache(baseDir string) *DocCache {
+	return &DocCache{baseDir: baseDir}
+}
+
+// keyToPath converts key to file path
+// Key: "12345"
+// Path: cache/docs/12345.json
+func (c *DocCache) keyToPath(id string) string {
+	return filepath.Join(c.baseDir, "docs", id+".json")
+}
+
+// Get returns a cached documentation page
+func (c *DocCache) Get(id string) ([]byte, bool) {
+	data, err := os.ReadFile(c.keyToPath(id))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set saves a documentation page to cache
+func (c *DocCache) Set(id string, data []byte) error {
+	return writeFileBytes(c.keyToPath(id), data)
+}
@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Encrypted archive format
+//
+// An encrypted archive starts with encMagic and a random per-file nonce prefix, followed by
+// the archive in chunks of encChunkSize bytes, each sealed with AES-256-GCM and carrying its
+// own tag. Chunk i is sealed with the nonce prefix followed by i (big-endian uint32). Its
+// additional data is a flag for the last chunk followed by the archive's cache key
+// ({namespace}/{name}/{version}/{filename}), so truncated or extended files fail to decrypt, and
+// so do files moved to the path of another archive. Chunks decrypt independently, which keeps
+// random access for ZIP readers and range requests.
+const (
+	encChunkSize  = 64 << 10
+	encNonceSize  = 8 // random per-file part of the 12-byte GCM nonce
+	encTagSize    = 16
+	encHeaderSize = 8 + encNonceSize
+)
+
+// encMagic starts every encrypted archive; ZIP archives start with "PK"
+var encMagic = []byte("TFMENC2\n")
+
+// encMagicV1 started archives encrypted without their cache key in the additional data;
+// they are treated as corrupt, so they are downloaded again and stored in the current format
+var encMagicV1 = []byte("TFMENC1\n")
+
+// chunkAD returns the additional data of a chunk of the archive with a cache key
+func chunkAD(last bool, key string) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append([]byte{flag}, key...)
+}
+
+// ErrEncrypted is returned for an encrypted archive when no encryption key is configured
+var ErrEncrypted = errors.New("archive is encrypted and no cache encryption key is configured")
+
+// ErrCorrupt is returned for encrypted archives that fail to decrypt: they were modified,
+// truncated or encrypted with another key
+var ErrCorrupt = errors.New("encrypted archive is corrupt or was encrypted with another key")
+
+// ArchiveFile is an open cached archive; encrypted archives are decrypted as they are read
+type ArchiveFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// encryption encrypts the archives of some namespaces
+type encryption struct {
+	aead       cipher.AEAD
+	namespaces map[string]bool // "*" for all
+}
+
+// LoadEncryptionKey returns a base64-encoded 256-bit key, given directly or printed by a command
+// (e.g. a KMS call that decrypts a data key)
+func LoadEncryptionKey(key, command string) ([]byte, error) {
+	if command != "" {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return nil, errors.New("encryption key command is empty")
+		}
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return nil, fmt.Errorf("encryption key command: %w", err)
+		}
+		key = string(out)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, errors.New("encryption key must be base64-encoded")
+	}
+	if len(decoded) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(decoded))
+	}
+	return decoded, nil
+}
+
+// SetEncryption encrypts archives of the given namespaces ("*" for all) with AES-256-GCM
+// when they are stored; encrypted archives of any namespace are decrypted when read
+// Archives cached before keep their format until they are stored again
+// The logger (may be nil) reports archives that fail to decrypt
+func (c *ArchiveCache) SetEncryption(key []byte, namespaces []string, logger *slog.Logger) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	enc := &encryption{aead: aead, namespaces: make(map[string]bool)}
+	for _, ns := range namespaces {
+		enc.namespaces[strings.ToLower(ns)] = true
+	}
+	c.enc = enc
+	if logger != nil {
+		c.logger = logger
+	}
+	return nil
+}
+
+// encrypts reports whether archives of a namespace are encrypted when stored
+func (c *ArchiveCache) encrypts(namespace string) bool {
+	return c.enc != nil && (c.enc.namespaces["*"] || c.enc.namespaces[strings.ToLower(namespace)])
+}
+
+// openFile opens an archive on local disk, decrypting it if it is encrypted
+func (c *ArchiveCache) openFile(namespace, name, version, filename string) (ArchiveFile, int64, error) {
+	path := c.keyToPath(namespace, name, version, filename)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	header := make([]byte, encHeaderSize)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, 0, err
+	}
+	if n >= len(encMagicV1) && bytes.Equal(header[:len(encMagicV1)], encMagicV1) {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s: encrypted in an earlier format: %w", path, ErrCorrupt)
+	}
+	if n < len(encMagic) || !bytes.Equal(header[:len(encMagic)], encMagic) {
+		return f, info.Size(), nil
+	}
+
+	if c.enc == nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s: %w", path, ErrEncrypted)
+	}
+	chunks, size, ok := plainSize(info.Size())
+	if n < encHeaderSize || !ok {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s: %w", path, ErrCorrupt)
+	}
+	r := &chunkReader{
+		aead:     c.enc.aead,
+		f:        f,
+		fileSize: info.Size(),
+		chunks:   chunks,
+		size:     size,
+		moreAD:   chunkAD(false, archiveKey(namespace, name, version, filename)),
+		lastAD:   chunkAD(true, archiveKey(namespace, name, version, filename)),
+		cached:   -1,
+		sealed:   make([]byte, encChunkSize+encTagSize),
+		plain:    make([]byte, 0, encChunkSize),
+	}
+	copy(r.nonce[:], header[len(encMagic):])
+	return &decryptingFile{SectionReader: io.NewSectionReader(r, 0, size), reader: r}, size, nil
+}
+
+// authenticate checks every chunk of an encrypted archive, so a modified file is treated as
+// missing instead of failing halfway through a download
+func authenticate(f ArchiveFile) error {
+	d, ok := f.(*decryptingFile)
+	if !ok {
+		return nil
+	}
+	r := d.reader
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := int64(0); i < r.chunks; i++ {
+		if err := r.load(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveSize returns the size of an archive as served, which for encrypted archives is
+// smaller than the file on disk
+func (c *ArchiveCache) archiveSize(path string, fileSize int64) int64 {
+	if c.enc == nil {
+		return fileSize
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fileSize
+	}
+	defer f.Close()
+	magic := make([]byte, len(encMagic))
+	if _, err := f.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, encMagic) {
+		return fileSize
+	}
+	if _, size, ok := plainSize(fileSize); ok {
+		return size
+	}
+	return fileSize
+}
+
+// plainSize returns the number of chunks and the plaintext size of an encrypted archive
+// ok is false when the file size cannot be that of an encrypted archive
+func plainSize(fileSize int64) (chunks, size int64, ok bool) {
+	data := fileSize - encHeaderSize
+	if data < encTagSize {
+		return 0, 0, false
+	}
+	chunks = (data + encChunkSize + encTagSize - 1) / (encChunkSize + encTagSize)
+	if last := data - (chunks-1)*(encChunkSize+encTagSize); last < encTagSize {
+		return 0, 0, false
+	}
+	return chunks, data - chunks*encTagSize, true
+}
+
+// encrypt returns a reader producing the encrypted form of the archive with a cache key
+func (e *encryption) encrypt(r io.Reader, key string) (io.Reader, error) {
+	er := &encryptingReader{
+		aead:   e.aead,
+		moreAD: chunkAD(false, key),
+		lastAD: chunkAD(true, key),
+		src:    bufio.NewReaderSize(r, encChunkSize),
+		plain:  make([]byte, encChunkSize),
+		buf:    make([]byte, 0, encChunkSize+encTagSize),
+	}
+	if _, err := rand.Read(er.nonce[:encNonceSize]); err != nil {
+		return nil, err
+	}
+	er.out = append(append([]byte(nil), encMagic...), er.nonce[:encNonceSize]...)
+	return er, nil
+}
+
+// encryptingReader seals an archive chunk by chunk as it is read
+type encryptingReader struct {
+	aead   cipher.AEAD
+	moreAD []byte
+	lastAD []byte
+	src    *bufio.Reader
+	nonce  [12]byte
+	index  uint32
+	plain  []byte
+	buf    []byte
+	out    []byte // sealed data not yet read
+	done   bool
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// seal encrypts the next chunk; a short chunk, or a full one at the end of the input, is the last
+func (r *encryptingReader) seal() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := n < len(r.plain)
+	if !last {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if r.done = last; !last && r.index == 1<<32-1 {
+		return errors.New("archive too large to encrypt")
+	}
+
+	ad := r.moreAD
+	if last {
+		ad = r.lastAD
+	}
+	binary.BigEndian.PutUint32(r.nonce[encNonceSize:], r.index)
+	r.out = r.aead.Seal(r.buf[:0], r.nonce[:], r.plain[:n], ad)
+	r.index++
+	return nil
+}
+
+// chunkReader decrypts an encrypted archive at arbitrary offsets
+// The last decrypted chunk is kept, so sequential reads decrypt each chunk once
+type chunkReader struct {
+	aead     cipher.AEAD
+	f        *os.File
+	nonce    [12]byte
+	fileSize int64
+	chunks   int64
+	size     int64 // plaintext
+	moreAD   []byte
+	lastAD   []byte
+
+	mu     sync.Mutex
+	cached int64 // index of the chunk in plain, -1 for none
+	sealed []byte
+	plain  []byte
+}
+
+func (r *chunkReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		chunk := pos / encChunkSize
+		if err := r.load(chunk); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], r.plain[pos-chunk*encChunkSize:])
+	}
+	return n, nil
+}
+
+// load decrypts a chunk into plain; the caller holds r.mu
+func (r *chunkReader) load(chunk int64) error {
+	if chunk == r.cached {
+		return nil
+	}
+	r.cached = -1
+
+	off := encHeaderSize + chunk*(encChunkSize+encTagSize)
+	sealed := r.sealed[:min(encChunkSize+encTagSize, r.fileSize-off)]
+	if _, err := r.f.ReadAt(sealed, off); err != nil {
+		return err
+	}
+
+	ad := r.moreAD
+	if chunk == r.chunks-1 {
+		ad = r.lastAD
+	}
+	nonce := r.nonce
+	binary.BigEndian.PutUint32(nonce[encNonceSize:], uint32(chunk))
+	plain, err := r.aead.Open(r.plain[:0], nonce[:], sealed, ad)
+	if err != nil {
+		return fmt.Errorf("%s: chunk %d: %w", r.f.Name(), chunk, ErrCorrupt)
+	}
+	r.plain = plain
+	r.cached = chunk
+	return nil
+}
+
+// decryptingFile is an open encrypted archive
+type decryptingFile struct {
+	*io.SectionReader
+	reader *chunkReader
+}
+
+func (d *decryptingFile) Close() error {
+	return d.reader.f.Close()
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// noFsync skips flushing cache files to disk (see SetFsync)
+var noFsync atomic.Bool
+
+// SetFsync sets whether archives, artifacts and documentation pages are flushed to disk
+// before a write returns (the default). Without it writes are faster, but a power loss
+// may lose files written shortly before; it never leaves a partial file.
+// The metadata database always syncs its commits.
+func SetFsync(enabled bool) {
+	noFsync.Store(!enabled)
+}
+
+// writeFile atomically replaces path with the contents of r
+// Data is written to a temporary file in the same directory and renamed,
+// so readers and crashes never see a partial file
+func writeFile(path string, r io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if !noFsync.Load() {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if noFsync.Load() {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+// writeFileBytes is writeFile for data held in memory
+func writeFileBytes(path string, data []byte) error {
+	return writeFile(path, bytes.NewReader(data))
+}
+
+// syncDir flushes a directory so a rename into it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// jobsBucket holds finished admin jobs: {id} -> JSON
+var jobsBucket = []byte("jobs")
+
+// JobStore keeps the history of finished admin jobs in the metadata database
+type JobStore struct {
+	db *metadataDB
+}
+
+// NewJobStore creates a job store in a cache directory
+func NewJobStore(baseDir string) *JobStore {
+	return &JobStore{db: newMetadataDB(baseDir)}
+}
+
+// Put stores a job, replacing an earlier record with the same ID
+func (s *JobStore) Put(id string, data []byte) error {
+	return s.db.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+}
+
+// Delete removes jobs from the history
+func (s *JobStore) Delete(ids ...string) error {
+	return s.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		if b == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load returns all stored jobs in ID order
+func (s *JobStore) Load() ([][]byte, error) {
+	var result [][]byte
+	err := s.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			result = append(result, append([]byte(nil), v...))
+			return nil
+		})
+	})
+	return result, err
+}
@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	bolt "go.etcd.io/bbolt"
+)
+
+// MetadataFile is the embedded database with cache metadata, relative to the cache directory
+// Archives and release artifacts stay in plain files next to it
+const MetadataFile = "metadata.db"
+
+// metadataFormat is the layout version of the database written by this release
+// 1: hashes bucket with JSON records; .h1 files were imported unchecked
+// 2: every stored hash is a well-formed h1 hash
+const metadataFormat = 2
+
+// metadataLockTimeout is how long to wait for another process holding the database
+const metadataLockTimeout = 5 * time.Second
+
+var (
+	hashesBucket = []byte("hashes")
+	metaBucket   = []byte("meta")
+
+	// hashesMigratedKey marks that .h1 files were imported into the hashes bucket
+	hashesMigratedKey = []byte("hashes_migrated")
+
+	// formatKey holds the metadataFormat the database was last upgraded to
+	formatKey = []byte("format")
+)
+
+// migrations[i] upgrades the database from format i+1 to i+2
+var migrations = []func(tx *bolt.Tx) (int, error){
+	dropInvalidHashes,
+}
+
+// metadataDB is the bbolt database of a cache directory
+// Layout: hashes/{namespace}/{name}/{version}/{platform} -> hashRecord JSON of the h1 hash,
+// hashes/{namespace}/{name}/{version}/{platform}/{scheme} for other local schemes
+//
+// The database is opened on first use and stays open, shared by every store of the
+// directory, until CloseMetadata. bbolt locks it, so only one process can use a cache directory.
+// Each update first upgrades an older format; a newer one is refused.
+type metadataDB struct {
+	path string
+	mu   sync.Mutex
+
+	// Records removed by migrations
+	dropped int
+}
+
+// openDBs holds the databases opened by this process by path
+var openDBs = struct {
+	sync.Mutex
+	dbs map[string]*bolt.DB
+}{dbs: make(map[string]*bolt.DB)}
+
+// hashRecord is a stored hash
+type hashRecord struct {
+	Hash   string    `json:"hash"`
+	Stored time.Time `json:"stored"`
+}
+
+func newMetadataDB(baseDir string) *metadataDB {
+	return &metadataDB{path: filepath.Join(baseDir, MetadataFile)}
+}
+
+// open returns the database of m.path, opening it on first use
+func (m *metadataDB) open() (*bolt.DB, error) {
+	openDBs.Lock()
+	defer openDBs.Unlock()
+
+	if db, ok := openDBs.dbs[m.path]; ok {
+		return db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(m.path, 0644, &bolt.Options{Timeout: metadataLockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("opening metadata database: %s is in use by another process", m.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening metadata database: %w", err)
+	}
+	openDBs.dbs[m.path] = db
+	return db, nil
+}
+
+// update runs fn in a read-write transaction
+func (m *metadataDB) update(fn func(tx *bolt.Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	db, err := m.open()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := m.upgrade(tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// CloseMetadata closes the metadata database of a cache directory, if this process opened it
+// Stores of the directory reopen it when they are used again
+func CloseMetadata(baseDir string) error {
+	path := filepath.Join(baseDir, MetadataFile)
+
+	openDBs.Lock()
+	defer openDBs.Unlock()
+
+	db, ok := openDBs.dbs[path]
+	if !ok {
+		return nil
+	}
+	delete(openDBs.dbs, path)
+	return db.Close()
+}
+
+// upgrade runs the migrations a database needs to reach metadataFormat; m.mu must be held
+// Databases without a format key are format 1
+func (m *metadataDB) upgrade(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+
+	format := 1
+	if v := meta.Get(formatKey); v != nil {
+		if format, err = strconv.Atoi(string(v)); err != nil {
+			return fmt.Errorf("metadata database: invalid format %q", v)
+		}
+	}
+	if format == metadataFormat {
+		return nil
+	}
+	if format > metadataFormat {
+		return fmt.Errorf("metadata database has format %d, this release supports up to %d", format, metadataFormat)
+	}
+
+	for ; format < metadataFormat; format++ {
+		dropped, err := migrations[format-1](tx)
+		if err != nil {
+			return fmt.Errorf("upgrading metadata database to format %d: %w", format+1, err)
+		}
+		m.dropped += dropped
+	}
+	return meta.Put(formatKey, []byte(strconv.Itoa(metadataFormat)))
+}
+
+// dropInvalidHashes removes hash records that are not well-formed h1 hashes,
+// e.g. truncated .h1 files imported by format 1
+func dropInvalidHashes(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(hashesBucket)
+	if b == nil {
+		return 0, nil
+	}
+
+	var invalid [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var rec hashRecord
+		if err := json.Unmarshal(v, &rec); err != nil || !hash.IsH1(rec.Hash) {
+			invalid = append(invalid, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range invalid {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(invalid), nil
+}
+
+// hashKey returns the key of an entry; h1 keeps the key of earlier releases, which only stored h1
+func hashKey(e HashEntry) []byte {
+	key := e.Namespace + "/" + e.Name + "/" + e.Version + "/" + e.Platform
+	if scheme := e.Scheme(); scheme != hash.H1.ID {
+		key += "/" + scheme
+	}
+	return []byte(key)
+}
+
+// localHash reports whether h is a well-formed hash of a local scheme
+func localHash(h string) bool {
+	s, ok := hash.Lookup(hash.SchemeOf(h))
+	return ok && s.Local && hash.Valid(h)
+}
+
+// putHash stores an entry in the hashes bucket
+func putHash(tx *bolt.Tx, e HashEntry) error {
+	if !localHash(e.Hash) {
+		return fmt.Errorf("%s: invalid hash %q", hashKey(e), e.Hash)
+	}
+	b, err := tx.CreateBucketIfNotExists(hashesBucket)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(hashRecord{Hash: e.Hash, Stored: e.Stored.UTC()})
+	if err != nil {
+		return err
+	}
+	return b.Put(hashKey(e), data)
+}
+
+// readHashes returns every entry of the hashes bucket
+func readHashes(tx *bolt.Tx) ([]HashEntry, error) {
+	b := tx.Bucket(hashesBucket)
+	if b == nil {
+		return nil, nil
+	}
+
+	var result []HashEntry
+	err := b.ForEach(func(k, v []byte) error {
+		parts := strings.Split(string(k), "/")
+		if len(parts) != 4 && len(parts) != 5 {
+			return nil
+		}
+		// Hashes of schemes no longer registered are skipped
+		var rec hashRecord
+		if err := json.Unmarshal(v, &rec); err != nil || !localHash(rec.Hash) {
+			return nil
+		}
+		result = append(result, HashEntry{
+			Namespace: parts[0],
+			Name:      parts[1],
+			Version:   parts[2],
+			Platform:  parts[3],
+			Hash:      rec.Hash,
+			Stored:    rec.Stored,
+		})
+		return nil
+	})
+	return result, err
+}
+
+// migrateHashFiles imports .h1 files into the database once
+// The files are left in place so an older release can still read them;
+// files that do not hold a well-formed h1 hash (e.g. truncated by a crash) are skipped
+func (c *HashCache) migrateHashFiles(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	if meta.Get(hashesMigratedKey) != nil {
+		return nil
+	}
+
+	entries, err := c.scan()
+	if err != nil {
+		return fmt.Errorf("reading hash files: %w", err)
+	}
+	for _, e := range entries {
+		if !hash.IsH1(e.Hash) {
+			c.dropped++
+			continue
+		}
+		if err := putHash(tx, e); err != nil {
+			return err
+		}
+		c.migrated++
+	}
+
+	return meta.Put(hashesMigratedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
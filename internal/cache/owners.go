@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ownersBucket maps archive keys to the tenant whose request filled the cache
+var ownersBucket = []byte("archive_owners")
+
+// TenantUsage is the archive cache usage attributed to one tenant
+type TenantUsage struct {
+	Tenant     string `json:"tenant"`
+	Bytes      int64  `json:"bytes"`
+	Archives   int    `json:"archives"`
+	QuotaBytes int64  `json:"quota_bytes,omitempty"`
+}
+
+// SetTenantQuotas enables archive ownership and configures per-tenant quotas (tenant -> bytes)
+func (c *ArchiveCache) SetTenantQuotas(quotas map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tenantQuotas = quotas
+	if c.db == nil {
+		c.db = newMetadataDB(c.baseDir)
+	}
+}
+
+// SetOwner attributes a cached archive to a tenant
+// The first tenant to fill an archive keeps it; later downloads are served from the cache free of charge
+func (c *ArchiveCache) SetOwner(namespace, name, version, filename, tenant string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db == nil || tenant == "" {
+		return nil
+	}
+
+	key := []byte(namespace + "/" + name + "/" + version + "/" + filename)
+	return c.db.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(ownersBucket)
+		if err != nil {
+			return err
+		}
+		if b.Get(key) != nil {
+			return nil
+		}
+		return b.Put(key, []byte(tenant))
+	})
+}
+
+// TenantUsage returns the usage of every tenant with cached archives or a quota, sorted by tenant
+func (c *ArchiveCache) TenantUsage() ([]TenantUsage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.scan()
+	if err != nil {
+		return nil, err
+	}
+	owners, err := c.owners(entries, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byTenant := make(map[string]*TenantUsage)
+	for tenant, q := range c.tenantQuotas {
+		byTenant[tenant] = &TenantUsage{Tenant: tenant, QuotaBytes: q}
+	}
+	for _, e := range entries {
+		tenant, ok := owners[e.key]
+		if !ok {
+			continue
+		}
+		u, ok := byTenant[tenant]
+		if !ok {
+			u = &TenantUsage{Tenant: tenant}
+			byTenant[tenant] = u
+		}
+		u.Bytes += e.size
+		u.Archives++
+	}
+
+	result := make([]TenantUsage, 0, len(byTenant))
+	for _, u := range byTenant {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tenant < result[j].Tenant })
+	return result, nil
+}
+
+// owners reads archive owners and drops records of archives that are no longer cached
+// or listed in evicted; the caller holds c.mu
+func (c *ArchiveCache) owners(entries []archiveEntry, evicted []string) (map[string]string, error) {
+	result := make(map[string]string)
+	if c.db == nil {
+		return result, nil
+	}
+
+	cached := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		cached[e.key] = struct{}{}
+	}
+	for _, key := range evicted {
+		delete(cached, key)
+	}
+
+	err := c.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ownersBucket)
+		if b == nil {
+			return nil
+		}
+
+		var stale [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if _, ok := cached[string(k)]; !ok {
+				stale = append(stale, append([]byte(nil), k...))
+				return nil
+			}
+			result[string(k)] = string(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NamespaceUsage is the archive cache usage of one provider namespace
+type NamespaceUsage struct {
+	Namespace  string `json:"namespace"`
+	Bytes      int64  `json:"bytes"`
+	Archives   int    `json:"archives"`
+	QuotaBytes int64  `json:"quota_bytes,omitempty"`
+}
+
+// archiveEntry is a cached archive found on disk
+type archiveEntry struct {
+	key       string
+	path      string
+	namespace string
+	size      int64
+	modTime   time.Time
+}
+
+// SetLimits configures the total size limit and per-namespace quotas
+// quotas maps namespace to bytes, "*" applies to namespaces without an entry; 0 means unlimited
+func (c *ArchiveCache) SetLimits(maxSize int64, quotas map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	c.quotas = quotas
+}
+
+// quota returns the quota for a namespace (0 = unlimited)
+func (c *ArchiveCache) quota(namespace string) int64 {
+	if q, ok := c.quotas[namespace]; ok {
+		return q
+	}
+	return c.quotas["*"]
+}
+
+// Usage returns per-namespace usage sorted by namespace
+func (c *ArchiveCache) Usage() ([]NamespaceUsage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	byNamespace := make(map[string]*NamespaceUsage)
+	for _, e := range entries {
+		u, ok := byNamespace[e.namespace]
+		if !ok {
+			u = &NamespaceUsage{Namespace: e.namespace, QuotaBytes: c.quota(e.namespace)}
+			byNamespace[e.namespace] = u
+		}
+		u.Bytes += e.size
+		u.Archives++
+	}
+
+	result := make([]NamespaceUsage, 0, len(byNamespace))
+	for _, u := range byNamespace {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Namespace < result[j].Namespace })
+	return result, nil
+}
+
+// MaxSize returns the configured total size limit (0 = unlimited)
+func (c *ArchiveCache) MaxSize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.maxSize
+}
+
+// enforceDelay is how long ScheduleEnforce waits, so archives stored in a burst share one scan
+const enforceDelay = 5 * time.Second
+
+// ScheduleEnforce runs Enforce in the background after enforceDelay, unless a run is already
+// waiting, instead of walking the whole cache for every stored archive
+// The cache may exceed its limits by the archives stored in the meantime
+func (c *ArchiveCache) ScheduleEnforce(logger *slog.Logger) {
+	if !c.enforcePending.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(enforceDelay, func() {
+		c.enforcePending.Store(false)
+		evicted, err := c.Enforce()
+		if err != nil {
+			logger.Error("failed to enforce cache limits", "error", err)
+		}
+		for _, key := range evicted {
+			logger.Info("evicted archive", "key", key)
+		}
+	})
+}
+
+// Enforce evicts least recently used archives until limits are met
+// Namespaces over their quota are trimmed first, then tenants over theirs,
+// then the total size limit is applied
+// Returns the keys of evicted archives
+func (c *ArchiveCache) Enforce() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxSize <= 0 && len(c.quotas) == 0 && len(c.tenantQuotas) == 0 {
+		return nil, nil
+	}
+
+	entries, err := c.scan()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	var total int64
+	usage := make(map[string]int64)
+	for _, e := range entries {
+		usage[e.namespace] += e.size
+		total += e.size
+	}
+
+	var evicted []string
+	removed := make([]bool, len(entries))
+	evict := func(i int) {
+		e := entries[i]
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		removed[i] = true
+		usage[e.namespace] -= e.size
+		total -= e.size
+		evicted = append(evicted, e.key)
+
+		// Drop the version directory once it is empty
+		_ = os.Remove(filepath.Dir(e.path))
+	}
+
+	// Over-quota namespaces first
+	for i, e := range entries {
+		if q := c.quota(e.namespace); q > 0 && usage[e.namespace] > q {
+			evict(i)
+		}
+	}
+
+	// Then over-quota tenants
+	if len(c.tenantQuotas) > 0 {
+		owners, err := c.owners(entries, evicted)
+		if err != nil {
+			return evicted, err
+		}
+		tenantUsage := make(map[string]int64)
+		for i, e := range entries {
+			if !removed[i] {
+				tenantUsage[owners[e.key]] += e.size
+			}
+		}
+		for i, e := range entries {
+			tenant, ok := owners[e.key]
+			if q := c.tenantQuotas[tenant]; ok && !removed[i] && q > 0 && tenantUsage[tenant] > q {
+				evict(i)
+				if removed[i] {
+					tenantUsage[tenant] -= e.size
+				}
+			}
+		}
+	}
+
+	// Then the total limit
+	for i := range entries {
+		if c.maxSize <= 0 || total <= c.maxSize {
+			break
+		}
+		if !removed[i] {
+			evict(i)
+		}
+	}
+
+	// Forget the owners of evicted archives
+	if c.db != nil && len(evicted) > 0 {
+		if _, err := c.owners(entries, evicted); err != nil {
+			return evicted, err
+		}
+	}
+
+	return evicted, nil
+}
+
+// scan lists all cached archives
+func (c *ArchiveCache) scan() ([]archiveEntry, error) {
+	root := filepath.Join(c.baseDir, "archives")
+
+	var entries []archiveEntry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		key := filepath.ToSlash(rel)
+		namespace, _, _ := strings.Cut(key, "/")
+
+		entries = append(entries, archiveEntry{
+			key:       key,
+			path:      path,
+			namespace: namespace,
+			size:      info.Size(),
+			modTime:   info.ModTime(),
+		})
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"bytes"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// snapshotsBucket holds upstream version lists: {namespace}/{name}/{taken} -> JSON
+var snapshotsBucket = []byte("version_snapshots")
+
+// snapshotTimeFormat sorts lexicographically in time order
+const snapshotTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Snapshot is an upstream version list as it was at a point in time
+type Snapshot struct {
+	Taken time.Time
+	Data  []byte
+}
+
+// SnapshotStore keeps every distinct upstream version list in the metadata database,
+// so the mirror can serve a provider as it was at an earlier date
+type SnapshotStore struct {
+	db *metadataDB
+}
+
+// NewSnapshotStore creates a snapshot store in a cache directory
+func NewSnapshotStore(baseDir string) *SnapshotStore {
+	return &SnapshotStore{db: newMetadataDB(baseDir)}
+}
+
+func snapshotPrefix(namespace, name string) []byte {
+	return []byte(namespace + "/" + name + "/")
+}
+
+// Add stores a version list taken at a time unless it equals the latest stored one
+// Reports whether a new snapshot was stored
+func (s *SnapshotStore) Add(namespace, name string, taken time.Time, data []byte) (bool, error) {
+	prefix := snapshotPrefix(namespace, name)
+	added := false
+
+	err := s.db.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(snapshotsBucket)
+		if err != nil {
+			return err
+		}
+		if _, latest, ok := lastWithPrefix(b.Cursor(), prefix); ok && bytes.Equal(latest, data) {
+			return nil
+		}
+		added = true
+		key := append(prefix, taken.UTC().Format(snapshotTimeFormat)...)
+		return b.Put(key, data)
+	})
+	return added, err
+}
+
+// At returns the latest snapshot of a provider taken at or before t
+func (s *SnapshotStore) At(namespace, name string, t time.Time) (Snapshot, bool, error) {
+	prefix := snapshotPrefix(namespace, name)
+	var (
+		result Snapshot
+		found  bool
+	)
+
+	err := s.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket)
+		if b == nil {
+			return nil
+		}
+		// Seek lands on the first snapshot after t (or past the provider); step back one
+		c := b.Cursor()
+		after := append(append([]byte(nil), prefix...), t.UTC().Add(time.Nanosecond).Format(snapshotTimeFormat)...)
+		k, v := c.Seek(after)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, prefix) {
+			return nil
+		}
+		taken, err := time.Parse(snapshotTimeFormat, string(k[len(prefix):]))
+		if err != nil {
+			return nil
+		}
+		result = Snapshot{Taken: taken, Data: append([]byte(nil), v...)}
+		found = true
+		return nil
+	})
+	return result, found, err
+}
+
+// List returns all snapshots of a provider, oldest first
+func (s *SnapshotStore) List(namespace, name string) ([]Snapshot, error) {
+	prefix := snapshotPrefix(namespace, name)
+	var result []Snapshot
+
+	err := s.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			taken, err := time.Parse(snapshotTimeFormat, string(k[len(prefix):]))
+			if err != nil {
+				continue
+			}
+			result = append(result, Snapshot{Taken: taken, Data: append([]byte(nil), v...)})
+		}
+		return nil
+	})
+	return result, err
+}
+
+// lastWithPrefix returns the last key/value starting with prefix
+func lastWithPrefix(c *bolt.Cursor, prefix []byte) ([]byte, []byte, bool) {
+	end := append(append([]byte(nil), prefix...), 0xff)
+	k, v := c.Seek(end)
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil, false
+	}
+	return k, v, true
+}
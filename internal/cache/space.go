@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"errors"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/disk"
+)
+
+// ErrLowSpace is returned when an archive is not stored because the cache volume is low on free space
+var ErrLowSpace = errors.New("cache volume below minimum free space")
+
+// SetMinFree sets the free space kept on the cache volume; no archives are stored below it (0 disables)
+func (c *ArchiveCache) SetMinFree(bytes int64) {
+	c.minFree.Store(bytes)
+}
+
+// MinFree returns the configured minimum free space
+func (c *ArchiveCache) MinFree() int64 {
+	return c.minFree.Load()
+}
+
+// Space returns the size and free space of the cache volume
+// ok is false when the platform or the (not yet created) cache directory cannot report it
+func (c *ArchiveCache) Space() (space disk.Space, ok bool) {
+	return disk.Stat(c.baseDir)
+}
+
+// LowSpace reports whether the cache volume has less free space than the minimum
+func (c *ArchiveCache) LowSpace() bool {
+	minFree := c.minFree.Load()
+	if minFree <= 0 {
+		return false
+	}
+	space, ok := c.Space()
+	return ok && space.Free < minFree
+}
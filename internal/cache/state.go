@@ -0,0 +1,51 @@
+package cache
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// stateBucket holds snapshots of in-memory state, keyed by component
+var stateBucket = []byte("state")
+
+// StateStore keeps snapshots of in-memory state in the metadata database,
+// so caches and circuit breakers survive a restart
+type StateStore struct {
+	db *metadataDB
+}
+
+// NewStateStore creates a state store in a cache directory
+func NewStateStore(baseDir string) *StateStore {
+	return &StateStore{db: newMetadataDB(baseDir)}
+}
+
+// Save stores snapshots, replacing earlier ones with the same key
+func (s *StateStore) Save(snapshots map[string][]byte) error {
+	return s.db.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(stateBucket)
+		if err != nil {
+			return err
+		}
+		for key, data := range snapshots {
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load returns all saved snapshots
+func (s *StateStore) Load() (map[string][]byte, error) {
+	result := make(map[string][]byte)
+	err := s.db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(stateBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			result[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return result, err
+}
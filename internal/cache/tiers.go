@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/objectstore"
+)
+
+// ObjectStore is the shared tier below the local archive cache (objectstore.Store)
+// Keys are "namespace/name/version/filename"; missing objects are objectstore.ErrNotFound
+type ObjectStore interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	Presign(key string, expires time.Duration) string
+}
+
+// SetObjectStore adds a shared tier below the local cache
+// Stored archives are written through to it, and archives missing locally are
+// looked up there and promoted to local disk; evictions only remove local copies
+func (c *ArchiveCache) SetObjectStore(store ObjectStore, logger *slog.Logger) {
+	c.remote = store
+	c.logger = logger
+}
+
+// objectKey returns the object store key of an archive
+func objectKey(namespace, name, version, filename string) string {
+	return namespace + "/" + name + "/" + version + "/" + filename
+}
+
+// promote copies an archive from the object store to local disk
+// Concurrent promotions of the same archive share one download
+func (c *ArchiveCache) promote(namespace, name, version, filename string) bool {
+	key := objectKey(namespace, name, version, filename)
+	_, err, _ := c.promotions.Do(key, func() (any, error) {
+		// Another promotion may have just finished
+		path := c.keyToPath(namespace, name, version, filename)
+		if _, err := os.Stat(path); err == nil {
+			return nil, nil
+		}
+		if c.LowSpace() {
+			return nil, ErrLowSpace
+		}
+
+		body, _, err := c.remote.Get(context.Background(), key)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		if err := writeFile(path, body); err != nil {
+			return nil, err
+		}
+		c.logger.Info("promoted archive from object store", "key", key)
+		c.ScheduleEnforce(c.logger)
+		return nil, nil
+	})
+	if err != nil && !errors.Is(err, objectstore.ErrNotFound) {
+		c.logger.Warn("failed to promote archive from object store", "key", key, "error", err)
+	}
+	return err == nil
+}
+
+// upload writes a locally stored archive through to the object store
+func (c *ArchiveCache) upload(namespace, name, version, filename string) error {
+	f, err := os.Open(c.keyToPath(namespace, name, version, filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return c.remote.Put(context.Background(), objectKey(namespace, name, version, filename), f, info.Size())
+}
+
+// ObjectURL returns a presigned URL of an archive in the object store, valid for expires
+// ok is false without an object store, for encrypted namespaces (clients would receive the
+// encrypted file) or when the archive is not stored there
+func (c *ArchiveCache) ObjectURL(namespace, name, version, filename string, expires time.Duration) (string, bool) {
+	if c.remote == nil || c.encrypts(namespace) || !c.remoteHas(namespace, name, version, filename) {
+		return "", false
+	}
+	return c.remote.Presign(objectKey(namespace, name, version, filename), expires), true
+}
+
+// remoteHas reports whether the object store holds an archive
+func (c *ArchiveCache) remoteHas(namespace, name, version, filename string) bool {
+	key := objectKey(namespace, name, version, filename)
+	ok, err := c.remote.Exists(context.Background(), key)
+	if err != nil {
+		c.logger.Warn("failed to look up archive in object store", "key", key, "error", err)
+	}
+	return ok
+}
+
+// remoteHasVersion reports whether the object store holds any archive of a provider version
+func (c *ArchiveCache) remoteHasVersion(namespace, name, version string) bool {
+	prefix := objectKey(namespace, name, version, "")
+	keys, err := c.remote.List(context.Background(), prefix)
+	if err != nil {
+		c.logger.Warn("failed to list archives in object store", "prefix", prefix, "error", err)
+		return false
+	}
+	for _, key := range keys {
+		if rest := strings.TrimPrefix(key, prefix); !strings.Contains(rest, "/") && strings.HasSuffix(rest, ".zip") {
+			return true
+		}
+	}
+	return false
+}
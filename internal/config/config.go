@@ -1,67 +1,723 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/buildinfo"
 )
 
 // Config holds application settings
 type Config struct {
-	// Server
-	ListenAddr   string
+	// Server; WriteTimeout caps writing any response (0 = no cap, routes set their own deadlines)
+	ListenAddrs  []string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 
+	// Separate listen addresses for /admin/* (none serves them on ListenAddrs)
+	AdminListenAddrs []string
+
+	// Keep-alive and connection limits (MaxConnections 0 = unlimited)
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+
+	// Time budget for metadata, admin and API requests; upstream calls made for it stop when it runs out
+	// (0 disables). Archive downloads instead get DownloadTimeout to start
+	RequestTimeout time.Duration
+
+	// Archive downloads fail when the client accepts no data for this long (0 disables)
+	DownloadIdleTimeout time.Duration
+	MaxHeaderBytes      int
+	MaxConnections      int
+
+	// TLS listener; with a client CA, client certificates are verified ("require" or "optional")
+	// and their identity (CN or first SAN) is mapped to a policy: "read", "publish", "admin" or "deny"
+	TLSCert        string
+	TLSKey         string
+	TLSClientCA    string
+	TLSClientAuth  string
+	ClientPolicies map[string]string
+
+	// HTTP/2 without TLS via h2c, opt-in; HTTP/2 over TLS is always offered
+	HTTP2Enabled    bool
+	HTTP2MaxStreams int
+
+	// Path prefix of all routes (e.g. "/terraform-mirror"), "" to serve at the root
+	BasePath string
+
+	// External base URL (e.g. "https://lb.example.com/terraform"); when set, archive URLs
+	// are absolute and its path prefix is accepted on incoming requests
+	ExternalURL string
+
+	// How long downstream HTTP caches and CDNs may keep archive responses (0 sends no caching headers)
+	ArchiveMaxAge time.Duration
+
+	// Send X-Mirror-Cache and X-Mirror-Upstream on metadata and archive responses
+	AttributionHeaders bool
+
+	// Origin mode behind a CDN: archive URLs in metadata responses point to CDNURL (when set) and,
+	// with a secret, carry an HMAC signature valid for SignedURLTTL; unsigned archive requests are refused
+	CDNURL          string
+	SignedURLSecret string
+	SignedURLTTL    time.Duration
+
+	// Download links minted by POST /api/download-links for runners without credentials:
+	// HMAC secret ("" disables) and the longest (and default) lifetime of a link
+	DownloadLinkSecret string
+	DownloadLinkTTL    time.Duration
+
 	// Upstream
 	UpstreamURL     string
 	UpstreamTimeout time.Duration
 
+	// Upstream type ("registry" or "artifactory"), Artifactory repository key
+	// and credentials sent to the upstream host
+	UpstreamType     string
+	UpstreamRepo     string
+	UpstreamToken    string
+	UpstreamUsername string
+	UpstreamPassword string
+
+	// Limit for a single archive transfer from upstream, including the body,
+	// and for a client download to start
+	DownloadTimeout time.Duration
+
+	// How long upstream download URLs are reused (capped by signed URL expiry, 0 disables)
+	DownloadURLTTL time.Duration
+
+	// How long a SHA256SUMS file that could not be fetched is not requested again (0 retries every time)
+	ShasumsRetry time.Duration
+
+	// How long rendered {version}.json documents are reused until a new hash is stored (0 disables)
+	VersionCacheTTL time.Duration
+
+	// User-Agent and extra headers sent to upstream
+	UserAgent       string
+	UpstreamHeaders map[string]string
+
+	// Provider aliases ("oldns/oldname" -> "newns/newname")
+	ProviderAliases map[string]string
+
+	// Providers served from GitHub release assets ("namespace/name" -> "owner/repo")
+	GitHubProviders map[string]string
+	GitHubAPIURL    string
+	GitHubToken     string
+
+	// Providers served from OCI registries ("namespace/name" -> "registry/repository")
+	OCIProviders map[string]string
+	OCIUsername  string
+	OCIPassword  string
+
+	// Hostnames accepted in /v1/providers/{hostname}/... (defaults to the upstream host)
+	AllowedHostnames []string
+
+	// Hosts that archives and shasums may be downloaded from ("*.example.com" wildcards, "*" for any)
+	DownloadAllowedHosts []string
+
+	// Upstream circuit breaker: open after N consecutive failures for the cooldown period (0 disables)
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Requests per second to the upstream registry host and their burst (0 disables);
+	// requests queue for their turn and for a 429's Retry-After up to UpstreamRateWait
+	UpstreamRateLimit int
+	UpstreamRateBurst int
+	UpstreamRateWait  time.Duration
+
 	// SOCKS5 Proxy (optional, for accessing blocked registries)
 	SOCKS5Addr string
 
+	// Record upstream responses to a directory, or replay them from one without network access
+	UpstreamRecordDir string
+	UpstreamReplayDir string
+
+	// Address family of direct upstream connections: "any", "ipv4", "ipv6", "prefer-ipv4" or "prefer-ipv6"
+	UpstreamIPFamily string
+
+	// DNS servers ("ip[:port]") or a DNS-over-HTTPS URL resolving upstream hosts instead of the system's
+	UpstreamDNS []string
+	UpstreamDoH string
+
+	// Upstream connections: dial timeout, happy eyeballs fallback delay (0 disables),
+	// connections per host (0 is unlimited) and idle ones kept for reuse,
+	// TLS sessions kept for resumption (0 disables) and HTTP/2 negotiation
+	UpstreamDialTimeout time.Duration
+	UpstreamFallback    time.Duration
+	UpstreamMaxConns    int
+	UpstreamMaxIdle     int
+	UpstreamTLSSessions int
+	UpstreamHTTP2       bool
+
 	// Cache
 	CacheEnabled bool
 	CacheDir     string
 
+	// Flush cached files to disk before a write completes
+	CacheFsync bool
+
+	// Free space kept on the cache volume; below it archives are served but not cached
+	CacheMinFree int64
+
+	// Archive cache limits (0 = unlimited)
+	// NamespaceQuotas maps namespace (or "*" for any other) to its byte quota
+	CacheMaxSize    int64
+	NamespaceQuotas map[string]int64
+
+	// Encryption at rest of cached archives (AES-256-GCM): a base64-encoded 256-bit key, given
+	// directly or printed by a command (e.g. a KMS call), and the namespaces encrypted ("*" for all)
+	CacheEncryptionKey string
+	CacheKeyCommand    string
+	CacheEncryption    []string
+
+	// Shared object store below the local archive cache (S3-compatible bucket URL):
+	// archives are written through to it and promoted to local disk when missing there
+	ObjectStoreURL       string
+	ObjectStoreRegion    string
+	ObjectStoreAccessKey string
+	ObjectStoreSecret    string
+	ObjectStoreTimeout   time.Duration
+
+	// Clients (CIDR ranges or addresses) redirected to presigned object store URLs
+	// instead of receiving archives through the mirror, and how long those URLs are valid
+	ObjectStoreRedirect []string
+	PresignTTL          time.Duration
+
+	// Archives up to this size are buffered in memory instead of a temp file
+	SpoolMemoryLimit int64
+
+	// Spool directory for large downloads ("" for the system temp dir) and the space kept free there
+	TmpDir     string
+	TmpMinFree int64
+
+	// Refuse archives whose h1 hash cannot be calculated (e.g. corrupt zips)
+	RequireHash bool
+
+	// Refuse archives Terraform could not install from: no provider executable at the root,
+	// one without an executable bit, or entries with backslashes or outside the archive
+	CheckArchives bool
+
+	// Namespaces whose archives are normalized ("*" for all): only the provider executable and
+	// license files are kept, with fixed timestamps and modes, so hashes are reproducible
+	NormalizeArchives []string
+
+	// Scanner run on new archives before they are served: a command (given the archive path)
+	// or a URL the archive is POSTed to; rejected archives are quarantined
+	ScanCommand string
+	ScanURL     string
+	ScanTimeout time.Duration
+
+	// Hash pre-warming (compute h1 for all platforms when {version}.json is requested)
+	PrewarmHashes bool
+
+	// Background h1 hashing of cached archives without a hash: archives hashed at once
+	// (0 disables) and how often the cache is scanned
+	HashWorkers        int
+	HashWorkerInterval time.Duration
+
+	// CPUs hashing one archive may use (0 for GOMAXPROCS, 1 hashes serially)
+	HashConcurrency int
+
+	// Background downloads (pre-warming and prefetch)
+	FetchConcurrency int
+	FetchRetries     int
+
+	// Concurrent upstream archive downloads (0 = unlimited) and how many more may wait;
+	// requests beyond the queue get 503 with Retry-After
+	MaxDownloads       int
+	DownloadQueueDepth int
+
+	// Prefetch list (one provider per line), re-read and downloaded every PrefetchInterval
+	PrefetchFile      string
+	PrefetchInterval  time.Duration
+	PrefetchPlatforms []string
+
+	// Provider documentation proxy (/docs)
+	DocsEnabled bool
+
+	// Deny-list of vulnerable provider versions (file path or http(s) URL), reloaded every DenyListRefresh
+	DenyList        string
+	DenyListRefresh time.Duration
+
+	// Deprecated providers and versions (JSON file); still served, with a Warning header
+	DeprecationsFile string
+
+	// Rules for Terraform and OpenTofu versions, matched by User-Agent (JSON file)
+	ClientRulesFile string
+
+	// Hub-and-spoke replication: a hub serves the Registry API to downstream mirrors,
+	// a spoke (upstream URL pointing at the hub) copies the hub's cache every ReplicateInterval
+	RegistryAPIEnabled bool
+	ReplicateEnabled   bool
+	ReplicateInterval  time.Duration
+	ReplicateToken     string
+
+	// Sibling mirrors (base URLs) asked for cached archives before downloading upstream, and the
+	// secret shared by all mirrors that signs peer and shard requests between them
+	Peers      []string
+	PeerSecret string
+
+	// Replicas (base URLs, the same list on every replica) sharing providers by consistent hashing,
+	// and this replica's entry; archives of providers owned by another replica are fetched through it
+	ShardNodes []string
+	ShardSelf  string
+
+	// Every distinct upstream version list is kept in {CacheDir}/metadata.db;
+	// Snapshot pins the mirror to the lists as of a date or RFC 3339 time (empty serves upstream)
+	SnapshotsEnabled bool
+	Snapshot         string
+
+	// Serve only what is already cached and never introduce versions or archives from upstream
+	FreezeEnabled bool
+
+	// How often in-memory state (download URLs, upstream health, hash failures) is saved
+	// to {CacheDir}/metadata.db for warm restarts (0 disables)
+	StateInterval time.Duration
+
+	// Download statistics stored in {CacheDir}/stats.db, pruned after StatsRetention
+	StatsEnabled   bool
+	StatsRetention time.Duration
+
+	// Metrics exporter ("none", "statsd" or "dogstatsd")
+	MetricsExporter string
+	StatsDAddr      string
+	MetricsPrefix   string
+
+	// Admin API bearer token (empty leaves /admin unauthenticated)
+	AdminToken string
+
+	// Tenants file (JSON); when set, mirror requests need a tenant token or client certificate
+	TenantsFile string
+
+	// HMAC secret for mirror-issued tenant tokens (terraform login, credentials helper),
+	// their lifetime and how long refreshing extends them past the login; empty disables token issuance
+	TokenSecret string
+	TokenTTL    time.Duration
+	TokenMaxAge time.Duration
+
+	// OpenID Connect issuer whose JWTs authenticate tenants (e.g. CI workload identities),
+	// the audience they must be issued for, the claim matched against tenant subjects and
+	// the JWKS signing keys (discovered from the issuer when empty) with their cache lifetime
+	OIDCIssuer   string
+	OIDCAudience string
+	OIDCClaim    string
+	OIDCJWKSURL  string
+	OIDCJWKSTTL  time.Duration
+
+	// Ed25519 private key (PEM, PKCS#8) for signing index.json and {version}.json; empty disables signing
+	SigningKey string
+
+	// Proxies (CIDR ranges or addresses) whose X-Forwarded-For and X-Real-IP headers are trusted
+	TrustedProxies []string
+
+	// Network ACLs (CIDR ranges or addresses) checked against the client address before authentication:
+	// AllowCIDRs (empty allows all) and DenyCIDRs apply to every route, the admin lists additionally to /admin/
+	AllowCIDRs      []string
+	DenyCIDRs       []string
+	AdminAllowCIDRs []string
+	AdminDenyCIDRs  []string
+
+	// Browser access (CORS): allowed origins ("*" for any; empty disables), methods and request headers,
+	// and how long browsers may cache a preflight response
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+	CORSMaxAge  time.Duration
+
+	// Extra headers added to every response (e.g. security headers)
+	ResponseHeaders map[string]string
+
 	// Logging
 	LogLevel string
+
+	// Finished admin jobs kept in the job history
+	JobHistory int
+
+	// Anonymization of client addresses and identities in logs: none, truncate or hash
+	LogAnonymize string
+
+	// Key for the daily hashes of anonymized client data (random per start when empty)
+	LogAnonymizeSecret string
+
+	// Effective values of all settings and the values Load could not parse
+	settings []setting
+	problems Errors
 }
 
 // Load loads configuration from environment variables
+// Values that cannot be parsed fall back to their defaults and are reported by Validate
 func Load() *Config {
-	return &Config{
-		ListenAddr:      getEnv("TF_MIRROR_LISTEN", ":8080"),
-		ReadTimeout:     getDurationEnv("TF_MIRROR_READ_TIMEOUT", 30*time.Second),
-		WriteTimeout:    getDurationEnv("TF_MIRROR_WRITE_TIMEOUT", 300*time.Second),
-		UpstreamURL:     getEnv("TF_MIRROR_UPSTREAM_URL", "https://registry.terraform.io"),
-		UpstreamTimeout: getDurationEnv("TF_MIRROR_UPSTREAM_TIMEOUT", 60*time.Second),
-		SOCKS5Addr:      getEnv("TF_MIRROR_SOCKS5_ADDR", ""),
-		CacheEnabled:    getBoolEnv("TF_MIRROR_CACHE_ENABLED", true),
-		CacheDir:        getEnv("TF_MIRROR_CACHE_DIR", "./cache"),
-		LogLevel:        getEnv("TF_MIRROR_LOG_LEVEL", "info"),
+	e := &env{}
+	upstreamURL := e.getEnv("TF_MIRROR_UPSTREAM_URL", "https://registry.terraform.io")
+
+	cfg := &Config{
+		ListenAddrs:          e.getListEnv("TF_MIRROR_LISTEN", []string{":8080"}),
+		AdminListenAddrs:     e.getListEnv("TF_MIRROR_ADMIN_LISTEN", nil),
+		ReadTimeout:          e.getDurationEnv("TF_MIRROR_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:         e.getDurationEnv("TF_MIRROR_WRITE_TIMEOUT", 0),
+		ReadHeaderTimeout:    e.getDurationEnv("TF_MIRROR_READ_HEADER_TIMEOUT", 10*time.Second),
+		IdleTimeout:          e.getDurationEnv("TF_MIRROR_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:       int(e.getSizeEnv("TF_MIRROR_MAX_HEADER_BYTES", 1<<20)),
+		MaxConnections:       e.getIntEnv("TF_MIRROR_MAX_CONNECTIONS", 0),
+		TLSCert:              e.getEnv("TF_MIRROR_TLS_CERT", ""),
+		TLSKey:               e.getEnv("TF_MIRROR_TLS_KEY", ""),
+		TLSClientCA:          e.getEnv("TF_MIRROR_TLS_CLIENT_CA", ""),
+		TLSClientAuth:        e.getEnv("TF_MIRROR_TLS_CLIENT_AUTH", "require"),
+		ClientPolicies:       e.getMapEnv("TF_MIRROR_CLIENT_POLICIES"),
+		RequestTimeout:       e.getDurationEnv("TF_MIRROR_REQUEST_TIMEOUT", 60*time.Second),
+		DownloadIdleTimeout:  e.getDurationEnv("TF_MIRROR_DOWNLOAD_IDLE_TIMEOUT", 60*time.Second),
+		HTTP2Enabled:         e.getBoolEnv("TF_MIRROR_HTTP2", false),
+		HTTP2MaxStreams:      e.getIntEnv("TF_MIRROR_HTTP2_MAX_STREAMS", 250),
+		ExternalURL:          strings.TrimSuffix(e.getEnv("TF_MIRROR_EXTERNAL_URL", ""), "/"),
+		BasePath:             basePath(e.getEnv("TF_MIRROR_BASE_PATH", "")),
+		ArchiveMaxAge:        e.getDurationEnv("TF_MIRROR_ARCHIVE_MAX_AGE", 365*24*time.Hour),
+		AttributionHeaders:   e.getBoolEnv("TF_MIRROR_ATTRIBUTION_HEADERS", true),
+		CDNURL:               strings.TrimSuffix(e.getEnv("TF_MIRROR_CDN_URL", ""), "/"),
+		SignedURLSecret:      e.getEnv("TF_MIRROR_SIGNED_URL_SECRET", ""),
+		SignedURLTTL:         e.getDurationEnv("TF_MIRROR_SIGNED_URL_TTL", time.Hour),
+		DownloadLinkSecret:   e.getEnv("TF_MIRROR_DOWNLOAD_LINK_SECRET", ""),
+		DownloadLinkTTL:      e.getDurationEnv("TF_MIRROR_DOWNLOAD_LINK_TTL", time.Hour),
+		UpstreamURL:          upstreamURL,
+		UpstreamTimeout:      e.getDurationEnv("TF_MIRROR_UPSTREAM_TIMEOUT", 60*time.Second),
+		DownloadURLTTL:       e.getDurationEnv("TF_MIRROR_DOWNLOAD_URL_TTL", time.Hour),
+		UpstreamType:         e.getEnv("TF_MIRROR_UPSTREAM_TYPE", "registry"),
+		UpstreamRepo:         e.getEnv("TF_MIRROR_UPSTREAM_REPO", ""),
+		UpstreamToken:        e.getEnv("TF_MIRROR_UPSTREAM_TOKEN", ""),
+		UpstreamUsername:     e.getEnv("TF_MIRROR_UPSTREAM_USERNAME", ""),
+		UpstreamPassword:     e.getEnv("TF_MIRROR_UPSTREAM_PASSWORD", ""),
+		DownloadTimeout:      e.getDurationEnv("TF_MIRROR_DOWNLOAD_TIMEOUT", 5*time.Minute),
+		ShasumsRetry:         e.getDurationEnv("TF_MIRROR_SHASUMS_RETRY", 15*time.Minute),
+		VersionCacheTTL:      e.getDurationEnv("TF_MIRROR_VERSION_CACHE_TTL", time.Hour),
+		UserAgent:            e.getEnv("TF_MIRROR_USER_AGENT", buildinfo.UserAgent()),
+		UpstreamHeaders:      e.getMapEnv("TF_MIRROR_UPSTREAM_HEADERS"),
+		ProviderAliases:      e.getMapEnv("TF_MIRROR_PROVIDER_ALIASES"),
+		GitHubProviders:      e.getMapEnv("TF_MIRROR_GITHUB_PROVIDERS"),
+		GitHubAPIURL:         strings.TrimSuffix(e.getEnv("TF_MIRROR_GITHUB_API_URL", "https://api.github.com"), "/"),
+		GitHubToken:          e.getEnv("TF_MIRROR_GITHUB_TOKEN", ""),
+		OCIProviders:         e.getMapEnv("TF_MIRROR_OCI_PROVIDERS"),
+		OCIUsername:          e.getEnv("TF_MIRROR_OCI_USERNAME", ""),
+		OCIPassword:          e.getEnv("TF_MIRROR_OCI_PASSWORD", ""),
+		AllowedHostnames:     e.getListEnv("TF_MIRROR_ALLOWED_HOSTNAMES", []string{hostOf(upstreamURL)}),
+		DownloadAllowedHosts: e.getListEnv("TF_MIRROR_DOWNLOAD_ALLOWED_HOSTS", []string{"releases.hashicorp.com", "github.com", "objects.githubusercontent.com", "release-assets.githubusercontent.com"}),
+		BreakerThreshold:     e.getIntEnv("TF_MIRROR_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:      e.getDurationEnv("TF_MIRROR_BREAKER_COOLDOWN", 30*time.Second),
+		UpstreamRateLimit:    e.getIntEnv("TF_MIRROR_UPSTREAM_RATE_LIMIT", 0),
+		UpstreamRateBurst:    e.getIntEnv("TF_MIRROR_UPSTREAM_RATE_BURST", 0),
+		UpstreamRateWait:     e.getDurationEnv("TF_MIRROR_UPSTREAM_RATE_WAIT", 30*time.Second),
+		SOCKS5Addr:           e.getEnv("TF_MIRROR_SOCKS5_ADDR", ""),
+		UpstreamRecordDir:    e.getEnv("TF_MIRROR_UPSTREAM_RECORD", ""),
+		UpstreamReplayDir:    e.getEnv("TF_MIRROR_UPSTREAM_REPLAY", ""),
+		UpstreamIPFamily:     e.getEnv("TF_MIRROR_UPSTREAM_IP_FAMILY", "any"),
+		UpstreamDNS:          e.getListEnv("TF_MIRROR_UPSTREAM_DNS", nil),
+		UpstreamDoH:          e.getEnv("TF_MIRROR_UPSTREAM_DOH", ""),
+		UpstreamDialTimeout:  e.getDurationEnv("TF_MIRROR_UPSTREAM_DIAL_TIMEOUT", 30*time.Second),
+		UpstreamFallback:     e.getDurationEnv("TF_MIRROR_UPSTREAM_FALLBACK_DELAY", 300*time.Millisecond),
+		UpstreamMaxConns:     e.getIntEnv("TF_MIRROR_UPSTREAM_MAX_CONNS_PER_HOST", 0),
+		UpstreamMaxIdle:      e.getIntEnv("TF_MIRROR_UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32),
+		UpstreamTLSSessions:  e.getIntEnv("TF_MIRROR_UPSTREAM_TLS_SESSION_CACHE", 64),
+		UpstreamHTTP2:        e.getBoolEnv("TF_MIRROR_UPSTREAM_HTTP2", true),
+		CacheEnabled:         e.getBoolEnv("TF_MIRROR_CACHE_ENABLED", false),
+		CacheDir:             e.getEnv("TF_MIRROR_CACHE_DIR", "./cache"),
+		CacheFsync:           e.getBoolEnv("TF_MIRROR_CACHE_FSYNC", true),
+		CacheMinFree:         e.getSizeEnv("TF_MIRROR_CACHE_MIN_FREE", 1<<30),
+		CacheMaxSize:         e.getSizeEnv("TF_MIRROR_CACHE_MAX_SIZE", 0),
+		NamespaceQuotas:      e.getSizeMapEnv("TF_MIRROR_NAMESPACE_QUOTAS"),
+		CacheEncryptionKey:   e.getEnv("TF_MIRROR_CACHE_ENCRYPTION_KEY", ""),
+		CacheKeyCommand:      strings.TrimSpace(e.getEnv("TF_MIRROR_CACHE_ENCRYPTION_KEY_COMMAND", "")),
+		CacheEncryption:      e.getListEnv("TF_MIRROR_CACHE_ENCRYPTION_NAMESPACES", []string{"*"}),
+		ObjectStoreURL:       e.getEnv("TF_MIRROR_OBJECT_STORE_URL", ""),
+		ObjectStoreRegion:    e.getEnv("TF_MIRROR_OBJECT_STORE_REGION", "us-east-1"),
+		ObjectStoreAccessKey: e.getEnv("TF_MIRROR_OBJECT_STORE_ACCESS_KEY", ""),
+		ObjectStoreSecret:    e.getEnv("TF_MIRROR_OBJECT_STORE_SECRET", ""),
+		ObjectStoreTimeout:   e.getDurationEnv("TF_MIRROR_OBJECT_STORE_TIMEOUT", 5*time.Minute),
+		ObjectStoreRedirect:  e.getListEnv("TF_MIRROR_OBJECT_STORE_REDIRECT", nil),
+		PresignTTL:           e.getDurationEnv("TF_MIRROR_OBJECT_STORE_REDIRECT_TTL", 15*time.Minute),
+		SpoolMemoryLimit:     e.getSizeEnv("TF_MIRROR_SPOOL_MEMORY_LIMIT", 10<<20),
+		TmpDir:               e.getEnv("TF_MIRROR_TMP_DIR", ""),
+		TmpMinFree:           e.getSizeEnv("TF_MIRROR_TMP_MIN_FREE", 100<<20),
+		RequireHash:          e.getBoolEnv("TF_MIRROR_REQUIRE_HASH", false),
+		CheckArchives:        e.getBoolEnv("TF_MIRROR_CHECK_ARCHIVES", true),
+		NormalizeArchives:    e.getListEnv("TF_MIRROR_NORMALIZE_NAMESPACES", nil),
+		ScanCommand:          e.getEnv("TF_MIRROR_SCAN_COMMAND", ""),
+		ScanURL:              e.getEnv("TF_MIRROR_SCAN_URL", ""),
+		ScanTimeout:          e.getDurationEnv("TF_MIRROR_SCAN_TIMEOUT", 5*time.Minute),
+		PrewarmHashes:        e.getBoolEnv("TF_MIRROR_PREWARM_HASHES", false),
+		HashWorkers:          e.getIntEnv("TF_MIRROR_HASH_WORKERS", 1),
+		HashConcurrency:      e.getIntEnv("TF_MIRROR_HASH_CONCURRENCY", 0),
+		HashWorkerInterval:   e.getDurationEnv("TF_MIRROR_HASH_WORKER_INTERVAL", time.Hour),
+		FetchConcurrency:     e.getIntEnv("TF_MIRROR_FETCH_CONCURRENCY", e.getIntEnv("TF_MIRROR_PREWARM_CONCURRENCY", 2)),
+		FetchRetries:         e.getIntEnv("TF_MIRROR_FETCH_RETRIES", 3),
+		MaxDownloads:         e.getIntEnv("TF_MIRROR_MAX_DOWNLOADS", 32),
+		DownloadQueueDepth:   e.getIntEnv("TF_MIRROR_DOWNLOAD_QUEUE_DEPTH", 256),
+		PrefetchFile:         e.getEnv("TF_MIRROR_PREFETCH_FILE", ""),
+		PrefetchInterval:     e.getDurationEnv("TF_MIRROR_PREFETCH_INTERVAL", 24*time.Hour),
+		PrefetchPlatforms:    e.getListEnv("TF_MIRROR_PREFETCH_PLATFORMS", nil),
+		DocsEnabled:          e.getBoolEnv("TF_MIRROR_DOCS_ENABLED", false),
+		DenyList:             e.getEnv("TF_MIRROR_DENYLIST", ""),
+		DenyListRefresh:      e.getDurationEnv("TF_MIRROR_DENYLIST_REFRESH", time.Hour),
+		DeprecationsFile:     e.getEnv("TF_MIRROR_DEPRECATIONS", ""),
+		ClientRulesFile:      e.getEnv("TF_MIRROR_CLIENT_RULES", ""),
+		RegistryAPIEnabled:   e.getBoolEnv("TF_MIRROR_REGISTRY_API", false),
+		ReplicateEnabled:     e.getBoolEnv("TF_MIRROR_REPLICATE", false),
+		ReplicateInterval:    e.getDurationEnv("TF_MIRROR_REPLICATE_INTERVAL", time.Hour),
+		ReplicateToken:       e.getEnv("TF_MIRROR_REPLICATE_TOKEN", ""),
+		Peers:                e.getListEnv("TF_MIRROR_PEERS", nil),
+		PeerSecret:           e.getEnv("TF_MIRROR_PEER_SECRET", ""),
+		ShardNodes:           e.getListEnv("TF_MIRROR_SHARD_NODES", nil),
+		ShardSelf:            e.getEnv("TF_MIRROR_SHARD_SELF", ""),
+		StateInterval:        e.getDurationEnv("TF_MIRROR_STATE_INTERVAL", time.Minute),
+		SnapshotsEnabled:     e.getBoolEnv("TF_MIRROR_SNAPSHOTS", true),
+		FreezeEnabled:        e.getBoolEnv("TF_MIRROR_FREEZE", false),
+		Snapshot:             e.getEnv("TF_MIRROR_SNAPSHOT", ""),
+		StatsEnabled:         e.getBoolEnv("TF_MIRROR_STATS_ENABLED", true),
+		StatsRetention:       e.getDurationEnv("TF_MIRROR_STATS_RETENTION", 90*24*time.Hour),
+		MetricsExporter:      e.getEnv("TF_MIRROR_METRICS_EXPORTER", "none"),
+		StatsDAddr:           e.getEnv("TF_MIRROR_STATSD_ADDR", "127.0.0.1:8125"),
+		MetricsPrefix:        e.getEnv("TF_MIRROR_METRICS_PREFIX", "tf_mirror."),
+		AdminToken:           e.getEnv("TF_MIRROR_ADMIN_TOKEN", ""),
+		TenantsFile:          e.getEnv("TF_MIRROR_TENANTS_FILE", ""),
+		TokenSecret:          e.getEnv("TF_MIRROR_TOKEN_SECRET", ""),
+		TokenTTL:             e.getDurationEnv("TF_MIRROR_TOKEN_TTL", 7*24*time.Hour),
+		TokenMaxAge:          e.getDurationEnv("TF_MIRROR_TOKEN_MAX_AGE", 90*24*time.Hour),
+		OIDCIssuer:           strings.TrimSuffix(e.getEnv("TF_MIRROR_OIDC_ISSUER", ""), "/"),
+		OIDCAudience:         e.getEnv("TF_MIRROR_OIDC_AUDIENCE", ""),
+		OIDCClaim:            e.getEnv("TF_MIRROR_OIDC_CLAIM", "sub"),
+		OIDCJWKSURL:          e.getEnv("TF_MIRROR_OIDC_JWKS_URL", ""),
+		OIDCJWKSTTL:          e.getDurationEnv("TF_MIRROR_OIDC_JWKS_TTL", time.Hour),
+		SigningKey:           e.getEnv("TF_MIRROR_SIGNING_KEY", ""),
+		TrustedProxies:       e.getListEnv("TF_MIRROR_TRUSTED_PROXIES", nil),
+		AllowCIDRs:           e.getListEnv("TF_MIRROR_ALLOW_CIDRS", nil),
+		DenyCIDRs:            e.getListEnv("TF_MIRROR_DENY_CIDRS", nil),
+		AdminAllowCIDRs:      e.getListEnv("TF_MIRROR_ADMIN_ALLOW_CIDRS", nil),
+		AdminDenyCIDRs:       e.getListEnv("TF_MIRROR_ADMIN_DENY_CIDRS", nil),
+		CORSOrigins:          e.getListEnv("TF_MIRROR_CORS_ORIGINS", nil),
+		CORSMethods:          e.getListEnv("TF_MIRROR_CORS_METHODS", []string{"GET", "HEAD"}),
+		CORSHeaders:          e.getListEnv("TF_MIRROR_CORS_HEADERS", []string{"Authorization"}),
+		CORSMaxAge:           e.getDurationEnv("TF_MIRROR_CORS_MAX_AGE", time.Hour),
+		ResponseHeaders:      e.getMapEnv("TF_MIRROR_RESPONSE_HEADERS"),
+		LogLevel:             e.getEnv("TF_MIRROR_LOG_LEVEL", "info"),
+		JobHistory:           e.getIntEnv("TF_MIRROR_JOB_HISTORY", 50),
+		LogAnonymize:         e.getEnv("TF_MIRROR_LOG_ANONYMIZE", "none"),
+		LogAnonymizeSecret:   e.getEnv("TF_MIRROR_LOG_ANONYMIZE_SECRET", ""),
+	}
+	cfg.settings = e.settings
+	cfg.problems = e.problems
+	return cfg
+}
+
+// env reads settings from environment variables,
+// recording their effective values and the ones that cannot be parsed
+type env struct {
+	settings []setting
+	problems Errors
+}
+
+// set records the effective value of a setting
+func (e *env) set(key, value string) {
+	e.settings = append(e.settings, setting{Key: key, Value: value})
+}
+
+// invalid records a value that cannot be used; the setting keeps its default
+func (e *env) invalid(key, value, reason string) {
+	e.problems = append(e.problems, &FieldError{Key: key, Value: value, Reason: reason})
+}
+
+func (e *env) getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		value = defaultValue
+	}
+	e.set(key, value)
+	return value
+}
+
+func (e *env) getBoolEnv(key string, defaultValue bool) bool {
+	result := defaultValue
+	if value := os.Getenv(key); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			e.invalid(key, value, "expected true or false")
+		} else {
+			result = b
+		}
+	}
+	e.set(key, strconv.FormatBool(result))
+	return result
+}
+
+// getListEnv parses a comma-separated list, ignoring empty items
+func (e *env) getListEnv(key string, defaultValue []string) []string {
+	result := splitList(os.Getenv(key))
+	if result == nil {
+		result = defaultValue
+	}
+	e.set(key, strings.Join(result, ","))
+	return result
+}
+
+// getMapEnv parses comma-separated "key=value" pairs
+func (e *env) getMapEnv(key string) map[string]string {
+	result := make(map[string]string)
+	for _, item := range splitList(os.Getenv(key)) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			e.invalid(key, os.Getenv(key), fmt.Sprintf("item %q is not key=value", item))
+			continue
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
 	}
+	e.set(key, joinMap(result))
+	return result
 }
 
-func getEnv(key, defaultValue string) string {
+func (e *env) getIntEnv(key string, defaultValue int) int {
+	result := defaultValue
 	if value := os.Getenv(key); value != "" {
-		return value
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.invalid(key, value, "expected an integer")
+		} else {
+			result = n
+		}
 	}
-	return defaultValue
+	e.set(key, strconv.Itoa(result))
+	return result
 }
 
-func getBoolEnv(key string, defaultValue bool) bool {
+// getSizeEnv parses a byte size such as "10MB", "512KB" or "1048576"
+func (e *env) getSizeEnv(key string, defaultValue int64) int64 {
+	result := defaultValue
 	if value := os.Getenv(key); value != "" {
-		return value == "true" || value == "1"
+		n, ok := parseSize(value)
+		if !ok {
+			e.invalid(key, value, "expected a size such as 512, 10MB or 5GB")
+		} else {
+			result = n
+		}
+	}
+	e.set(key, formatSize(result))
+	return result
+}
+
+// getSizeMapEnv parses "key=size,..." pairs
+func (e *env) getSizeMapEnv(key string) map[string]int64 {
+	result := make(map[string]int64)
+	values := make(map[string]string)
+	for _, item := range splitList(os.Getenv(key)) {
+		k, v, ok := strings.Cut(item, "=")
+		n, valid := parseSize(v)
+		if !ok || !valid || strings.TrimSpace(k) == "" {
+			e.invalid(key, os.Getenv(key), fmt.Sprintf("item %q is not key=size", item))
+			continue
+		}
+		result[strings.TrimSpace(k)] = n
+		values[strings.TrimSpace(k)] = formatSize(n)
 	}
-	return defaultValue
+	e.set(key, joinMap(values))
+	return result
 }
 
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+func (e *env) getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	result := defaultValue
 	if value := os.Getenv(key); value != "" {
-		if d, err := time.ParseDuration(value); err == nil {
-			return d
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.invalid(key, value, "expected a duration such as 30s, 5m or 24h")
+		} else {
+			result = d
+		}
+	}
+	e.set(key, result.String())
+	return result
+}
+
+// splitList splits a comma-separated list, ignoring empty items
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
 		}
 	}
-	return defaultValue
+	return result
+}
+
+// joinMap formats a map as sorted "key=value" pairs
+func joinMap(m map[string]string) string {
+	items := make([]string, 0, len(m))
+	for k, v := range m {
+		items = append(items, k+"="+v)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
 }
 
+// parseSize parses sizes like "512", "10MB" or "5GB"
+func parseSize(s string) (int64, bool) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	if value == "" {
+		return 0, false
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.size
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
+// formatSize formats a byte size with the largest unit that divides it
+func formatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+	} {
+		if n != 0 && n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// basePath normalizes a route prefix to "/prefix" without a trailing slash
+func basePath(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), "/")
+	if value == "" {
+		return ""
+	}
+	return "/" + value
+}
+
+// hostOf returns the host[:port] part of a URL
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// redacted replaces secret values in printed configuration and errors
+const redacted = "<redacted>"
+
+// setting is the effective value of one environment variable
+type setting struct {
+	Key   string
+	Value string
+}
+
+// Print writes the effective configuration as KEY=value lines, sorted by key, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	settings := append([]setting(nil), c.settings...)
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+
+	for _, s := range settings {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", s.Key, redact(s.Key, s.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redact hides the values of secret settings (*_TOKEN, *_PASSWORD, *_SECRET, the cache
+// encryption key), of upstream headers and the passwords of URLs and addresses
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	if key == "TF_MIRROR_CACHE_ENCRYPTION_KEY" {
+		return redacted
+	}
+	if key == "TF_MIRROR_UPSTREAM_HEADERS" {
+		items := strings.Split(value, ",")
+		for i, item := range items {
+			if k, _, ok := strings.Cut(item, "="); ok {
+				items[i] = k + "=" + redacted
+			}
+		}
+		return strings.Join(items, ",")
+	}
+	for _, suffix := range []string{"_TOKEN", "_PASSWORD", "_SECRET"} {
+		if strings.HasSuffix(key, suffix) {
+			return redacted
+		}
+	}
+	return redactPasswords(key, value)
+}
+
+// redactPasswords hides the user:pass@ passwords of URLs in a value, item by item for lists,
+// and of *_ADDR addresses, which have no scheme
+func redactPasswords(key, value string) string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		start := 0
+		if j := strings.Index(item, "://"); j >= 0 {
+			start = j + len("://")
+		} else if !strings.HasSuffix(key, "_ADDR") {
+			continue
+		}
+		end := len(item)
+		if j := strings.IndexAny(item[start:], "/?#"); j >= 0 {
+			end = start + j
+		}
+		at := strings.LastIndex(item[start:end], "@")
+		if at < 0 {
+			continue
+		}
+		if user, _, ok := strings.Cut(item[start:start+at], ":"); ok {
+			items[i] = item[:start] + user + ":" + redacted + item[start+at:]
+		}
+	}
+	return strings.Join(items, ",")
+}
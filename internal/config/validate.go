@@ -0,0 +1,432 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// FieldError is an unusable value of a single setting
+type FieldError struct {
+	Key    string // environment variable
+	Value  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	if e.Value == "" {
+		return e.Key + ": " + e.Reason
+	}
+	return fmt.Sprintf("%s=%q: %s", e.Key, redact(e.Key, e.Value), e.Reason)
+}
+
+// Errors collects every problem found in a configuration
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate reports values Load could not parse and settings that are invalid or contradict each other
+// The returned error is Errors, listing all problems at once
+func (c *Config) Validate() error {
+	problems := append(Errors(nil), c.problems...)
+	fail := func(key, value, reason string) {
+		problems = append(problems, &FieldError{Key: key, Value: value, Reason: reason})
+	}
+
+	for key, d := range map[string]time.Duration{
+		"TF_MIRROR_READ_TIMEOUT":          c.ReadTimeout,
+		"TF_MIRROR_WRITE_TIMEOUT":         c.WriteTimeout,
+		"TF_MIRROR_READ_HEADER_TIMEOUT":   c.ReadHeaderTimeout,
+		"TF_MIRROR_IDLE_TIMEOUT":          c.IdleTimeout,
+		"TF_MIRROR_REQUEST_TIMEOUT":       c.RequestTimeout,
+		"TF_MIRROR_DOWNLOAD_IDLE_TIMEOUT": c.DownloadIdleTimeout,
+		"TF_MIRROR_UPSTREAM_TIMEOUT":      c.UpstreamTimeout,
+		"TF_MIRROR_DOWNLOAD_TIMEOUT":      c.DownloadTimeout,
+		"TF_MIRROR_DOWNLOAD_URL_TTL":      c.DownloadURLTTL,
+		"TF_MIRROR_SHASUMS_RETRY":         c.ShasumsRetry,
+		"TF_MIRROR_VERSION_CACHE_TTL":     c.VersionCacheTTL,
+		"TF_MIRROR_OBJECT_STORE_TIMEOUT":  c.ObjectStoreTimeout,
+		"TF_MIRROR_BREAKER_COOLDOWN":      c.BreakerCooldown,
+		"TF_MIRROR_UPSTREAM_RATE_WAIT":    c.UpstreamRateWait,
+		"TF_MIRROR_PREFETCH_INTERVAL":     c.PrefetchInterval,
+		"TF_MIRROR_HASH_WORKER_INTERVAL":  c.HashWorkerInterval,
+		"TF_MIRROR_DENYLIST_REFRESH":      c.DenyListRefresh,
+		"TF_MIRROR_REPLICATE_INTERVAL":    c.ReplicateInterval,
+		"TF_MIRROR_STATE_INTERVAL":        c.StateInterval,
+		"TF_MIRROR_STATS_RETENTION":       c.StatsRetention,
+		"TF_MIRROR_TOKEN_TTL":             c.TokenTTL,
+		"TF_MIRROR_TOKEN_MAX_AGE":         c.TokenMaxAge,
+		"TF_MIRROR_CORS_MAX_AGE":          c.CORSMaxAge,
+		"TF_MIRROR_ARCHIVE_MAX_AGE":       c.ArchiveMaxAge,
+	} {
+		if d < 0 {
+			fail(key, d.String(), "must not be negative")
+		}
+	}
+	for key, n := range map[string]int{
+		"TF_MIRROR_MAX_CONNECTIONS":      c.MaxConnections,
+		"TF_MIRROR_BREAKER_THRESHOLD":    c.BreakerThreshold,
+		"TF_MIRROR_UPSTREAM_RATE_LIMIT":  c.UpstreamRateLimit,
+		"TF_MIRROR_UPSTREAM_RATE_BURST":  c.UpstreamRateBurst,
+		"TF_MIRROR_FETCH_RETRIES":        c.FetchRetries,
+		"TF_MIRROR_MAX_DOWNLOADS":        c.MaxDownloads,
+		"TF_MIRROR_DOWNLOAD_QUEUE_DEPTH": c.DownloadQueueDepth,
+		"TF_MIRROR_HASH_WORKERS":         c.HashWorkers,
+		"TF_MIRROR_HASH_CONCURRENCY":     c.HashConcurrency,
+		"TF_MIRROR_JOB_HISTORY":          c.JobHistory,
+	} {
+		if n < 0 {
+			fail(key, fmt.Sprint(n), "must not be negative")
+		}
+	}
+	if c.FetchConcurrency < 1 {
+		fail("TF_MIRROR_FETCH_CONCURRENCY", fmt.Sprint(c.FetchConcurrency), "must be at least 1")
+	}
+	if (c.HTTP2Enabled || c.TLSCert != "") && c.HTTP2MaxStreams < 1 {
+		fail("TF_MIRROR_HTTP2_MAX_STREAMS", fmt.Sprint(c.HTTP2MaxStreams), "must be at least 1")
+	}
+	if c.UpstreamDialTimeout <= 0 {
+		fail("TF_MIRROR_UPSTREAM_DIAL_TIMEOUT", c.UpstreamDialTimeout.String(), "must be positive")
+	}
+	if c.UpstreamFallback < 0 {
+		fail("TF_MIRROR_UPSTREAM_FALLBACK_DELAY", c.UpstreamFallback.String(), "must not be negative")
+	}
+	for key, n := range map[string]int{
+		"TF_MIRROR_UPSTREAM_MAX_CONNS_PER_HOST":      c.UpstreamMaxConns,
+		"TF_MIRROR_UPSTREAM_MAX_IDLE_CONNS_PER_HOST": c.UpstreamMaxIdle,
+		"TF_MIRROR_UPSTREAM_TLS_SESSION_CACHE":       c.UpstreamTLSSessions,
+	} {
+		if n < 0 {
+			fail(key, fmt.Sprint(n), "must not be negative")
+		}
+	}
+	if (c.ScanCommand != "" || c.ScanURL != "") && c.ScanTimeout <= 0 {
+		fail("TF_MIRROR_SCAN_TIMEOUT", c.ScanTimeout.String(), "must be positive")
+	}
+	if c.TokenSecret != "" && c.TokenTTL == 0 {
+		fail("TF_MIRROR_TOKEN_TTL", "0s", "must be positive when TF_MIRROR_TOKEN_SECRET is set")
+	}
+	if c.TokenSecret != "" && c.TokenMaxAge < c.TokenTTL {
+		fail("TF_MIRROR_TOKEN_MAX_AGE", c.TokenMaxAge.String(), "must not be shorter than TF_MIRROR_TOKEN_TTL")
+	}
+	if c.OIDCIssuer != "" {
+		if err := checkURL(c.OIDCIssuer); err != nil {
+			fail("TF_MIRROR_OIDC_ISSUER", c.OIDCIssuer, err.Error())
+		}
+		if c.OIDCAudience == "" {
+			fail("TF_MIRROR_OIDC_AUDIENCE", "", "is required with TF_MIRROR_OIDC_ISSUER")
+		}
+		if c.OIDCClaim == "" {
+			fail("TF_MIRROR_OIDC_CLAIM", "", "must not be empty")
+		}
+		if c.OIDCJWKSURL != "" {
+			if err := checkURL(c.OIDCJWKSURL); err != nil {
+				fail("TF_MIRROR_OIDC_JWKS_URL", c.OIDCJWKSURL, err.Error())
+			}
+		}
+		if c.OIDCJWKSTTL <= 0 {
+			fail("TF_MIRROR_OIDC_JWKS_TTL", c.OIDCJWKSTTL.String(), "must be positive")
+		}
+	}
+
+	// URLs
+	for key, value := range map[string]string{
+		"TF_MIRROR_UPSTREAM_URL":   c.UpstreamURL,
+		"TF_MIRROR_GITHUB_API_URL": c.GitHubAPIURL,
+	} {
+		if err := checkURL(value); err != nil {
+			fail(key, value, err.Error())
+		}
+	}
+	if c.ExternalURL != "" {
+		if err := checkURL(c.ExternalURL); err != nil {
+			fail("TF_MIRROR_EXTERNAL_URL", c.ExternalURL, err.Error())
+		}
+	}
+	for _, peer := range c.Peers {
+		if err := checkURL(peer); err != nil {
+			fail("TF_MIRROR_PEERS", peer, err.Error())
+		}
+	}
+	if len(c.Peers) > 0 && c.PeerSecret == "" {
+		fail("TF_MIRROR_PEERS", strings.Join(c.Peers, ","), "requires TF_MIRROR_PEER_SECRET")
+	}
+	for _, node := range c.ShardNodes {
+		if err := checkURL(node); err != nil {
+			fail("TF_MIRROR_SHARD_NODES", node, err.Error())
+		}
+	}
+	if c.ObjectStoreURL != "" {
+		if err := checkURL(c.ObjectStoreURL); err != nil {
+			fail("TF_MIRROR_OBJECT_STORE_URL", c.ObjectStoreURL, err.Error())
+		}
+	}
+	if strings.Contains(c.DenyList, "://") {
+		if err := checkURL(c.DenyList); err != nil {
+			fail("TF_MIRROR_DENYLIST", c.DenyList, err.Error())
+		}
+	}
+
+	// Listeners
+	for key, addrs := range map[string][]string{
+		"TF_MIRROR_LISTEN":       c.ListenAddrs,
+		"TF_MIRROR_ADMIN_LISTEN": c.AdminListenAddrs,
+	} {
+		for _, addr := range addrs {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				fail(key, addr, "expected host:port, e.g. :8080, 0.0.0.0:8080 or [::]:8080")
+			}
+		}
+	}
+
+	for key, values := range map[string][]string{
+		"TF_MIRROR_TRUSTED_PROXIES":       c.TrustedProxies,
+		"TF_MIRROR_OBJECT_STORE_REDIRECT": c.ObjectStoreRedirect,
+		"TF_MIRROR_ALLOW_CIDRS":           c.AllowCIDRs,
+		"TF_MIRROR_DENY_CIDRS":            c.DenyCIDRs,
+		"TF_MIRROR_ADMIN_ALLOW_CIDRS":     c.AdminAllowCIDRs,
+		"TF_MIRROR_ADMIN_DENY_CIDRS":      c.AdminDenyCIDRs,
+	} {
+		for _, value := range values {
+			if _, err := netip.ParsePrefix(value); err != nil {
+				if _, err := netip.ParseAddr(value); err != nil {
+					fail(key, value, "expected an IP address or CIDR range")
+				}
+			}
+		}
+	}
+
+	// Headers
+	for _, origin := range c.CORSOrigins {
+		if origin != "*" && !isOrigin(origin) {
+			fail("TF_MIRROR_CORS_ORIGINS", origin, "expected * or an origin such as https://tools.example.com")
+		}
+	}
+	for _, method := range c.CORSMethods {
+		if !httpguts.ValidHeaderFieldName(method) {
+			fail("TF_MIRROR_CORS_METHODS", method, "expected an HTTP method")
+		}
+	}
+	for _, header := range c.CORSHeaders {
+		if !httpguts.ValidHeaderFieldName(header) {
+			fail("TF_MIRROR_CORS_HEADERS", header, "expected a header name")
+		}
+	}
+	for name, value := range c.ResponseHeaders {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			fail("TF_MIRROR_RESPONSE_HEADERS", name+"="+value, "expected Header-Name=value")
+		}
+	}
+
+	// Choices
+	switch c.UpstreamType {
+	case "registry":
+	case "artifactory":
+		if c.UpstreamRepo == "" {
+			fail("TF_MIRROR_UPSTREAM_REPO", "", "required when TF_MIRROR_UPSTREAM_TYPE is artifactory")
+		}
+	default:
+		fail("TF_MIRROR_UPSTREAM_TYPE", c.UpstreamType, "expected registry or artifactory")
+	}
+	if c.TLSClientAuth != "require" && c.TLSClientAuth != "optional" {
+		fail("TF_MIRROR_TLS_CLIENT_AUTH", c.TLSClientAuth, "expected require or optional")
+	}
+	for identity, policy := range c.ClientPolicies {
+		if policy != "read" && policy != "publish" && policy != "admin" && policy != "deny" {
+			fail("TF_MIRROR_CLIENT_POLICIES", identity+"="+policy, "expected read, publish, admin or deny")
+		}
+	}
+	switch c.UpstreamIPFamily {
+	case "any", "ipv4", "ipv6", "prefer-ipv4", "prefer-ipv6":
+	default:
+		fail("TF_MIRROR_UPSTREAM_IP_FAMILY", c.UpstreamIPFamily, "expected any, ipv4, ipv6, prefer-ipv4 or prefer-ipv6")
+	}
+	for _, server := range c.UpstreamDNS {
+		if _, err := netip.ParseAddrPort(server); err != nil {
+			if _, err := netip.ParseAddr(server); err != nil {
+				fail("TF_MIRROR_UPSTREAM_DNS", server, "expected an IP address with an optional port")
+			}
+		}
+	}
+	if c.ScanURL != "" {
+		if err := checkURL(c.ScanURL); err != nil {
+			fail("TF_MIRROR_SCAN_URL", c.ScanURL, err.Error())
+		} else if c.ScanCommand != "" {
+			fail("TF_MIRROR_SCAN_URL", c.ScanURL, "cannot be combined with TF_MIRROR_SCAN_COMMAND")
+		}
+	}
+	if c.CDNURL != "" {
+		if err := checkURL(c.CDNURL); err != nil {
+			fail("TF_MIRROR_CDN_URL", c.CDNURL, err.Error())
+		}
+	}
+	if c.SignedURLSecret != "" && c.SignedURLTTL <= 0 {
+		fail("TF_MIRROR_SIGNED_URL_TTL", c.SignedURLTTL.String(), "must be positive when TF_MIRROR_SIGNED_URL_SECRET is set")
+	}
+	if c.DownloadLinkSecret != "" && c.DownloadLinkTTL <= 0 {
+		fail("TF_MIRROR_DOWNLOAD_LINK_TTL", c.DownloadLinkTTL.String(), "must be positive when TF_MIRROR_DOWNLOAD_LINK_SECRET is set")
+	}
+	if c.UpstreamDoH != "" {
+		if err := checkURL(c.UpstreamDoH); err != nil {
+			fail("TF_MIRROR_UPSTREAM_DOH", c.UpstreamDoH, err.Error())
+		} else if len(c.UpstreamDNS) > 0 {
+			fail("TF_MIRROR_UPSTREAM_DOH", c.UpstreamDoH, "cannot be combined with TF_MIRROR_UPSTREAM_DNS")
+		}
+	}
+	switch c.MetricsExporter {
+	case "none", "statsd", "dogstatsd":
+	default:
+		fail("TF_MIRROR_METRICS_EXPORTER", c.MetricsExporter, "expected none, statsd or dogstatsd")
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("TF_MIRROR_LOG_LEVEL", c.LogLevel, "expected debug, info, warn or error")
+	}
+	switch c.LogAnonymize {
+	case "none", "truncate", "hash":
+	default:
+		fail("TF_MIRROR_LOG_ANONYMIZE", c.LogAnonymize, "expected none, truncate or hash")
+	}
+
+	// Provider maps
+	for key, m := range map[string]map[string]string{
+		"TF_MIRROR_PROVIDER_ALIASES": c.ProviderAliases,
+		"TF_MIRROR_GITHUB_PROVIDERS": c.GitHubProviders,
+	} {
+		for from, to := range m {
+			if !isPair(from) || !isPair(to) {
+				fail(key, from+"="+to, "expected namespace/name=namespace/name")
+			}
+		}
+	}
+
+	for provider, repo := range c.OCIProviders {
+		if !isPair(provider) || !isOCIRepository(repo) {
+			fail("TF_MIRROR_OCI_PROVIDERS", provider+"="+repo, "expected namespace/name=registry/repository")
+		}
+	}
+
+	// Combinations
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		fail("TF_MIRROR_TLS_CERT", c.TLSCert, "TF_MIRROR_TLS_CERT and TF_MIRROR_TLS_KEY must be set together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		fail("TF_MIRROR_TLS_CLIENT_CA", c.TLSClientCA, "requires TF_MIRROR_TLS_CERT and TF_MIRROR_TLS_KEY")
+	}
+	if c.UpstreamRecordDir != "" && c.UpstreamReplayDir != "" {
+		fail("TF_MIRROR_UPSTREAM_RECORD", c.UpstreamRecordDir, "cannot be combined with TF_MIRROR_UPSTREAM_REPLAY")
+	}
+	if c.CacheEncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(c.CacheEncryptionKey)); err != nil || len(key) != 32 {
+			fail("TF_MIRROR_CACHE_ENCRYPTION_KEY", c.CacheEncryptionKey, "expected a base64-encoded 32-byte key")
+		} else if c.CacheKeyCommand != "" {
+			fail("TF_MIRROR_CACHE_ENCRYPTION_KEY", c.CacheEncryptionKey, "cannot be combined with TF_MIRROR_CACHE_ENCRYPTION_KEY_COMMAND")
+		}
+	}
+	if (c.CacheEncryptionKey != "" || c.CacheKeyCommand != "") && !c.CacheEnabled {
+		fail("TF_MIRROR_CACHE_ENCRYPTION_KEY", c.CacheEncryptionKey, "requires TF_MIRROR_CACHE_ENABLED")
+	}
+	for _, ns := range c.CacheEncryption {
+		if ns == "" || strings.ContainsAny(ns, "/*") && ns != "*" {
+			fail("TF_MIRROR_CACHE_ENCRYPTION_NAMESPACES", ns, "expected a namespace or *")
+		}
+	}
+	for _, ns := range c.NormalizeArchives {
+		if ns == "" || strings.ContainsAny(ns, "/*") && ns != "*" {
+			fail("TF_MIRROR_NORMALIZE_NAMESPACES", ns, "expected a namespace or *")
+		}
+	}
+	if c.ObjectStoreURL != "" && !c.CacheEnabled {
+		fail("TF_MIRROR_OBJECT_STORE_URL", c.ObjectStoreURL, "requires TF_MIRROR_CACHE_ENABLED")
+	}
+	if len(c.ObjectStoreRedirect) > 0 {
+		if c.ObjectStoreURL == "" {
+			fail("TF_MIRROR_OBJECT_STORE_REDIRECT", strings.Join(c.ObjectStoreRedirect, ","), "requires TF_MIRROR_OBJECT_STORE_URL")
+		}
+		if c.PresignTTL <= 0 || c.PresignTTL > 7*24*time.Hour {
+			fail("TF_MIRROR_OBJECT_STORE_REDIRECT_TTL", c.PresignTTL.String(), "must be positive and at most 168h")
+		}
+	}
+	if (c.ObjectStoreAccessKey == "") != (c.ObjectStoreSecret == "") {
+		fail("TF_MIRROR_OBJECT_STORE_ACCESS_KEY", c.ObjectStoreAccessKey, "TF_MIRROR_OBJECT_STORE_ACCESS_KEY and TF_MIRROR_OBJECT_STORE_SECRET must be set together")
+	}
+	if len(c.ShardNodes) > 0 {
+		if !slices.Contains(c.ShardNodes, c.ShardSelf) {
+			fail("TF_MIRROR_SHARD_SELF", c.ShardSelf, "must be one of TF_MIRROR_SHARD_NODES")
+		}
+		if !c.CacheEnabled {
+			fail("TF_MIRROR_SHARD_NODES", strings.Join(c.ShardNodes, ","), "requires TF_MIRROR_CACHE_ENABLED")
+		}
+		if c.PeerSecret == "" {
+			fail("TF_MIRROR_SHARD_NODES", strings.Join(c.ShardNodes, ","), "requires TF_MIRROR_PEER_SECRET")
+		}
+	} else if c.ShardSelf != "" {
+		fail("TF_MIRROR_SHARD_SELF", c.ShardSelf, "requires TF_MIRROR_SHARD_NODES")
+	}
+	if c.Snapshot != "" && !validSnapshot(c.Snapshot) {
+		fail("TF_MIRROR_SNAPSHOT", c.Snapshot, "expected YYYY-MM-DD or an RFC 3339 time")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+	return problems
+}
+
+// checkURL requires an absolute http(s) URL
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("expected an absolute http or https URL")
+	}
+	return nil
+}
+
+// isOrigin reports whether s is a browser origin: scheme and host without a path
+func isOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		strings.TrimSuffix(u.Path, "/") == "" && u.RawQuery == "" && u.User == nil
+}
+
+// isPair reports whether s has the form "a/b"
+func isPair(s string) bool {
+	a, b, ok := strings.Cut(s, "/")
+	return ok && a != "" && b != "" && !strings.Contains(b, "/")
+}
+
+// isOCIRepository reports whether s has the form "registry/repository", optionally with an http(s) scheme
+func isOCIRepository(s string) bool {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	host, repo, ok := strings.Cut(s, "/")
+	return ok && host != "" && repo != "" && repo == strings.ToLower(repo)
+}
+
+// validSnapshot accepts the selectors of TF_MIRROR_SNAPSHOT: a date or an RFC 3339 time
+func validSnapshot(s string) bool {
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		return true
+	}
+	_, err := time.Parse(time.RFC3339, s)
+	return err == nil
+}
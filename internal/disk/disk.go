@@ -0,0 +1,7 @@
+package disk
+
+// Space is the size of a filesystem and the bytes available to unprivileged users
+type Space struct {
+	Free  int64 `json:"free_bytes"`
+	Total int64 `json:"total_bytes"`
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package disk
+
+import "syscall"
+
+// Stat returns the space of the filesystem holding dir
+func Stat(dir string) (Space, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return Space{}, false
+	}
+	return Space{
+		Free:  int64(st.Bavail) * int64(st.Bsize),
+		Total: int64(st.Blocks) * int64(st.Bsize),
+	}, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package disk
+
+// Stat is not available on this platform
+func Stat(string) (Space, bool) {
+	return Space{}, false
+}
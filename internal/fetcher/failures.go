@@ -0,0 +1,112 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrHashFailed is returned when an archive's h1 hash cannot be calculated
+// and unverified archives are refused
+var ErrHashFailed = errors.New("archive hash could not be calculated")
+
+// ErrShasumMismatch is returned when a downloaded archive differs from the published shasum
+var ErrShasumMismatch = errors.New("archive does not match upstream shasum")
+
+// HashFailure is an archive whose h1 hash calculation keeps failing
+type HashFailure struct {
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	Platform     string    `json:"platform"`
+	Failures     int       `json:"failures"`
+	LastError    string    `json:"last_error"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+}
+
+// failureTracker counts hash failures per archive until a calculation succeeds
+type failureTracker struct {
+	mu       sync.Mutex
+	failures map[string]*HashFailure // "namespace/name/version/platform"
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{failures: make(map[string]*HashFailure)}
+}
+
+// record adds a failure and returns the number of consecutive failures
+func (t *failureTracker) record(namespace, name, version, platform string, err error) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := namespace + "/" + name + "/" + version + "/" + platform
+	now := time.Now()
+	f, ok := t.failures[key]
+	if !ok {
+		f = &HashFailure{Namespace: namespace, Name: name, Version: version, Platform: platform, FirstFailure: now}
+		t.failures[key] = f
+	}
+	f.Failures++
+	f.LastError = err.Error()
+	f.LastFailure = now
+	return f.Failures
+}
+
+// clear forgets an archive after a successful calculation
+func (t *failureTracker) clear(namespace, name, version, platform string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, namespace+"/"+name+"/"+version+"/"+platform)
+}
+
+// has reports whether an archive has failed since its last successful calculation
+func (t *failureTracker) has(namespace, name, version, platform string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.failures[namespace+"/"+name+"/"+version+"/"+platform]
+	return ok
+}
+
+// HashFailures returns archives whose hash calculation failed, most failures first
+func (f *Fetcher) HashFailures() []HashFailure {
+	f.failures.mu.Lock()
+	result := make([]HashFailure, 0, len(f.failures.failures))
+	for _, hf := range f.failures.failures {
+		result = append(result, *hf)
+	}
+	f.failures.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Failures != result[j].Failures {
+			return result[i].Failures > result[j].Failures
+		}
+		return result[i].LastFailure.After(result[j].LastFailure)
+	})
+	return result
+}
+
+// FailureState returns the hash failure counters as JSON for a warm restart
+func (f *Fetcher) FailureState() ([]byte, error) {
+	return json.Marshal(f.HashFailures())
+}
+
+// RestoreFailures loads hash failure counters saved by FailureState
+func (f *Fetcher) RestoreFailures(data []byte) error {
+	var failures []HashFailure
+	if err := json.Unmarshal(data, &failures); err != nil {
+		return err
+	}
+
+	f.failures.mu.Lock()
+	defer f.failures.mu.Unlock()
+	for i := range failures {
+		hf := failures[i]
+		f.failures.failures[hf.Namespace+"/"+hf.Name+"/"+hf.Version+"/"+hf.Platform] = &hf
+	}
+	return nil
+}
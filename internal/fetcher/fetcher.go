@@ -0,0 +1,439 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/scan"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// defaultJobTimeout limits a single background archive download when none is configured
+const defaultJobTimeout = 5 * time.Minute
+
+// Options configures a Fetcher
+type Options struct {
+	// Concurrency limits how many archives are downloaded in the background at once
+	// (pre-warming and prefetch runs share this limit)
+	Concurrency int
+
+	// Retries is how many times a failed background download is retried
+	Retries int
+
+	// SpoolDir is where archives larger than SpoolMemoryLimit are spooled ("" for the system temp dir)
+	SpoolDir string
+
+	// SpoolMemoryLimit is the largest archive buffered in memory
+	SpoolMemoryLimit int64
+
+	// SpoolMinFree is the disk space kept free in SpoolDir; larger downloads are refused
+	SpoolMinFree int64
+
+	// JobTimeout limits one background download, from metadata lookups to the stored archive
+	JobTimeout time.Duration
+
+	// MaxDownloads limits concurrent upstream archive transfers (0 = unlimited);
+	// QueueDepth more wait for a slot before downloads fail with ErrSaturated
+	MaxDownloads int
+	QueueDepth   int
+
+	// RequireHash refuses archives whose h1 hash cannot be calculated
+	RequireHash bool
+
+	// CheckArchives refuses archives Terraform could not install from (see registry.CheckArchive)
+	CheckArchives bool
+
+	// Metrics receives hash failure counts (nil disables)
+	Metrics metrics.Recorder
+
+	// Peers are sibling mirrors (base URLs) asked for a cached archive before upstream
+	// PeerHostname is the {hostname} path segment used in peer and shard requests
+	Peers        []string
+	PeerHostname string
+
+	// ShardNodes are the replicas (base URLs) sharing providers by consistent hashing,
+	// ShardSelf is this replica among them; archives of providers owned by another
+	// replica are requested from it instead of upstream (see shards.go)
+	ShardNodes []string
+	ShardSelf  string
+
+	// Scanner checks new archives before they are hashed, cached and served (nil disables);
+	// ScanResults records its verdicts, including quarantined archives (see scan.go)
+	Scanner     *scan.Scanner
+	ScanResults *scan.Store
+
+	// URLSigner signs peer and shard archive URLs for mirrors in origin mode (nil disables)
+	URLSigner *token.URLSigner
+}
+
+// Fetcher downloads provider archives from upstream and records their h1 hashes
+type Fetcher struct {
+	client       *upstream.Client
+	registry     *registry.Registry
+	hashCache    *cache.HashCache
+	archiveCache *cache.ArchiveCache
+	opts         Options
+	logger       *slog.Logger
+	metrics      metrics.Recorder
+	failures     *failureTracker
+	queue        *downloadQueue
+	ring         *ring // nil without sharding
+
+	// Background download slots
+	sem chan struct{}
+
+	// Pre-warm state
+	prewarmed sync.Map // "namespace/name/version" -> struct{}
+
+	// Refuse archives that are not cached yet (see freeze.go)
+	frozen atomic.Bool
+}
+
+// New creates a new Fetcher
+// archiveCache may be nil, in which case archives are hashed but not stored
+func New(client *upstream.Client, reg *registry.Registry, hashCache *cache.HashCache, archiveCache *cache.ArchiveCache, opts Options, logger *slog.Logger) *Fetcher {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.JobTimeout <= 0 {
+		opts.JobTimeout = defaultJobTimeout
+	}
+	recorder := opts.Metrics
+	if recorder == nil {
+		recorder = metrics.Nop()
+	}
+
+	var shards *ring
+	if len(opts.ShardNodes) > 0 {
+		shards = newRing(opts.ShardNodes)
+	}
+
+	return &Fetcher{
+		client:       client,
+		registry:     reg,
+		hashCache:    hashCache,
+		archiveCache: archiveCache,
+		opts:         opts,
+		logger:       logger,
+		metrics:      recorder,
+		failures:     newFailureTracker(),
+		queue:        newDownloadQueue(opts.MaxDownloads, opts.QueueDepth),
+		ring:         shards,
+		sem:          make(chan struct{}, opts.Concurrency),
+	}
+}
+
+// Open resolves the download URL and starts the archive transfer
+// The caller must close the response body
+func (f *Fetcher) Open(ctx context.Context, namespace, name, version, os, arch string) (*http.Response, error) {
+	resp, _, err := f.open(ctx, namespace, name, version, os, arch)
+	return resp, err
+}
+
+// open is Open that also returns the download metadata
+func (f *Fetcher) open(ctx context.Context, namespace, name, version, os, arch string) (*http.Response, *providersv1.RegistryDownloadResponse, error) {
+	info, err := f.registry.DownloadInfo(ctx, namespace, name, version, os, arch)
+	if err != nil {
+		return nil, nil, err
+	}
+	downloadURL := info.DownloadURL
+
+	if err := f.queue.acquire(ctx, namespace+"/"+name); err != nil {
+		if errors.Is(err, ErrSaturated) {
+			f.metrics.Count(metrics.DownloadsRejected, 1, "provider:"+namespace+"/"+name)
+			f.logger.Warn("download queue full", "provider", namespace+"/"+name, "version", version, "platform", os+"_"+arch)
+		}
+		return nil, nil, err
+	}
+	f.reportQueue()
+
+	f.logger.Debug("opening archive", "url", downloadURL)
+
+	resp, err := f.client.Download(ctx, downloadURL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		f.releaseSlot()
+	} else {
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: f.releaseSlot}
+	}
+	if (err != nil && ctx.Err() == nil) || (err == nil && resp.StatusCode != http.StatusOK) {
+		// A cached download URL may have expired or been revoked
+		f.registry.ForgetDownload(namespace, name, version, os, arch)
+	}
+	if err != nil {
+		if errors.Is(err, upstream.ErrHostNotAllowed) {
+			f.logger.Warn("download URL outside allowlist", "provider", namespace+"/"+name, "version", version, "url", downloadURL, "error", err)
+		}
+		return nil, nil, fmt.Errorf("downloading archive: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("archive %s_%s %w", os, arch, registry.ErrNotFound)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, &registry.UpstreamError{StatusCode: resp.StatusCode}
+	}
+
+	return resp, info, nil
+}
+
+// Fetch downloads an archive into a spool, records its h1 hash and stores it in the archive cache
+// The caller must close the returned spool
+func (f *Fetcher) Fetch(ctx context.Context, namespace, name, version, os, arch string) (*spool.Spool, error) {
+	sp, _, err := f.FetchFrom(ctx, namespace, name, version, os, arch)
+	return sp, err
+}
+
+// FetchFrom is Fetch that also returns the host the archive was downloaded from:
+// a shard owner, a peer or the upstream download host
+func (f *Fetcher) FetchFrom(ctx context.Context, namespace, name, version, os, arch string) (*spool.Spool, string, error) {
+	job := Job{Namespace: namespace, Name: name, Version: version, OS: os, Arch: arch}
+	if err := f.checkFrozen(job); err != nil {
+		return nil, "", err
+	}
+	if err := f.checkQuarantine(job); err != nil {
+		return nil, "", err
+	}
+
+	// Peer copies of normalized archives cannot be checked against the upstream shasum
+	normalize := f.registry.Normalizes(namespace)
+
+	var (
+		sp     *spool.Spool
+		source string
+	)
+	if !normalize {
+		var err error
+		sp, source, err = f.fetchOwner(ctx, namespace, name, version, os, arch)
+		if err != nil {
+			return nil, "", err
+		}
+		if sp == nil {
+			sp, source, err = f.fetchPeer(ctx, namespace, name, version, os, arch)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		source = hostOf(source)
+	}
+
+	if sp == nil {
+		resp, info, err := f.open(ctx, namespace, name, version, os, arch)
+		if err != nil {
+			return nil, "", err
+		}
+		source = resp.Request.URL.Host
+		sp, err = f.spoolResponse(ctx, resp, namespace, name, version, true)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := verifyShasum(sp, info.SHA256Sum); err != nil {
+			sp.Close()
+			f.logger.Error("archive does not match upstream shasum", "provider", namespace+"/"+name, "version", version, "platform", os+"_"+arch, "error", err)
+			return nil, "", fmt.Errorf("%s: %w: %v", registry.ZipFilename(name, version, os, arch), ErrShasumMismatch, err)
+		}
+	}
+
+	platform := os + "_" + arch
+	filename := registry.ZipFilename(name, version, os, arch)
+
+	if normalize {
+		normalized, err := f.normalize(sp, name, os)
+		sp.Close()
+		if err != nil {
+			f.logger.Error("failed to normalize archive", "provider", namespace+"/"+name, "version", version, "platform", platform, "error", err)
+			return nil, "", fmt.Errorf("%s: %w", filename, err)
+		}
+		f.logger.Debug("normalized archive", "file", filename, "size", normalized.Size())
+		sp = normalized
+	}
+
+	if f.opts.CheckArchives {
+		if err := registry.CheckArchive(sp, sp.Size(), name, os); err != nil {
+			sp.Close()
+			f.logger.Error("refusing malformed archive", "provider", namespace+"/"+name, "version", version, "platform", platform, "error", err)
+			return nil, "", fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	if err := f.scan(ctx, sp, job, filename); err != nil {
+		sp.Close()
+		return nil, "", err
+	}
+
+	// Calculate h1 and the other local hash schemes
+	if _, ok := f.hashCache.Get(namespace, name, version, platform); !ok {
+		hashes, err := hash.CalculateLocal(sp, sp.Size())
+		if err != nil {
+			failures := f.failures.record(namespace, name, version, platform, err)
+			f.metrics.Count(metrics.HashFailures, 1, "provider:"+namespace+"/"+name)
+			f.logger.Error("failed to calculate h1", "file", filename, "failures", failures, "error", err)
+
+			if f.opts.RequireHash {
+				sp.Close()
+				return nil, "", fmt.Errorf("%s: %w: %v", filename, ErrHashFailed, err)
+			}
+
+			// Serve it, but do not cache it so the next download retries the hash
+			return sp, source, nil
+		}
+		f.failures.clear(namespace, name, version, platform)
+		for _, h := range hashes {
+			f.StoreHash(namespace, name, version, platform, h)
+		}
+	}
+
+	// Store archive
+	if f.archiveCache != nil {
+		if err := f.archiveCache.Set(namespace, name, version, filename, sp.Reader()); errors.Is(err, cache.ErrLowSpace) {
+			f.logger.Warn("archive not cached, cache volume is low on space", "file", filename, "min_free", f.archiveCache.MinFree())
+		} else if err != nil {
+			f.logger.Error("failed to cache archive", "file", filename, "error", err)
+		} else if t := tenant.FromContext(ctx); t != nil {
+			// Charge the archive to the tenant whose request filled the cache
+			if err := f.archiveCache.SetOwner(namespace, name, version, filename, t.Name); err != nil {
+				f.logger.Error("failed to record archive owner", "file", filename, "tenant", t.Name, "error", err)
+			}
+		}
+
+		f.archiveCache.ScheduleEnforce(f.logger)
+	}
+
+	return sp, source, nil
+}
+
+// normalize re-packs a spooled archive in its canonical form (see registry.Normalize) into a new spool
+func (f *Fetcher) normalize(sp *spool.Spool, name, os string) (*spool.Spool, error) {
+	normalized := spool.New(f.opts.SpoolDir, f.opts.SpoolMemoryLimit)
+	if err := registry.Normalize(sp, sp.Size(), normalized, name, os); err != nil {
+		normalized.Close()
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// hostOf returns the host of a peer or shard owner base URL
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+// spoolResponse reads an archive response into a spool and closes the body
+// With resume set, a transfer that breaks partway is continued with Range requests
+func (f *Fetcher) spoolResponse(ctx context.Context, resp *http.Response, namespace, name, version string, resume bool) (*spool.Spool, error) {
+	defer resp.Body.Close()
+
+	// Archives above the memory limit spill to disk; refuse them early if they would not fit
+	if resp.ContentLength > f.opts.SpoolMemoryLimit {
+		if err := spool.CheckSpace(f.opts.SpoolDir, resp.ContentLength, f.opts.SpoolMinFree); err != nil {
+			f.logger.Error("not enough disk space for download", "provider", namespace+"/"+name, "version", version, "error", err)
+			return nil, err
+		}
+	}
+
+	sp := spool.New(f.opts.SpoolDir, f.opts.SpoolMemoryLimit)
+	_, err := io.Copy(sp, resp.Body)
+	if err != nil && resume {
+		err = f.resume(ctx, sp, resp, err)
+	}
+	if err != nil {
+		sp.Close()
+		return nil, fmt.Errorf("downloading archive: %w", err)
+	}
+	return sp, nil
+}
+
+// releaseSlot returns an upstream download slot
+func (f *Fetcher) releaseSlot() {
+	f.queue.release()
+	f.reportQueue()
+}
+
+// reportQueue records the download queue gauges
+func (f *Fetcher) reportQueue() {
+	if f.queue.limit <= 0 {
+		return
+	}
+	stats := f.queue.stats()
+	f.metrics.Gauge(metrics.DownloadsActive, float64(stats.Active))
+	f.metrics.Gauge(metrics.DownloadsQueued, float64(stats.Queued))
+}
+
+// StoreHash saves a calculated hash of a local scheme to the hash cache
+func (f *Fetcher) StoreHash(namespace, name, version, platform, h string) {
+	scheme := hash.SchemeOf(h)
+	if err := f.hashCache.Set(namespace, name, version, platform, h); err != nil {
+		f.logger.Error("failed to cache "+scheme, "error", err)
+		return
+	}
+	f.logger.Info("cached "+scheme+" hash", "provider", namespace+"/"+name, "version", version, "platform", platform, scheme, h)
+}
+
+// Prewarm computes missing h1 hashes for all platforms of a version in the background
+// Each version is pre-warmed at most once per process; failed versions may be retried
+func (f *Fetcher) Prewarm(namespace, name, version string) {
+	if f.Frozen() {
+		return
+	}
+	key := namespace + "/" + name + "/" + version
+	if _, loaded := f.prewarmed.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		if err := f.prewarm(namespace, name, version); err != nil {
+			f.logger.Warn("hash pre-warm failed", "provider", namespace+"/"+name, "version", version, "error", err)
+			f.prewarmed.Delete(key)
+		}
+	}()
+}
+
+func (f *Fetcher) prewarm(namespace, name, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.opts.JobTimeout)
+	platforms, err := f.registry.Platforms(ctx, namespace, name, version)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	cached := f.hashCache.GetAll(namespace, name, version)
+
+	jobs := make([]Job, 0, len(platforms))
+	for _, p := range platforms {
+		if _, ok := cached[p.OS+"_"+p.Arch]; ok {
+			continue
+		}
+		jobs = append(jobs, Job{Namespace: namespace, Name: name, Version: version, OS: p.OS, Arch: p.Arch})
+	}
+
+	var lastErr error
+	for _, result := range f.Run(context.Background(), jobs, nil) {
+		if result.Err != nil {
+			lastErr = result.Err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,28 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFrozen is returned for downloads that would add an archive to a frozen mirror
+var ErrFrozen = errors.New("mirror is frozen")
+
+// SetFrozen stops (or resumes) downloads of archives that are not complete in the cache
+// A frozen fetcher still streams archives whose hash is known when archives are not cached
+func (f *Fetcher) SetFrozen(frozen bool) {
+	f.frozen.Store(frozen)
+}
+
+// Frozen reports whether new archives are refused
+func (f *Fetcher) Frozen() bool {
+	return f.frozen.Load()
+}
+
+// checkFrozen refuses a job that would introduce an archive while frozen
+func (f *Fetcher) checkFrozen(job Job) error {
+	if f.frozen.Load() && !f.complete(job) {
+		return fmt.Errorf("%s: %w", job, ErrFrozen)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// HashWorker computes missing h1 hashes of archives that are already cached
+// (e.g. copied into the cache directory or cached while hashing failed),
+// so {version}.json converges to full hash coverage without hashing on the request path
+type HashWorker struct {
+	fetcher     *Fetcher
+	concurrency int
+	interval    time.Duration
+	logger      *slog.Logger
+}
+
+// NewHashWorker creates a worker that hashes with at most concurrency archives at a time
+func NewHashWorker(f *Fetcher, concurrency int, interval time.Duration, logger *slog.Logger) *HashWorker {
+	return &HashWorker{
+		fetcher:     f,
+		concurrency: max(concurrency, 1),
+		interval:    interval,
+		logger:      logger,
+	}
+}
+
+// Run scans the cache immediately and then every interval until ctx is done
+// A zero interval runs once
+func (w *HashWorker) Run(ctx context.Context) {
+	for {
+		w.runOnce(ctx)
+
+		if w.interval <= 0 {
+			return
+		}
+		select {
+		case <-time.After(w.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// hashJob is a cached archive without an h1 hash
+type hashJob struct {
+	archive  cache.ArchiveInfo
+	platform string
+}
+
+func (w *HashWorker) runOnce(ctx context.Context) {
+	f := w.fetcher
+	if f.archiveCache == nil {
+		return
+	}
+	start := time.Now()
+
+	archives, err := f.archiveCache.List()
+	if err != nil {
+		w.logger.Error("failed to list cached archives", "error", err)
+		return
+	}
+
+	jobs := w.missing(archives)
+	if len(jobs) == 0 {
+		return
+	}
+
+	var (
+		mu             sync.Mutex
+		hashed, failed int
+	)
+	queue := make(chan hashJob)
+	var wg sync.WaitGroup
+	for i := 0; i < min(len(jobs), w.concurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				err := w.hash(job)
+				mu.Lock()
+				// Archives evicted since the scan count as neither
+				switch {
+				case err == nil:
+					hashed++
+				case !errors.Is(err, fs.ErrNotExist):
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	w.logger.Info("hash worker finished",
+		"archives", len(archives),
+		"missing", len(jobs),
+		"hashed", hashed,
+		"failed", failed,
+		"duration", time.Since(start).Round(time.Millisecond),
+	)
+}
+
+// missing returns the cached archives whose h1 hash is unknown
+// Archives that already failed are skipped until a download hashes them (see /admin/hash-failures)
+func (w *HashWorker) missing(archives []cache.ArchiveInfo) []hashJob {
+	f := w.fetcher
+
+	var jobs []hashJob
+	for _, a := range archives {
+		name, version, os, arch, err := registry.ParseZipFilename(a.Filename)
+		if err != nil || name != a.Name || version != a.Version {
+			continue
+		}
+		platform := os + "_" + arch
+		if _, ok := f.hashCache.Get(a.Namespace, a.Name, a.Version, platform); ok {
+			continue
+		}
+		if f.failures.has(a.Namespace, a.Name, a.Version, platform) {
+			continue
+		}
+		jobs = append(jobs, hashJob{archive: a, platform: platform})
+	}
+	return jobs
+}
+
+// hash calculates and stores the h1 and other local hashes of one archive
+func (w *HashWorker) hash(job hashJob) error {
+	f := w.fetcher
+	a := job.archive
+
+	file, size, err := f.archiveCache.OpenLocal(a.Namespace, a.Name, a.Version, a.Filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var hashes []string
+	if err == nil {
+		hashes, err = hash.CalculateLocal(file, size)
+		file.Close()
+	}
+	if err != nil {
+		failures := f.failures.record(a.Namespace, a.Name, a.Version, job.platform, err)
+		f.metrics.Count(metrics.HashFailures, 1, "provider:"+a.Namespace+"/"+a.Name)
+		w.logger.Error("failed to calculate h1", "file", a.Filename, "failures", failures, "error", err)
+		return err
+	}
+	f.failures.clear(a.Namespace, a.Name, a.Version, job.platform)
+	for _, h := range hashes {
+		f.StoreHash(a.Namespace, a.Name, a.Version, job.platform, h)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+)
+
+// peerTimeout limits how long a peer may take to start answering
+const peerTimeout = 2 * time.Second
+
+// archiveURL returns the URL of an archive on a peer or shard owner, signed when the
+// mirrors run in origin mode
+func (f *Fetcher) archiveURL(node, namespace, name, filename string) string {
+	rawURL := strings.TrimSuffix(node, "/") + providersv1.MirrorPath(f.opts.PeerHostname, namespace, name, filename)
+	if f.opts.URLSigner != nil {
+		rawURL += "?" + f.opts.URLSigner.Sign(namespace+"/"+name+"/"+filename, f.opts.URLSigner.Expiry())
+	}
+	return rawURL
+}
+
+// fetchPeer asks each peer for a cached copy of the archive and returns it with the peer
+// Returns nil without error when no peer has it; a copy that does not match
+// the upstream shasum is discarded
+func (f *Fetcher) fetchPeer(ctx context.Context, namespace, name, version, os, arch string) (*spool.Spool, string, error) {
+	if len(f.opts.Peers) == 0 {
+		return nil, "", nil
+	}
+
+	filename := registry.ZipFilename(name, version, os, arch)
+	for _, peer := range f.opts.Peers {
+		rawURL := f.archiveURL(peer, namespace, name, filename)
+
+		resp, cancel, err := f.openPeer(ctx, rawURL)
+		if err != nil {
+			f.logger.Debug("peer lookup failed", "peer", peer, "file", filename, "error", err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			cancel()
+			f.logger.Debug("archive not cached on peer", "peer", peer, "file", filename, "status", resp.StatusCode)
+			continue
+		}
+
+		sp, err := f.spoolResponse(ctx, resp, namespace, name, version, false)
+		cancel()
+		if err != nil {
+			if errors.Is(err, spool.ErrInsufficientSpace) {
+				return nil, "", err
+			}
+			f.logger.Warn("peer download failed", "peer", peer, "file", filename, "error", err)
+			continue
+		}
+
+		if err := f.verifyPeer(ctx, sp, namespace, name, version, os, arch); err != nil {
+			sp.Close()
+			f.logger.Warn("discarding archive from peer", "peer", peer, "file", filename, "error", err)
+			continue
+		}
+
+		f.logger.Info("fetched archive from peer", "peer", peer, "file", filename, "size", sp.Size())
+		return sp, peer, nil
+	}
+	return nil, "", nil
+}
+
+// openPeer starts a cache-only request to a peer; cancel releases the request
+func (f *Fetcher) openPeer(ctx context.Context, rawURL string) (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Give up on peers that do not answer quickly; the transfer itself is not limited
+	timer := time.AfterFunc(peerTimeout, cancel)
+	resp, err := f.client.Peer(ctx, rawURL)
+	if !timer.Stop() || err != nil {
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+			err = context.DeadlineExceeded
+		}
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// verifyPeer compares a peer's archive with the shasum published upstream
+func (f *Fetcher) verifyPeer(ctx context.Context, sp *spool.Spool, namespace, name, version, os, arch string) error {
+	info, err := f.registry.DownloadInfo(ctx, namespace, name, version, os, arch)
+	if err != nil {
+		return err
+	}
+	return verifyShasum(sp, info.SHA256Sum)
+}
+
+// verifyShasum compares a spooled archive with a published SHA-256 (skipped when empty)
+func verifyShasum(sp *spool.Spool, want string) error {
+	if want == "" {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, sp.Reader()); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, want) {
+		return errors.New("shasum mismatch: got " + sum + ", upstream " + want)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// maxRetryBackoff caps the delay between download attempts
+const maxRetryBackoff = 30 * time.Second
+
+// Job is a single provider archive to download
+type Job struct {
+	Namespace string
+	Name      string
+	Version   string
+	OS        string
+	Arch      string
+}
+
+func (j Job) String() string {
+	return fmt.Sprintf("%s/%s %s %s_%s", j.Namespace, j.Name, j.Version, j.OS, j.Arch)
+}
+
+// Result is the outcome of a Job
+type Result struct {
+	Job      Job
+	Skipped  bool // already cached
+	Attempts int
+	Err      error
+}
+
+// Run downloads archives with a pool of workers, retrying transient failures
+// Archives that are already cached are skipped, so an interrupted run resumes where it stopped
+// onResult (optional) is called once per job as it finishes
+func (f *Fetcher) Run(ctx context.Context, jobs []Job, onResult func(Result)) []Result {
+	results := make([]Result, len(jobs))
+	queue := make(chan int)
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for w := 0; w < min(len(jobs), cap(f.sem)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				result := f.runJob(ctx, jobs[i])
+				results[i] = result
+
+				if onResult != nil {
+					mu.Lock()
+					onResult(result)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+// runJob downloads a single archive, holding a background download slot
+func (f *Fetcher) runJob(ctx context.Context, job Job) Result {
+	result := Result{Job: job}
+
+	if f.complete(job) {
+		result.Skipped = true
+		return result
+	}
+
+	select {
+	case f.sem <- struct{}{}:
+		defer func() { <-f.sem }()
+	case <-ctx.Done():
+		result.Err = ctx.Err()
+		return result
+	}
+
+	backoff := time.Second
+	for {
+		result.Attempts++
+		result.Err = f.fetchJob(ctx, job)
+		if result.Err == nil || result.Attempts > f.opts.Retries || !retryable(result.Err) {
+			return result
+		}
+
+		f.logger.Warn("download failed, retrying", "job", job.String(), "attempt", result.Attempts, "error", result.Err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			return result
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+func (f *Fetcher) fetchJob(ctx context.Context, job Job) error {
+	ctx, cancel := context.WithTimeout(ctx, f.opts.JobTimeout)
+	defer cancel()
+
+	sp, err := f.Fetch(ctx, job.Namespace, job.Name, job.Version, job.OS, job.Arch)
+	if err != nil {
+		return err
+	}
+	return sp.Close()
+}
+
+// complete reports whether the job's hash (and archive, when caching) is already stored
+func (f *Fetcher) complete(job Job) bool {
+	if _, ok := f.hashCache.Get(job.Namespace, job.Name, job.Version, job.OS+"_"+job.Arch); !ok {
+		return false
+	}
+	if f.archiveCache == nil {
+		return true
+	}
+	return f.archiveCache.Has(job.Namespace, job.Name, job.Version, registry.ZipFilename(job.Name, job.Version, job.OS, job.Arch))
+}
+
+// retryable reports whether a failed download may succeed on another attempt
+func retryable(err error) bool {
+	if errors.Is(err, registry.ErrNotFound) || errors.Is(err, upstream.ErrHostNotAllowed) || errors.Is(err, spool.ErrInsufficientSpace) || errors.Is(err, ErrFrozen) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var upstreamErr *registry.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode >= 500 || upstreamErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrSaturated is returned when every download slot is busy and the queue is full
+var ErrSaturated = errors.New("too many concurrent upstream downloads")
+
+// QueueStats is a snapshot of the upstream download queue
+type QueueStats struct {
+	Active    int   `json:"active"`
+	Queued    int   `json:"queued"`
+	Limit     int   `json:"limit"`
+	Depth     int   `json:"queue_depth"`
+	Rejected  int64 `json:"rejected"`
+	Providers int   `json:"queued_providers"`
+}
+
+// downloadQueue limits concurrent upstream archive downloads
+// Waiters are queued per provider and slots are handed out round-robin,
+// so one provider with many platforms cannot starve the others
+type downloadQueue struct {
+	limit int // 0 = unlimited
+	depth int
+
+	mu       sync.Mutex
+	active   int
+	waiting  int
+	rejected int64
+	queues   map[string][]chan struct{} // provider -> waiters, oldest first
+	order    []string                   // providers with waiters, next first
+}
+
+func newDownloadQueue(limit, depth int) *downloadQueue {
+	return &downloadQueue{limit: limit, depth: depth, queues: make(map[string][]chan struct{})}
+}
+
+// acquire waits for a download slot; the caller must call release when the transfer ends
+func (q *downloadQueue) acquire(ctx context.Context, provider string) error {
+	if q.limit <= 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	if q.active < q.limit && q.waiting == 0 {
+		q.active++
+		q.mu.Unlock()
+		return nil
+	}
+	if q.waiting >= q.depth {
+		q.rejected++
+		q.mu.Unlock()
+		return ErrSaturated
+	}
+	ready := make(chan struct{})
+	if len(q.queues[provider]) == 0 {
+		q.order = append(q.order, provider)
+	}
+	q.queues[provider] = append(q.queues[provider], ready)
+	q.waiting++
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	select {
+	case <-ready:
+		// The slot was handed over while the request was canceled; pass it on
+		q.mu.Unlock()
+		q.release()
+	default:
+		q.remove(provider, ready)
+		q.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// release frees a slot or hands it to the next provider's oldest waiter
+func (q *downloadQueue) release() {
+	if q.limit <= 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		q.active--
+		return
+	}
+
+	provider := q.order[0]
+	q.order = q.order[1:]
+	waiters := q.queues[provider]
+	ready := waiters[0]
+	if len(waiters) > 1 {
+		q.queues[provider] = waiters[1:]
+		q.order = append(q.order, provider)
+	} else {
+		delete(q.queues, provider)
+	}
+	q.waiting--
+	close(ready)
+}
+
+// remove drops a waiter that gave up; the caller holds q.mu
+func (q *downloadQueue) remove(provider string, ready chan struct{}) {
+	waiters := q.queues[provider]
+	for i, w := range waiters {
+		if w != ready {
+			continue
+		}
+		waiters = append(waiters[:i], waiters[i+1:]...)
+		q.waiting--
+		break
+	}
+	if len(waiters) > 0 {
+		q.queues[provider] = waiters
+		return
+	}
+
+	delete(q.queues, provider)
+	for i, p := range q.order {
+		if p == provider {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (q *downloadQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{
+		Active:    q.active,
+		Queued:    q.waiting,
+		Limit:     q.limit,
+		Depth:     q.depth,
+		Rejected:  q.rejected,
+		Providers: len(q.order),
+	}
+}
+
+// QueueStats returns the state of the upstream download queue
+func (f *Fetcher) QueueStats() QueueStats {
+	return f.queue.stats()
+}
+
+// releaseBody returns the download slot when the archive body is closed
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,81 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+)
+
+// maxResumes limits how many times one archive transfer is resumed
+const maxResumes = 3
+
+// resume continues an interrupted archive transfer into the same spool
+// Each attempt asks for the bytes after those already spooled; the upstream
+// must answer 206 with a matching Content-Range, otherwise the original error is returned
+func (f *Fetcher) resume(ctx context.Context, sp *spool.Spool, resp *http.Response, err error) error {
+	if resp.Request == nil || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return err
+	}
+
+	rawURL := resp.Request.URL.String()
+	host := resp.Request.URL.Host
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	for attempt := 1; attempt <= maxResumes; attempt++ {
+		if ctx.Err() != nil {
+			return err
+		}
+
+		offset := sp.Size()
+		f.logger.Warn("archive transfer interrupted, resuming", "host", host, "offset", offset, "attempt", attempt, "error", err)
+
+		next, rangeErr := f.client.DownloadRange(ctx, rawURL, offset, validator)
+		if rangeErr != nil {
+			err = rangeErr
+			continue
+		}
+		if rangeErr := checkRange(next, offset, resp.ContentLength); rangeErr != nil {
+			next.Body.Close()
+			return fmt.Errorf("%w (resume failed: %v)", err, rangeErr)
+		}
+
+		_, err = io.Copy(sp, next.Body)
+		next.Body.Close()
+		if err == nil {
+			f.logger.Info("resumed archive transfer", "host", host, "size", sp.Size(), "attempts", attempt)
+			return nil
+		}
+	}
+	return err
+}
+
+// checkRange verifies that a ranged response continues at offset
+// total is the full length from the original response (-1 when unknown)
+func checkRange(resp *http.Response, offset, total int64) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("upstream answered %d to a range request", resp.StatusCode)
+	}
+
+	// Content-Range: bytes {start}-{end}/{size}
+	spec, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return fmt.Errorf("invalid Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+	start, rest, _ := strings.Cut(spec, "-")
+	_, size, _ := strings.Cut(rest, "/")
+	if start != strconv.FormatInt(offset, 10) {
+		return fmt.Errorf("Content-Range %q does not start at %d", spec, offset)
+	}
+	if total >= 0 && size != "*" && size != strconv.FormatInt(total, 10) {
+		return fmt.Errorf("Content-Range %q does not match length %d", spec, total)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/scan"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+)
+
+// ErrQuarantined is returned for archives the scanner rejected, until an admin releases them
+var ErrQuarantined = errors.New("archive is quarantined")
+
+// Scans reports whether new archives are scanned before they are served
+func (f *Fetcher) Scans() bool {
+	return f.opts.Scanner != nil
+}
+
+// checkQuarantine refuses an archive quarantined by an earlier scan without downloading it again
+func (f *Fetcher) checkQuarantine(job Job) error {
+	if f.opts.Scanner == nil {
+		return nil
+	}
+	if r, ok := f.opts.ScanResults.Get(job.Namespace, job.Name, job.Version, job.OS+"_"+job.Arch); ok && r.Status == scan.StatusQuarantined {
+		return fmt.Errorf("%s: %w: %s", job, ErrQuarantined, r.Reason)
+	}
+	return nil
+}
+
+// scan runs the scanner on a downloaded archive before it is hashed and cached
+// A rejected archive, or one the scanner failed on, is quarantined; a passed or released
+// archive is not scanned again as long as its content is unchanged
+func (f *Fetcher) scan(ctx context.Context, sp *spool.Spool, job Job, filename string) error {
+	if f.opts.Scanner == nil {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, sp.Reader()); err != nil {
+		return err
+	}
+	archive := scan.Archive{
+		Namespace: job.Namespace,
+		Name:      job.Name,
+		Version:   job.Version,
+		Platform:  job.OS + "_" + job.Arch,
+		Filename:  filename,
+		SHA256:    hex.EncodeToString(h.Sum(nil)),
+	}
+	if r, ok := f.opts.ScanResults.Get(job.Namespace, job.Name, job.Version, archive.Platform); ok && r.Status != scan.StatusQuarantined && r.SHA256 == archive.SHA256 {
+		return nil
+	}
+
+	verdict, err := f.opts.Scanner.Scan(ctx, archive, sp, sp.Size())
+	if err != nil {
+		if ctx.Err() != nil {
+			// The request was canceled, not the scan's fault
+			return ctx.Err()
+		}
+		verdict.Reason = "scan failed: " + err.Error()
+	}
+
+	result := scan.Result{
+		Namespace:  archive.Namespace,
+		Name:       archive.Name,
+		Version:    archive.Version,
+		Platform:   archive.Platform,
+		Filename:   filename,
+		SHA256:     archive.SHA256,
+		Status:     scan.StatusPassed,
+		Reason:     verdict.Reason,
+		ReportType: verdict.ReportType,
+		ScannedAt:  time.Now().UTC(),
+	}
+	if !verdict.Passed {
+		result.Status = scan.StatusQuarantined
+	}
+	if err := f.opts.ScanResults.Record(result, verdict.Report); err != nil {
+		f.logger.Error("failed to record scan result", "file", filename, "error", err)
+	}
+
+	if !verdict.Passed {
+		f.metrics.Count(metrics.ArchivesQuarantined, 1, "provider:"+job.Namespace+"/"+job.Name)
+		f.logger.Warn("archive quarantined", "provider", job.Namespace+"/"+job.Name, "version", job.Version, "platform", archive.Platform, "reason", verdict.Reason)
+		return fmt.Errorf("%s: %w: %s", job, ErrQuarantined, verdict.Reason)
+	}
+	f.logger.Info("archive passed scan", "provider", job.Namespace+"/"+job.Name, "version", job.Version, "platform", archive.Platform)
+	return nil
+}
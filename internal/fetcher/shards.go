@@ -0,0 +1,123 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+)
+
+// ringReplicas is the number of points each replica has on the hash ring
+const ringReplicas = 128
+
+// ring assigns providers to replicas by consistent hashing
+// Adding or removing a replica only moves the providers of its own points
+type ring struct {
+	points []uint64
+	nodes  map[uint64]string
+}
+
+// newRing places every node on the ring ringReplicas times
+func newRing(nodes []string) *ring {
+	r := &ring{nodes: make(map[uint64]string, len(nodes)*ringReplicas)}
+	for _, node := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			p := ringPoint(node + "#" + strconv.Itoa(i))
+			if _, taken := r.nodes[p]; taken {
+				continue
+			}
+			r.nodes[p] = node
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// owner returns the node owning key: the first point at or after its hash
+func (r *ring) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringPoint(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i]]
+}
+
+func ringPoint(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// shardOwnerKey marks requests a replica received as the owner of their provider
+type shardOwnerKey struct{}
+
+// AsShardOwner marks a download another replica forwarded to this one as the owner
+// Such downloads go upstream and are never forwarded again, even if the replicas disagree about ownership
+func AsShardOwner(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shardOwnerKey{}, true)
+}
+
+// ShardOwner returns the replica owning a provider and whether that is this one
+// Without sharding every provider is owned locally
+func (f *Fetcher) ShardOwner(namespace, name string) (string, bool) {
+	if f.ring == nil {
+		return f.opts.ShardSelf, true
+	}
+	owner := f.ring.owner(namespace + "/" + name)
+	return owner, owner == f.opts.ShardSelf
+}
+
+// fetchOwner asks the replica owning the provider for the archive, which it downloads
+// from upstream if needed, and returns it with the owner; returns nil without error when this
+// replica owns the provider or the owner cannot deliver it, so the caller falls back to upstream
+func (f *Fetcher) fetchOwner(ctx context.Context, namespace, name, version, os, arch string) (*spool.Spool, string, error) {
+	if f.ring == nil || ctx.Value(shardOwnerKey{}) != nil {
+		return nil, "", nil
+	}
+	owner, local := f.ShardOwner(namespace, name)
+	if local {
+		return nil, "", nil
+	}
+
+	filename := registry.ZipFilename(name, version, os, arch)
+	rawURL := f.archiveURL(owner, namespace, name, filename)
+
+	resp, err := f.client.Shard(ctx, rawURL)
+	if err != nil {
+		f.logger.Warn("shard owner unreachable, downloading upstream", "owner", owner, "file", filename, "error", err)
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		f.logger.Warn("shard owner failed, downloading upstream", "owner", owner, "file", filename, "status", resp.StatusCode)
+		return nil, "", nil
+	}
+
+	sp, err := f.spoolResponse(ctx, resp, namespace, name, version, false)
+	if err != nil {
+		if errors.Is(err, spool.ErrInsufficientSpace) {
+			return nil, "", err
+		}
+		f.logger.Warn("shard owner download failed, downloading upstream", "owner", owner, "file", filename, "error", err)
+		return nil, "", nil
+	}
+
+	if err := f.verifyPeer(ctx, sp, namespace, name, version, os, arch); err != nil {
+		sp.Close()
+		f.logger.Warn("discarding archive from shard owner", "owner", owner, "file", filename, "error", err)
+		return nil, "", nil
+	}
+
+	f.logger.Info("fetched archive from shard owner", "owner", owner, "file", filename, "size", sp.Size())
+	return sp, owner, nil
+}
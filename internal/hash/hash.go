@@ -1,9 +1,11 @@
 package hash
 
 import (
+	"archive/zip"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"golang.org/x/mod/sumdb/dirhash"
 )
@@ -15,6 +17,42 @@ func CalculateH1(zipPath string) (string, error) {
 	return dirhash.HashZip(zipPath, dirhash.Hash1)
 }
 
+// IsH1 reports whether s is a well-formed h1 hash ("h1:" and a base64 SHA-256)
+func IsH1(s string) bool {
+	value, ok := strings.CutPrefix(s, "h1:")
+	return ok && H1.Valid(value)
+}
+
+// CalculateH1FromReaderAt calculates h1 hash for a provider ZIP held in memory or a spool
+// Equivalent to dirhash.HashZip without requiring a file on disk; entries are hashed in
+// parallel (see SetConcurrency)
+func CalculateH1FromReaderAt(r io.ReaderAt, size int64) (string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", fmt.Errorf("opening zip: %w", err)
+	}
+
+	files := make([]string, 0, len(z.File))
+	zfiles := make(map[string]*zip.File, len(z.File))
+	for _, file := range z.File {
+		files = append(files, file.Name)
+		zfiles[file.Name] = file
+	}
+
+	workers := Concurrency()
+	if workers == 1 {
+		open := func(name string) (io.ReadCloser, error) {
+			f := zfiles[name]
+			if f == nil {
+				return nil, fmt.Errorf("file %q not found in zip", name)
+			}
+			return f.Open()
+		}
+		return dirhash.Hash1(files, open)
+	}
+	return hashEntries(files, zfiles, workers)
+}
+
 // CalculateH1FromReader calculates h1 hash by saving data to a temporary file
 func CalculateH1FromReader(r io.Reader) (string, error) {
 	// Create temporary file
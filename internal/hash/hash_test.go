@@ -0,0 +1,117 @@
+package hash
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// testArchive packs a provider archive: an executable of binarySize bytes, a license and
+// extra small files, with contents that compress about as well as a Go binary
+func testArchive(tb testing.TB, binarySize, extra int) []byte {
+	tb.Helper()
+
+	rng := rand.New(rand.NewSource(1))
+	content := func(size int) []byte {
+		b := make([]byte, size)
+		for i := range b {
+			b[i] = byte(rng.Intn(24))
+		}
+		return b
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	add("terraform-provider-aws_v5.0.0", content(binarySize))
+	add("LICENSE", content(16<<10))
+	for i := 0; i < extra; i++ {
+		add(fmt.Sprintf("docs/%03d.md", i), content(64<<10))
+	}
+	if err := zw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withConcurrency runs f with SetConcurrency(n) and restores the default afterwards
+func withConcurrency(n int, f func()) {
+	defer SetConcurrency(0)
+	SetConcurrency(n)
+	f()
+}
+
+func TestParallelH1MatchesDirhash(t *testing.T) {
+	for _, data := range [][]byte{
+		testArchive(t, 12<<20, 0),
+		testArchive(t, 1<<20, 40),
+		testArchive(t, 0, 0),
+	} {
+		var serial, parallel string
+		var err error
+		withConcurrency(1, func() { serial, err = CalculateH1FromReaderAt(bytes.NewReader(data), int64(len(data))) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		withConcurrency(4, func() { parallel, err = CalculateH1FromReaderAt(bytes.NewReader(data), int64(len(data))) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parallel != serial {
+			t.Errorf("parallel h1 %s, dirhash %s", parallel, serial)
+		}
+	}
+
+	// A corrupted entry fails in the pipeline as it does in dirhash
+	data := testArchive(t, 12<<20, 0)
+	data[len(data)/2] ^= 0xff
+	withConcurrency(4, func() {
+		if _, err := CalculateH1FromReaderAt(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Error("corrupted archive hashed without error")
+		}
+	})
+}
+
+// BenchmarkH1 compares dirhash (concurrency 1) with parallel hashing, for an archive dominated
+// by one large executable like most providers and for one with many entries
+// Run with -cpu 1,2,4,8 to see how each scales
+func BenchmarkH1(b *testing.B) {
+	for _, shape := range []struct {
+		name string
+		data []byte
+	}{
+		{"executable", testArchive(b, 64<<20, 0)},
+		{"many-entries", testArchive(b, 4<<20, 200)},
+	} {
+		for _, mode := range []struct {
+			name        string
+			concurrency int
+		}{
+			{"dirhash", 1},
+			{"parallel", 0},
+		} {
+			b.Run(shape.name+"/"+mode.name, func(b *testing.B) {
+				withConcurrency(mode.concurrency, func() {
+					r := bytes.NewReader(shape.data)
+					b.SetBytes(int64(len(shape.data)))
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						if _, err := CalculateH1FromReaderAt(r, int64(len(shape.data))); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package hash
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// pipelineMin is the entry size from which inflating and hashing run on separate goroutines
+	pipelineMin = 4 << 20
+
+	// pipelineChunk and pipelineDepth size the buffers handed from inflating to hashing
+	pipelineChunk = 1 << 20
+	pipelineDepth = 4
+)
+
+// concurrency is how many goroutines hash one archive, 0 for GOMAXPROCS
+var concurrency atomic.Int64
+
+// SetConcurrency sets how many CPUs hashing one archive may use (0 for all of them, 1 to hash
+// serially with dirhash)
+func SetConcurrency(n int) {
+	concurrency.Store(int64(n))
+}
+
+// Concurrency returns how many CPUs hashing one archive may use
+func Concurrency() int {
+	if n := int(concurrency.Load()); n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// hashEntries is dirhash.Hash1 over the entries of a ZIP, hashing up to workers entries at once
+// The summary is still written in name order, so the result is identical to dirhash's
+func hashEntries(files []string, zfiles map[string]*zip.File, workers int) (string, error) {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+	for _, name := range files {
+		if strings.Contains(name, "\n") {
+			return "", errors.New("dirhash: filenames with newlines are not supported")
+		}
+	}
+
+	sums := make([][]byte, len(files))
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for i, name := range files {
+		g.Go(func() error {
+			sum, err := hashEntry(zfiles[name])
+			sums[i] = sum
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for i, name := range files {
+		fmt.Fprintf(h, "%x  %s\n", sums[i], name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashEntry returns the SHA-256 of the contents of an archive entry
+// Inflating a provider executable takes about as long as hashing it, so large entries
+// do both at once on separate goroutines
+func hashEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if f.UncompressedSize64 >= pipelineMin {
+		err = pipelineCopy(h, rc)
+	} else {
+		_, err = io.Copy(h, rc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// pipelineCopy copies r to w in chunks, reading the next chunks while w consumes earlier ones
+func pipelineCopy(w io.Writer, r io.Reader) error {
+	free := make(chan []byte, pipelineDepth)
+	full := make(chan []byte, pipelineDepth)
+	for i := 0; i < pipelineDepth; i++ {
+		free <- make([]byte, pipelineChunk)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		var err error
+		for chunk := range full {
+			if err == nil {
+				_, err = w.Write(chunk)
+			}
+			free <- chunk[:cap(chunk)]
+		}
+		written <- err
+	}()
+
+	var readErr error
+	for readErr == nil {
+		buf := <-free
+		n, err := fill(r, buf)
+		if n > 0 {
+			full <- buf[:n]
+		} else {
+			free <- buf
+		}
+		readErr = err
+	}
+	close(full)
+
+	if err := <-written; err != nil {
+		return err
+	}
+	if readErr != io.EOF {
+		return readErr
+	}
+	return nil
+}
+
+// fill reads into buf until it is full or r fails, returning io.EOF only at the end of r
+// (io.ReadFull would hide a truncated deflate stream's io.ErrUnexpectedEOF)
+func fill(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,166 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownScheme is returned for hashes whose scheme is not registered
+	ErrUnknownScheme = errors.New("unknown hash scheme")
+
+	// ErrMismatch is returned when an archive does not match a hash
+	ErrMismatch = errors.New("hash mismatch")
+)
+
+// Scheme is a package hash scheme of Terraform lock files, written as "{id}:{value}"
+type Scheme struct {
+	// ID is the prefix of the scheme's hashes, e.g. "h1"
+	ID string
+
+	// Local schemes are calculated from downloaded archives and kept in the hash cache;
+	// the others are relayed from upstream (zh from SHA256SUMS)
+	Local bool
+
+	// Calculate hashes a provider archive and returns "{id}:{value}"
+	Calculate func(r io.ReaderAt, size int64) (string, error)
+
+	// Valid reports whether a value (without the "{id}:" prefix) is well-formed
+	Valid func(value string) bool
+}
+
+// H1 is Terraform's hash of the files inside an archive (dirhash)
+var H1 = Scheme{
+	ID:        "h1",
+	Local:     true,
+	Calculate: CalculateH1FromReaderAt,
+	Valid: func(value string) bool {
+		b, err := base64.StdEncoding.DecodeString(value)
+		return err == nil && len(b) == sha256.Size
+	},
+}
+
+// ZH is the hex SHA-256 of the archive file, as published in SHA256SUMS
+var ZH = Scheme{
+	ID: "zh",
+	Calculate: func(r io.ReaderAt, size int64) (string, error) {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+			return "", err
+		}
+		return "zh:" + hex.EncodeToString(h.Sum(nil)), nil
+	},
+	Valid: func(value string) bool {
+		b, err := hex.DecodeString(value)
+		return err == nil && len(b) == sha256.Size && value == strings.ToLower(value)
+	},
+}
+
+var (
+	mu      sync.RWMutex
+	schemes = []Scheme{H1, ZH}
+)
+
+// Register adds a hash scheme; call it from an init function, like hooks.Register
+// Hashes of local schemes are stored and listed in responses after those registered before
+func Register(s Scheme) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, existing := range schemes {
+		if existing.ID == s.ID {
+			panic("hash scheme " + s.ID + " registered twice")
+		}
+	}
+	schemes = append(schemes, s)
+}
+
+// Schemes returns the registered schemes in registration order
+func Schemes() []Scheme {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]Scheme(nil), schemes...)
+}
+
+// Lookup returns a registered scheme by ID
+func Lookup(id string) (Scheme, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, s := range schemes {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Scheme{}, false
+}
+
+// SchemeOf returns the scheme ID of a hash ("h1" for "h1:...")
+func SchemeOf(h string) string {
+	id, _, _ := strings.Cut(h, ":")
+	return id
+}
+
+// Valid reports whether h is a well-formed hash of a registered scheme
+func Valid(h string) bool {
+	id, value, ok := strings.Cut(h, ":")
+	if !ok {
+		return false
+	}
+	s, ok := Lookup(id)
+	return ok && s.Valid(value)
+}
+
+// Verify checks an archive against a hash of any registered scheme
+func Verify(r io.ReaderAt, size int64, h string) error {
+	s, ok := Lookup(SchemeOf(h))
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownScheme, SchemeOf(h))
+	}
+	got, err := s.Calculate(r, size)
+	if err != nil {
+		return err
+	}
+	if got != h {
+		return fmt.Errorf("%w: got %s, want %s", ErrMismatch, got, h)
+	}
+	return nil
+}
+
+// CalculateLocal calculates the hashes of every local scheme of an archive, in registration order
+func CalculateLocal(r io.ReaderAt, size int64) ([]string, error) {
+	var hashes []string
+	for _, s := range Schemes() {
+		if !s.Local {
+			continue
+		}
+		h, err := s.Calculate(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.ID, err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// CalculateLocalFile is CalculateLocal for an archive on disk
+func CalculateLocalFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return CalculateLocal(f, info.Size())
+}
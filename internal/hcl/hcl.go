@@ -0,0 +1,354 @@
+package hcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Block is a block of an HCL file, with its attributes and nested blocks
+type Block struct {
+	Type   string
+	Labels []string
+	Attrs  map[string]Value
+	Blocks []Block
+	Line   int
+}
+
+// Value is an attribute value: a string, a list of strings or an object
+// Exactly one of Str (possibly empty), List (non-nil) and Object (non-nil) is set
+type Value struct {
+	Str    string
+	List   []string
+	Object map[string]Value
+	Line   int
+}
+
+// IsString reports whether a value is a string
+func (v Value) IsString() bool {
+	return v.List == nil && v.Object == nil
+}
+
+// Parse reads the blocks of an HCL file
+// Only the subset of HCL written by Terraform for lock files and used in provider manifests
+// is supported: blocks with string labels and attributes whose values are strings, lists of
+// strings or objects of such values
+func Parse(data []byte) ([]Block, error) {
+	p := &parser{lexer: lexer{src: string(data), line: 1}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	var blocks []Block
+	for p.tok.kind != tokEOF {
+		block, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lexer.next()
+	p.tok = tok
+	return err
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.tok.line, fmt.Sprintf(format, args...))
+}
+
+// expect consumes a punctuation token
+func (p *parser) expect(kind tokenKind) error {
+	if p.tok.kind != kind {
+		return p.errorf("expected %s, got %s", kind, p.tok)
+	}
+	return p.next()
+}
+
+// block reads a block: its type, labels and body
+func (p *parser) block() (Block, error) {
+	if p.tok.kind != tokIdent {
+		return Block{}, p.errorf("expected a block, got %s", p.tok)
+	}
+	block := Block{Type: p.tok.text, Line: p.tok.line}
+	if err := p.next(); err != nil {
+		return block, err
+	}
+	labels, err := p.labels()
+	if err != nil {
+		return block, err
+	}
+	block.Labels = labels
+	return block, p.body(&block)
+}
+
+// labels reads the string labels of a block up to its opening brace
+func (p *parser) labels() ([]string, error) {
+	var labels []string
+	for p.tok.kind == tokString {
+		labels = append(labels, p.tok.text)
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return labels, p.expect(tokLBrace)
+}
+
+// body reads the attributes and nested blocks of a block up to its closing brace
+func (p *parser) body(block *Block) error {
+	block.Attrs = make(map[string]Value)
+	for p.tok.kind != tokRBrace {
+		if p.tok.kind != tokIdent {
+			return p.errorf("expected an attribute or block, got %s", p.tok)
+		}
+		name, line := p.tok.text, p.tok.line
+		if err := p.next(); err != nil {
+			return err
+		}
+
+		if p.tok.kind != tokEquals {
+			nested := Block{Type: name, Line: line}
+			labels, err := p.labels()
+			if err != nil {
+				return err
+			}
+			nested.Labels = labels
+			if err := p.body(&nested); err != nil {
+				return err
+			}
+			block.Blocks = append(block.Blocks, nested)
+			continue
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		block.Attrs[name] = v
+	}
+	return p.next()
+}
+
+// value reads a string, a list of strings (with an optional trailing comma) or an object
+func (p *parser) value() (Value, error) {
+	line := p.tok.line
+	switch p.tok.kind {
+	case tokString:
+		v := Value{Str: p.tok.text, Line: line}
+		return v, p.next()
+	case tokLBracket:
+		if err := p.next(); err != nil {
+			return Value{}, err
+		}
+		v := Value{List: []string{}, Line: line}
+		for p.tok.kind != tokRBracket {
+			if p.tok.kind != tokString {
+				return Value{}, p.errorf("expected a string, got %s", p.tok)
+			}
+			v.List = append(v.List, p.tok.text)
+			if err := p.next(); err != nil {
+				return Value{}, err
+			}
+			if p.tok.kind == tokComma {
+				if err := p.next(); err != nil {
+					return Value{}, err
+				}
+			} else if p.tok.kind != tokRBracket {
+				return Value{}, p.errorf("expected , or ], got %s", p.tok)
+			}
+		}
+		return v, p.next()
+	case tokLBrace:
+		return p.object()
+	}
+	return Value{}, p.errorf("expected a string, list or object, got %s", p.tok)
+}
+
+// object reads an object of "key = value" entries, separated by newlines or commas
+func (p *parser) object() (Value, error) {
+	v := Value{Object: make(map[string]Value), Line: p.tok.line}
+	if err := p.next(); err != nil {
+		return Value{}, err
+	}
+	for p.tok.kind != tokRBrace {
+		if p.tok.kind != tokIdent && p.tok.kind != tokString {
+			return Value{}, p.errorf("expected an object key, got %s", p.tok)
+		}
+		key := p.tok.text
+		if err := p.next(); err != nil {
+			return Value{}, err
+		}
+		if err := p.expect(tokEquals); err != nil {
+			return Value{}, err
+		}
+		entry, err := p.value()
+		if err != nil {
+			return Value{}, err
+		}
+		v.Object[key] = entry
+		if p.tok.kind == tokComma {
+			if err := p.next(); err != nil {
+				return Value{}, err
+			}
+		}
+	}
+	return v, p.next()
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokEquals
+	tokComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokIdent:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokLBrace:
+		return "{"
+	case tokRBrace:
+		return "}"
+	case tokLBracket:
+		return "["
+	case tokRBracket:
+		return "]"
+	case tokEquals:
+		return "="
+	case tokComma:
+		return ","
+	}
+	return "end of file"
+}
+
+type token struct {
+	kind tokenKind
+	text string // identifier name or unquoted string
+	line int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokIdent:
+		return strconv.Quote(t.text)
+	case tokString:
+		return "string " + strconv.Quote(t.text)
+	}
+	return t.kind.String()
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+// next returns the next token, skipping whitespace and comments
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}, nil
+	}
+
+	c := l.src[l.pos]
+	punct := map[byte]tokenKind{'{': tokLBrace, '}': tokRBrace, '[': tokLBracket, ']': tokRBracket, '=': tokEquals, ',': tokComma}
+	if kind, ok := punct[c]; ok {
+		l.pos++
+		return token{kind: kind, line: l.line}, nil
+	}
+
+	switch {
+	case c == '"':
+		return l.string()
+	case isIdentStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || l.src[l.pos] == '-' || ('0' <= l.src[l.pos] && l.src[l.pos] <= '9')) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], line: l.line}, nil
+	}
+	return token{}, fmt.Errorf("line %d: unexpected character %q", l.line, c)
+}
+
+// string reads a quoted string; template interpolations are not supported
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\n':
+			return token{}, fmt.Errorf("line %d: unterminated string", l.line)
+		case '"':
+			l.pos++
+			raw := l.src[start:l.pos]
+			if strings.Contains(raw, "${") || strings.Contains(raw, "%{") {
+				return token{}, fmt.Errorf("line %d: templates are not supported", l.line)
+			}
+			s, err := strconv.Unquote(raw)
+			if err != nil {
+				return token{}, fmt.Errorf("line %d: invalid string %s", l.line, raw)
+			}
+			return token{kind: tokString, text: s, line: l.line}, nil
+		}
+		l.pos++
+	}
+	return token{}, fmt.Errorf("line %d: unterminated string", l.line)
+}
+
+// skip advances past whitespace and #, // and /* */ comments
+func (l *lexer) skip() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#' || strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated comment", l.line)
+			}
+			comment := l.src[l.pos : l.pos+2+end+2]
+			l.line += strings.Count(comment, "\n")
+			l.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
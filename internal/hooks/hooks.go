@@ -0,0 +1,138 @@
+package hooks
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Hook is an extension compiled into the mirror binary
+// A hook implements any of ResolveHook, AuthorizeHook and ResponseHook
+type Hook interface {
+	// Name identifies the hook in logs
+	Name() string
+}
+
+// ResolveHook rewrites a provider address after aliases are applied
+// and before any cache or upstream lookup
+type ResolveHook interface {
+	ResolveProvider(ctx context.Context, r *http.Request, namespace, name string) (string, string, error)
+}
+
+// AuthorizeHook decides whether an archive download may proceed
+// Returning an error (preferably from Deny) refuses the download
+type AuthorizeHook interface {
+	AuthorizeDownload(ctx context.Context, r *http.Request, d Download) error
+}
+
+// ResponseHook may change the status code and headers of any response
+// It runs right before the headers are written
+type ResponseHook interface {
+	MutateResponse(r *http.Request, status int, header http.Header) int
+}
+
+// Download describes a requested provider archive
+type Download struct {
+	Namespace string
+	Name      string
+	Version   string
+	OS        string
+	Arch      string
+	Filename  string
+}
+
+// Error is a refusal returned by a hook, rendered to the client with Status
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Deny returns an error that refuses a request with the given status
+func Deny(status int, message string) error {
+	return &Error{Status: status, Message: message}
+}
+
+var (
+	mu         sync.Mutex
+	registered []Hook
+)
+
+// Register adds a hook; call it from an init function of the hook package
+// and import that package for side effects in the main package
+func Register(h Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registered = append(registered, h)
+}
+
+// Registered returns the hooks in registration order
+func Registered() []Hook {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]Hook(nil), registered...)
+}
+
+// Chain runs hooks in registration order
+type Chain struct {
+	resolvers   []ResolveHook
+	authorizers []AuthorizeHook
+	responders  []ResponseHook
+}
+
+// NewChain groups hooks by the extension points they implement
+func NewChain(hs []Hook) *Chain {
+	c := &Chain{}
+	for _, h := range hs {
+		if rh, ok := h.(ResolveHook); ok {
+			c.resolvers = append(c.resolvers, rh)
+		}
+		if ah, ok := h.(AuthorizeHook); ok {
+			c.authorizers = append(c.authorizers, ah)
+		}
+		if rh, ok := h.(ResponseHook); ok {
+			c.responders = append(c.responders, rh)
+		}
+	}
+	return c
+}
+
+// ResolveProvider passes the address through every ResolveHook
+func (c *Chain) ResolveProvider(ctx context.Context, r *http.Request, namespace, name string) (string, string, error) {
+	for _, h := range c.resolvers {
+		var err error
+		namespace, name, err = h.ResolveProvider(ctx, r, namespace, name)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return namespace, name, nil
+}
+
+// AuthorizeDownload stops at the first AuthorizeHook that refuses the download
+func (c *Chain) AuthorizeDownload(ctx context.Context, r *http.Request, d Download) error {
+	for _, h := range c.authorizers {
+		if err := h.AuthorizeDownload(ctx, r, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MutateResponse passes the status and headers through every ResponseHook
+func (c *Chain) MutateResponse(r *http.Request, status int, header http.Header) int {
+	for _, h := range c.responders {
+		status = h.MutateResponse(r, status, header)
+	}
+	return status
+}
+
+// MutatesResponses reports whether any ResponseHook is registered
+func (c *Chain) MutatesResponses() bool {
+	return len(c.responders) > 0
+}
@@ -0,0 +1,294 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/buildinfo"
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// Formats supported by Write
+const (
+	FormatJSON      = "json"
+	FormatCSV       = "csv"
+	FormatCycloneDX = "cyclonedx"
+)
+
+// Item is one provider platform known to the mirror
+type Item struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Version   string   `json:"version"`
+	Platform  string   `json:"platform"`
+	Filename  string   `json:"filename"`
+	Hashes    []string `json:"hashes"`
+	Size      int64    `json:"size,omitempty"`
+	Cached    bool     `json:"cached"`
+
+	// FirstSeen is when the h1 hash was first recorded
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+
+	// LastServed is when the cached archive was last stored or served
+	LastServed *time.Time `json:"last_served,omitempty"`
+
+	// Scan is the status of the archive's latest scan ("passed", "quarantined" or "released")
+	// when the mirror scans archives; Collect leaves it empty
+	Scan string `json:"scan,omitempty"`
+}
+
+// Collect builds the inventory from the cache directory
+// archives may be nil when archive caching is disabled; items of the normalized namespaces
+// ("*" for all) carry no zh hashes, SHA256SUMS describes the archives before normalization
+func Collect(hashes *cache.HashCache, archives *cache.ArchiveCache, artifacts *cache.ArtifactCache, normalized []string) ([]Item, error) {
+	items := make(map[string]*Item)
+	item := func(namespace, name, version, platform string) *Item {
+		key := namespace + "/" + name + "/" + version + "/" + platform
+		it, ok := items[key]
+		if !ok {
+			osName, arch, _ := strings.Cut(platform, "_")
+			it = &Item{
+				Namespace: namespace,
+				Name:      name,
+				Version:   version,
+				Platform:  platform,
+				Filename:  registry.ZipFilename(name, version, osName, arch),
+			}
+			items[key] = it
+		}
+		return it
+	}
+
+	hashEntries, err := hashes.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing hashes: %w", err)
+	}
+	for _, h := range hashEntries {
+		it := item(h.Namespace, h.Name, h.Version, h.Platform)
+		it.Hashes = append(it.Hashes, h.Hash)
+		stored := h.Stored
+		it.FirstSeen = &stored
+	}
+
+	if archives != nil {
+		archiveEntries, err := archives.List()
+		if err != nil {
+			return nil, fmt.Errorf("listing archives: %w", err)
+		}
+		for _, a := range archiveEntries {
+			_, _, osName, arch, err := registry.ParseZipFilename(a.Filename)
+			if err != nil {
+				continue
+			}
+			it := item(a.Namespace, a.Name, a.Version, osName+"_"+arch)
+			it.Size = a.Size
+			it.Cached = true
+			lastUsed := a.LastUsed
+			it.LastServed = &lastUsed
+		}
+	}
+
+	// Add zh hashes from cached SHA256SUMS files
+	versions := make(map[[3]string]bool)
+	for _, it := range items {
+		versions[[3]string{it.Namespace, it.Name, it.Version}] = true
+	}
+	for v := range versions {
+		if registry.NormalizesNamespace(normalized, v[0]) {
+			continue
+		}
+		data, ok := artifacts.Get(v[0], v[1], v[2], registry.ShasumsFilename(v[1], v[2]))
+		if !ok {
+			continue
+		}
+		sums := registry.ParseShasums(data)
+		for _, it := range items {
+			if it.Namespace != v[0] || it.Name != v[1] || it.Version != v[2] {
+				continue
+			}
+			if sum, ok := sums[it.Filename]; ok {
+				it.Hashes = append(it.Hashes, "zh:"+sum)
+			}
+		}
+	}
+
+	result := make([]Item, 0, len(items))
+	for _, it := range items {
+		result = append(result, *it)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Platform < b.Platform
+	})
+	return result, nil
+}
+
+// ContentType returns the MIME type for a format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatCycloneDX:
+		return "application/vnd.cyclonedx+json"
+	default:
+		return "application/json"
+	}
+}
+
+// Write renders items in the given format
+func Write(w io.Writer, format string, items []Item) error {
+	switch format {
+	case FormatJSON, "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]any{
+			"generated_at": time.Now().UTC(),
+			"providers":    items,
+		})
+	case FormatCSV:
+		return writeCSV(w, items)
+	case FormatCycloneDX:
+		return writeCycloneDX(w, items)
+	default:
+		return fmt.Errorf("unknown inventory format %q", format)
+	}
+}
+
+func writeCSV(w io.Writer, items []Item) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"namespace", "name", "version", "platform", "filename", "hashes", "size", "cached", "first_seen", "last_served"})
+	for _, it := range items {
+		size := ""
+		if it.Size > 0 {
+			size = strconv.FormatInt(it.Size, 10)
+		}
+		_ = cw.Write([]string{
+			it.Namespace,
+			it.Name,
+			it.Version,
+			it.Platform,
+			it.Filename,
+			strings.Join(it.Hashes, " "),
+			size,
+			strconv.FormatBool(it.Cached),
+			formatTime(it.FirstSeen),
+			formatTime(it.LastServed),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// CycloneDX JSON subset
+type cdxBOM struct {
+	BOMFormat   string         `json:"bomFormat"`
+	SpecVersion string         `json:"specVersion"`
+	Version     int            `json:"version"`
+	Metadata    cdxMetadata    `json:"metadata"`
+	Components  []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string    `json:"timestamp"`
+	Tools     []cdxTool `json:"tools"`
+}
+
+type cdxTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref"`
+	Group      string        `json:"group"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func writeCycloneDX(w io.Writer, items []Item) error {
+	bom := cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Components:  make([]cdxComponent, 0, len(items)),
+	}
+	bom.Metadata = cdxMetadata{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Tools:     []cdxTool{{Name: "terraform-mirror", Version: buildinfo.Version}},
+	}
+
+	for _, it := range items {
+		c := cdxComponent{
+			Type:    "application",
+			BOMRef:  it.Namespace + "/" + it.Name + "@" + it.Version + "/" + it.Platform,
+			Group:   it.Namespace,
+			Name:    "terraform-provider-" + it.Name,
+			Version: it.Version,
+			Properties: []cdxProperty{
+				{Name: "terraform:platform", Value: it.Platform},
+				{Name: "terraform:filename", Value: it.Filename},
+			},
+		}
+		for _, h := range it.Hashes {
+			switch {
+			case strings.HasPrefix(h, "zh:"):
+				c.Hashes = append(c.Hashes, cdxHash{Alg: "SHA-256", Content: strings.TrimPrefix(h, "zh:")})
+			case strings.HasPrefix(h, "h1:"):
+				c.Properties = append(c.Properties, cdxProperty{Name: "terraform:h1", Value: h})
+			}
+		}
+		if it.Size > 0 {
+			c.Properties = append(c.Properties, cdxProperty{Name: "terraform:size", Value: strconv.FormatInt(it.Size, 10)})
+		}
+		if it.FirstSeen != nil {
+			c.Properties = append(c.Properties, cdxProperty{Name: "terraform-mirror:first_seen", Value: formatTime(it.FirstSeen)})
+		}
+		if it.LastServed != nil {
+			c.Properties = append(c.Properties, cdxProperty{Name: "terraform-mirror:last_served", Value: formatTime(it.LastServed)})
+		}
+		if it.Scan != "" {
+			c.Properties = append(c.Properties, cdxProperty{Name: "terraform-mirror:scan", Value: it.Scan})
+		}
+		bom.Components = append(bom.Components, c)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bom)
+}
@@ -0,0 +1,351 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of a job
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("job not found")
+
+	// ErrBusy is returned when a job of the same kind is still running
+	ErrBusy = errors.New("a job of this kind is already running")
+
+	// ErrFinished is returned when canceling a job that has already finished
+	ErrFinished = errors.New("job has already finished")
+)
+
+// Job is an admin-triggered operation running in the background
+type Job struct {
+	ID       string            `json:"id"`
+	Kind     string            `json:"kind"`
+	State    string            `json:"state"`
+	Actor    string            `json:"actor,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Progress Progress          `json:"progress"`
+	Result   json.RawMessage   `json:"result,omitempty"`
+	Error    string            `json:"error,omitempty"`
+
+	// Output is a file written by the job (e.g. an export); it is removed with the job
+	Output string `json:"output,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Progress is how far a running job has come; Total is 0 while unknown
+type Progress struct {
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+// Finished reports whether the job is no longer running
+func (j Job) Finished() bool {
+	return j.State != StateRunning
+}
+
+// Func runs a job, reporting progress through t; its result is stored with the job as JSON
+// A job stops when ctx is canceled
+type Func func(ctx context.Context, t *Task) (any, error)
+
+// Store persists finished jobs (cache.JobStore)
+type Store interface {
+	Put(id string, data []byte) error
+	Delete(ids ...string) error
+	Load() ([][]byte, error)
+}
+
+// Manager runs jobs and keeps the history of the last finished ones
+type Manager struct {
+	store   Store // nil keeps the history in memory only
+	history int
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	wg      sync.WaitGroup
+	closing bool
+}
+
+type entry struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+// NewManager creates a manager keeping up to history finished jobs and loads them from store
+func NewManager(store Store, history int, logger *slog.Logger) (*Manager, error) {
+	m := &Manager{
+		store:   store,
+		history: history,
+		logger:  logger,
+		jobs:    make(map[string]*entry),
+	}
+	if store == nil {
+		return m, nil
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range records {
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, fmt.Errorf("parsing stored job: %w", err)
+		}
+		m.jobs[job.ID] = &entry{job: job}
+	}
+	m.mu.Lock()
+	m.prune()
+	m.mu.Unlock()
+	return m, nil
+}
+
+// Start runs fn as a job of kind in the background
+// It fails with ErrBusy, returning the running job, while another job of the same kind runs
+func (m *Manager) Start(kind, actor string, params map[string]string, fn Func) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closing {
+		return Job{}, errors.New("shutting down")
+	}
+	for _, e := range m.jobs {
+		if e.job.Kind == kind && !e.job.Finished() {
+			return e.job, ErrBusy
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{
+		job: Job{
+			ID:        newID(),
+			Kind:      kind,
+			State:     StateRunning,
+			Actor:     actor,
+			Params:    params,
+			CreatedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+	m.jobs[e.job.ID] = e
+
+	m.wg.Add(1)
+	go m.run(ctx, e.job.ID, fn)
+
+	m.logger.Info("job started", "job", e.job.ID, "kind", kind, "actor", actor)
+	return e.job, nil
+}
+
+func (m *Manager) run(ctx context.Context, id string, fn Func) {
+	defer m.wg.Done()
+
+	result, err := fn(ctx, &Task{m: m, id: id})
+
+	// A failed or canceled job keeps its partial result
+	var data json.RawMessage
+	if result != nil {
+		var marshalErr error
+		if data, marshalErr = json.Marshal(result); marshalErr != nil && err == nil {
+			err = marshalErr
+		}
+	}
+
+	m.mu.Lock()
+	e := m.jobs[id]
+	now := time.Now().UTC()
+	e.job.FinishedAt = &now
+	e.job.Result = data
+	switch {
+	case ctx.Err() != nil:
+		e.job.State = StateCanceled
+	case err != nil:
+		e.job.State = StateFailed
+		e.job.Error = err.Error()
+	default:
+		e.job.State = StateSucceeded
+	}
+	e.cancel()
+	job := e.job
+	m.prune()
+	m.mu.Unlock()
+
+	m.logger.Info("job finished", "job", id, "kind", job.Kind, "state", job.State, "error", job.Error,
+		"duration", now.Sub(job.CreatedAt).Round(time.Millisecond))
+	m.save(job)
+}
+
+// save persists a finished job
+func (m *Manager) save(job Job) {
+	if m.store == nil || m.history == 0 {
+		return
+	}
+	data, err := json.Marshal(job)
+	if err == nil {
+		err = m.store.Put(job.ID, data)
+	}
+	if err != nil {
+		m.logger.Error("failed to store job", "job", job.ID, "error", err)
+	}
+}
+
+// prune drops the oldest finished jobs beyond the history size and their output files
+// Callers hold m.mu
+func (m *Manager) prune() {
+	var finished []Job
+	for _, e := range m.jobs {
+		if e.job.Finished() {
+			finished = append(finished, e.job)
+		}
+	}
+	if len(finished) <= m.history {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].ID < finished[j].ID })
+
+	var dropped []string
+	for _, job := range finished[:len(finished)-m.history] {
+		delete(m.jobs, job.ID)
+		dropped = append(dropped, job.ID)
+		if job.Output != "" {
+			if err := os.Remove(job.Output); err != nil && !errors.Is(err, os.ErrNotExist) {
+				m.logger.Warn("failed to remove job output", "job", job.ID, "file", job.Output, "error", err)
+			}
+		}
+	}
+	if m.store != nil {
+		if err := m.store.Delete(dropped...); err != nil {
+			m.logger.Error("failed to prune job history", "error", err)
+		}
+	}
+}
+
+// Get returns a job by ID
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// List returns running and recent jobs, newest first
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Job, 0, len(m.jobs))
+	for _, e := range m.jobs {
+		list = append(list, e.job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list
+}
+
+// Cancel stops a running job; it is recorded as canceled once its function returns
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if e.job.Finished() {
+		return e.job, ErrFinished
+	}
+	e.cancel()
+	return e.job, nil
+}
+
+// Shutdown cancels running jobs and waits until they are recorded or ctx is done
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closing = true
+	for _, e := range m.jobs {
+		if !e.job.Finished() {
+			e.cancel()
+		}
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Task reports the progress of a running job
+type Task struct {
+	m  *Manager
+	id string
+}
+
+// update changes the job under the manager's lock
+func (t *Task) update(fn func(job *Job)) {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	if e, ok := t.m.jobs[t.id]; ok {
+		fn(&e.job)
+	}
+}
+
+// ID returns the ID of the job
+func (t *Task) ID() string {
+	return t.id
+}
+
+// SetTotal sets the number of steps of the job
+func (t *Task) SetTotal(total int64) {
+	t.update(func(job *Job) { job.Progress.Total = total })
+}
+
+// Add records n completed steps
+func (t *Task) Add(n int64) {
+	t.update(func(job *Job) { job.Progress.Done += n })
+}
+
+// SetMessage describes the current step
+func (t *Task) SetMessage(message string) {
+	t.update(func(job *Job) { job.Progress.Message = message })
+}
+
+// SetOutput records a file written by the job
+func (t *Task) SetOutput(path string) {
+	t.update(func(job *Job) { job.Output = path })
+}
+
+// newID returns a job ID that sorts by creation time
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405.000") + "-" + hex.EncodeToString(b)
+}
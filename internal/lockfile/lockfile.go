@@ -0,0 +1,57 @@
+package lockfile
+
+import (
+	"fmt"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hcl"
+)
+
+// Provider is a provider block of a .terraform.lock.hcl file
+type Provider struct {
+	Address     string   // "hostname/namespace/type" as written in the file
+	Version     string   // selected version
+	Constraints string   // version constraints of the configuration, if any
+	Hashes      []string // "h1:...", "zh:..."
+}
+
+// Parse reads the provider blocks of a dependency lock file
+// Only the subset of HCL that Terraform and OpenTofu write is supported (see hcl.Parse)
+func Parse(data []byte) ([]Provider, error) {
+	blocks, err := hcl.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []Provider
+	seen := make(map[string]bool)
+	for _, block := range blocks {
+		if block.Type != "provider" {
+			continue
+		}
+		line := block.Line
+		if len(block.Labels) != 1 {
+			return nil, fmt.Errorf("line %d: provider block needs one label, the provider address", line)
+		}
+
+		provider := Provider{Address: block.Labels[0]}
+		if seen[provider.Address] {
+			return nil, fmt.Errorf("line %d: duplicate provider block for %s", line, provider.Address)
+		}
+		seen[provider.Address] = true
+		for name, v := range block.Attrs {
+			switch name {
+			case "version":
+				provider.Version = v.Str
+			case "constraints":
+				provider.Constraints = v.Str
+			case "hashes":
+				provider.Hashes = v.List
+			}
+		}
+		if provider.Version == "" {
+			return nil, fmt.Errorf("line %d: provider %s has no version", line, provider.Address)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
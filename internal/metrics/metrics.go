@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metric names shared by all exporters
+const (
+	HTTPRequests        = "http.requests"           // count; tags: route, status
+	HTTPDuration        = "http.duration"           // timing; tags: route
+	HTTPBytesServed     = "http.bytes_served"       // count; tags: route
+	CacheHits           = "cache.hits"              // count; tags: cache
+	CacheMisses         = "cache.misses"            // count; tags: cache
+	UpstreamRequests    = "upstream.requests"       // count; tags: host, status
+	UpstreamLatency     = "upstream.latency"        // timing; tags: host
+	UpstreamRateLimited = "upstream.rate_limited"   // count; tags: host
+	UpstreamSchemaDrift = "upstream.schema_drift"   // count; tags: document, kind
+	ConnsOpened         = "http.connections.opened" // count
+	ConnsActive         = "http.connections.active" // gauge
+	HashFailures        = "hash.failures"           // count; tags: provider
+	ArchivesQuarantined = "archives.quarantined"    // count; tags: provider
+	TenantRequests      = "tenant.requests"         // count; tags: tenant, status
+	TenantBytes         = "tenant.bytes_served"     // count; tags: tenant
+	DownloadsActive     = "downloads.active"        // gauge
+	DownloadsQueued     = "downloads.queued"        // gauge
+	DownloadsRejected   = "downloads.rejected"      // count; tags: provider
+	NetworkRejected     = "http.network_rejected"   // count; tags: group
+	DiskFree            = "disk.free_bytes"         // gauge; tags: volume
+	CacheBytes          = "cache.archive_bytes"     // gauge
+	SpoolBytes          = "spool.bytes"             // gauge
+)
+
+// Recorder receives metrics
+// Tags are "key:value" pairs; exporters without tag support drop them
+type Recorder interface {
+	Count(name string, value int64, tags ...string)
+	Timing(name string, d time.Duration, tags ...string)
+	Gauge(name string, value float64, tags ...string)
+}
+
+// Nop returns a Recorder that discards everything
+func Nop() Recorder {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Count(string, int64, ...string)          {}
+func (nop) Timing(string, time.Duration, ...string) {}
+func (nop) Gauge(string, float64, ...string)        {}
+
+// New creates the Recorder for an exporter name: "" or "none", "statsd" or "dogstatsd"
+func New(exporter, statsdAddr, prefix string) (Recorder, error) {
+	switch exporter {
+	case "", "none":
+		return Nop(), nil
+	case "statsd":
+		return NewStatsD(statsdAddr, prefix, false)
+	case "dogstatsd":
+		return NewStatsD(statsdAddr, prefix, true)
+	default:
+		return nil, fmt.Errorf("unknown metrics exporter %q", exporter)
+	}
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacketSize keeps StatsD datagrams below a typical MTU
+const maxPacketSize = 1432
+
+// flushInterval is how often buffered metrics are sent
+const flushInterval = time.Second
+
+// StatsD sends metrics to a StatsD or DogStatsD agent over UDP
+// Metrics are buffered and sent in batches; send errors are ignored
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	dogstatsd bool
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewStatsD creates a StatsD exporter
+// With dogstatsd enabled tags are sent in the DogStatsD "|#key:value" format
+func NewStatsD(addr, prefix string, dogstatsd bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to statsd: %w", err)
+	}
+
+	s := &StatsD{
+		conn:      conn,
+		prefix:    prefix,
+		dogstatsd: dogstatsd,
+		buf:       make([]byte, 0, maxPacketSize),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+// Count sends a counter increment
+func (s *StatsD) Count(name string, value int64, tags ...string) {
+	s.write(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Timing sends a timer in milliseconds
+func (s *StatsD) Timing(name string, d time.Duration, tags ...string) {
+	s.write(name, strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64), "ms", tags)
+}
+
+// Gauge sends a gauge value
+func (s *StatsD) Gauge(name string, value float64, tags ...string) {
+	s.write(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (s *StatsD) write(name, value, kind string, tags []string) {
+	line := s.prefix + name + ":" + value + "|" + kind
+	if s.dogstatsd && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > maxPacketSize {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+func (s *StatsD) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		s.flushLocked()
+		s.mu.Unlock()
+	}
+}
+
+func (s *StatsD) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	_, _ = s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
@@ -0,0 +1,336 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for keys missing from the bucket
+var ErrNotFound = errors.New("object not found")
+
+// unsignedPayload skips hashing request bodies in signatures, as S3 allows
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// maxPresignExpiry is the longest validity S3 accepts for presigned URLs
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// emptyPayload is the SHA-256 of an empty body
+const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Options configure a Store
+type Options struct {
+	// Bucket URL, path-style ("http://minio:9000/mirror") or virtual-hosted
+	// ("https://mirror.s3.eu-west-1.amazonaws.com")
+	URL string
+
+	Region    string
+	AccessKey string // empty sends unsigned requests (public or proxy-authenticated buckets)
+	SecretKey string
+
+	// Timeout of a single request, including transferring the object (0 = none)
+	Timeout time.Duration
+}
+
+// Store is an S3-compatible bucket accessed with Signature Version 4
+type Store struct {
+	base       *url.URL
+	region     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// New creates a store for a bucket URL
+func New(opts Options) (*Store, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid object store URL: %w", err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid object store URL %q: expected an absolute http or https URL", opts.URL)
+	}
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &Store{
+		base:       base,
+		region:     region,
+		accessKey:  opts.AccessKey,
+		secretKey:  opts.SecretKey,
+		httpClient: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// String returns the bucket URL for logs
+func (s *Store) String() string {
+	return s.base.String()
+}
+
+// Get returns the content of an object and its size
+// The caller must close the reader
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := checkResponse(resp, key); err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Exists reports whether an object is in the bucket
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	err = checkResponse(resp, key)
+	resp.Body.Close()
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put uploads an object of known size, replacing an existing one
+func (s *Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(resp, key)
+	resp.Body.Close()
+	return err
+}
+
+// listResult is the body of a ListObjectsV2 response
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the keys starting with prefix
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(resp, prefix); err != nil {
+			return nil, err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing object list: %w", err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for an object (key) or the bucket (empty key)
+func (s *Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.base
+	u.Path += "/" + key
+	u.RawPath = escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+	return s.httpClient.Do(req)
+}
+
+// sign adds a Signature Version 4 Authorization header
+// Bodies are not part of the signature (UNSIGNED-PAYLOAD)
+func (s *Store) sign(req *http.Request, now time.Time) {
+	payload := emptyPayload
+	if req.Body != nil && req.ContentLength != 0 {
+		payload = unsignedPayload
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.accessKey == "" {
+		return
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payload,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, scope, canonicalRequest)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Presign returns a URL that downloads an object without credentials until it expires (at most 7 days)
+// Without an access key it is the plain object URL
+func (s *Store) Presign(key string, expires time.Duration) string {
+	return s.presign(key, expires, time.Now().UTC())
+}
+
+func (s *Store) presign(key string, expires time.Duration, now time.Time) string {
+	u := *s.base
+	u.Path += "/" + key
+	u.RawPath = escape(u.Path, false)
+	if s.accessKey == "" {
+		return u.String()
+	}
+
+	scope := s.scope(now)
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + scope},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(min(expires, maxPresignExpiry) / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(now, scope, canonicalRequest)
+	return u.String()
+}
+
+// scope is the credential scope of requests signed at now
+func (s *Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request with a key derived from the secret key, date and region
+func (s *Store) signature(now time.Time, scope, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// checkResponse turns unsuccessful responses into errors, closing their body
+func checkResponse(resp *http.Response, key string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("object store: %s: status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(detail)))
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signatures require
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, escape(name, true)+"="+escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes everything but unreserved characters (and "/" unless slash is set)
+func escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
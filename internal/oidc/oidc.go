@@ -0,0 +1,377 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the RS, PS and ES algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalid is returned for JWTs that are malformed, not signed by the issuer or not meant for the mirror
+	ErrInvalid = errors.New("invalid JWT")
+
+	// ErrExpired is returned for JWTs past their expiry
+	ErrExpired = errors.New("JWT expired")
+)
+
+const (
+	// leeway tolerates clock skew between the issuer and the mirror
+	leeway = time.Minute
+
+	// minRefresh limits how often a JWT with an unknown key ID triggers a JWKS fetch
+	minRefresh = time.Minute
+
+	// fetchTimeout limits discovery and JWKS requests
+	fetchTimeout = 10 * time.Second
+)
+
+// Claims are the claims of a verified JWT
+type Claims map[string]any
+
+// String returns a string claim ("" when missing or not a string)
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Options configures a Verifier
+type Options struct {
+	// Issuer is the iss claim JWTs must carry, and the base of OpenID Connect discovery
+	Issuer string
+
+	// Audience must be among the aud claim of JWTs
+	Audience string
+
+	// JWKSURL is where the issuer publishes its signing keys ("" to discover it from the issuer)
+	JWKSURL string
+
+	// KeysTTL is how long fetched signing keys are used before they are fetched again
+	KeysTTL time.Duration
+}
+
+// Verifier validates JWTs of an OpenID Connect issuer, e.g. the workload identities of CI systems
+// The issuer's signing keys are fetched from its JWKS and cached; a JWT signed with an unknown
+// key refreshes them early (at most once per minRefresh), so rotated keys are picked up
+type Verifier struct {
+	opts   Options
+	client *http.Client
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey // by key ID
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+// NewVerifier returns a verifier; keys are fetched on first use
+func NewVerifier(opts Options) *Verifier {
+	return &Verifier{opts: opts, client: &http.Client{Timeout: fetchTimeout}, jwksURL: opts.JWKSURL}
+}
+
+// IsJWT reports whether a bearer credential looks like a JWT rather than a static token
+func IsJWT(credential string) bool {
+	return strings.HasPrefix(credential, "eyJ") && strings.Count(credential, ".") == 2
+}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature, issuer, audience and validity period of a JWT and returns its claims
+func (v *Verifier) Verify(raw string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	keys, err := v.signingKeys(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalid)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims checks the registered claims of a JWT whose signature is valid
+func (v *Verifier) checkClaims(claims Claims) error {
+	if iss := claims.String("iss"); strings.TrimSuffix(iss, "/") != v.opts.Issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalid, iss)
+	}
+
+	audience := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = aud == v.opts.Audience
+	case []any:
+		for _, a := range aud {
+			if a == v.opts.Audience {
+				audience = true
+			}
+		}
+	}
+	if !audience {
+		return fmt.Errorf("%w: audience %v", ErrInvalid, claims["aud"])
+	}
+
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalid)
+	}
+	if now.After(exp.Add(leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalid)
+	}
+	return nil
+}
+
+// signingKeys returns the keys a JWT may be signed with: the one with its key ID, or all
+// keys for a JWT without one
+func (v *Verifier) signingKeys(kid string) ([]crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stale := time.Since(v.fetchedAt) > v.opts.KeysTTL
+	_, known := v.keys[kid]
+	if (stale || (kid != "" && !known)) && time.Since(v.triedAt) > minRefresh {
+		v.triedAt = time.Now()
+		if err := v.refresh(); err != nil && v.keys == nil {
+			return nil, err
+		}
+	}
+
+	if kid != "" {
+		if key, ok := v.keys[kid]; ok {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalid, kid)
+	}
+	keys := make([]crypto.PublicKey, 0, len(v.keys))
+	for _, key := range v.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// refresh fetches the signing keys, discovering the JWKS URL first if needed; the caller holds v.mu
+// Keys fetched before are kept when the issuer cannot be reached
+func (v *Verifier) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	if v.jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.fetchJSON(ctx, v.opts.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return fmt.Errorf("OIDC discovery: %w", err)
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != v.opts.Issuer || discovery.JWKSURI == "" {
+			return fmt.Errorf("OIDC discovery: issuer %q does not match or has no jwks_uri", discovery.Issuer)
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.fetchJSON(ctx, v.jwksURL, &set); err != nil {
+		return fmt.Errorf("JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS: no usable signing keys")
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+func (v *Verifier) fetchJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 1<<20)).Decode(out)
+}
+
+// curves are the curves of EC keys by JWK name
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// ecAlgs is the algorithm signing with each curve, by curve size
+var ecAlgs = map[int]string{256: "ES256", 384: "ES384", 521: "ES512"}
+
+// jwk is a JSON Web Key of a JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key of an RSA, EC (P-256, P-384, P-521) or Ed25519 JWK
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := decodeInt(k.N)
+		e, err2 := decodeInt(k.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := curves[k.Crv]
+		x, err1 := decodeInt(k.X)
+		y, err2 := decodeInt(k.Y)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks a JWS signature of one of the RS, PS, ES or EdDSA algorithms
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, sig)
+	}
+
+	var hash crypto.Hash
+	switch strings.TrimLeft(alg, "RPES") {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if ecAlgs[pub.Curve.Params().BitSize] != alg || len(sig) != 2*size {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalid
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, ErrInvalid
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// numericDate reads a NumericDate claim (seconds since the epoch)
+func numericDate(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
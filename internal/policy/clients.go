@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+// Client is a Terraform-compatible CLI identified by its User-Agent
+type Client struct {
+	Product string // "terraform" or "opentofu"
+	Version string // e.g. "1.5.7"
+}
+
+func (c Client) String() string {
+	return c.Product + "/" + c.Version
+}
+
+// clientProducts maps User-Agent product tokens (lowercase) to client products
+var clientProducts = map[string]string{
+	"terraform": "terraform",
+	"opentofu":  "opentofu",
+}
+
+// ParseUserAgent finds the CLI product and version in a User-Agent header,
+// e.g. "Terraform/1.5.7 (+https://www.terraform.io)", "HashiCorp Terraform/0.11.14" or "OpenTofu/1.6.0"
+func ParseUserAgent(ua string) (Client, bool) {
+	for _, field := range strings.Fields(ua) {
+		product, version, ok := strings.Cut(field, "/")
+		if !ok {
+			continue
+		}
+		name, known := clientProducts[strings.ToLower(product)]
+		version = strings.TrimPrefix(version, "v")
+		if known && semver.IsValid("v"+version) {
+			return Client{Product: name, Version: version}, true
+		}
+	}
+	return Client{}, false
+}
+
+// ClientRule changes how the mirror answers some CLI versions
+type ClientRule struct {
+	Client   string `json:"client"`             // "terraform" or "opentofu"
+	Versions string `json:"versions,omitempty"` // constraints, e.g. "< 0.14"; empty for every version
+
+	// OmitHashes lists hash schemes ("zh", "h1" or another registered one) left out of {version}.json
+	OmitHashes []string `json:"omit_hashes,omitempty"`
+
+	// Deny refuses mirror requests with this message when set
+	Deny string `json:"deny,omitempty"`
+
+	constraints versions.Constraints
+}
+
+// clientRulesFile is the client rules file format
+type clientRulesFile struct {
+	Rules []ClientRule `json:"rules"`
+}
+
+// ClientRules holds the rules from TF_MIRROR_CLIENT_RULES
+type ClientRules struct {
+	rules []*ClientRule
+}
+
+// LoadClientRules reads a client rules file
+func LoadClientRules(path string) (*ClientRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file clientRulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing client rules: %w", err)
+	}
+
+	c := &ClientRules{}
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		rule.Client = strings.ToLower(rule.Client)
+		if _, ok := clientProducts[rule.Client]; !ok {
+			return nil, fmt.Errorf("client rule %d: client must be terraform or opentofu", i+1)
+		}
+		for _, scheme := range rule.OmitHashes {
+			if _, ok := hash.Lookup(scheme); !ok {
+				return nil, fmt.Errorf("client rule %d: unknown hash scheme %q", i+1, scheme)
+			}
+		}
+		cs, err := versions.ParseConstraints(rule.Versions)
+		if err != nil {
+			return nil, fmt.Errorf("client rule %d: %w", i+1, err)
+		}
+		rule.constraints = cs
+		c.rules = append(c.rules, rule)
+	}
+	return c, nil
+}
+
+// Len returns the number of rules
+func (c *ClientRules) Len() int {
+	return len(c.rules)
+}
+
+// OmitsHashes reports whether any rule removes hashes, so responses vary by User-Agent
+func (c *ClientRules) OmitsHashes() bool {
+	for _, rule := range c.rules {
+		if len(rule.OmitHashes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the rules that apply to a client, in file order
+func (c *ClientRules) Match(client Client) []*ClientRule {
+	var matched []*ClientRule
+	for _, rule := range c.rules {
+		if rule.Client == client.Product && rule.constraints.Matches(client.Version) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
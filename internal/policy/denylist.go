@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+// maxDenyListSize limits a downloaded deny-list
+const maxDenyListSize = 10 << 20
+
+// Advisory blocks versions of a provider
+type Advisory struct {
+	ID       string `json:"id"`       // e.g. "CVE-2024-12345"
+	URL      string `json:"url"`      // advisory reference
+	Provider string `json:"provider"` // "namespace/type"
+	Versions string `json:"versions"` // constraints, e.g. ">= 5.0, < 5.31.2"
+	Summary  string `json:"summary"`
+
+	constraints versions.Constraints
+}
+
+// Reference returns the advisory ID and URL for error messages
+func (a *Advisory) Reference() string {
+	if a.URL == "" {
+		return a.ID
+	}
+	return a.ID + " (" + a.URL + ")"
+}
+
+// denyListFile is the deny-list format
+type denyListFile struct {
+	Advisories []Advisory `json:"advisories"`
+}
+
+// DenyList blocks provider versions with known vulnerabilities
+// It is loaded from a local file or an http(s) URL and refreshed periodically;
+// the last good list stays active when a refresh fails
+type DenyList struct {
+	source string
+	client *http.Client
+	logger *slog.Logger
+
+	mu         sync.RWMutex
+	byProvider map[string][]*Advisory
+}
+
+// NewDenyList creates a deny-list for a file path or URL
+func NewDenyList(source string, logger *slog.Logger) *DenyList {
+	return &DenyList{
+		source: source,
+		client: &http.Client{Timeout: 30 * time.Second},
+		logger: logger,
+	}
+}
+
+// Load reads the deny-list source and replaces the active list
+func (d *DenyList) Load(ctx context.Context) error {
+	data, err := d.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	var file denyListFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing deny-list: %w", err)
+	}
+
+	byProvider := make(map[string][]*Advisory)
+	for i := range file.Advisories {
+		a := &file.Advisories[i]
+		cs, err := versions.ParseConstraints(a.Versions)
+		if err != nil {
+			return fmt.Errorf("advisory %s: %w", a.ID, err)
+		}
+		if len(cs) == 0 {
+			return fmt.Errorf("advisory %s: no versions", a.ID)
+		}
+		a.constraints = cs
+		provider := strings.ToLower(a.Provider)
+		byProvider[provider] = append(byProvider[provider], a)
+	}
+
+	d.mu.Lock()
+	d.byProvider = byProvider
+	d.mu.Unlock()
+
+	d.logger.Info("deny-list loaded", "source", d.source, "advisories", len(file.Advisories))
+	return nil
+}
+
+func (d *DenyList) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(d.source, "http://") && !strings.HasPrefix(d.source, "https://") {
+		return os.ReadFile(d.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching deny-list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching deny-list: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDenyListSize))
+}
+
+// Run refreshes the deny-list every interval until ctx is done
+func (d *DenyList) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Load(ctx); err != nil {
+				d.logger.Error("failed to refresh deny-list, keeping previous list", "source", d.source, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check returns the advisory blocking a provider version, if any
+func (d *DenyList) Check(namespace, name, version string) (*Advisory, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, a := range d.byProvider[strings.ToLower(namespace+"/"+name)] {
+		if a.constraints.Matches(version) {
+			return a, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+// Deprecation marks a provider, or some of its versions, as deprecated
+// Deprecated versions are still served, with a warning
+type Deprecation struct {
+	Provider string `json:"provider"`           // "namespace/type"
+	Versions string `json:"versions,omitempty"` // constraints, e.g. "< 4.0"; empty for every version
+	Message  string `json:"message"`            // e.g. "use hashicorp/cloudinit instead"
+
+	constraints versions.Constraints
+}
+
+// Warning describes the deprecation of a provider version ("" for the provider as a whole)
+func (d *Deprecation) Warning(namespace, name, version string) string {
+	subject := namespace + "/" + name
+	if version != "" {
+		subject += " " + version
+	}
+	if d.Message == "" {
+		return subject + " is deprecated"
+	}
+	return subject + " is deprecated: " + d.Message
+}
+
+// deprecationsFile is the deprecations file format
+type deprecationsFile struct {
+	Deprecations []Deprecation `json:"deprecations"`
+}
+
+// Deprecations holds the deprecated providers and versions from TF_MIRROR_DEPRECATIONS
+type Deprecations struct {
+	byProvider map[string][]*Deprecation
+}
+
+// LoadDeprecations reads a deprecations file
+func LoadDeprecations(path string) (*Deprecations, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file deprecationsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing deprecations: %w", err)
+	}
+
+	d := &Deprecations{byProvider: make(map[string][]*Deprecation)}
+	for i := range file.Deprecations {
+		dep := &file.Deprecations[i]
+		if _, _, ok := strings.Cut(dep.Provider, "/"); !ok {
+			return nil, fmt.Errorf("deprecation %d: provider must be namespace/type", i+1)
+		}
+		cs, err := versions.ParseConstraints(dep.Versions)
+		if err != nil {
+			return nil, fmt.Errorf("deprecation of %s: %w", dep.Provider, err)
+		}
+		dep.constraints = cs
+		provider := strings.ToLower(dep.Provider)
+		d.byProvider[provider] = append(d.byProvider[provider], dep)
+	}
+	return d, nil
+}
+
+// Len returns the number of deprecations
+func (d *Deprecations) Len() int {
+	n := 0
+	for _, deps := range d.byProvider {
+		n += len(deps)
+	}
+	return n
+}
+
+// Check returns the deprecation covering a provider version, if any
+func (d *Deprecations) Check(namespace, name, version string) (*Deprecation, bool) {
+	for _, dep := range d.byProvider[strings.ToLower(namespace+"/"+name)] {
+		if dep.constraints.Matches(version) {
+			return dep, true
+		}
+	}
+	return nil, false
+}
+
+// CheckProvider returns the deprecation covering every version of a provider, if any
+func (d *Deprecations) CheckProvider(namespace, name string) (*Deprecation, bool) {
+	for _, dep := range d.byProvider[strings.ToLower(namespace+"/"+name)] {
+		if len(dep.constraints) == 0 {
+			return dep, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,223 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tombstone withdraws a provider version: it stays on disk but is never served
+type Tombstone struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TombstoneEvent is an audit log record
+type TombstoneEvent struct {
+	Action    string    `json:"action"` // "tombstone" or "restore"
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor"`
+	Time      time.Time `json:"time"`
+}
+
+// Tombstones stores tombstoned versions in files with an append-only audit log
+// Layout: {dir}/{namespace}/{name}/{version}.json and {dir}/audit.log
+type Tombstones struct {
+	dir string
+
+	mu      sync.RWMutex
+	entries map[string]Tombstone // "namespace/name/version"
+}
+
+// NewTombstones loads tombstones from dir
+func NewTombstones(dir string) (*Tombstones, error) {
+	t := &Tombstones{
+		dir:     dir,
+		entries: make(map[string]Tombstone),
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var ts Tombstone
+		if err := json.Unmarshal(data, &ts); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		t.entries[tombstoneKey(ts.Namespace, ts.Name, ts.Version)] = ts
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func tombstoneKey(namespace, name, version string) string {
+	return namespace + "/" + name + "/" + version
+}
+
+func (t *Tombstones) path(namespace, name, version string) string {
+	return filepath.Join(t.dir, namespace, name, version+".json")
+}
+
+// Get returns the tombstone for a provider version
+func (t *Tombstones) Get(namespace, name, version string) (Tombstone, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ts, ok := t.entries[tombstoneKey(namespace, name, version)]
+	return ts, ok
+}
+
+// List returns all tombstones sorted by provider and version
+func (t *Tombstones) List() []Tombstone {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]Tombstone, 0, len(t.entries))
+	for _, ts := range t.entries {
+		result = append(result, ts)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return tombstoneKey(result[i].Namespace, result[i].Name, result[i].Version) <
+			tombstoneKey(result[j].Namespace, result[j].Name, result[j].Version)
+	})
+	return result
+}
+
+// Add tombstones a provider version
+func (t *Tombstones) Add(namespace, name, version, reason, actor string) (Tombstone, error) {
+	ts := Tombstone{
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		Reason:    reason,
+		Actor:     actor,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.MarshalIndent(ts, "", "  ")
+	if err != nil {
+		return ts, err
+	}
+
+	path := t.path(namespace, name, version)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return ts, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return ts, err
+	}
+	t.entries[tombstoneKey(namespace, name, version)] = ts
+
+	return ts, t.audit(TombstoneEvent{
+		Action:    "tombstone",
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		Reason:    reason,
+		Actor:     actor,
+		Time:      ts.CreatedAt,
+	})
+}
+
+// Restore removes a tombstone; it reports false if the version was not tombstoned
+func (t *Tombstones) Restore(namespace, name, version, reason, actor string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tombstoneKey(namespace, name, version)
+	if _, ok := t.entries[key]; !ok {
+		return false, nil
+	}
+
+	if err := os.Remove(t.path(namespace, name, version)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	delete(t.entries, key)
+
+	return true, t.audit(TombstoneEvent{
+		Action:    "restore",
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		Reason:    reason,
+		Actor:     actor,
+		Time:      time.Now().UTC(),
+	})
+}
+
+// History returns the audit log, oldest first
+func (t *Tombstones) History() ([]TombstoneEvent, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	data, err := os.ReadFile(filepath.Join(t.dir, "audit.log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var events []TombstoneEvent
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e TombstoneEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// audit appends an event to the audit log; the caller holds t.mu
+func (t *Tombstones) audit(e TombstoneEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(t.dir, "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,77 @@
+package prefetch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+// Selector picks versions from the upstream versions list
+// Syntax (comma-separated, all must match):
+//
+//	5.31.0              exact version
+//	>= 5.0, < 6.0       Terraform-style constraints (=, !=, >, >=, <, <=, ~>)
+//	latest              the newest matching release (default)
+//	latest:3            the 3 newest matching releases
+type Selector struct {
+	constraints versions.Constraints
+	latest      int // 0 = all matching versions
+}
+
+// ParseSelector parses a version selector; an empty string selects the latest release
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		sel.latest = 1
+		return sel, nil
+	}
+
+	var constraints []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		switch {
+		case part == "latest":
+			sel.latest = 1
+		case strings.HasPrefix(part, "latest:"):
+			n, err := strconv.Atoi(strings.TrimPrefix(part, "latest:"))
+			if err != nil || n < 1 {
+				return sel, fmt.Errorf("invalid selector %q", part)
+			}
+			sel.latest = n
+		default:
+			constraints = append(constraints, part)
+		}
+	}
+
+	cs, err := versions.ParseConstraints(strings.Join(constraints, ","))
+	if err != nil {
+		return sel, err
+	}
+	sel.constraints = cs
+	return sel, nil
+}
+
+// Select returns matching versions, newest first
+func (sel Selector) Select(available []string) []string {
+	var matched []string
+	for _, v := range available {
+		if sel.constraints.Check(v) {
+			matched = append(matched, v)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return versions.Compare(matched[i], matched[j]) > 0
+	})
+
+	if sel.latest > 0 && len(matched) > sel.latest {
+		matched = matched[:sel.latest]
+	}
+	return matched
+}
@@ -0,0 +1,113 @@
+package prefetch
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hcl"
+)
+
+// isManifest reports whether a prefetch file is an HCL manifest rather than a provider list
+func isManifest(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".hcl" || ext == ".tf"
+}
+
+// ParseManifest translates an HCL provider manifest into entries, to migrate from existing
+// bundling pipelines. Two formats are read:
+//
+//	providers { ... }                           terraform-bundle manifests, with
+//	                                            aws = { versions = ["~> 5.0"], source = "hashicorp/aws" }
+//	                                            or the older aws = ["~> 2.0"]
+//	terraform { required_providers { ... } }   Terraform configurations (e.g. the versions.tf
+//	                                            Terragrunt generates), with
+//	                                            aws = { source = "hashicorp/aws", version = "~> 5.0" }
+//	                                            or the older aws = "~> 2.0"
+//
+// Each version constraint selects its newest matching release, as terraform-bundle and
+// terraform init do; providers without a source are in the hashicorp namespace
+func ParseManifest(data []byte) ([]Entry, error) {
+	blocks, err := hcl.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	seen := make(map[string]bool)
+	add := func(line int, name, source string, constraints []string) error {
+		if source == "" {
+			source = "hashicorp/" + name
+		}
+		if len(constraints) == 0 {
+			constraints = []string{""}
+		}
+		for _, c := range constraints {
+			selector := "latest"
+			if strings.TrimSpace(c) != "" {
+				selector = c + ", latest"
+			}
+			e, err := ParseEntry(source + "@" + selector)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", line, name, err)
+			}
+			if !seen[e.String()] {
+				seen[e.String()] = true
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	}
+
+	for _, block := range blocks {
+		switch block.Type {
+		case "providers":
+			for _, name := range byLine(block.Attrs) {
+				v := block.Attrs[name]
+				switch {
+				case v.List != nil:
+					err = add(v.Line, name, "", v.List)
+				case v.Object != nil && v.Object["versions"].List != nil:
+					err = add(v.Line, name, v.Object["source"].Str, v.Object["versions"].List)
+				default:
+					err = fmt.Errorf("line %d: %s: expected a list of versions or { versions = [...] }", v.Line, name)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		case "terraform":
+			for _, nested := range block.Blocks {
+				if nested.Type != "required_providers" {
+					continue
+				}
+				for _, name := range byLine(nested.Attrs) {
+					v := nested.Attrs[name]
+					switch {
+					case v.IsString():
+						err = add(v.Line, name, "", []string{v.Str})
+					case v.Object != nil:
+						err = add(v.Line, name, v.Object["source"].Str, []string{v.Object["version"].Str})
+					default:
+						err = fmt.Errorf("line %d: %s: expected a version or { source = ..., version = ... }", v.Line, name)
+					}
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// byLine returns the names of attributes in the order they are written
+func byLine(attrs map[string]hcl.Value) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return attrs[names[i]].Line < attrs[names[j]].Line })
+	return names
+}
@@ -0,0 +1,266 @@
+package prefetch
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/fetcher"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// Entry is a provider to prefetch with the versions to select
+// Versions are resolved against the upstream versions list on every run
+type Entry struct {
+	Namespace string
+	Name      string
+	Versions  string // selector as written, e.g. ">= 5.0, < 6.0" or "latest:3"
+	Selector  Selector
+}
+
+func (e Entry) String() string {
+	if e.Versions == "" {
+		return e.Namespace + "/" + e.Name
+	}
+	return e.Namespace + "/" + e.Name + " " + e.Versions
+}
+
+// ParseEntry parses "[hostname/]namespace/type[@selector]" or "[hostname/]namespace/type selector"
+// See Selector for the version syntax
+func ParseEntry(s string) (Entry, error) {
+	var e Entry
+
+	s = strings.TrimSpace(s)
+	addr, versions := s, ""
+	if i := strings.IndexAny(s, " \t@"); i >= 0 {
+		addr, versions = s[:i], s[i+1:]
+	}
+	e.Versions = strings.TrimSpace(versions)
+
+	selector, err := ParseSelector(e.Versions)
+	if err != nil {
+		return e, fmt.Errorf("%s: %w", addr, err)
+	}
+	e.Selector = selector
+
+	parts := strings.Split(addr, "/")
+	switch len(parts) {
+	case 2:
+		e.Namespace, e.Name = parts[0], parts[1]
+	case 3:
+		e.Namespace, e.Name = parts[1], parts[2]
+	default:
+		return e, fmt.Errorf("invalid provider address %q", s)
+	}
+
+	if e.Namespace == "" || e.Name == "" {
+		return e, fmt.Errorf("invalid provider address %q", s)
+	}
+	return e, nil
+}
+
+// Parse reads entries, one per line; blank lines and # comments are ignored
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		e, err := ParseEntry(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// ParseFile reads entries from a file; .hcl and .tf files are read as manifests (see ParseManifest)
+func ParseFile(path string) ([]Entry, error) {
+	if isManifest(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseManifest(data)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Jobs expands entries into one download job per platform
+// platforms limits the platforms ("linux_amd64"); empty means all published platforms
+// An entry that cannot be resolved (e.g. an unknown provider or no matching version) is passed
+// to skip and left out, so one bad entry does not stop the others; only cancellation fails
+func Jobs(ctx context.Context, reg *registry.Registry, entries []Entry, platforms []string, skip func(Entry, error)) ([]fetcher.Job, error) {
+	wanted := make(map[string]bool, len(platforms))
+	for _, p := range platforms {
+		wanted[p] = true
+	}
+
+	var jobs []fetcher.Job
+	for _, e := range entries {
+		entryJobs, err := expand(ctx, reg, e, wanted)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			skip(e, err)
+			continue
+		}
+		jobs = append(jobs, entryJobs...)
+	}
+	return jobs, nil
+}
+
+// expand returns the jobs of one entry
+func expand(ctx context.Context, reg *registry.Registry, e Entry, wanted map[string]bool) ([]fetcher.Job, error) {
+	namespace, name := reg.Resolve(e.Namespace, e.Name)
+
+	upstreamVersions, err := reg.Versions(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := e.Selector.Select(upstreamVersions)
+	if len(versions) == 0 {
+		return nil, errors.New("no matching versions")
+	}
+
+	var jobs []fetcher.Job
+	for _, version := range versions {
+		available, err := reg.Platforms(ctx, namespace, name, version)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range available {
+			if len(wanted) > 0 && !wanted[p.OS+"_"+p.Arch] {
+				continue
+			}
+			jobs = append(jobs, fetcher.Job{Namespace: namespace, Name: name, Version: version, OS: p.OS, Arch: p.Arch})
+		}
+	}
+	return jobs, nil
+}
+
+// Scheduler periodically downloads the configured providers into the cache
+type Scheduler struct {
+	fetcher   *fetcher.Fetcher
+	registry  *registry.Registry
+	path      string
+	platforms []string
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// NewScheduler creates a scheduler for the prefetch list at path
+// The list is re-read on every run so edits take effect without a restart
+func NewScheduler(f *fetcher.Fetcher, reg *registry.Registry, path string, platforms []string, interval time.Duration, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		fetcher:   f,
+		registry:  reg,
+		path:      path,
+		platforms: platforms,
+		interval:  interval,
+		logger:    logger,
+	}
+}
+
+// Run prefetches immediately and then every interval until ctx is done
+// A zero interval runs once
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		if _, err := s.RunOnce(ctx, nil); err != nil {
+			s.logger.Error("prefetch failed", "error", err)
+		}
+
+		if s.interval <= 0 {
+			return
+		}
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Summary counts the archives of a prefetch run
+type Summary struct {
+	Providers int  `json:"providers"`
+	Archives  int  `json:"archives"`
+	Fetched   int  `json:"fetched"`
+	Skipped   int  `json:"skipped"`
+	Failed    int  `json:"failed"`
+	Invalid   int  `json:"invalid,omitempty"` // entries skipped because they could not be resolved
+	Frozen    bool `json:"frozen,omitempty"`  // skipped because the mirror is frozen
+}
+
+// RunOnce prefetches the list once; progress, when set, is called after every archive
+func (s *Scheduler) RunOnce(ctx context.Context, progress func(done, total int)) (Summary, error) {
+	var summary Summary
+	if s.fetcher.Frozen() {
+		s.logger.Info("prefetch skipped, mirror is frozen")
+		summary.Frozen = true
+		return summary, nil
+	}
+	start := time.Now()
+
+	entries, err := ParseFile(s.path)
+	if err != nil {
+		return summary, fmt.Errorf("reading prefetch list %s: %w", s.path, err)
+	}
+
+	jobs, err := Jobs(ctx, s.registry, entries, s.platforms, func(e Entry, err error) {
+		summary.Invalid++
+		s.logger.Warn("prefetch entry skipped", "entry", e.String(), "error", err)
+	})
+	if err != nil {
+		return summary, fmt.Errorf("resolving prefetch list: %w", err)
+	}
+	summary.Providers, summary.Archives = len(entries), len(jobs)
+
+	s.fetcher.Run(ctx, jobs, func(r fetcher.Result) {
+		switch {
+		case r.Err != nil:
+			summary.Failed++
+			s.logger.Warn("prefetch failed", "job", r.Job.String(), "attempts", r.Attempts, "error", r.Err)
+		case r.Skipped:
+			summary.Skipped++
+		default:
+			summary.Fetched++
+		}
+		if progress != nil {
+			progress(summary.Fetched+summary.Skipped+summary.Failed, len(jobs))
+		}
+	})
+
+	s.logger.Info("prefetch finished",
+		"providers", summary.Providers,
+		"archives", summary.Archives,
+		"fetched", summary.Fetched,
+		"skipped", summary.Skipped,
+		"failed", summary.Failed,
+		"duration", time.Since(start).Round(time.Millisecond),
+	)
+	return summary, ctx.Err()
+}
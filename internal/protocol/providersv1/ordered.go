@@ -0,0 +1,68 @@
+package providersv1
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+// VersionSet is the "versions" object of index.json
+// It marshals with versions in ascending semver order so responses are byte-stable
+type VersionSet map[string]struct{}
+
+// MarshalJSON emits versions in semver order
+func (v VersionSet) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(v))
+	for version := range v {
+		keys = append(keys, version)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c := versions.Compare(keys[i], keys[j]); c != 0 {
+			return c < 0
+		}
+		return keys[i] < keys[j]
+	})
+
+	return marshalOrdered(keys, func(string) any { return struct{}{} })
+}
+
+// ArchiveMap is the "archives" object of {version}.json, keyed by "os_arch"
+// It marshals with platforms in lexical order
+type ArchiveMap map[string]MirrorArchive
+
+// MarshalJSON emits archives sorted by platform
+func (a ArchiveMap) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(a))
+	for platform := range a {
+		keys = append(keys, platform)
+	}
+	sort.Strings(keys)
+
+	return marshalOrdered(keys, func(key string) any { return a[key] })
+}
+
+// marshalOrdered writes a JSON object with keys in the given order
+func marshalOrdered(keys []string, value func(string) any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(value(key))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
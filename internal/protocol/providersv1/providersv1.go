@@ -0,0 +1,34 @@
+package providersv1
+
+// Version 1 of Terraform's provider protocols, as served under /v1/providers/:
+//   - the provider registry protocol, service "providers.v1" in service discovery
+//   - the provider network mirror protocol, with the origin registry's hostname as the first segment
+//
+// Each protocol revision gets a package of its own with its paths and wire types, so a later one
+// (e.g. with OpenTofu extensions) is served next to v1 without changing what v1 clients receive
+
+// Service is the service discovery ID of the provider registry protocol
+const Service = "providers.v1"
+
+// Prefix is the path prefix of every v1 route
+const Prefix = "/v1/providers/"
+
+// Route patterns of the v1 endpoints, for http.ServeMux
+const (
+	// Provider registry protocol
+	VersionsRoute = "GET " + Prefix + "{namespace}/{name}/versions"
+	DownloadRoute = "GET " + Prefix + "{namespace}/{name}/{version}/download/{os}/{arch}"
+
+	// Provider network mirror protocol: index.json, then every other file by suffix
+	IndexRoute = "GET " + Prefix + "{hostname}/{namespace}/{name}/index.json"
+	FileRoute  = "GET " + Prefix + "{hostname}/{namespace}/{name}/{file}"
+
+	// Archive checksums for CI (non-standard)
+	ChecksumRoute = "GET " + Prefix + "{hostname}/{namespace}/{name}/{version}/sha256/{os}/{arch}"
+)
+
+// MirrorPath returns the network mirror protocol path of a provider file, e.g.
+// /v1/providers/registry.terraform.io/hashicorp/aws/index.json
+func MirrorPath(hostname, namespace, name, file string) string {
+	return Prefix + hostname + "/" + namespace + "/" + name + "/" + file
+}
@@ -0,0 +1,211 @@
+package providersv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Schemas of the registry protocol documents read from upstream
+//
+// Responses are checked against them before they are decoded, so a change of the API shape fails
+// with the place it was found ("versions[3].platforms: expected array, got string") instead of an
+// unmarshal error. Fields the schemas do not describe are tolerated and returned to the caller,
+// which reports them, so additions to the API are noticed before they matter
+
+// Documents of the registry protocol, as named in schema errors
+const (
+	VersionsDocument = "versions"
+	DownloadDocument = "download"
+)
+
+// shape describes the JSON value expected at a place in a document
+type shape struct {
+	kind   string           // "object", "array" or "string"
+	fields map[string]field // members of an object
+	items  *shape           // elements of an array
+}
+
+// field is a member of an object; optional fields may be missing or null
+type field struct {
+	shape
+	required bool
+}
+
+var str = shape{kind: "string"}
+
+func object(fields map[string]field) shape { return shape{kind: "object", fields: fields} }
+func arrayOf(items shape) shape            { return shape{kind: "array", items: &items} }
+func required(s shape) field               { return field{shape: s, required: true} }
+func optional(s shape) field               { return field{shape: s} }
+
+// versionsSchema describes GET /v1/providers/{namespace}/{type}/versions
+var versionsSchema = object(map[string]field{
+	"id":       optional(str),
+	"warnings": optional(arrayOf(str)),
+	"versions": required(arrayOf(object(map[string]field{
+		"version":   required(str),
+		"protocols": optional(arrayOf(str)),
+		"platforms": required(arrayOf(object(map[string]field{
+			"os":   required(str),
+			"arch": required(str),
+		}))),
+	}))),
+})
+
+// downloadSchema describes GET /v1/providers/{namespace}/{type}/{version}/download/{os}/{arch}
+var downloadSchema = object(map[string]field{
+	"protocols":             optional(arrayOf(str)),
+	"os":                    optional(str),
+	"arch":                  optional(str),
+	"filename":              optional(str),
+	"download_url":          required(str),
+	"shasums_url":           optional(str),
+	"shasums_signature_url": optional(str),
+	"shasum":                optional(str),
+	"signing_keys": optional(object(map[string]field{
+		"gpg_public_keys": optional(arrayOf(object(map[string]field{
+			"key_id":          required(str),
+			"ascii_armor":     required(str),
+			"trust_signature": optional(str),
+			"source":          optional(str),
+			"source_url":      optional(str),
+		}))),
+	})),
+})
+
+// SchemaError reports an upstream document that does not match its schema
+type SchemaError struct {
+	Document string // VersionsDocument or DownloadDocument
+	Path     string // e.g. versions[3].platforms; "" for the whole document
+	Problem  string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Path == "" {
+		return e.Document + " response: " + e.Problem
+	}
+	return e.Document + " response: " + e.Path + ": " + e.Problem
+}
+
+// DecodeVersions checks a versions response against its schema and decodes it
+// unknown lists the fields outside the schema, e.g. "versions[].published_at"
+func DecodeVersions(data []byte) (resp *RegistryVersionsResponse, unknown []string, err error) {
+	resp = &RegistryVersionsResponse{}
+	unknown, err = decode(VersionsDocument, &versionsSchema, data, resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, unknown, nil
+}
+
+// DecodeDownload checks a download response against its schema and decodes it
+// unknown lists the fields outside the schema, e.g. "signing_keys.gpg_public_keys[].expires"
+func DecodeDownload(data []byte) (resp *RegistryDownloadResponse, unknown []string, err error) {
+	resp = &RegistryDownloadResponse{}
+	unknown, err = decode(DownloadDocument, &downloadSchema, data, resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, unknown, nil
+}
+
+// decode checks data against s, unmarshals it into v and returns the sorted unknown fields
+func decode(document string, s *shape, data []byte, v any) ([]string, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, &SchemaError{Document: document, Problem: "invalid JSON: " + err.Error()}
+	}
+	c := checker{document: document, unknown: make(map[string]bool)}
+	if err := c.check(s, doc, "", ""); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, &SchemaError{Document: document, Problem: err.Error()}
+	}
+
+	unknown := make([]string, 0, len(c.unknown))
+	for path := range c.unknown {
+		unknown = append(unknown, path)
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// checker walks a decoded document; unknown collects undescribed fields by their path with
+// array indexes left out, so a field new in every element is reported once
+type checker struct {
+	document string
+	unknown  map[string]bool
+}
+
+func (c *checker) check(s *shape, v any, path, general string) error {
+	if got := kindOf(v); got != s.kind {
+		return &SchemaError{Document: c.document, Path: path, Problem: fmt.Sprintf("expected %s, got %s", s.kind, got)}
+	}
+
+	switch s.kind {
+	case "object":
+		members := v.(map[string]any)
+		names := make([]string, 0, len(s.fields))
+		for name := range s.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := s.fields[name]
+			value, ok := members[name]
+			switch {
+			case !ok && f.required:
+				return &SchemaError{Document: c.document, Path: member(path, name), Problem: "required field missing"}
+			case value == nil && f.required:
+				return &SchemaError{Document: c.document, Path: member(path, name), Problem: "required field is null"}
+			case value == nil:
+				continue
+			}
+			if err := c.check(&f.shape, value, member(path, name), member(general, name)); err != nil {
+				return err
+			}
+		}
+		for name := range members {
+			if _, ok := s.fields[name]; !ok {
+				c.unknown[member(general, name)] = true
+			}
+		}
+	case "array":
+		for i, item := range v.([]any) {
+			if err := c.check(s.items, item, path+"["+strconv.Itoa(i)+"]", general+"[]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// member returns the path of an object member
+func member(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// kindOf returns the JSON type of a value decoded into an interface
+func kindOf(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
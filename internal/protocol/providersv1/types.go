@@ -0,0 +1,57 @@
+package providersv1
+
+// RegistryVersionsResponse — Registry API response /v1/providers/{ns}/{type}/versions
+type RegistryVersionsResponse struct {
+	Versions []RegistryVersion `json:"versions"`
+}
+
+type RegistryVersion struct {
+	Version   string             `json:"version"`
+	Protocols []string           `json:"protocols,omitempty"`
+	Platforms []RegistryPlatform `json:"platforms"`
+}
+
+type RegistryPlatform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// RegistryDownloadResponse — Registry API response /download/{os}/{arch}
+type RegistryDownloadResponse struct {
+	DownloadURL         string `json:"download_url"`
+	Filename            string `json:"filename"`
+	SHA256Sum           string `json:"shasum"`
+	ShasumsURL          string `json:"shasums_url"`
+	ShasumsSignatureURL string `json:"shasums_signature_url"`
+
+	SigningKeys *SigningKeys `json:"signing_keys,omitempty"`
+}
+
+// SigningKeys are the keys that sign a provider's SHA256SUMS
+type SigningKeys struct {
+	GPGPublicKeys []GPGPublicKey `json:"gpg_public_keys"`
+}
+
+// GPGPublicKey is an ASCII-armored provider signing key
+type GPGPublicKey struct {
+	KeyID          string `json:"key_id"`
+	ASCIIArmor     string `json:"ascii_armor"`
+	TrustSignature string `json:"trust_signature,omitempty"`
+	Source         string `json:"source,omitempty"`
+	SourceURL      string `json:"source_url,omitempty"`
+}
+
+// MirrorVersionsResponse — Mirror Protocol response index.json
+type MirrorVersionsResponse struct {
+	Versions VersionSet `json:"versions"`
+}
+
+// MirrorVersionResponse — Mirror Protocol response {version}.json
+type MirrorVersionResponse struct {
+	Archives ArchiveMap `json:"archives"`
+}
+
+type MirrorArchive struct {
+	URL    string   `json:"url"`
+	Hashes []string `json:"hashes,omitempty"`
+}
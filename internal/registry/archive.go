@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrMalformedArchive is returned for provider archives Terraform could not install from
+var ErrMalformedArchive = errors.New("malformed provider archive")
+
+// CheckArchive inspects the entries of a provider archive for the mistakes that only show up
+// when Terraform runs the provider, typically in archives packed on Windows:
+//   - every entry has a relative path with forward slashes and stays inside the archive
+//   - the archive root holds the provider executable, terraform-provider-{name} optionally
+//     followed by "_" and a version (with .exe on Windows)
+//   - outside Windows, the executable is a regular file with an executable bit
+func CheckArchive(r io.ReaderAt, size int64, name, os string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedArchive, err)
+	}
+
+	var binary *zip.File
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, `\`) {
+			return fmt.Errorf("%w: entry %q uses backslashes", ErrMalformedArchive, f.Name)
+		}
+		if clean := path.Clean(f.Name); path.IsAbs(f.Name) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("%w: entry %q is outside the archive", ErrMalformedArchive, f.Name)
+		}
+		if binary == nil && isProviderBinary(f.Name, name, os) {
+			binary = f
+		}
+	}
+
+	if binary == nil {
+		return fmt.Errorf("%w: no terraform-provider-%s executable at the archive root", ErrMalformedArchive, name)
+	}
+	if os == "windows" {
+		return nil
+	}
+	if mode := binary.Mode(); !mode.IsRegular() || mode.Perm()&0111 == 0 {
+		return fmt.Errorf("%w: %s is not executable (mode %s)", ErrMalformedArchive, binary.Name, mode)
+	}
+	return nil
+}
+
+// isProviderBinary reports whether an archive entry is the executable of a provider
+func isProviderBinary(entry, name, os string) bool {
+	if os == "windows" {
+		var ok bool
+		if entry, ok = strings.CutSuffix(entry, ".exe"); !ok {
+			return false
+		}
+	}
+	rest, ok := strings.CutPrefix(entry, "terraform-provider-"+name)
+	return ok && (rest == "" || (strings.HasPrefix(rest, "_") && !strings.Contains(rest, "/")))
+}
@@ -0,0 +1,182 @@
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+const (
+	shasumsSuffix    = "_SHA256SUMS"
+	signatureSuffix  = ".sig"
+	providerPrefix   = "terraform-provider-"
+	maxArtifactBytes = 1 << 20
+)
+
+// ShasumsFilename returns the SHA256SUMS filename for a provider version
+// terraform-provider-{name}_{version}_SHA256SUMS
+func ShasumsFilename(name, version string) string {
+	return providerPrefix + name + "_" + version + shasumsSuffix
+}
+
+// ParseArtifactFilename parses a SHA256SUMS (or .sig) filename
+// terraform-provider-{name}_{version}_SHA256SUMS[.sig]
+func ParseArtifactFilename(filename string) (name, version string, signature bool, err error) {
+	if strings.HasSuffix(filename, signatureSuffix) {
+		signature = true
+		filename = strings.TrimSuffix(filename, signatureSuffix)
+	}
+
+	if !strings.HasPrefix(filename, providerPrefix) || !strings.HasSuffix(filename, shasumsSuffix) {
+		return "", "", false, fmt.Errorf("invalid filename format")
+	}
+	filename = strings.TrimSuffix(strings.TrimPrefix(filename, providerPrefix), shasumsSuffix)
+
+	// name may contain _, so take last part as version
+	idx := strings.LastIndex(filename, "_")
+	if idx <= 0 || idx == len(filename)-1 {
+		return "", "", false, fmt.Errorf("invalid filename format: not enough parts")
+	}
+
+	return filename[:idx], filename[idx+1:], signature, nil
+}
+
+// Artifact returns the upstream SHA256SUMS file (or its detached signature) for a provider version
+// Artifacts are fetched once and then served from the artifact cache
+func (r *Registry) Artifact(ctx context.Context, namespace, name, version string, signature bool) ([]byte, error) {
+	namespace, name = r.Resolve(namespace, name)
+
+	filename := ShasumsFilename(name, version)
+	if signature {
+		filename += signatureSuffix
+	}
+
+	if data, ok := r.artifactCache.Get(namespace, name, version, filename); ok {
+		return data, nil
+	}
+
+	// OCI repositories have no checksum files; SHA256SUMS is built from the layer digests
+	if repo, ok := r.ociRepo(namespace, name); ok {
+		if signature {
+			return nil, fmt.Errorf("%s %w", filename, ErrNotFound)
+		}
+		data, err := r.ociShasums(ctx, repo, namespace, name, version)
+		if err == nil {
+			r.shasumsStored(namespace, name, version)
+		}
+		return data, err
+	}
+
+	// The shasums URLs are the same for every platform, so any platform will do
+	targetVersion, err := r.findVersion(ctx, namespace, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(targetVersion.Platforms) == 0 {
+		return nil, fmt.Errorf("version %s has no platforms", version)
+	}
+	p := targetVersion.Platforms[0]
+
+	info, err := r.DownloadInfo(ctx, namespace, name, version, p.OS, p.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	artifactURL := info.ShasumsURL
+	if signature {
+		artifactURL = info.ShasumsSignatureURL
+	}
+	if artifactURL == "" {
+		return nil, fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
+
+	data, err := r.fetchArtifact(ctx, namespace, name, version, filename, artifactURL)
+	if err == nil && !signature {
+		r.shasumsStored(namespace, name, version)
+	}
+	return data, err
+}
+
+// shasumsStored drops a {version}.json document that may have been rendered without the zh hashes
+func (r *Registry) shasumsStored(namespace, name, version string) {
+	if r.versions != nil {
+		r.versions.drop(namespace, name, version)
+	}
+}
+
+// fetchArtifact returns a release artifact from the artifact cache or downloads and stores it
+func (r *Registry) fetchArtifact(ctx context.Context, namespace, name, version, filename, artifactURL string) ([]byte, error) {
+	if data, ok := r.artifactCache.Get(namespace, name, version, filename); ok {
+		return data, nil
+	}
+
+	r.logger.Debug("fetching artifact", "url", artifactURL)
+
+	resp, err := r.client.GetURL(ctx, artifactURL)
+	if err != nil {
+		if errors.Is(err, upstream.ErrHostNotAllowed) {
+			r.logger.Warn("artifact URL outside allowlist", "file", filename, "url", artifactURL, "error", err)
+		}
+		return nil, fmt.Errorf("fetching %s: %w", filename, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &UpstreamError{StatusCode: resp.StatusCode}
+	}
+
+	// Read one byte past the limit, so an oversized file fails instead of being cached truncated
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArtifactBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filename, err)
+	}
+	if len(data) > maxArtifactBytes {
+		return nil, fmt.Errorf("%s exceeds %d bytes", filename, maxArtifactBytes)
+	}
+
+	// An error page served with 200 must not be cached in place of the checksums
+	if strings.HasSuffix(filename, shasumsSuffix) && len(ParseShasums(data)) == 0 {
+		return nil, fmt.Errorf("%s contains no checksums", filename)
+	}
+
+	if err := r.artifactCache.Set(namespace, name, version, filename, data); err != nil {
+		r.logger.Error("failed to cache artifact", "file", filename, "error", err)
+	}
+
+	return data, nil
+}
+
+// ParseShasums parses a SHA256SUMS file into filename -> hex digest
+// Each line has the form "{sha256}  {filename}"; lines without a valid digest are skipped
+func ParseShasums(data []byte) map[string]string {
+	result := make(map[string]string)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !isSHA256(fields[0]) {
+			continue
+		}
+		result[fields[1]] = strings.ToLower(fields[0])
+	}
+
+	return result
+}
+
+// isSHA256 reports whether s is a hex-encoded SHA-256 digest
+func isSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
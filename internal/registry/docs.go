@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// docCategories are the documentation categories listed for a provider version
+var docCategories = []string{"overview", "guides", "resources", "data-sources", "ephemeral-resources", "functions"}
+
+// maxDocPages limits pagination through a single docs category
+const maxDocPages = 50
+
+const docsIndexFilename = "docs-index.json"
+
+// Docs is a client for the registry provider documentation API (/v2/provider-docs)
+// Documentation for a published version never changes, so responses are cached on disk
+type Docs struct {
+	client        *upstream.Client
+	artifactCache *cache.ArtifactCache
+	docCache      *cache.DocCache
+	logger        *slog.Logger
+}
+
+// NewDocs creates a new documentation client
+func NewDocs(client *upstream.Client, artifactCache *cache.ArtifactCache, docCache *cache.DocCache, logger *slog.Logger) *Docs {
+	return &Docs{
+		client:        client,
+		artifactCache: artifactCache,
+		docCache:      docCache,
+		logger:        logger,
+	}
+}
+
+// ProviderDoc describes a single documentation page
+type ProviderDoc struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Slug        string `json:"slug"`
+	Category    string `json:"category"`
+	Subcategory string `json:"subcategory,omitempty"`
+	Content     string `json:"content,omitempty"`
+}
+
+// Index returns all documentation pages (without content) for a provider version
+func (d *Docs) Index(ctx context.Context, namespace, name, version string) ([]ProviderDoc, error) {
+	if data, ok := d.artifactCache.Get(namespace, name, version, docsIndexFilename); ok {
+		var docs []ProviderDoc
+		if err := json.Unmarshal(data, &docs); err == nil {
+			return docs, nil
+		}
+	}
+
+	versionID, err := d.versionID(ctx, namespace, name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []ProviderDoc
+	for _, category := range docCategories {
+		for page := 1; page <= maxDocPages; page++ {
+			query := url.Values{}
+			query.Set("filter[provider-version]", versionID)
+			query.Set("filter[category]", category)
+			query.Set("filter[language]", "hcl")
+			query.Set("page[size]", "100")
+			query.Set("page[number]", fmt.Sprint(page))
+
+			var resp docsListResponse
+			if err := d.getJSON(ctx, "/v2/provider-docs?"+query.Encode(), &resp); err != nil {
+				return nil, err
+			}
+
+			for _, item := range resp.Data {
+				docs = append(docs, item.toDoc())
+			}
+
+			if resp.Meta.Pagination.NextPage == nil {
+				break
+			}
+		}
+	}
+
+	if data, err := json.Marshal(docs); err == nil {
+		if err := d.artifactCache.Set(namespace, name, version, docsIndexFilename, data); err != nil {
+			d.logger.Error("failed to cache docs index", "error", err)
+		}
+	}
+
+	return docs, nil
+}
+
+// Doc returns a single documentation page including its markdown content
+func (d *Docs) Doc(ctx context.Context, id string) (*ProviderDoc, error) {
+	data, err := d.Raw(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp docResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+
+	doc := resp.Data.toDoc()
+	return &doc, nil
+}
+
+// Raw returns the registry API response for /v2/provider-docs/{id}
+func (d *Docs) Raw(ctx context.Context, id string) ([]byte, error) {
+	if data, ok := d.docCache.Get(id); ok {
+		return data, nil
+	}
+
+	body, statusCode, err := d.client.GetJSON(ctx, "/v2/provider-docs/"+url.PathEscape(id))
+	if err != nil {
+		return nil, fmt.Errorf("fetching doc: %w", err)
+	}
+	if statusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("doc %s %w", id, ErrNotFound)
+	}
+	if statusCode != http.StatusOK {
+		return nil, &UpstreamError{StatusCode: statusCode}
+	}
+
+	if err := d.docCache.Set(id, body); err != nil {
+		d.logger.Error("failed to cache doc", "id", id, "error", err)
+	}
+
+	return body, nil
+}
+
+// versionID resolves the registry's internal provider-version ID
+func (d *Docs) versionID(ctx context.Context, namespace, name, version string) (string, error) {
+	path := fmt.Sprintf("/v2/providers/%s/%s?include=provider-versions", url.PathEscape(namespace), url.PathEscape(name))
+
+	var resp providerV2Response
+	if err := d.getJSON(ctx, path, &resp); err != nil {
+		return "", err
+	}
+
+	for _, item := range resp.Included {
+		if item.Type == "provider-versions" && item.Attributes.Version == version {
+			return item.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("version %s %w", version, ErrNotFound)
+}
+
+func (d *Docs) getJSON(ctx context.Context, path string, v any) error {
+	d.logger.Debug("fetching docs", "path", path)
+
+	body, statusCode, err := d.client.GetJSON(ctx, path)
+	if err != nil {
+		return fmt.Errorf("fetching docs: %w", err)
+	}
+	if statusCode == http.StatusNotFound {
+		return fmt.Errorf("docs %w", ErrNotFound)
+	}
+	if statusCode != http.StatusOK {
+		return &UpstreamError{StatusCode: statusCode}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	return nil
+}
+
+// === Types ===
+
+// providerV2Response — Registry API response /v2/providers/{ns}/{type}?include=provider-versions
+type providerV2Response struct {
+	Included []struct {
+		Type       string `json:"type"`
+		ID         string `json:"id"`
+		Attributes struct {
+			Version string `json:"version"`
+		} `json:"attributes"`
+	} `json:"included"`
+}
+
+type docData struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Title       string  `json:"title"`
+		Slug        string  `json:"slug"`
+		Category    string  `json:"category"`
+		Subcategory *string `json:"subcategory"`
+		Content     string  `json:"content"`
+	} `json:"attributes"`
+}
+
+func (d docData) toDoc() ProviderDoc {
+	doc := ProviderDoc{
+		ID:       d.ID,
+		Title:    d.Attributes.Title,
+		Slug:     d.Attributes.Slug,
+		Category: d.Attributes.Category,
+		Content:  d.Attributes.Content,
+	}
+	if d.Attributes.Subcategory != nil {
+		doc.Subcategory = *d.Attributes.Subcategory
+	}
+	return doc
+}
+
+// docsListResponse — Registry API response /v2/provider-docs
+type docsListResponse struct {
+	Data []docData `json:"data"`
+	Meta struct {
+		Pagination struct {
+			NextPage *int `json:"next-page"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+// docResponse — Registry API response /v2/provider-docs/{id}
+type docResponse struct {
+	Data docData `json:"data"`
+}
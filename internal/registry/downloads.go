@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+const (
+	// maxDownloadEntries bounds the download metadata cache
+	maxDownloadEntries = 10000
+
+	// signedURLMargin is how long before a signed URL expires it stops being reused
+	signedURLMargin = time.Minute
+)
+
+// downloadCache keeps download metadata so requests for uncached archives
+// skip the upstream round-trip; entries never outlive the signed URLs they contain
+type downloadCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]downloadEntry // "namespace/name/version/os_arch" -> entry
+}
+
+type downloadEntry struct {
+	info    *providersv1.RegistryDownloadResponse
+	expires time.Time
+}
+
+// SetDownloadCacheTTL enables caching of download metadata for ttl (0 disables)
+func (r *Registry) SetDownloadCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		r.downloads = nil
+		return
+	}
+	r.downloads = &downloadCache{ttl: ttl, entries: make(map[string]downloadEntry)}
+}
+
+// ForgetDownload drops cached download metadata, e.g. after the download URL failed
+func (r *Registry) ForgetDownload(namespace, name, version, os, arch string) {
+	if r.downloads == nil {
+		return
+	}
+	namespace, name = r.Resolve(namespace, name)
+
+	r.downloads.mu.Lock()
+	defer r.downloads.mu.Unlock()
+	delete(r.downloads.entries, downloadKey(namespace, name, version, os, arch))
+}
+
+func downloadKey(namespace, name, version, os, arch string) string {
+	return namespace + "/" + name + "/" + version + "/" + os + "_" + arch
+}
+
+func (c *downloadCache) get(key string) (*providersv1.RegistryDownloadResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.info, true
+}
+
+func (c *downloadCache) set(key string, info *providersv1.RegistryDownloadResponse) {
+	now := time.Now()
+	expires := now.Add(c.ttl)
+	for _, rawURL := range []string{info.DownloadURL, info.ShasumsURL, info.ShasumsSignatureURL} {
+		if t, ok := urlExpiry(rawURL); ok && t.Add(-signedURLMargin).Before(expires) {
+			expires = t.Add(-signedURLMargin)
+		}
+	}
+	if !expires.After(now) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxDownloadEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= maxDownloadEntries {
+		c.entries = make(map[string]downloadEntry)
+	}
+	c.entries[key] = downloadEntry{info: info, expires: expires}
+}
+
+// urlExpiry returns when a pre-signed URL expires
+// Recognizes AWS SigV4 (X-Amz-Date + X-Amz-Expires), Google Cloud Storage V4
+// (X-Goog-Date + X-Goog-Expires), CloudFront/S3 V2 (Expires as a Unix time)
+// and Azure SAS (se) parameters
+func urlExpiry(rawURL string) (time.Time, bool) {
+	if rawURL == "" {
+		return time.Time{}, false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	q := u.Query()
+
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		date, seconds := q.Get(prefix+"Date"), q.Get(prefix+"Expires")
+		if date == "" || seconds == "" {
+			continue
+		}
+		signed, err := time.Parse("20060102T150405Z", date)
+		n, nerr := strconv.ParseInt(seconds, 10, 64)
+		if err == nil && nerr == nil {
+			return signed.Add(time.Duration(n) * time.Second), true
+		}
+	}
+
+	if v := q.Get("Expires"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(n, 0), true
+		}
+	}
+
+	if v := q.Get("se"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse("2006-01-02", v); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// downloadState is a saved download cache entry
+type downloadState struct {
+	Info    *providersv1.RegistryDownloadResponse `json:"info"`
+	Expires time.Time                             `json:"expires"`
+}
+
+// DownloadCacheState returns the unexpired download metadata as JSON for a warm restart
+func (r *Registry) DownloadCacheState() ([]byte, error) {
+	state := make(map[string]downloadState)
+	if r.downloads != nil {
+		now := time.Now()
+		r.downloads.mu.Lock()
+		for key, e := range r.downloads.entries {
+			if e.expires.After(now) {
+				state[key] = downloadState{Info: e.info, Expires: e.expires}
+			}
+		}
+		r.downloads.mu.Unlock()
+	}
+	return json.Marshal(state)
+}
+
+// RestoreDownloadCache loads download metadata saved by DownloadCacheState
+// Entries keep their original expiry, so signed URLs are never reused past it
+func (r *Registry) RestoreDownloadCache(data []byte) error {
+	if r.downloads == nil {
+		return nil
+	}
+
+	var state map[string]downloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	r.downloads.mu.Lock()
+	defer r.downloads.mu.Unlock()
+	for key, s := range state {
+		if s.Info == nil || !s.Expires.After(now) || len(r.downloads.entries) >= maxDownloadEntries {
+			continue
+		}
+		// A shorter TTL configured since the entry was saved still applies
+		if limit := now.Add(r.downloads.ttl); s.Expires.After(limit) {
+			s.Expires = limit
+		}
+		r.downloads.entries[key] = downloadEntry{info: s.Info, expires: s.Expires}
+	}
+	return nil
+}
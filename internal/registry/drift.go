@@ -0,0 +1,50 @@
+package registry
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// Upstream API drift
+//
+// Registry API responses are checked against the providers.v1 schemas. A response that does not
+// match fails with a descriptive error; fields the schemas do not describe are tolerated. Both are
+// counted in the upstream.schema_drift metric and logged, unknown fields once per document and
+// field, so changes of the upstream API are noticed before they break anything
+
+// SetMetrics sets the recorder of upstream schema drift
+func (r *Registry) SetMetrics(m metrics.Recorder) {
+	r.metrics = m
+}
+
+// reportDrift reports the outcome of checking an upstream document requested from endpoint
+func (r *Registry) reportDrift(document, endpoint string, unknown []string, err error) {
+	host := endpoint
+	if u, perr := url.Parse(endpoint); perr == nil {
+		host = u.Host
+	}
+
+	var schemaErr *providersv1.SchemaError
+	if errors.As(err, &schemaErr) {
+		r.metrics.Count(metrics.UpstreamSchemaDrift, 1, "document:"+document, "kind:invalid")
+		r.logger.Warn("upstream response does not match the registry schema", "document", document, "host", host, "error", err)
+		return
+	}
+	if len(unknown) == 0 {
+		return
+	}
+
+	r.metrics.Count(metrics.UpstreamSchemaDrift, 1, "document:"+document, "kind:unknown_field")
+	var fresh []string
+	for _, field := range unknown {
+		if _, logged := r.driftFields.LoadOrStore(document+":"+field, true); !logged {
+			fresh = append(fresh, field)
+		}
+	}
+	if len(fresh) > 0 {
+		r.logger.Warn("upstream response has fields unknown to the registry schema", "document", document, "fields", fresh, "host", host)
+	}
+}
@@ -0,0 +1,18 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned when a provider, version or artifact does not exist
+var ErrNotFound = errors.New("not found")
+
+// UpstreamError reports an unexpected status code from the upstream registry
+type UpstreamError struct {
+	StatusCode int
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream returned status %d", e.StatusCode)
+}
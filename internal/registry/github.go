@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+const (
+	// githubPageSize is the number of releases requested per page
+	githubPageSize = 100
+
+	// githubMaxPages limits how many release pages are read for one provider
+	githubMaxPages = 10
+
+	// maxGitHubResponseBytes limits a single GitHub API response
+	maxGitHubResponseBytes = 10 << 20
+)
+
+// gitHubReleases serves providers that only publish GitHub release assets
+// Assets must follow the registry naming convention:
+// terraform-provider-{name}_{version}_{os}_{arch}.zip and terraform-provider-{name}_{version}_SHA256SUMS[.sig]
+type gitHubReleases struct {
+	apiURL string
+	token  string
+	repos  map[string]string // "namespace/name" -> "owner/repo"
+}
+
+// gitHubRelease is the part of a GitHub release used by the mirror
+type gitHubRelease struct {
+	TagName string        `json:"tag_name"`
+	Draft   bool          `json:"draft"`
+	Assets  []gitHubAsset `json:"assets"`
+}
+
+// gitHubAsset is a file attached to a GitHub release
+type gitHubAsset struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"browser_download_url"`
+}
+
+// UseGitHubReleases serves the given providers ("namespace/name" -> "owner/repo")
+// from GitHub releases instead of the upstream registry
+func (r *Registry) UseGitHubReleases(apiURL, token string, repos map[string]string) {
+	if len(repos) == 0 {
+		return
+	}
+	r.github = &gitHubReleases{apiURL: strings.TrimSuffix(apiURL, "/"), token: token, repos: repos}
+}
+
+// gitHubRepo returns the repository a provider is served from, if any
+func (r *Registry) gitHubRepo(namespace, name string) (string, bool) {
+	if r.github == nil {
+		return "", false
+	}
+	repo, ok := r.github.repos[namespace+"/"+name]
+	return repo, ok
+}
+
+// gitHubVersions builds the versions list from release tags
+// Drafts and releases without archives or SHA256SUMS are skipped
+func (r *Registry) gitHubVersions(ctx context.Context, repo, namespace, name string) (*providersv1.RegistryVersionsResponse, error) {
+	resp := &providersv1.RegistryVersionsResponse{Versions: []providersv1.RegistryVersion{}}
+
+	for page := 1; page <= githubMaxPages; page++ {
+		var releases []gitHubRelease
+		path := fmt.Sprintf("/repos/%s/releases?per_page=%d&page=%d", repo, githubPageSize, page)
+		if err := r.gitHubGet(ctx, path, &releases); err != nil {
+			return nil, fmt.Errorf("provider %s/%s: %w", namespace, name, err)
+		}
+
+		for _, release := range releases {
+			if release.Draft {
+				continue
+			}
+			if v, ok := releaseVersion(release, name); ok {
+				resp.Versions = append(resp.Versions, v)
+			}
+		}
+
+		if len(releases) < githubPageSize {
+			break
+		}
+	}
+
+	return resp, nil
+}
+
+// gitHubDownload returns the download metadata of a release asset
+// The shasum comes from the release's SHA256SUMS, so downloaded archives can be verified
+func (r *Registry) gitHubDownload(ctx context.Context, repo, namespace, name, version, os, arch string) (*providersv1.RegistryDownloadResponse, error) {
+	release, err := r.gitHubRelease(ctx, repo, version)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s/%s %s: %w", namespace, name, version, err)
+	}
+
+	filename := ZipFilename(name, version, os, arch)
+	shasums := ShasumsFilename(name, version)
+	assets := make(map[string]string, len(release.Assets))
+	for _, a := range release.Assets {
+		assets[a.Name] = a.DownloadURL
+	}
+
+	info := &providersv1.RegistryDownloadResponse{
+		DownloadURL:         assets[filename],
+		Filename:            filename,
+		ShasumsURL:          assets[shasums],
+		ShasumsSignatureURL: assets[shasums+signatureSuffix],
+	}
+	if info.DownloadURL == "" {
+		return nil, fmt.Errorf("archive %s %w", filename, ErrNotFound)
+	}
+	if info.ShasumsURL == "" {
+		return nil, fmt.Errorf("%s %w", shasums, ErrNotFound)
+	}
+
+	data, err := r.fetchArtifact(ctx, namespace, name, version, shasums, info.ShasumsURL)
+	if err != nil {
+		return nil, err
+	}
+	info.SHA256Sum = ParseShasums(data)[filename]
+	if info.SHA256Sum == "" {
+		return nil, fmt.Errorf("%s is not listed in %s: %w", filename, shasums, ErrNotFound)
+	}
+
+	return info, nil
+}
+
+// gitHubRelease finds the release of a version, tagged "v{version}" or "{version}"
+func (r *Registry) gitHubRelease(ctx context.Context, repo, version string) (*gitHubRelease, error) {
+	var err error
+	for _, tag := range []string{"v" + version, version} {
+		var release gitHubRelease
+		err = r.gitHubGet(ctx, "/repos/"+repo+"/releases/tags/"+tag, &release)
+		if err == nil && !release.Draft {
+			return &release, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("release %s %w", tag, ErrNotFound)
+		}
+	}
+	return nil, err
+}
+
+// gitHubGet requests a GitHub API path and decodes the JSON response
+func (r *Registry) gitHubGet(ctx context.Context, path string, v any) error {
+	header := make(http.Header)
+	header.Set("Accept", "application/vnd.github+json")
+	if r.github.token != "" {
+		header.Set("Authorization", "Bearer "+r.github.token)
+	}
+
+	r.logger.Debug("fetching GitHub releases", "path", path)
+
+	resp, err := r.client.GetTrusted(ctx, r.github.apiURL+path, header)
+	if err != nil {
+		return fmt.Errorf("fetching GitHub releases: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("GitHub release %w", ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &UpstreamError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxGitHubResponseBytes))
+	if err != nil {
+		return fmt.Errorf("reading GitHub response: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("parsing GitHub response: %w", err)
+	}
+	return nil
+}
+
+// releaseVersion returns the provider version published by a release
+// The version is the tag without a leading "v"; its platforms come from the archive assets
+func releaseVersion(release gitHubRelease, name string) (providersv1.RegistryVersion, bool) {
+	version := strings.TrimPrefix(release.TagName, "v")
+	v := providersv1.RegistryVersion{Version: version, Platforms: []providersv1.RegistryPlatform{}}
+
+	hasShasums := false
+	for _, a := range release.Assets {
+		if a.Name == ShasumsFilename(name, version) {
+			hasShasums = true
+			continue
+		}
+		if !strings.HasSuffix(a.Name, ".zip") {
+			continue
+		}
+		assetName, assetVersion, os, arch, err := ParseZipFilename(a.Name)
+		if err != nil || assetName != name || assetVersion != version {
+			continue
+		}
+		v.Platforms = append(v.Platforms, providersv1.RegistryPlatform{OS: os, Arch: arch})
+	}
+
+	return v, hasShasums && len(v.Platforms) > 0
+}
@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// Upstream types
+const (
+	UpstreamRegistry    = "registry"
+	UpstreamArtifactory = "artifactory"
+)
+
+const (
+	// defaultProvidersPath is the providers service path of the public registry
+	defaultProvidersPath = providersv1.Prefix
+
+	// artifactoryProvidersPath is used when Artifactory service discovery fails
+	artifactoryProvidersPath = "/artifactory/api/terraform/v1/providers/"
+)
+
+// upstreamLayout maps provider addresses to API paths of registries that
+// do not serve the providers service at /v1/providers/
+type upstreamLayout struct {
+	fallbackPath    string
+	namespacePrefix string
+
+	mu   sync.Mutex
+	path string // discovered providers.v1 path
+}
+
+// UseUpstreamType configures how the upstream registry is addressed
+// "registry" speaks the Registry API at /v1/providers/ (also Nexus and other facades);
+// "artifactory" finds the providers service by discovery and requests
+// providers as {repo}__{namespace}/{name}
+func (r *Registry) UseUpstreamType(kind, repo string) error {
+	switch kind {
+	case "", UpstreamRegistry:
+		return nil
+	case UpstreamArtifactory:
+		if repo == "" {
+			return fmt.Errorf("upstream type %q requires a repository", kind)
+		}
+		r.layout = &upstreamLayout{fallbackPath: artifactoryProvidersPath, namespacePrefix: repo + "__"}
+		return nil
+	}
+	return fmt.Errorf("unknown upstream type %q", kind)
+}
+
+// providerPath returns the upstream API path of a provider, e.g. /v1/providers/hashicorp/aws
+func (r *Registry) providerPath(ctx context.Context, namespace, name string) string {
+	if r.layout == nil {
+		return defaultProvidersPath + namespace + "/" + name
+	}
+	return r.providersPath(ctx) + r.layout.namespacePrefix + namespace + "/" + name
+}
+
+// providersPath returns the discovered providers service path
+// A failed discovery falls back to the default path and is retried on the next request
+func (r *Registry) providersPath(ctx context.Context) string {
+	l := r.layout
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.path != "" {
+		return l.path
+	}
+
+	path, err := r.discoverProviders(ctx)
+	if err != nil {
+		r.logger.Warn("upstream service discovery failed", "fallback", l.fallbackPath, "error", err)
+		return l.fallbackPath
+	}
+
+	r.logger.Info("discovered upstream providers service", "path", path)
+	l.path = path
+	return path
+}
+
+// discoverProviders reads providers.v1 from /.well-known/terraform.json
+func (r *Registry) discoverProviders(ctx context.Context) (string, error) {
+	body, statusCode, err := r.client.GetJSON(ctx, "/.well-known/terraform.json")
+	if err != nil {
+		return "", err
+	}
+	if statusCode != http.StatusOK {
+		return "", &UpstreamError{StatusCode: statusCode}
+	}
+
+	var services map[string]any
+	if err := json.Unmarshal(body, &services); err != nil {
+		return "", fmt.Errorf("parsing service discovery: %w", err)
+	}
+	value, ok := services[providersv1.Service].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("%s service %w", providersv1.Service, ErrNotFound)
+	}
+
+	// The value may be an absolute URL; only its path is used
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("parsing providers.v1: %w", err)
+	}
+	path := u.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path, nil
+}
+
+// resolveURL resolves a URL from an upstream response against the URL it was returned by
+// The Registry API allows download and shasums URLs relative to the download endpoint
+func resolveURL(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(u).String()
+}
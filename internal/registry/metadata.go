@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// ProviderMetadata is the upstream metadata of a provider version
+// beyond what the Mirror Protocol exposes; Hostname is set by the caller
+type ProviderMetadata struct {
+	Hostname            string                   `json:"hostname,omitempty"`
+	Namespace           string                   `json:"namespace"`
+	Name                string                   `json:"name"`
+	Version             string                   `json:"version"`
+	Protocols           []string                 `json:"protocols"`
+	ShasumsURL          string                   `json:"shasums_url"`
+	ShasumsSignatureURL string                   `json:"shasums_signature_url"`
+	SigningKeys         *providersv1.SigningKeys `json:"signing_keys,omitempty"`
+	Platforms           []PlatformMetadata       `json:"platforms"`
+}
+
+// PlatformMetadata describes one platform archive of a provider version
+type PlatformMetadata struct {
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	Filename string   `json:"filename"`
+	Hashes   []string `json:"hashes"`
+}
+
+// Metadata returns protocols, signing keys, shasum URLs and per-platform hashes of a version
+// Signing keys and shasum URLs are shared by all platforms, so only the first platform's
+// download info is requested
+func (r *Registry) Metadata(ctx context.Context, namespace, name, version string) (*ProviderMetadata, error) {
+	namespace, name = r.Resolve(namespace, name)
+
+	targetVersion, err := r.findVersion(ctx, namespace, name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &ProviderMetadata{
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		Protocols: targetVersion.Protocols,
+		Platforms: []PlatformMetadata{},
+	}
+	if meta.Protocols == nil {
+		meta.Protocols = []string{}
+	}
+
+	if len(targetVersion.Platforms) > 0 {
+		p := targetVersion.Platforms[0]
+		info, err := r.DownloadInfo(ctx, namespace, name, version, p.OS, p.Arch)
+		if err != nil {
+			return nil, err
+		}
+		meta.ShasumsURL = info.ShasumsURL
+		meta.ShasumsSignatureURL = info.ShasumsSignatureURL
+		meta.SigningKeys = info.SigningKeys
+	}
+
+	cachedHashes := r.hashCache.Hashes(namespace, name, version)
+	zipHashes := r.zipHashes(ctx, namespace, name, version)
+
+	for _, p := range targetVersion.Platforms {
+		filename := ZipFilename(name, version, p.OS, p.Arch)
+		platform := PlatformMetadata{
+			OS:       p.OS,
+			Arch:     p.Arch,
+			Filename: filename,
+			Hashes:   []string{},
+		}
+		platform.Hashes = append(platform.Hashes, cachedHashes[p.OS+"_"+p.Arch]...)
+		if zh, ok := zipHashes[filename]; ok {
+			platform.Hashes = append(platform.Hashes, zh)
+		}
+		meta.Platforms = append(meta.Platforms, platform)
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// normalizedTime is the modification time of every entry of a normalized archive (the ZIP epoch)
+var normalizedTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// licensePrefixes are the root files kept next to the provider executable, so the license
+// terms travel with it
+var licensePrefixes = []string{"license", "licence", "notice", "copying"}
+
+// Normalize writes the canonical form of a provider archive to w: the provider executable
+// and license files at the archive root, sorted by name, with a fixed timestamp and modes
+// (0755 for the executable, 0644 otherwise) and no archive comment
+// Everything else (READMEs, build metadata, directories) is dropped, so archives that differ
+// only in such files or in timestamps normalize to identical bytes and h1 hashes
+func Normalize(r io.ReaderAt, size int64, w io.Writer, name, os string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedArchive, err)
+	}
+
+	var kept []*zip.File
+	binary := false
+	for _, f := range zr.File {
+		if isProviderBinary(f.Name, name, os) && !binary {
+			kept = append(kept, f)
+			binary = true
+		} else if isLicense(f.Name) {
+			kept = append(kept, f)
+		}
+	}
+	if !binary {
+		return fmt.Errorf("%w: no terraform-provider-%s executable at the archive root", ErrMalformedArchive, name)
+	}
+	slices.SortFunc(kept, func(a, b *zip.File) int { return strings.Compare(a.Name, b.Name) })
+
+	zw := zip.NewWriter(w)
+	for _, f := range kept {
+		header := &zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: normalizedTime}
+		if isProviderBinary(f.Name, name, os) {
+			header.SetMode(0755)
+		} else {
+			header.SetMode(0644)
+		}
+		if err := copyEntry(zw, header, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func copyEntry(zw *zip.Writer, header *zip.FileHeader, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrMalformedArchive, f.Name, err)
+	}
+	defer rc.Close()
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrMalformedArchive, f.Name, err)
+	}
+	return nil
+}
+
+// isLicense reports whether an archive entry is a license file at the archive root
+func isLicense(entry string) bool {
+	if strings.ContainsAny(entry, `/\`) {
+		return false
+	}
+	lower := strings.ToLower(entry)
+	for _, prefix := range licensePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizesNamespace reports whether archives of a namespace are normalized, given the
+// configured namespaces ("*" for all)
+func NormalizesNamespace(namespaces []string, namespace string) bool {
+	for _, ns := range namespaces {
+		if ns == "*" || strings.EqualFold(ns, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNormalized sets the namespaces whose archives are normalized before they are hashed
+// and cached ("*" for all); the zh hashes of their SHA256SUMS files describe the upstream
+// archives, so they are left out of metadata responses
+func (r *Registry) SetNormalized(namespaces []string) {
+	r.normalized = namespaces
+}
+
+// Normalizes reports whether archives of a namespace are normalized
+func (r *Registry) Normalizes(namespace string) bool {
+	return NormalizesNamespace(r.normalized, namespace)
+}
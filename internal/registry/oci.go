@@ -0,0 +1,363 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+const (
+	// OCI media types of OpenTofu provider artifacts
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+	// ociTagPageSize is the number of tags requested per page
+	ociTagPageSize = 1000
+
+	// ociMaxTagPages limits how many tag pages are read for one provider
+	ociMaxTagPages = 10
+
+	// ociConcurrency limits the manifests requested at once for a versions list
+	ociConcurrency = 8
+
+	// maxOCIResponseBytes limits a single manifest or tag list
+	maxOCIResponseBytes = 4 << 20
+)
+
+// ociArchiveMediaTypes are the layer media types of a provider archive
+// "archive/zip" is used by OpenTofu, "application/zip" by generic ORAS pushes
+var ociArchiveMediaTypes = map[string]bool{
+	"archive/zip":     true,
+	"application/zip": true,
+}
+
+// ociRepositories serves providers stored in OCI registries in the OpenTofu layout:
+// one tag per version ("1.2.3") pointing to an image index with one manifest per platform,
+// each holding the provider archive as its single ZIP layer
+type ociRepositories struct {
+	repos map[string]ociRepository // "namespace/name" -> repository
+	auth  *ociAuth
+}
+
+// ociRepository is a repository in an OCI registry
+type ociRepository struct {
+	base string // registry URL, e.g. "https://ghcr.io"
+	name string // repository, e.g. "opentofu/providers/hashicorp/random"
+}
+
+// ociIndex is an OCI image index (or the part of it used by the mirror)
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// ociDescriptor references a manifest or blob by digest
+type ociDescriptor struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Platform  *ociPlatform `json:"platform,omitempty"`
+}
+
+// ociPlatform is the platform of a manifest in an image index
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+}
+
+// providerPlatform reports whether an index entry is a platform's manifest
+// Entries without a platform and attestations ("unknown/unknown") are not
+func (d ociDescriptor) providerPlatform() bool {
+	return d.Platform != nil && d.Platform.OS != "" && d.Platform.OS != "unknown"
+}
+
+// parseOCIRepository parses "registry/repository" ("http://" selects plain HTTP for local registries)
+func parseOCIRepository(ref string) (ociRepository, error) {
+	scheme := "https"
+	if rest, ok := strings.CutPrefix(ref, "http://"); ok {
+		scheme, ref = "http", rest
+	} else {
+		ref = strings.TrimPrefix(ref, "https://")
+	}
+
+	host, name, ok := strings.Cut(ref, "/")
+	if !ok || host == "" || name == "" {
+		return ociRepository{}, fmt.Errorf("invalid OCI repository %q: expected registry/repository", ref)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("._-/", c)) {
+			return ociRepository{}, fmt.Errorf("invalid OCI repository %q: names are lowercase letters, digits and ._-/", ref)
+		}
+	}
+	return ociRepository{base: scheme + "://" + host, name: strings.Trim(name, "/")}, nil
+}
+
+// host returns the registry host, e.g. "ghcr.io"
+func (o ociRepository) host() string {
+	return strings.TrimPrefix(strings.TrimPrefix(o.base, "https://"), "http://")
+}
+
+// url returns the URL of a registry API path of the repository ("manifests/1.2.3")
+func (o ociRepository) url(path string) string {
+	return o.base + "/v2/" + o.name + "/" + path
+}
+
+// UseOCIRepositories serves the given providers ("namespace/name" -> "registry/repository")
+// from OCI registries instead of the upstream registry
+// username and password are sent to registries that require authentication
+func (r *Registry) UseOCIRepositories(repos map[string]string, username, password string) error {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	r.oci = &ociRepositories{
+		repos: make(map[string]ociRepository, len(repos)),
+		auth:  newOCIAuth(r.client, username, password),
+	}
+	for provider, ref := range repos {
+		repo, err := parseOCIRepository(ref)
+		if err != nil {
+			return fmt.Errorf("provider %s: %w", provider, err)
+		}
+		r.oci.repos[provider] = repo
+		r.client.SetAuthorizer(repo.host(), r.oci.auth.authorize)
+	}
+	return nil
+}
+
+// ociRepo returns the OCI repository a provider is served from, if any
+func (r *Registry) ociRepo(namespace, name string) (ociRepository, bool) {
+	if r.oci == nil {
+		return ociRepository{}, false
+	}
+	repo, ok := r.oci.repos[namespace+"/"+name]
+	return repo, ok
+}
+
+// ociVersions builds the versions list from the repository's version tags
+// Tags that are not versions and indexes without platforms are skipped
+func (r *Registry) ociVersions(ctx context.Context, repo ociRepository, namespace, name string) (*providersv1.RegistryVersionsResponse, error) {
+	tags, err := r.ociTags(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s/%s: %w", namespace, name, err)
+	}
+
+	var versions []string
+	for _, tag := range tags {
+		if ValidateVersion(tag) == nil {
+			versions = append(versions, tag)
+		}
+	}
+
+	// Platforms are only listed in each version's index
+	found := make([]providersv1.RegistryVersion, len(versions))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(ociConcurrency)
+	for i, version := range versions {
+		g.Go(func() error {
+			index, err := r.ociIndex(gctx, repo, version)
+			if err != nil {
+				return fmt.Errorf("provider %s/%s %s: %w", namespace, name, version, err)
+			}
+			v := providersv1.RegistryVersion{Version: version, Platforms: []providersv1.RegistryPlatform{}}
+			for _, m := range index.Manifests {
+				if m.providerPlatform() {
+					v.Platforms = append(v.Platforms, providersv1.RegistryPlatform{OS: m.Platform.OS, Arch: m.Platform.Architecture})
+				}
+			}
+			found[i] = v
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	resp := &providersv1.RegistryVersionsResponse{Versions: []providersv1.RegistryVersion{}}
+	for _, v := range found {
+		if len(v.Platforms) > 0 {
+			resp.Versions = append(resp.Versions, v)
+		}
+	}
+	return resp, nil
+}
+
+// ociDownload returns the download metadata of a platform's archive layer
+// The blob digest is the archive's SHA-256, so downloads are verified like registry archives
+func (r *Registry) ociDownload(ctx context.Context, repo ociRepository, namespace, name, version, os, arch string) (*providersv1.RegistryDownloadResponse, error) {
+	index, err := r.ociIndex(ctx, repo, version)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s/%s %s: %w", namespace, name, version, err)
+	}
+
+	filename := ZipFilename(name, version, os, arch)
+	for _, m := range index.Manifests {
+		if !m.providerPlatform() || m.Platform.OS != os || m.Platform.Architecture != arch {
+			continue
+		}
+		layer, err := r.ociArchiveLayer(ctx, repo, m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s/%s %s for %s_%s: %w", namespace, name, version, os, arch, err)
+		}
+		return &providersv1.RegistryDownloadResponse{
+			DownloadURL: repo.url("blobs/" + layer.Digest),
+			Filename:    filename,
+			SHA256Sum:   strings.TrimPrefix(layer.Digest, "sha256:"),
+		}, nil
+	}
+	return nil, fmt.Errorf("archive %s %w", filename, ErrNotFound)
+}
+
+// ociShasums builds a SHA256SUMS file from the archive layer digests of every platform
+// OCI repositories publish no checksum file; this one lets clients and zh hashes work as usual
+func (r *Registry) ociShasums(ctx context.Context, repo ociRepository, namespace, name, version string) ([]byte, error) {
+	filename := ShasumsFilename(name, version)
+	if data, ok := r.artifactCache.Get(namespace, name, version, filename); ok {
+		return data, nil
+	}
+
+	index, err := r.ociIndex(ctx, repo, version)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s/%s %s: %w", namespace, name, version, err)
+	}
+
+	sums := make(map[string]string)
+	for _, m := range index.Manifests {
+		if !m.providerPlatform() {
+			continue
+		}
+		layer, err := r.ociArchiveLayer(ctx, repo, m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s/%s %s: %w", namespace, name, version, err)
+		}
+		sums[ZipFilename(name, version, m.Platform.OS, m.Platform.Architecture)] = strings.TrimPrefix(layer.Digest, "sha256:")
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("%s %w", filename, ErrNotFound)
+	}
+
+	archives := make([]string, 0, len(sums))
+	for archive := range sums {
+		archives = append(archives, archive)
+	}
+	sort.Strings(archives)
+	var b strings.Builder
+	for _, archive := range archives {
+		b.WriteString(sums[archive] + "  " + archive + "\n")
+	}
+
+	data := []byte(b.String())
+	if err := r.artifactCache.Set(namespace, name, version, filename, data); err != nil {
+		r.logger.Error("failed to cache artifact", "file", filename, "error", err)
+	}
+	return data, nil
+}
+
+// ociTags lists the tags of a repository, following pagination links
+func (r *Registry) ociTags(ctx context.Context, repo ociRepository) ([]string, error) {
+	var tags []string
+	next := repo.url(fmt.Sprintf("tags/list?n=%d", ociTagPageSize))
+	for page := 0; page < ociMaxTagPages && next != ""; page++ {
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		header, err := r.ociGet(ctx, next, "application/json", &list)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, list.Tags...)
+		next = nextLink(next, header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// ociIndex returns the image index a version tag points to
+func (r *Registry) ociIndex(ctx context.Context, repo ociRepository, version string) (*ociIndex, error) {
+	var index ociIndex
+	if _, err := r.ociGet(ctx, repo.url("manifests/"+version), ociIndexMediaType, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// ociArchiveLayer returns the ZIP layer of a platform manifest
+func (r *Registry) ociArchiveLayer(ctx context.Context, repo ociRepository, digest string) (*ociDescriptor, error) {
+	var manifest ociManifest
+	if _, err := r.ociGet(ctx, repo.url("manifests/"+digest), ociManifestMediaType, &manifest); err != nil {
+		return nil, err
+	}
+
+	for _, layer := range manifest.Layers {
+		if !ociArchiveMediaTypes[layer.MediaType] {
+			continue
+		}
+		if !strings.HasPrefix(layer.Digest, "sha256:") || !isSHA256(strings.TrimPrefix(layer.Digest, "sha256:")) {
+			return nil, fmt.Errorf("manifest %s: archive digest %q is not SHA-256", digest, layer.Digest)
+		}
+		return &layer, nil
+	}
+	return nil, fmt.Errorf("manifest %s has no archive layer: %w", digest, ErrNotFound)
+}
+
+// ociGet requests an OCI registry URL and decodes the JSON response
+func (r *Registry) ociGet(ctx context.Context, rawURL, accept string, v any) (http.Header, error) {
+	header := make(http.Header)
+	header.Set("Accept", accept)
+
+	r.logger.Debug("fetching OCI registry", "url", rawURL)
+
+	resp, err := r.client.GetTrusted(ctx, rawURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("fetching OCI registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("OCI manifest %w", ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &UpstreamError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOCIResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI registry response: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("parsing OCI registry response: %w", err)
+	}
+	return resp.Header, nil
+}
+
+// nextLink returns the absolute URL of a Link header's rel="next" target ("" for none)
+func nextLink(current, link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		target = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(target), "<"), ">")
+		base, err := url.Parse(current)
+		if err != nil {
+			return ""
+		}
+		next, err := base.Parse(target)
+		if err != nil {
+			return ""
+		}
+		return next.String()
+	}
+	return ""
+}
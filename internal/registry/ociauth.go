@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+const (
+	// ociTokenLifetime is assumed for tokens issued without expires_in
+	ociTokenLifetime = 60 * time.Second
+
+	// ociTokenMargin renews tokens this long before they expire
+	ociTokenMargin = 10 * time.Second
+)
+
+// ociAuth authorizes requests to OCI registries with the distribution token protocol:
+// a registry that requires authentication answers /v2/ with a Bearer challenge naming a
+// token endpoint, which issues pull tokens per repository (anonymously or with credentials)
+type ociAuth struct {
+	client   *upstream.Client
+	username string
+	password string
+
+	mu         sync.Mutex
+	challenges map[string]*ociChallenge // registry URL -> challenge, nil when none is needed
+	tokens     map[string]ociToken      // realm, service and scope -> token
+}
+
+// ociChallenge is the WWW-Authenticate challenge of a registry
+type ociChallenge struct {
+	scheme  string // "bearer" or "basic"
+	realm   string
+	service string
+}
+
+// ociToken is an issued registry token
+type ociToken struct {
+	value   string
+	expires time.Time
+}
+
+func newOCIAuth(client *upstream.Client, username, password string) *ociAuth {
+	return &ociAuth{
+		client:     client,
+		username:   username,
+		password:   password,
+		challenges: make(map[string]*ociChallenge),
+		tokens:     make(map[string]ociToken),
+	}
+}
+
+// authorize returns the Authorization header of a repository request (upstream.Authorizer)
+// Requests outside a repository, such as the /v2/ check itself or a token endpoint on the same host, get none
+func (a *ociAuth) authorize(ctx context.Context, req *http.Request) (string, error) {
+	repo, ok := ociRepositoryName(req.URL.Path)
+	if !ok {
+		return "", nil
+	}
+
+	challenge, err := a.challenge(ctx, req.URL.Scheme+"://"+req.URL.Host)
+	if err != nil || challenge == nil {
+		return "", err
+	}
+	if challenge.scheme == "basic" {
+		return a.basic(), nil
+	}
+
+	scope := "repository:" + repo + ":pull"
+	key := challenge.realm + " " + challenge.service + " " + scope
+	a.mu.Lock()
+	token, ok := a.tokens[key]
+	a.mu.Unlock()
+	if ok && time.Now().Before(token.expires) {
+		return "Bearer " + token.value, nil
+	}
+
+	token, err = a.requestToken(ctx, challenge, scope)
+	if err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	a.tokens[key] = token
+	a.mu.Unlock()
+	return "Bearer " + token.value, nil
+}
+
+// challenge returns how a registry expects to be authenticated, asking its /v2/ endpoint once
+func (a *ociAuth) challenge(ctx context.Context, base string) (*ociChallenge, error) {
+	a.mu.Lock()
+	challenge, ok := a.challenges[base]
+	a.mu.Unlock()
+	if ok {
+		return challenge, nil
+	}
+
+	resp, err := a.client.GetTrusted(ctx, base+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		challenge = nil
+	case http.StatusUnauthorized:
+		challenge = parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		if challenge == nil {
+			return nil, fmt.Errorf("unsupported registry challenge %q", resp.Header.Get("WWW-Authenticate"))
+		}
+	default:
+		return nil, &UpstreamError{StatusCode: resp.StatusCode}
+	}
+
+	a.mu.Lock()
+	a.challenges[base] = challenge
+	a.mu.Unlock()
+	return challenge, nil
+}
+
+// requestToken asks a registry's token endpoint for a pull token
+func (a *ociAuth) requestToken(ctx context.Context, challenge *ociChallenge, scope string) (ociToken, error) {
+	query := url.Values{"scope": {scope}}
+	if challenge.service != "" {
+		query.Set("service", challenge.service)
+	}
+	sep := "?"
+	if strings.Contains(challenge.realm, "?") {
+		sep = "&"
+	}
+
+	header := make(http.Header)
+	if auth := a.basic(); auth != "" {
+		header.Set("Authorization", auth)
+	}
+	resp, err := a.client.GetTrusted(ctx, challenge.realm+sep+query.Encode(), header)
+	if err != nil {
+		return ociToken{}, fmt.Errorf("requesting registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ociToken{}, fmt.Errorf("requesting registry token: %w", &UpstreamError{StatusCode: resp.StatusCode})
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOCIResponseBytes))
+	if err != nil {
+		return ociToken{}, fmt.Errorf("reading registry token: %w", err)
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return ociToken{}, fmt.Errorf("parsing registry token: %w", err)
+	}
+
+	token := ociToken{value: body.Token, expires: time.Now().Add(ociTokenLifetime - ociTokenMargin)}
+	if token.value == "" {
+		token.value = body.AccessToken
+	}
+	if token.value == "" {
+		return ociToken{}, fmt.Errorf("registry token endpoint returned no token")
+	}
+	if body.ExpiresIn > 0 {
+		token.expires = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - ociTokenMargin)
+	}
+	return token, nil
+}
+
+// basic returns the Basic Authorization header of the configured credentials ("" without them)
+func (a *ociAuth) basic() string {
+	if a.username == "" {
+		return ""
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.username+":"+a.password))
+}
+
+// ociRepositoryName extracts the repository from a registry API path
+// /v2/{name}/manifests/{reference}, /v2/{name}/blobs/{digest} or /v2/{name}/tags/list
+func ociRepositoryName(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v2/")
+	if !ok {
+		return "", false
+	}
+	for _, marker := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(rest, marker); i > 0 {
+			return rest[:i], true
+		}
+	}
+	return "", false
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://ghcr.io/token",service="ghcr.io"; nil when the scheme is not supported
+func parseChallenge(header string) *ociChallenge {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	challenge := &ociChallenge{scheme: strings.ToLower(scheme)}
+
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "realm":
+			challenge.realm = value
+		case "service":
+			challenge.service = value
+		}
+	}
+
+	switch {
+	case challenge.scheme == "basic":
+		return challenge
+	case challenge.scheme == "bearer" && challenge.realm != "":
+		return challenge
+	}
+	return nil
+}
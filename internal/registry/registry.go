@@ -5,26 +5,109 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 
 	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
 	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
 )
 
 // Registry represents a client for working with Terraform Registry API
 type Registry struct {
-	client    *upstream.Client
-	hashCache *cache.HashCache
-	logger    *slog.Logger
+	client        *upstream.Client
+	hashCache     *cache.HashCache
+	artifactCache *cache.ArtifactCache
+	aliases       map[string]string
+	logger        *slog.Logger
+
+	// Providers served from GitHub releases (nil when none are configured)
+	github *gitHubReleases
+
+	// Providers served from OCI registries (nil when none are configured)
+	oci *ociRepositories
+
+	// Download metadata cache (nil when disabled)
+	downloads *downloadCache
+
+	// Parsed SHA256SUMS files and recent failures to fetch them
+	shasums *shasumsCache
+
+	// Rendered {version}.json documents (nil when disabled)
+	versions *versionCache
+
+	// Version list history (nil when snapshots are disabled)
+	snapshots *snapshots
+
+	// Non-standard upstream API layout (nil for the Registry API at /v1/providers/)
+	layout *upstreamLayout
+
+	// Namespaces whose archives are normalized ("*" for all, see normalize.go)
+	normalized []string
+
+	// Concurrent identical metadata requests share one upstream call
+	group singleflight.Group
+
+	// Last refresh of a version list after a requested version was missing from it ("namespace/name" -> time.Time)
+	refreshes sync.Map
+
+	// Upstream schema drift: its recorder and the unknown fields already logged ("document:field", see drift.go)
+	metrics     metrics.Recorder
+	driftFields sync.Map
 }
 
+// versionRefreshInterval is how often a version list is refreshed for versions missing from it
+const versionRefreshInterval = time.Minute
+
 // New creates a new Registry client
-func New(client *upstream.Client, hashCache *cache.HashCache, logger *slog.Logger) *Registry {
+// aliases maps old provider addresses to new ones ("oldns/oldname" -> "newns/newname")
+func New(client *upstream.Client, hashCache *cache.HashCache, artifactCache *cache.ArtifactCache, aliases map[string]string, logger *slog.Logger) *Registry {
 	return &Registry{
-		client:    client,
-		hashCache: hashCache,
-		logger:    logger,
+		client:        client,
+		hashCache:     hashCache,
+		artifactCache: artifactCache,
+		aliases:       aliases,
+		shasums:       newShasumsCache(defaultShasumsRetry),
+		logger:        logger,
+		metrics:       metrics.Nop(),
+	}
+}
+
+// Resolve returns the canonical namespace and name for a provider, applying aliases
+// Both the old and the new address share upstream data and cache entries
+func (r *Registry) Resolve(namespace, name string) (string, string) {
+	target, ok := r.aliases[namespace+"/"+name]
+	if !ok {
+		return namespace, name
+	}
+
+	newNamespace, newName, ok := strings.Cut(target, "/")
+	if !ok {
+		return namespace, name
+	}
+	return newNamespace, newName
+}
+
+// Origin returns the host a provider's metadata is requested from: its GitHub API or
+// OCI registry, else the upstream registry
+func (r *Registry) Origin(namespace, name string) string {
+	namespace, name = r.Resolve(namespace, name)
+	base := r.client.URL("")
+	if _, ok := r.gitHubRepo(namespace, name); ok {
+		base = r.github.apiURL
+	} else if repo, ok := r.ociRepo(namespace, name); ok {
+		base = repo.base
+	}
+	if u, err := url.Parse(base); err == nil && u.Host != "" {
+		return u.Host
 	}
+	return base
 }
 
 // HashCache returns the hash cache
@@ -35,30 +118,16 @@ func (r *Registry) HashCache() *cache.HashCache {
 // ProviderVersions returns list of provider versions in Mirror Protocol format
 // GET /v1/providers/{hostname}/{namespace}/{type}/versions -> index.json
 func (r *Registry) ProviderVersions(ctx context.Context, namespace, name string) ([]byte, error) {
-	// Request to Registry API
-	// https://registry.terraform.io/v1/providers/{namespace}/{type}/versions
-	path := fmt.Sprintf("/v1/providers/%s/%s/versions", namespace, name)
-
-	r.logger.Debug("fetching provider versions", "path", path)
+	namespace, name = r.Resolve(namespace, name)
 
-	body, statusCode, err := r.client.GetJSON(ctx, path)
+	registryResp, err := r.fetchVersions(ctx, namespace, name)
 	if err != nil {
-		return nil, fmt.Errorf("fetching versions: %w", err)
-	}
-
-	if statusCode != 200 {
-		return nil, fmt.Errorf("upstream returned status %d", statusCode)
-	}
-
-	// Parse Registry API response
-	var registryResp RegistryVersionsResponse
-	if err := json.Unmarshal(body, &registryResp); err != nil {
-		return nil, fmt.Errorf("parsing response: %w", err)
+		return nil, err
 	}
 
 	// Transform to Mirror Protocol format
-	mirrorResp := MirrorVersionsResponse{
-		Versions: make(map[string]struct{}),
+	mirrorResp := providersv1.MirrorVersionsResponse{
+		Versions: make(providersv1.VersionSet),
 	}
 
 	for _, v := range registryResp.Versions {
@@ -71,91 +140,349 @@ func (r *Registry) ProviderVersions(ctx context.Context, namespace, name string)
 // ProviderVersion returns information about a specific version in Mirror Protocol format
 // GET /v1/providers/{hostname}/{namespace}/{type}/{version} -> {version}.json
 func (r *Registry) ProviderVersion(ctx context.Context, namespace, name, version string) ([]byte, error) {
-	// Request to Registry API to get platform information
-	// https://registry.terraform.io/v1/providers/{namespace}/{type}/{version}/download/{os}/{arch}
-	// But it's easier to get all platforms through versions endpoint
-
-	path := fmt.Sprintf("/v1/providers/%s/%s/versions", namespace, name)
-
-	r.logger.Debug("fetching provider version", "path", path, "version", version)
-
-	body, statusCode, err := r.client.GetJSON(ctx, path)
-	if err != nil {
-		return nil, fmt.Errorf("fetching versions: %w", err)
-	}
+	data, _, err := r.ProviderVersionCached(ctx, namespace, name, version)
+	return data, err
+}
 
-	if statusCode != 200 {
-		return nil, fmt.Errorf("upstream returned status %d", statusCode)
+// ProviderVersionCached is ProviderVersion that also reports whether the document came from the version cache
+func (r *Registry) ProviderVersionCached(ctx context.Context, namespace, name, version string) ([]byte, bool, error) {
+	namespace, name = r.Resolve(namespace, name)
+
+	// Documents of a snapshot are rendered from its version list and not cached
+	key := versionCacheKey(namespace, name, version)
+	cached := r.versions != nil && snapshotSuffix(ctx) == ""
+	var generation uint64
+	if cached {
+		data, gen, ok := r.versions.get(key)
+		if ok {
+			return data, true, nil
+		}
+		generation = gen
 	}
 
-	// Parse Registry API response
-	var registryResp RegistryVersionsResponse
-	if err := json.Unmarshal(body, &registryResp); err != nil {
-		return nil, fmt.Errorf("parsing response: %w", err)
+	result, err := r.coalesce(ctx, "version:"+namespace+"/"+name+"/"+version+snapshotSuffix(ctx), func(ctx context.Context) (any, error) {
+		return r.providerVersion(ctx, namespace, name, version)
+	})
+	if err != nil {
+		return nil, false, err
 	}
+	doc := result.(renderedVersion)
 
-	// Find target version
-	var targetVersion *RegistryVersion
-	for _, v := range registryResp.Versions {
-		if v.Version == version {
-			targetVersion = &v
-			break
+	if cached {
+		// Without every zh hash the document is only kept until SHA256SUMS is tried again
+		ttl := r.versions.ttl
+		if !doc.complete {
+			ttl = r.shasums.retry
 		}
+		r.versions.set(key, doc.data, generation, ttl)
 	}
+	return doc.data, false, nil
+}
 
-	if targetVersion == nil {
-		return nil, fmt.Errorf("version %s not found", version)
+// renderedVersion is a {version}.json document; complete when every platform has a zh hash
+type renderedVersion struct {
+	data     []byte
+	complete bool
+}
+
+func (r *Registry) providerVersion(ctx context.Context, namespace, name, version string) (renderedVersion, error) {
+	targetVersion, err := r.findVersion(ctx, namespace, name, version)
+	if err != nil {
+		return renderedVersion{}, err
 	}
 
 	// Transform to Mirror Protocol format
-	mirrorResp := MirrorVersionResponse{
-		Archives: make(map[string]MirrorArchive),
+	mirrorResp := providersv1.MirrorVersionResponse{
+		Archives: make(providersv1.ArchiveMap),
 	}
 
 	// Get all hashes for this version from cache
-	cachedHashes := r.hashCache.GetAll(namespace, name, version)
+	cachedHashes := r.hashCache.Hashes(namespace, name, version)
+
+	// zh hashes published upstream cover every platform without downloading archives
+	zipHashes := r.zipHashes(ctx, namespace, name, version)
+	complete := true
 
 	for _, p := range targetVersion.Platforms {
 		platform := fmt.Sprintf("%s_%s", p.OS, p.Arch)
-		filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", name, version, p.OS, p.Arch)
+		filename := ZipFilename(name, version, p.OS, p.Arch)
 
-		archive := MirrorArchive{
+		archive := providersv1.MirrorArchive{
 			URL: filename,
 		}
 
-		// Add h1 hash if it exists in cache
-		if h1, ok := cachedHashes[platform]; ok {
-			archive.Hashes = []string{h1}
+		// Add h1 and other local hashes if they exist in cache
+		archive.Hashes = append(archive.Hashes, cachedHashes[platform]...)
+
+		// Add zh hash from upstream SHA256SUMS
+		if zh, ok := zipHashes[filename]; ok {
+			archive.Hashes = append(archive.Hashes, zh)
+		} else if !r.Normalizes(namespace) {
+			complete = false
 		}
 
 		mirrorResp.Archives[platform] = archive
 	}
 
-	return json.Marshal(mirrorResp)
+	data, err := json.Marshal(mirrorResp)
+	return renderedVersion{data: data, complete: complete}, err
 }
 
 // DownloadURL returns the download URL for a provider
 func (r *Registry) DownloadURL(ctx context.Context, namespace, name, version, os, arch string) (string, error) {
+	downloadResp, err := r.DownloadInfo(ctx, namespace, name, version, os, arch)
+	if err != nil {
+		return "", err
+	}
+
+	return downloadResp.DownloadURL, nil
+}
+
+// DownloadInfo returns the registry download metadata for a provider platform
+// The result may be shared with other callers and must not be modified
+func (r *Registry) DownloadInfo(ctx context.Context, namespace, name, version, os, arch string) (*providersv1.RegistryDownloadResponse, error) {
+	namespace, name = r.Resolve(namespace, name)
+
+	if r.downloads == nil {
+		return r.requestDownloadInfo(ctx, namespace, name, version, os, arch)
+	}
+
+	key := downloadKey(namespace, name, version, os, arch)
+	if info, ok := r.downloads.get(key); ok {
+		r.logger.Debug("using cached download URL", "key", key)
+		return info, nil
+	}
+
+	info, err := r.requestDownloadInfo(ctx, namespace, name, version, os, arch)
+	if err != nil {
+		return nil, err
+	}
+	r.downloads.set(key, info)
+	return info, nil
+}
+
+// requestDownloadInfo requests download metadata from upstream (or GitHub releases or an OCI registry)
+func (r *Registry) requestDownloadInfo(ctx context.Context, namespace, name, version, os, arch string) (*providersv1.RegistryDownloadResponse, error) {
+	if repo, ok := r.gitHubRepo(namespace, name); ok {
+		return r.gitHubDownload(ctx, repo, namespace, name, version, os, arch)
+	}
+	if repo, ok := r.ociRepo(namespace, name); ok {
+		return r.ociDownload(ctx, repo, namespace, name, version, os, arch)
+	}
+
 	// GET /v1/providers/{namespace}/{type}/{version}/download/{os}/{arch}
-	path := fmt.Sprintf("/v1/providers/%s/%s/%s/download/%s/%s", namespace, name, version, os, arch)
+	path := fmt.Sprintf("%s/%s/download/%s/%s", r.providerPath(ctx, namespace, name), version, os, arch)
 
 	r.logger.Debug("fetching download URL", "path", path)
 
 	body, statusCode, err := r.client.GetJSON(ctx, path)
 	if err != nil {
-		return "", fmt.Errorf("fetching download URL: %w", err)
+		return nil, fmt.Errorf("fetching download URL: %w", err)
+	}
+
+	if statusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("provider %s/%s %s for %s_%s %w", namespace, name, version, os, arch, ErrNotFound)
 	}
 
 	if statusCode != 200 {
-		return "", fmt.Errorf("upstream returned status %d", statusCode)
+		return nil, &UpstreamError{StatusCode: statusCode}
 	}
 
-	var downloadResp RegistryDownloadResponse
-	if err := json.Unmarshal(body, &downloadResp); err != nil {
-		return "", fmt.Errorf("parsing response: %w", err)
+	endpoint := r.client.URL(path)
+	downloadResp, unknown, err := providersv1.DecodeDownload(body)
+	r.reportDrift(providersv1.DownloadDocument, endpoint, unknown, err)
+	if err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
 	}
 
-	return downloadResp.DownloadURL, nil
+	downloadResp.DownloadURL = resolveURL(endpoint, downloadResp.DownloadURL)
+	downloadResp.ShasumsURL = resolveURL(endpoint, downloadResp.ShasumsURL)
+	downloadResp.ShasumsSignatureURL = resolveURL(endpoint, downloadResp.ShasumsSignatureURL)
+
+	return downloadResp, nil
+}
+
+// RegistryVersions returns the upstream Registry API versions list for a provider
+func (r *Registry) RegistryVersions(ctx context.Context, namespace, name string) (*providersv1.RegistryVersionsResponse, error) {
+	namespace, name = r.Resolve(namespace, name)
+	return r.fetchVersions(ctx, namespace, name)
+}
+
+// Versions returns the versions published upstream for a provider
+func (r *Registry) Versions(ctx context.Context, namespace, name string) ([]string, error) {
+	namespace, name = r.Resolve(namespace, name)
+
+	registryResp, err := r.fetchVersions(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(registryResp.Versions))
+	for _, v := range registryResp.Versions {
+		versions = append(versions, v.Version)
+	}
+	return versions, nil
+}
+
+// Platforms returns the platforms published for a provider version
+func (r *Registry) Platforms(ctx context.Context, namespace, name, version string) ([]providersv1.RegistryPlatform, error) {
+	namespace, name = r.Resolve(namespace, name)
+
+	targetVersion, err := r.findVersion(ctx, namespace, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return targetVersion.Platforms, nil
+}
+
+// fetchVersions requests the Registry API versions list, or reads it from the snapshot selected by ctx
+// Concurrent callers share the response, which must not be modified
+func (r *Registry) fetchVersions(ctx context.Context, namespace, name string) (*providersv1.RegistryVersionsResponse, error) {
+	if t, ok := snapshotFromContext(ctx); ok {
+		return r.snapshotVersions(namespace, name, t)
+	}
+
+	resp, err := r.coalesce(ctx, "versions:"+namespace+"/"+name, func(ctx context.Context) (any, error) {
+		resp, err := r.requestVersions(ctx, namespace, name)
+		if err == nil {
+			r.recordSnapshot(namespace, name, resp)
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*providersv1.RegistryVersionsResponse), nil
+}
+
+func (r *Registry) requestVersions(ctx context.Context, namespace, name string) (*providersv1.RegistryVersionsResponse, error) {
+	if repo, ok := r.gitHubRepo(namespace, name); ok {
+		return r.gitHubVersions(ctx, repo, namespace, name)
+	}
+	if repo, ok := r.ociRepo(namespace, name); ok {
+		return r.ociVersions(ctx, repo, namespace, name)
+	}
+
+	// Request to Registry API
+	// https://registry.terraform.io/v1/providers/{namespace}/{type}/versions
+	path := r.providerPath(ctx, namespace, name) + "/versions"
+
+	r.logger.Debug("fetching provider versions", "path", path)
+
+	body, statusCode, err := r.client.GetJSON(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching versions: %w", err)
+	}
+
+	if statusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("provider %s/%s %w", namespace, name, ErrNotFound)
+	}
+
+	if statusCode != 200 {
+		return nil, &UpstreamError{StatusCode: statusCode}
+	}
+
+	// Parse Registry API response
+	registryResp, unknown, err := providersv1.DecodeVersions(body)
+	r.reportDrift(providersv1.VersionsDocument, r.client.URL(path), unknown, err)
+	if err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+
+	return registryResp, nil
+}
+
+// coalesce runs fn once for concurrent callers with the same key
+// fn is detached from the caller's cancellation, so one client giving up does not
+// fail the others (upstream timeouts still apply); each caller stops waiting when its own context ends
+func (r *Registry) coalesce(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	ch := r.group.DoChan(key, func() (any, error) {
+		return fn(context.WithoutCancel(ctx))
+	})
+
+	select {
+	case res := <-ch:
+		if res.Shared {
+			r.logger.Debug("coalesced upstream request", "key", key)
+		}
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// findVersion returns a single version (with its platforms) from the versions list
+// The versions endpoint is used because it returns all platforms in one request
+// A version missing from the list is looked up once more in a list requested past any
+// caches, so releases published after an intermediate cache stored the list work at once
+func (r *Registry) findVersion(ctx context.Context, namespace, name, version string) (*providersv1.RegistryVersion, error) {
+	registryResp, err := r.fetchVersions(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if v := lookupVersion(registryResp, version); v != nil {
+		return v, nil
+	}
+
+	if !SnapshotSelected(ctx) && r.refreshDue(namespace, name) {
+		r.logger.Info("version missing from upstream list, refreshing it", "provider", namespace+"/"+name, "version", version)
+		registryResp, err := r.refreshVersions(ctx, namespace, name)
+		if err != nil {
+			r.logger.Warn("failed to refresh upstream versions list", "provider", namespace+"/"+name, "error", err)
+		} else if v := lookupVersion(registryResp, version); v != nil {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("version %s %w", version, ErrNotFound)
+}
+
+func lookupVersion(registryResp *providersv1.RegistryVersionsResponse, version string) *providersv1.RegistryVersion {
+	for i := range registryResp.Versions {
+		if registryResp.Versions[i].Version == version {
+			return &registryResp.Versions[i]
+		}
+	}
+	return nil
+}
+
+// refreshDue reports whether the version list of a provider may be refreshed for a missing version
+// Refreshes are limited to one per versionRefreshInterval, so requests for versions that do not
+// exist do not double the upstream traffic
+func (r *Registry) refreshDue(namespace, name string) bool {
+	key := namespace + "/" + name
+	now := time.Now()
+	last, loaded := r.refreshes.Load(key)
+	if loaded && now.Sub(last.(time.Time)) < versionRefreshInterval {
+		return false
+	}
+	if loaded {
+		return r.refreshes.CompareAndSwap(key, last, now)
+	}
+	_, loaded = r.refreshes.LoadOrStore(key, now)
+	return !loaded
+}
+
+// refreshVersions requests the version list again, asking caches in between to revalidate it
+// It does not join a request already in flight, which may have been answered before the release
+func (r *Registry) refreshVersions(ctx context.Context, namespace, name string) (*providersv1.RegistryVersionsResponse, error) {
+	resp, err := r.coalesce(ctx, "versions-refresh:"+namespace+"/"+name, func(ctx context.Context) (any, error) {
+		resp, err := r.requestVersions(upstream.NoCache(ctx), namespace, name)
+		if err == nil {
+			r.recordSnapshot(namespace, name, resp)
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*providersv1.RegistryVersionsResponse), nil
+}
+
+// ZipFilename returns the archive filename for a provider platform
+// terraform-provider-{name}_{version}_{os}_{arch}.zip
+func ZipFilename(name, version, os, arch string) string {
+	return fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", name, version, os, arch)
 }
 
 // ParseZipFilename parses a provider filename
@@ -182,45 +509,9 @@ func ParseZipFilename(filename string) (name, version, os, arch string, err erro
 	version = parts[len(parts)-3]
 	name = strings.Join(parts[:len(parts)-3], "_")
 
-	return name, version, os, arch, nil
-}
-
-// === Types ===
-
-// RegistryVersionsResponse — Registry API response /v1/providers/{ns}/{type}/versions
-type RegistryVersionsResponse struct {
-	Versions []RegistryVersion `json:"versions"`
-}
-
-type RegistryVersion struct {
-	Version   string             `json:"version"`
-	Platforms []RegistryPlatform `json:"platforms"`
-}
-
-type RegistryPlatform struct {
-	OS   string `json:"os"`
-	Arch string `json:"arch"`
-}
-
-// RegistryDownloadResponse — Registry API response /download/{os}/{arch}
-type RegistryDownloadResponse struct {
-	DownloadURL string `json:"download_url"`
-	Filename    string `json:"filename"`
-	SHA256Sum   string `json:"shasum"`
-}
-
-// MirrorVersionsResponse — Mirror Protocol response index.json
-type MirrorVersionsResponse struct {
-	Versions map[string]struct{} `json:"versions"`
-}
-
-// MirrorVersionResponse — Mirror Protocol response {version}.json
-type MirrorVersionResponse struct {
-	Archives map[string]MirrorArchive `json:"archives"`
-}
+	if err := ValidatePlatform(os, arch); err != nil {
+		return "", "", "", "", err
+	}
 
-type MirrorArchive struct {
-	URL    string   `json:"url"`
-	Hashes []string `json:"hashes,omitempty"`
+	return name, version, os, arch, nil
 }
-
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// maxShasumsEntries bounds the parsed SHA256SUMS cache
+	maxShasumsEntries = 10000
+
+	// defaultShasumsRetry is how long a SHA256SUMS file that could not be fetched is not requested again
+	defaultShasumsRetry = 15 * time.Minute
+)
+
+// shasumsCache keeps the zh hashes parsed from SHA256SUMS files, so {version}.json responses
+// neither re-read nor re-parse them, and remembers files that could not be fetched,
+// so a registry without them is not asked on every request
+type shasumsCache struct {
+	retry time.Duration
+
+	mu     sync.Mutex
+	hashes map[string]map[string]string // "namespace/name/version" -> filename -> "zh:..."
+	failed map[string]time.Time         // "namespace/name/version" -> next attempt
+}
+
+func newShasumsCache(retry time.Duration) *shasumsCache {
+	return &shasumsCache{
+		retry:  retry,
+		hashes: make(map[string]map[string]string),
+		failed: make(map[string]time.Time),
+	}
+}
+
+// SetShasumsRetry sets how long a SHA256SUMS file that could not be fetched is not requested again
+// (0 retries on every request)
+func (r *Registry) SetShasumsRetry(retry time.Duration) {
+	r.shasums = newShasumsCache(retry)
+}
+
+func (c *shasumsCache) get(key string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hashes, ok := c.hashes[key]
+	return hashes, ok
+}
+
+func (c *shasumsCache) set(key string, hashes map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.hashes) >= maxShasumsEntries {
+		c.hashes = make(map[string]map[string]string)
+	}
+	c.hashes[key] = hashes
+	delete(c.failed, key)
+}
+
+// backoff reports whether an earlier attempt failed recently
+func (c *shasumsCache) backoff(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next, ok := c.failed[key]
+	if ok && time.Now().After(next) {
+		delete(c.failed, key)
+		return false
+	}
+	return ok
+}
+
+func (c *shasumsCache) fail(key string) {
+	if c.retry <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.failed) >= maxShasumsEntries {
+		c.failed = make(map[string]time.Time)
+	}
+	c.failed[key] = time.Now().Add(c.retry)
+}
+
+// ZipHash returns the zh hash of an archive from its version's SHA256SUMS file
+func (r *Registry) ZipHash(ctx context.Context, namespace, name, version, filename string) (string, bool) {
+	h, ok := r.zipHashes(ctx, namespace, name, version)[filename]
+	return h, ok
+}
+
+// zipHashes returns zh hashes (SHA-256 of the archive) for a version keyed by filename
+// The SHA256SUMS file is fetched once per version and covers every platform without downloading archives.
+// A missing or unreachable file is not fatal: h1 hashes are still served
+// Normalized archives no longer match SHA256SUMS, so their namespaces have no zh hashes
+func (r *Registry) zipHashes(ctx context.Context, namespace, name, version string) map[string]string {
+	if r.Normalizes(namespace) {
+		return nil
+	}
+	key := namespace + "/" + name + "/" + version
+	if hashes, ok := r.shasums.get(key); ok {
+		return hashes
+	}
+
+	// After a failure, only a file stored meanwhile (e.g. by a direct SHA256SUMS request) is used
+	if r.shasums.backoff(key) {
+		if _, ok := r.artifactCache.Get(namespace, name, version, ShasumsFilename(name, version)); !ok {
+			return nil
+		}
+	}
+
+	data, err := r.Artifact(ctx, namespace, name, version, false)
+	if err != nil {
+		if ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+			r.shasums.fail(key)
+		}
+		r.logger.Warn("zh hashes unavailable", "provider", namespace+"/"+name, "version", version, "error", err)
+		return nil
+	}
+
+	hashes := make(map[string]string)
+	for filename, sum := range ParseShasums(data) {
+		if _, fileVersion, _, _, err := ParseZipFilename(filename); err == nil && fileVersion == version {
+			hashes[filename] = "zh:" + sum
+		}
+	}
+	r.shasums.set(key, hashes)
+	return hashes
+}
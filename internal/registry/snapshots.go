@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// snapshotDateFormat selects the state at the end of a UTC day
+const snapshotDateFormat = "2006-01-02"
+
+// snapshots records upstream version lists and serves earlier ones
+type snapshots struct {
+	store *cache.SnapshotStore
+
+	// Digest of the last recorded list per provider, so unchanged lists skip the database
+	mu     sync.Mutex
+	latest map[string][sha256.Size]byte
+}
+
+// SnapshotInfo describes a stored version list
+type SnapshotInfo struct {
+	Taken    time.Time `json:"taken"`
+	Versions int       `json:"versions"`
+}
+
+type snapshotContextKey struct{}
+
+// SetSnapshots records every distinct upstream version list in store and enables serving snapshots
+func (r *Registry) SetSnapshots(store *cache.SnapshotStore) {
+	r.snapshots = &snapshots{store: store, latest: make(map[string][sha256.Size]byte)}
+}
+
+// WithSnapshot returns a context in which version lists come from the snapshot taken at or before t
+// instead of upstream
+func WithSnapshot(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, snapshotContextKey{}, t)
+}
+
+// SnapshotSelected reports whether version lists in ctx come from a snapshot
+func SnapshotSelected(ctx context.Context) bool {
+	_, ok := snapshotFromContext(ctx)
+	return ok
+}
+
+func snapshotFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(snapshotContextKey{}).(time.Time)
+	return t, ok
+}
+
+// snapshotSuffix distinguishes coalescing keys of requests served from a snapshot
+func snapshotSuffix(ctx context.Context) string {
+	if t, ok := snapshotFromContext(ctx); ok {
+		return "@" + t.UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// ParseSnapshot parses a snapshot selector: a date (the state at the end of that UTC day) or an RFC 3339 time
+func ParseSnapshot(s string) (time.Time, error) {
+	if t, err := time.Parse(snapshotDateFormat, s); err == nil {
+		return t.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid snapshot %q: expected YYYY-MM-DD or an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// Snapshots lists the stored version lists of a provider, oldest first
+func (r *Registry) Snapshots(namespace, name string) ([]SnapshotInfo, error) {
+	if r.snapshots == nil {
+		return nil, errors.New("version snapshots are not enabled")
+	}
+	namespace, name = r.Resolve(namespace, name)
+
+	stored, err := r.snapshots.store.List(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]SnapshotInfo, 0, len(stored))
+	for _, snap := range stored {
+		var resp providersv1.RegistryVersionsResponse
+		if err := json.Unmarshal(snap.Data, &resp); err != nil {
+			continue
+		}
+		result = append(result, SnapshotInfo{Taken: snap.Taken, Versions: len(resp.Versions)})
+	}
+	return result, nil
+}
+
+// recordSnapshot stores a version list fetched from upstream if it changed since the last one
+func (r *Registry) recordSnapshot(namespace, name string, resp *providersv1.RegistryVersionsResponse) {
+	if r.snapshots == nil {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+
+	key := namespace + "/" + name
+	digest := sha256.Sum256(data)
+	r.snapshots.mu.Lock()
+	unchanged := r.snapshots.latest[key] == digest
+	r.snapshots.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	added, err := r.snapshots.store.Add(namespace, name, time.Now(), data)
+	if err != nil {
+		r.logger.Warn("failed to record version snapshot", "provider", key, "error", err)
+		return
+	}
+	if added {
+		r.logger.Info("recorded version snapshot", "provider", key, "versions", len(resp.Versions))
+	}
+
+	r.snapshots.mu.Lock()
+	r.snapshots.latest[key] = digest
+	r.snapshots.mu.Unlock()
+}
+
+// snapshotVersions returns the version list of a provider as it was at t
+func (r *Registry) snapshotVersions(namespace, name string, t time.Time) (*providersv1.RegistryVersionsResponse, error) {
+	if r.snapshots == nil {
+		return nil, errors.New("version snapshots are not enabled")
+	}
+
+	snap, ok, err := r.snapshots.store.At(namespace, name, t)
+	if err != nil {
+		return nil, fmt.Errorf("reading version snapshot: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("snapshot of provider %s/%s at %s %w", namespace, name, t.UTC().Format(time.RFC3339), ErrNotFound)
+	}
+
+	var resp providersv1.RegistryVersionsResponse
+	if err := json.Unmarshal(snap.Data, &resp); err != nil {
+		return nil, fmt.Errorf("parsing version snapshot: %w", err)
+	}
+	return &resp, nil
+}
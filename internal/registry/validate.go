@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	maxSegmentLength = 64
+	maxVersionLength = 128
+)
+
+var (
+	// Namespaces and provider types: letters, digits, - and _, starting and ending alphanumeric
+	segmentPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9_-]*[A-Za-z0-9])?$`)
+
+	// MAJOR.MINOR.PATCH with optional pre-release and build metadata
+	versionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?$`)
+
+	// Platform parts such as linux, amd64 or 386
+	platformPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+)
+
+// ValidateProvider checks namespace and provider type path segments
+// Both are used in upstream URLs and cache paths, so anything else is refused
+func ValidateProvider(namespace, name string) error {
+	if err := validateSegment("namespace", namespace); err != nil {
+		return err
+	}
+	return validateSegment("provider type", name)
+}
+
+// ValidateVersion checks that a version is a semantic version such as 1.2.3 or 1.2.3-beta.1
+func ValidateVersion(version string) error {
+	if len(version) > maxVersionLength {
+		return fmt.Errorf("version is longer than %d characters", maxVersionLength)
+	}
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	return nil
+}
+
+// ValidatePlatform checks the os and arch parts of a platform
+func ValidatePlatform(os, arch string) error {
+	for _, part := range []string{os, arch} {
+		if len(part) > maxSegmentLength || !platformPattern.MatchString(part) {
+			return fmt.Errorf("invalid platform %q", os+"_"+arch)
+		}
+	}
+	return nil
+}
+
+func validateSegment(kind, value string) error {
+	if len(value) > maxSegmentLength {
+		return fmt.Errorf("%s is longer than %d characters", kind, maxSegmentLength)
+	}
+	if !segmentPattern.MatchString(value) {
+		return fmt.Errorf("invalid %s %q", kind, value)
+	}
+	return nil
+}
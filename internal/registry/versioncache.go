@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// maxVersionEntries bounds the rendered {version}.json cache
+const maxVersionEntries = 10000
+
+// versionCache keeps rendered {version}.json documents, so repeated requests neither
+// ask upstream for the version list nor read hashes; storing an h1 hash for a version drops it
+type versionCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]versionEntry // "namespace/name/version" -> entry
+
+	// Incremented by every invalidation; a document rendered across one is not stored,
+	// as it may lack the hash that caused it
+	generation uint64
+}
+
+type versionEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// SetVersionCacheTTL enables caching of rendered {version}.json documents for ttl (0 disables)
+// Documents are dropped as soon as a new h1 hash is stored for their version
+func (r *Registry) SetVersionCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		r.versions = nil
+		return
+	}
+	r.versions = &versionCache{ttl: ttl, entries: make(map[string]versionEntry)}
+	r.hashCache.OnSet(r.versions.invalidate)
+}
+
+func versionCacheKey(namespace, name, version string) string {
+	return namespace + "/" + name + "/" + version
+}
+
+// get returns a cached document and, on a miss, the generation to pass to set
+func (c *versionCache) get(key string) ([]byte, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && time.Now().Before(e.expires) {
+		return e.data, c.generation, true
+	}
+	if ok {
+		delete(c.entries, key)
+	}
+	return nil, c.generation, false
+}
+
+// set stores a document rendered at generation for ttl (capped by the cache TTL)
+func (c *versionCache) set(key string, data []byte, generation uint64, ttl time.Duration) {
+	ttl = min(ttl, c.ttl)
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if len(c.entries) >= maxVersionEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= maxVersionEntries {
+		c.entries = make(map[string]versionEntry)
+	}
+	c.entries[key] = versionEntry{data: data, expires: now.Add(ttl)}
+}
+
+// drop removes the document of a provider version, e.g. one rendered without zh hashes
+// when SHA256SUMS has been stored since
+func (c *versionCache) drop(namespace, name, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, versionCacheKey(namespace, name, version))
+}
+
+// invalidate drops the document of a provider version (cache.HashCache.OnSet)
+func (c *versionCache) invalidate(namespace, name, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, versionCacheKey(namespace, name, version))
+	c.generation++
+}
@@ -0,0 +1,162 @@
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/fetcher"
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// maxInventoryBytes limits the inventory read from the upstream mirror
+const maxInventoryBytes = 64 << 20
+
+// Replicator keeps this mirror in sync with an upstream tf-mirror (the hub):
+// it copies the hub's h1 hashes and downloads every archive the hub has cached
+type Replicator struct {
+	client    *upstream.Client
+	fetcher   *fetcher.Fetcher
+	hashCache *cache.HashCache
+	token     string
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// New creates a replicator; token is a read credential of the hub, e.g. a tenant token
+func New(client *upstream.Client, f *fetcher.Fetcher, hashCache *cache.HashCache, token string, interval time.Duration, logger *slog.Logger) *Replicator {
+	return &Replicator{
+		client:    client,
+		fetcher:   f,
+		hashCache: hashCache,
+		token:     token,
+		interval:  interval,
+		logger:    logger,
+	}
+}
+
+// Run replicates immediately and then every interval until ctx is done
+// A zero interval runs once
+func (r *Replicator) Run(ctx context.Context) {
+	for {
+		if err := r.Sync(ctx); err != nil {
+			r.logger.Error("replication failed", "error", err)
+		}
+
+		if r.interval <= 0 {
+			return
+		}
+		select {
+		case <-time.After(r.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync copies the hub's hash index and pulls archives missing locally
+func (r *Replicator) Sync(ctx context.Context) error {
+	if r.fetcher.Frozen() {
+		r.logger.Info("replication skipped, mirror is frozen")
+		return nil
+	}
+	start := time.Now()
+
+	items, err := FetchInventory(ctx, r.client, r.token)
+	if err != nil {
+		return err
+	}
+
+	var hashes int
+	var jobs []fetcher.Job
+	for _, it := range items {
+		if r.syncHash(it) {
+			hashes++
+		}
+		if !it.Cached {
+			continue
+		}
+		osName, arch, ok := strings.Cut(it.Platform, "_")
+		if !ok {
+			continue
+		}
+		jobs = append(jobs, fetcher.Job{
+			Namespace: it.Namespace,
+			Name:      it.Name,
+			Version:   it.Version,
+			OS:        osName,
+			Arch:      arch,
+		})
+	}
+
+	var fetched, skipped, failed int
+	r.fetcher.Run(ctx, jobs, func(res fetcher.Result) {
+		switch {
+		case res.Err != nil:
+			failed++
+			r.logger.Warn("replication download failed", "job", res.Job.String(), "attempts", res.Attempts, "error", res.Err)
+		case res.Skipped:
+			skipped++
+		default:
+			fetched++
+		}
+	})
+
+	r.logger.Info("replication finished",
+		"platforms", len(items),
+		"hashes", hashes,
+		"archives", len(jobs),
+		"fetched", fetched,
+		"skipped", skipped,
+		"failed", failed,
+		"duration", time.Since(start).Round(time.Millisecond),
+	)
+	return nil
+}
+
+// syncHash stores the hub's h1 hash for a platform unless one is already known
+func (r *Replicator) syncHash(it inventory.Item) bool {
+	if _, ok := r.hashCache.Get(it.Namespace, it.Name, it.Version, it.Platform); ok {
+		return false
+	}
+	for _, h := range it.Hashes {
+		if !strings.HasPrefix(h, "h1:") {
+			continue
+		}
+		if err := r.hashCache.Set(it.Namespace, it.Name, it.Version, it.Platform, h); err != nil {
+			r.logger.Warn("failed to store replicated hash", "error", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// FetchInventory reads GET /api/inventory from another tf-mirror; token is a read credential of it,
+// e.g. a tenant token, so replicas and syncs do not need its admin token
+func FetchInventory(ctx context.Context, client *upstream.Client, token string) ([]inventory.Item, error) {
+	resp, err := client.GetAdmin(ctx, "/api/inventory?format=json", token)
+	if err != nil {
+		return nil, fmt.Errorf("fetching upstream inventory: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching upstream inventory: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Providers []inventory.Item `json:"providers"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxInventoryBytes)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("parsing upstream inventory: %w", err)
+	}
+	return body.Providers, nil
+}
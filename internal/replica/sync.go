@@ -0,0 +1,238 @@
+package replica
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// ErrUnverifiable is returned for archives the source mirror publishes no hash for
+var ErrUnverifiable = errors.New("no hash to verify against")
+
+// Diff is what a destination cache lacks compared to a source mirror
+type Diff struct {
+	// Archives cached at the source but not at the destination
+	Archives []inventory.Item
+
+	// Platforms whose h1 hash the destination does not know
+	Hashes []inventory.Item
+}
+
+// Compare returns the differences between a source and a destination inventory
+func Compare(source, dest []inventory.Item) Diff {
+	type state struct{ cached, hashed bool }
+	have := make(map[string]state, len(dest))
+	for _, it := range dest {
+		have[itemKey(it)] = state{cached: it.Cached, hashed: h1Of(it) != ""}
+	}
+
+	var diff Diff
+	for _, it := range source {
+		got := have[itemKey(it)]
+		if it.Cached && !got.cached {
+			diff.Archives = append(diff.Archives, it)
+		}
+		if !got.hashed && h1Of(it) != "" {
+			diff.Hashes = append(diff.Hashes, it)
+		}
+	}
+	return diff
+}
+
+// Syncer copies archives from another tf-mirror into a cache directory
+type Syncer struct {
+	client       *upstream.Client
+	baseURL      string
+	hostname     string
+	token        string
+	hashCache    *cache.HashCache
+	archiveCache *cache.ArchiveCache
+
+	// Spool settings for archives in transfer
+	SpoolDir         string
+	SpoolMemoryLimit int64
+
+	// CheckArchives refuses archives Terraform could not install from (see registry.CheckArchive)
+	CheckArchives bool
+
+	// Normalize lists the namespaces whose archives are normalized ("*" for all, see registry.Normalize);
+	// the source mirror must normalize them too, so its h1 hashes match
+	Normalize []string
+}
+
+// NewSyncer creates a syncer for the mirror at baseURL
+// hostname is the {hostname} path segment of its archive URLs; token authenticates archive requests
+func NewSyncer(client *upstream.Client, baseURL, hostname, token string, hashCache *cache.HashCache, archiveCache *cache.ArchiveCache) *Syncer {
+	return &Syncer{
+		client:       client,
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		hostname:     hostname,
+		token:        token,
+		hashCache:    hashCache,
+		archiveCache: archiveCache,
+	}
+}
+
+// StoreHashes records the source's h1 hashes the destination does not know
+func (s *Syncer) StoreHashes(items []inventory.Item) (int, error) {
+	stored := 0
+	for _, it := range items {
+		if err := s.hashCache.Set(it.Namespace, it.Name, it.Version, it.Platform, h1Of(it)); err != nil {
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
+
+// Transfer downloads archives with concurrency workers; onResult (optional) is called once per item
+// Each archive is verified against the source's hashes before it is stored
+func (s *Syncer) Transfer(ctx context.Context, items []inventory.Item, concurrency int, onResult func(inventory.Item, int64, error)) {
+	queue := make(chan inventory.Item)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for w := 0; w < min(len(items), max(concurrency, 1)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range queue {
+				size, err := s.transfer(ctx, it)
+				if onResult != nil {
+					mu.Lock()
+					onResult(it, size, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, it := range items {
+		queue <- it
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// transfer copies a single archive
+func (s *Syncer) transfer(ctx context.Context, it inventory.Item) (int64, error) {
+	if h1Of(it) == "" && zhOf(it) == "" {
+		return 0, ErrUnverifiable
+	}
+
+	rawURL := s.baseURL + providersv1.MirrorPath(s.hostname, it.Namespace, it.Name, it.Filename)
+	resp, err := s.client.MirrorArchive(ctx, rawURL, s.token)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("source returned status %d", resp.StatusCode)
+	}
+
+	sp := spool.New(s.SpoolDir, s.SpoolMemoryLimit)
+	defer sp.Close()
+	if _, err := io.Copy(sp, resp.Body); err != nil {
+		return 0, fmt.Errorf("downloading archive: %w", err)
+	}
+
+	h1, err := verify(sp, it)
+	if err != nil {
+		return 0, err
+	}
+	if registry.NormalizesNamespace(s.Normalize, it.Namespace) {
+		normalized := spool.New(s.SpoolDir, s.SpoolMemoryLimit)
+		defer normalized.Close()
+		if err := registry.Normalize(sp, sp.Size(), normalized, it.Name, strings.SplitN(it.Platform, "_", 2)[0]); err != nil {
+			return 0, err
+		}
+		if h1, err = hash.CalculateH1FromReaderAt(normalized, normalized.Size()); err != nil {
+			return 0, fmt.Errorf("calculating h1: %w", err)
+		}
+		if want := h1Of(it); want != "" && h1 != want {
+			return 0, fmt.Errorf("h1 mismatch after normalization: got %s, source %s (does the source normalize %s?)", h1, want, it.Namespace)
+		}
+		sp = normalized
+	}
+	if s.CheckArchives {
+		if err := registry.CheckArchive(sp, sp.Size(), it.Name, strings.SplitN(it.Platform, "_", 2)[0]); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.archiveCache.Set(it.Namespace, it.Name, it.Version, it.Filename, sp.Reader()); err != nil {
+		return 0, fmt.Errorf("storing archive: %w", err)
+	}
+	if _, ok := s.hashCache.Get(it.Namespace, it.Name, it.Version, it.Platform); !ok {
+		if err := s.hashCache.Set(it.Namespace, it.Name, it.Version, it.Platform, h1); err != nil {
+			return 0, fmt.Errorf("storing hash: %w", err)
+		}
+	}
+	return sp.Size(), nil
+}
+
+// verify checks a downloaded archive against the size, h1 and zh hashes of the source inventory
+// and returns its h1 hash
+func verify(sp *spool.Spool, it inventory.Item) (string, error) {
+	if it.Size > 0 && sp.Size() != it.Size {
+		return "", fmt.Errorf("size mismatch: got %d bytes, source %d", sp.Size(), it.Size)
+	}
+
+	h1, err := hash.CalculateH1FromReaderAt(sp, sp.Size())
+	if err != nil {
+		return "", fmt.Errorf("calculating h1: %w", err)
+	}
+	if want := h1Of(it); want != "" && h1 != want {
+		return "", fmt.Errorf("h1 mismatch: got %s, source %s", h1, want)
+	}
+
+	if want := zhOf(it); want != "" {
+		sum := sha256.New()
+		if _, err := io.Copy(sum, sp.Reader()); err != nil {
+			return "", err
+		}
+		if got := hex.EncodeToString(sum.Sum(nil)); !strings.EqualFold(got, want) {
+			return "", fmt.Errorf("sha256 mismatch: got %s, source %s", got, want)
+		}
+	}
+	return h1, nil
+}
+
+func itemKey(it inventory.Item) string {
+	return it.Namespace + "/" + it.Name + "/" + it.Version + "/" + it.Platform
+}
+
+// h1Of returns the h1 hash of an inventory item ("" when unknown)
+func h1Of(it inventory.Item) string {
+	for _, h := range it.Hashes {
+		if strings.HasPrefix(h, "h1:") {
+			return h
+		}
+	}
+	return ""
+}
+
+// zhOf returns the hex SHA-256 of an inventory item's archive ("" when unknown)
+func zhOf(it inventory.Item) string {
+	for _, h := range it.Hashes {
+		if sum, ok := strings.CutPrefix(h, "zh:"); ok {
+			return sum
+		}
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTimeout limits one scan when none is configured
+	defaultTimeout = 5 * time.Minute
+
+	// maxReportSize limits the scanner output kept as the report
+	maxReportSize = 4 << 20
+
+	// maxReasonSize limits the stderr line kept as the reason of a rejection
+	maxReasonSize = 512
+)
+
+// Options configures a Scanner; exactly one of Command and URL is set
+type Options struct {
+	// Command runs with the archive path as its last argument; exit status 0 passes the archive
+	Command []string
+
+	// URL receives the archive in a POST request; a 2xx response passes the archive
+	URL string
+
+	// Timeout limits one scan (0 uses 5m)
+	Timeout time.Duration
+
+	// TempDir holds the archive files passed to Command ("" for the system temp dir)
+	TempDir string
+}
+
+// Archive identifies a scanned provider archive
+type Archive struct {
+	Namespace string
+	Name      string
+	Version   string
+	Platform  string // "os_arch"
+	Filename  string
+	SHA256    string // hex
+}
+
+// Verdict is the outcome of a completed scan
+type Verdict struct {
+	Passed bool
+	Reason string // why the archive was rejected
+
+	// Report is the scanner output (stdout or the response body), e.g. findings or an SBOM
+	Report     []byte
+	ReportType string // content type of Report
+}
+
+// Scanner runs an external scanner (e.g. malware or license checks) on provider archives
+type Scanner struct {
+	opts   Options
+	client *http.Client
+}
+
+// New returns a scanner; the command must exist
+func New(opts Options) (*Scanner, error) {
+	switch {
+	case len(opts.Command) > 0 && opts.URL != "":
+		return nil, errors.New("scanner command and URL cannot be combined")
+	case len(opts.Command) > 0:
+		if _, err := exec.LookPath(opts.Command[0]); err != nil {
+			return nil, fmt.Errorf("scanner command: %w", err)
+		}
+	case opts.URL == "":
+		return nil, errors.New("scanner command or URL required")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	return &Scanner{opts: opts, client: &http.Client{}}, nil
+}
+
+// String describes the scanner for logs
+func (s *Scanner) String() string {
+	if s.opts.URL != "" {
+		return s.opts.URL
+	}
+	return strings.Join(s.opts.Command, " ")
+}
+
+// Scan runs the scanner on an archive
+// An error means the scan did not complete (the command could not run, crashed or timed out,
+// or the URL could not be reached); the caller decides whether to trust such an archive
+func (s *Scanner) Scan(ctx context.Context, a Archive, r io.ReaderAt, size int64) (Verdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	if s.opts.URL != "" {
+		return s.post(ctx, a, io.NewSectionReader(r, 0, size), size)
+	}
+	return s.run(ctx, a, io.NewSectionReader(r, 0, size))
+}
+
+// run passes the archive to the scanner command as a temporary file
+func (s *Scanner) run(ctx context.Context, a Archive, archive io.Reader) (Verdict, error) {
+	f, err := os.CreateTemp(s.opts.TempDir, "scan-*-"+a.Filename)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, archive)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	args := append(append([]string(nil), s.opts.Command[1:]...), f.Name())
+	cmd := exec.CommandContext(ctx, s.opts.Command[0], args...)
+	cmd.Env = append(os.Environ(),
+		"TF_MIRROR_SCAN_PROVIDER="+a.Namespace+"/"+a.Name,
+		"TF_MIRROR_SCAN_VERSION="+a.Version,
+		"TF_MIRROR_SCAN_PLATFORM="+a.Platform,
+		"TF_MIRROR_SCAN_SHA256="+a.SHA256,
+	)
+	stdout := &limitedBuffer{limit: maxReportSize}
+	stderr := &limitedBuffer{limit: maxReportSize}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err = cmd.Run()
+	verdict := Verdict{Passed: err == nil, Report: stdout.Bytes(), ReportType: detectType(stdout.Bytes())}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return Verdict{}, fmt.Errorf("scanner timed out after %s", s.opts.Timeout)
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		verdict.Reason = fmt.Sprintf("scanner exited with status %d", exitErr.ExitCode())
+		if line := firstLine(stderr.String()); line != "" {
+			verdict.Reason += ": " + line
+		}
+	case err != nil:
+		return Verdict{}, fmt.Errorf("running scanner: %w", err)
+	}
+	return verdict, nil
+}
+
+// post sends the archive to the scanner URL
+func (s *Scanner) post(ctx context.Context, a Archive, archive io.Reader, size int64) (Verdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.URL, archive)
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/zip")
+	req.Header.Set("X-Tf-Mirror-Provider", a.Namespace+"/"+a.Name)
+	req.Header.Set("X-Tf-Mirror-Version", a.Version)
+	req.Header.Set("X-Tf-Mirror-Platform", a.Platform)
+	req.Header.Set("X-Tf-Mirror-Sha256", a.SHA256)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("calling scanner: %w", err)
+	}
+	defer resp.Body.Close()
+	report, err := io.ReadAll(io.LimitReader(resp.Body, maxReportSize))
+	if err != nil {
+		return Verdict{}, fmt.Errorf("reading scanner response: %w", err)
+	}
+	if resp.StatusCode >= 500 {
+		return Verdict{}, fmt.Errorf("scanner answered %s", resp.Status)
+	}
+
+	verdict := Verdict{
+		Passed:     resp.StatusCode < 300,
+		Report:     report,
+		ReportType: resp.Header.Get("Content-Type"),
+	}
+	if verdict.ReportType == "" {
+		verdict.ReportType = detectType(report)
+	}
+	if !verdict.Passed {
+		verdict.Reason = "scanner answered " + resp.Status
+	}
+	return verdict, nil
+}
+
+// detectType guesses the content type of command output: JSON (e.g. a CycloneDX or SPDX SBOM) or text
+func detectType(report []byte) string {
+	trimmed := bytes.TrimSpace(report)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// firstLine returns the first non-empty line of s, shortened for logs and API responses
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			if len(line) > maxReasonSize {
+				line = line[:maxReasonSize] + "..."
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,216 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status of a scanned archive
+const (
+	StatusPassed      = "passed"      // the scanner accepted the archive
+	StatusQuarantined = "quarantined" // the scanner rejected the archive or failed; it is not served
+	StatusReleased    = "released"    // quarantined, then released by an admin
+)
+
+// Result is the latest scan of a provider archive
+type Result struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	Platform   string    `json:"platform"`
+	Filename   string    `json:"filename"`
+	SHA256     string    `json:"sha256"`
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	ReportType string    `json:"report_type,omitempty"` // content type of the report, when there is one
+	ScannedAt  time.Time `json:"scanned_at"`
+
+	// Admin override of a quarantine
+	ReleasedBy    string     `json:"released_by,omitempty"`
+	ReleasedAt    *time.Time `json:"released_at,omitempty"`
+	ReleaseReason string     `json:"release_reason,omitempty"`
+}
+
+// Store keeps scan results and reports in files
+// Layout: {dir}/{namespace}/{name}/{version}/{platform}.json and {platform}.report
+type Store struct {
+	dir string
+
+	mu      sync.RWMutex
+	results map[string]Result // "namespace/name/version/platform"
+}
+
+// NewStore loads scan results from dir
+func NewStore(dir string) (*Store, error) {
+	s := &Store{
+		dir:     dir,
+		results: make(map[string]Result),
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var r Result
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		s.results[resultKey(r.Namespace, r.Name, r.Version, r.Platform)] = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func resultKey(namespace, name, version, platform string) string {
+	return namespace + "/" + name + "/" + version + "/" + platform
+}
+
+// path returns the result file of an archive; the report is stored next to it
+func (s *Store) path(namespace, name, version, platform string) string {
+	return filepath.Join(s.dir, namespace, name, version, platform+".json")
+}
+
+func reportPath(resultPath string) string {
+	return strings.TrimSuffix(resultPath, ".json") + ".report"
+}
+
+// Get returns the scan result of an archive
+func (s *Store) Get(namespace, name, version, platform string) (Result, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.results[resultKey(namespace, name, version, platform)]
+	return r, ok
+}
+
+// List returns the results with the given status ("" for all), sorted by archive
+func (s *Store) List(status string) []Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Result, 0, len(s.results))
+	for _, r := range s.results {
+		if status == "" || r.Status == status {
+			result = append(result, r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return resultKey(result[i].Namespace, result[i].Name, result[i].Version, result[i].Platform) <
+			resultKey(result[j].Namespace, result[j].Name, result[j].Version, result[j].Platform)
+	})
+	return result
+}
+
+// Record stores the result of a scan with its report, replacing any earlier one
+func (s *Store) Record(r Result, report []byte) error {
+	if len(report) == 0 {
+		r.ReportType = ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(r.Namespace, r.Name, r.Version, r.Platform)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if len(report) > 0 {
+		if err := os.WriteFile(reportPath(path), report, 0644); err != nil {
+			return err
+		}
+	} else if err := os.Remove(reportPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.write(path, r); err != nil {
+		return err
+	}
+	s.results[resultKey(r.Namespace, r.Name, r.Version, r.Platform)] = r
+	return nil
+}
+
+// Report returns the scanner output of an archive's latest scan
+func (s *Store) Report(namespace, name, version, platform string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := os.ReadFile(reportPath(s.path(namespace, name, version, platform)))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Release lets a quarantined archive be served; it reports false if the archive is not quarantined
+// The release holds as long as the archive is unchanged: a download with another SHA-256 is scanned again
+func (s *Store) Release(namespace, name, version, platform, reason, actor string) (Result, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := resultKey(namespace, name, version, platform)
+	r, ok := s.results[key]
+	if !ok || r.Status != StatusQuarantined {
+		return r, false, nil
+	}
+
+	now := time.Now().UTC()
+	r.Status = StatusReleased
+	r.ReleasedBy = actor
+	r.ReleasedAt = &now
+	r.ReleaseReason = reason
+	if err := s.write(s.path(namespace, name, version, platform), r); err != nil {
+		return r, false, err
+	}
+	s.results[key] = r
+	return r, true, nil
+}
+
+// Forget removes the result of an archive so its next download is scanned again;
+// it reports false if the archive has no result
+func (s *Store) Forget(namespace, name, version, platform string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := resultKey(namespace, name, version, platform)
+	if _, ok := s.results[key]; !ok {
+		return false, nil
+	}
+
+	path := s.path(namespace, name, version, platform)
+	for _, p := range []string{path, reportPath(path)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	delete(s.results, key)
+	return true, nil
+}
+
+// write saves a result file; the caller holds s.mu
+func (s *Store) write(path string, r Result) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+)
+
+// networkACL restricts a route group to client networks
+type networkACL struct {
+	allow []netip.Prefix // empty allows every address that is not denied
+	deny  []netip.Prefix
+}
+
+// newNetworkACL parses the allow and deny lists of a route group
+func newNetworkACL(allow, deny []string) (networkACL, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return networkACL{}, err
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return networkACL{}, err
+	}
+	return networkACL{allow: allowed, deny: denied}, nil
+}
+
+func (a networkACL) empty() bool {
+	return len(a.allow) == 0 && len(a.deny) == 0
+}
+
+// allows reports whether a client address may use the route group; deny entries win
+// An unknown address (e.g. on a Unix socket) matches no entry
+func (a networkACL) allows(addr netip.Addr) bool {
+	if containsAddr(a.deny, addr) {
+		return false
+	}
+	return len(a.allow) == 0 || containsAddr(a.allow, addr)
+}
+
+// withNetworkACL refuses requests from networks outside the ACLs before any authentication
+// Every route is checked against TF_MIRROR_ALLOW_CIDRS and TF_MIRROR_DENY_CIDRS, the admin API
+// also against the admin lists; /health stays reachable for load balancer health checks
+func (s *Server) withNetworkACL(next http.Handler) http.Handler {
+	if s.publicACL.empty() && s.adminACL.empty() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, _ := netip.ParseAddr(clientIP(r))
+		group, allowed := "public", s.publicACL.allows(addr)
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			group, allowed = "admin", allowed && s.adminACL.allows(addr)
+		}
+		if !allowed {
+			s.metrics.Count(metrics.NetworkRejected, 1, "group:"+group)
+			s.logger.Debug("request refused by network ACL", "group", group, "client", s.logClient(r), "method", r.Method, "path", r.URL.Path)
+			writeError(w, policyDenied("requests from this network are not allowed"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// writeJSON renders v as a JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// handleAdminUpstream handles GET /admin/upstream — rolling upstream health per host
+// and the state of the archive download queue
+func (s *Server) handleAdminUpstream(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{
+		"upstream_url": s.cfg.UpstreamURL,
+		"hosts":        s.upstream.Stats(),
+		"downloads":    s.fetcher.QueueStats(),
+	})
+}
+
+// handleAdminCache handles GET /admin/cache — archive cache usage and quotas per namespace
+func (s *Server) handleAdminCache(w http.ResponseWriter, _ *http.Request) {
+	if s.archiveCache == nil {
+		writeJSON(w, map[string]any{"enabled": false, "hashes": s.hashCache.Count()})
+		return
+	}
+
+	usage, err := s.archiveCache.Usage()
+	if err != nil {
+		s.logger.Error("failed to read cache usage", "error", err)
+		writeError(w, internalError())
+		return
+	}
+
+	var total int64
+	for _, u := range usage {
+		total += u.Bytes
+	}
+
+	writeJSON(w, map[string]any{
+		"enabled":     true,
+		"hashes":      s.hashCache.Count(),
+		"total_bytes": total,
+		"max_bytes":   s.archiveCache.MaxSize(),
+		"namespaces":  usage,
+	})
+}
+
+// handleAdminHashFailures handles GET /admin/hash-failures — archives whose h1 hash could not be calculated
+func (s *Server) handleAdminHashFailures(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{
+		"require_hash": s.cfg.RequireHash,
+		"failures":     s.fetcher.HashFailures(),
+	})
+}
+
+// handleInventory handles GET /admin/inventory and GET /api/inventory?format=json|csv|cyclonedx —
+// cached provider inventory; on /api the replicas and syncs of a tenant see its providers only
+func (s *Server) handleInventory(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = inventory.FormatJSON
+	}
+	if format != inventory.FormatJSON && format != inventory.FormatCSV && format != inventory.FormatCycloneDX {
+		writeError(w, badRequest("unknown format "+format))
+		return
+	}
+
+	items, err := inventory.Collect(s.hashCache, s.archiveCache, s.artifactCache, s.cfg.NormalizeArchives)
+	if err != nil {
+		s.logger.Error("failed to collect inventory", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	if t := tenant.FromContext(r.Context()); t != nil {
+		allowed := items[:0]
+		for _, it := range items {
+			if t.Allows(it.Namespace, it.Name) {
+				allowed = append(allowed, it)
+			}
+		}
+		items = allowed
+	}
+	s.annotateScans(items)
+
+	w.Header().Set("Content-Type", inventory.ContentType(format))
+	if err := inventory.Write(w, format, items); err != nil {
+		s.logger.Error("failed to write inventory", "error", err)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Anonymization modes of client data in logs (TF_MIRROR_LOG_ANONYMIZE)
+const (
+	anonymizeNone     = "none"
+	anonymizeTruncate = "truncate" // addresses cut to their network, identities hashed
+	anonymizeHash     = "hash"     // addresses and identities hashed
+)
+
+// anonymizer replaces client addresses, certificate identities and token subjects in logs
+// Hashes are keyed per UTC day, so entries of the same client correlate within a day but not across days
+type anonymizer struct {
+	mode   string
+	secret []byte
+
+	mu  sync.Mutex
+	day string
+	key []byte
+}
+
+// newAnonymizer returns nil for mode "none"
+// Without a secret a random one is used, so hashes also change on restart
+func newAnonymizer(mode, secret string) (*anonymizer, error) {
+	if mode == "" || mode == anonymizeNone {
+		return nil, nil
+	}
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &anonymizer{mode: mode, secret: key}, nil
+}
+
+// address anonymizes a client IP: IPv4 is truncated to /24 and IPv6 to /48, or hashed
+func (a *anonymizer) address(ip string) string {
+	if a == nil || ip == "" {
+		return ip
+	}
+	if a.mode == anonymizeHash {
+		return a.hash(ip)
+	}
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return a.hash(ip)
+	case parsed.To4() != nil:
+		return parsed.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// identity anonymizes a certificate identity, token subject or other client name
+func (a *anonymizer) identity(id string) string {
+	if a == nil || id == "" {
+		return id
+	}
+	return a.hash(id)
+}
+
+// hash returns a short keyed hash of value for the current day
+func (a *anonymizer) hash(value string) string {
+	mac := hmac.New(sha256.New, a.dayKey(time.Now()))
+	mac.Write([]byte(value))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// dayKey derives the hash key of the UTC day of now from the secret
+func (a *anonymizer) dayKey(now time.Time) []byte {
+	day := now.UTC().Format(time.DateOnly)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if day != a.day {
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write([]byte(day))
+		a.day, a.key = day, mac.Sum(nil)
+	}
+	return a.key
+}
+
+// logClient returns the client address of a request as it may appear in logs
+func (s *Server) logClient(r *http.Request) string {
+	return s.anonymizer.address(clientIP(r))
+}
+
+// logIdentity returns a client identity or token subject as it may appear in logs
+func (s *Server) logIdentity(id string) string {
+	return s.anonymizer.identity(id)
+}
@@ -3,14 +3,17 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
-	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
 	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
 )
 
 // handleHealth handles GET /health
@@ -22,142 +25,269 @@ func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 }
 
 // handleVersions handles GET index.json — list of versions
-func (s *Server) handleVersions(ctx context.Context, w http.ResponseWriter, namespace, name string) {
+func (s *Server) handleVersions(ctx context.Context, w http.ResponseWriter, hostname, namespace, name string) {
 	s.logger.Info("fetching versions", "provider", namespace+"/"+name)
 
-	data, err := s.registry.ProviderVersions(ctx, namespace, name)
+	data, err := s.versionsDocument(ctx, namespace, name)
+	status, origin := cacheMiss, s.registry.Origin(namespace, name)
+	if registry.SnapshotSelected(ctx) {
+		status, origin = cacheHit, ""
+	}
+	if errors.Is(err, upstream.ErrRateLimited) && s.useLatestSnapshot(ctx, err) {
+		// The last recorded list is better than failing while upstream asks us to slow down
+		if stale, snapErr := s.versionsDocument(registry.WithSnapshot(ctx, time.Now()), namespace, name); snapErr == nil {
+			s.logger.Warn("upstream rate limited, serving the latest version list snapshot", "provider", namespace+"/"+name)
+			w.Header().Add("Warning", `110 - "Response is Stale"`)
+			data, err = stale, nil
+			status, origin = cacheStale, ""
+		}
+	}
 	if err != nil {
 		s.logger.Error("failed to fetch versions", "error", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeError(w, err)
 		return
 	}
 
+	s.setAttribution(w, status, origin)
+	s.setSignature(w, hostname, namespace, name, "index.json", data)
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(data)
 }
 
+// versionsDocument returns the index.json body served for a provider
+func (s *Server) versionsDocument(ctx context.Context, namespace, name string) ([]byte, error) {
+	data, err := s.registry.ProviderVersions(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.filterVersions(namespace, name, data), nil
+}
+
 // handleVersion handles GET {version}.json — platform information
-func (s *Server) handleVersion(ctx context.Context, w http.ResponseWriter, namespace, name, version string) {
+// omit lists hash schemes left out for the requesting client (see clients.go)
+func (s *Server) handleVersion(ctx context.Context, w http.ResponseWriter, hostname, namespace, name, version string, omit []string) {
 	s.logger.Info("fetching version", "provider", namespace+"/"+name, "version", version)
 
-	data, err := s.registry.ProviderVersion(ctx, namespace, name, version)
+	if err := s.checkVersion(namespace, name, version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data, cached, err := s.versionDocument(ctx, hostname, namespace, name, version)
 	if err != nil {
 		s.logger.Error("failed to fetch version", "error", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeError(w, err)
 		return
 	}
+	if cached || registry.SnapshotSelected(ctx) {
+		s.setAttribution(w, cacheHit, "")
+	} else {
+		s.setAttribution(w, cacheMiss, s.registry.Origin(namespace, name))
+	}
+
+	// Compute missing hashes in the background so lock files become complete
+	if s.cfg.PrewarmHashes {
+		s.fetcher.Prewarm(namespace, name, version)
+	}
+
+	data = omitHashes(data, omit)
 
+	s.setSignature(w, hostname, namespace, name, version+".json", data)
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(data)
 }
 
+// versionDocument returns the {version}.json body served for a provider version, and whether
+// it came from the version cache
+func (s *Server) versionDocument(ctx context.Context, hostname, namespace, name, version string) ([]byte, bool, error) {
+	data, cached, err := s.registry.ProviderVersionCached(ctx, namespace, name, version)
+	if err != nil {
+		return nil, false, err
+	}
+	if s.frozen() {
+		data = s.filterFrozenPlatforms(namespace, name, version, data)
+	}
+	return s.archiveURLs(hostname, namespace, name, data), cached, nil
+}
+
 // handleDownload handles GET *.zip — proxy archive with h1 hash calculation
-func (s *Server) handleDownload(ctx context.Context, w http.ResponseWriter, namespace, providerName, filename string) {
+// With redirect set, an archive in the object store is answered with a redirect to a presigned URL
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, namespace, providerName, filename string, redirect bool) {
+	ctx := r.Context()
 	s.logger.Info("downloading provider", "provider", namespace+"/"+providerName, "file", filename)
 
 	// Parse filename: terraform-provider-{name}_{version}_{os}_{arch}.zip
 	name, version, osName, arch, err := registry.ParseZipFilename(filename)
 	if err != nil {
 		s.logger.Error("failed to parse filename", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+
+	// The path name is alias-resolved; the filename may still carry an old name
+	name = providerName
+	filename = registry.ZipFilename(name, version, osName, arch)
+
+	if err := s.checkVersion(namespace, name, version); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	platform := fmt.Sprintf("%s_%s", osName, arch)
 
+	// Send clients that can reach the object store there instead of proxying the bytes
+	if redirect && s.archiveCache != nil {
+		if location, ok := s.archiveCache.ObjectURL(namespace, name, version, filename, s.cfg.PresignTTL); ok {
+			s.logger.Debug("redirecting to object store", "file", filename)
+			s.metrics.Count(metrics.CacheHits, 1, "cache:object_store")
+			s.setAttribution(w, cacheHit, "")
+			w.Header().Set("Location", location)
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+	}
+
+	etag := s.archiveETag(ctx, namespace, name, version, filename)
+
+	// Serve from archive cache
+	if s.archiveCache != nil {
+		if f, size, ok := s.archiveCache.Open(namespace, name, version, filename); ok {
+			defer f.Close()
+			s.logger.Debug("serving cached archive", "file", filename)
+			s.metrics.Count(metrics.CacheHits, 1, "cache:archive")
+			s.setAttribution(w, cacheHit, "")
+			if !s.notModified(w, r, etag) {
+				serveArchive(w, f, size)
+			}
+			return
+		}
+		s.metrics.Count(metrics.CacheMisses, 1, "cache:archive")
+	}
+
 	// Check if h1 hash exists in cache
 	_, hasHash := s.hashCache.Get(namespace, name, version, platform)
 
-	// Get download URL
-	downloadURL, err := s.registry.DownloadURL(ctx, namespace, name, version, osName, arch)
-	if err != nil {
-		s.logger.Error("failed to get download URL", "error", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return
-	}
+	s.logger.Debug("proxying download", "file", filename, "hasHash", hasHash)
 
-	s.logger.Debug("proxying download", "url", downloadURL, "hasHash", hasHash)
+	// Hash already exists and archives are not cached, scanned or normalized — just stream
+	if hasHash && s.archiveCache == nil && !s.fetcher.Scans() && !s.registry.Normalizes(namespace) {
+		if s.notModified(w, r, etag) {
+			return
+		}
+		resp, err := s.fetcher.Open(ctx, namespace, name, version, osName, arch)
+		if err != nil {
+			s.logger.Error("failed to download", "error", err)
+			writeError(w, err)
+			return
+		}
+		defer resp.Body.Close()
 
-	// Make request to download URL
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
-	if err != nil {
-		s.logger.Error("failed to create request", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.setAttribution(w, cacheMiss, resp.Request.URL.Host)
+		w.Header().Set("Content-Type", "application/zip")
+		if resp.ContentLength > 0 {
+			w.Header().Set("Content-Length", resp.Header.Get("Content-Length"))
+		}
+		_, _ = io.Copy(w, resp.Body)
 		return
 	}
 
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Do(req)
+	// Otherwise spool the archive, calculate h1, cache it and serve from the spool
+	sp, source, err := s.fetcher.FetchFrom(ctx, namespace, name, version, osName, arch)
 	if err != nil {
 		s.logger.Error("failed to download", "error", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeError(w, err)
 		return
 	}
-	defer resp.Body.Close()
+	defer sp.Close()
+	s.setAttribution(w, cacheMiss, source)
 
-	if resp.StatusCode != http.StatusOK {
-		s.logger.Error("download failed", "status", resp.StatusCode)
-		http.Error(w, "download failed", resp.StatusCode)
-		return
+	if !s.notModified(w, r, etag) {
+		serveArchive(w, sp.Reader(), sp.Size())
 	}
+}
 
-	// If no hash — save to temp file, calculate h1, serve from file
-	if !hasHash {
-		s.downloadWithHash(w, resp, namespace, name, version, platform)
-		return
+// serveArchive writes a ZIP archive of known size to the client
+func serveArchive(w http.ResponseWriter, r io.Reader, size int64) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	_, _ = io.Copy(w, r)
+}
+
+// archiveETag returns the entity tag of an archive, its quoted zh hash, or "" when SHA256SUMS is unavailable
+func (s *Server) archiveETag(ctx context.Context, namespace, name, version, filename string) string {
+	if s.cfg.ArchiveMaxAge <= 0 {
+		return ""
 	}
+	zh, ok := s.registry.ZipHash(ctx, namespace, name, version, filename)
+	if !ok {
+		return ""
+	}
+	return `"` + zh + `"`
+}
 
-	// Hash already exists — just stream
-	w.Header().Set("Content-Type", "application/zip")
-	if resp.ContentLength > 0 {
-		w.Header().Set("Content-Length", resp.Header.Get("Content-Length"))
+// notModified sets the caching headers of an archive response and answers 304 Not Modified
+// when the client's copy (If-None-Match) is current
+// Published archives never change, so HTTP caches and CDNs in front of the mirror may keep them
+// for TF_MIRROR_ARCHIVE_MAX_AGE without revalidating; responses to authenticated requests are
+// only cached by the client, so shared caches cannot bypass tenant restrictions
+func (s *Server) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if s.cfg.ArchiveMaxAge <= 0 {
+		return false
+	}
+	scope := "public"
+	if r.Header.Get("Authorization") != "" || tenant.FromContext(r.Context()) != nil {
+		scope = "private"
 	}
-	_, _ = io.Copy(w, resp.Body)
+	// A CDN must not keep serving a signed URL after it expired
+	maxAge := s.cfg.ArchiveMaxAge
+	if lifetime, ok := s.signatureLifetime(r); ok {
+		maxAge = min(maxAge, lifetime)
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d, immutable", scope, int64(maxAge.Seconds())))
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/"); tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
 }
 
-// downloadWithHash downloads ZIP, calculates h1, saves to cache and serves to client
-func (s *Server) downloadWithHash(w http.ResponseWriter, resp *http.Response, namespace, name, version, platform string) {
-	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "provider-*.zip")
+// handleArtifact handles GET *_SHA256SUMS and *_SHA256SUMS.sig — upstream release artifacts
+func (s *Server) handleArtifact(ctx context.Context, w http.ResponseWriter, namespace, providerName, filename string) {
+	s.logger.Info("fetching artifact", "provider", namespace+"/"+providerName, "file", filename)
+
+	name, version, signature, err := registry.ParseArtifactFilename(filename)
 	if err != nil {
-		s.logger.Error("failed to create temp file", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		s.logger.Error("failed to parse filename", "error", err)
+		writeError(w, badRequest(err.Error()))
 		return
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
+	name = providerName
 
-	// Copy data to temporary file
-	written, err := io.Copy(tmpFile, resp.Body)
-	if err != nil {
-		s.logger.Error("failed to write temp file", "error", err)
-		http.Error(w, "download error", http.StatusBadGateway)
+	if err := s.checkVersion(namespace, name, version); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	// Calculate h1 hash
-	h1, err := hash.CalculateH1(tmpFile.Name())
+	data, err := s.registry.Artifact(ctx, namespace, name, version, signature)
 	if err != nil {
-		s.logger.Error("failed to calculate h1", "error", err)
-		// Continue without hash — this is a non-critical error
-	} else {
-		// Save h1 to cache
-		if err := s.hashCache.Set(namespace, name, version, platform, h1); err != nil {
-			s.logger.Error("failed to cache h1", "error", err)
-		} else {
-			s.logger.Info("cached h1 hash", "provider", namespace+"/"+name, "version", version, "platform", platform, "h1", h1)
-		}
-	}
-
-	// Seek back to beginning of file
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		s.logger.Error("failed to seek temp file", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		s.logger.Error("failed to fetch artifact", "error", err)
+		writeError(w, err)
 		return
 	}
 
-	// Serve file to client
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", written))
-	_, _ = io.Copy(w, tmpFile)
+	if signature {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	_, _ = w.Write(data)
 }
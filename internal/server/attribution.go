@@ -0,0 +1,32 @@
+package server
+
+import "net/http"
+
+// Attribution headers tell clients and synthetic monitoring where the bytes of a response
+// came from without reading the mirror's logs:
+//
+//	X-Mirror-Cache: HIT     served from the mirror's caches (archive cache, object store,
+//	                        version cache or a version list snapshot)
+//	X-Mirror-Cache: MISS    requested from a source while serving the response
+//	X-Mirror-Cache: STALE   a version list snapshot served while upstream rate limits the mirror
+//	X-Mirror-Upstream       host of the source of a MISS: the upstream registry, GitHub, an OCI
+//	                        registry, a peer mirror or the cluster member owning the archive
+const (
+	cacheHeader    = "X-Mirror-Cache"
+	upstreamHeader = "X-Mirror-Upstream"
+
+	cacheHit   = "HIT"
+	cacheMiss  = "MISS"
+	cacheStale = "STALE"
+)
+
+// setAttribution sets the attribution headers of a response unless TF_MIRROR_ATTRIBUTION_HEADERS is off
+func (s *Server) setAttribution(w http.ResponseWriter, status, origin string) {
+	if !s.cfg.AttributionHeaders {
+		return
+	}
+	w.Header().Set(cacheHeader, status)
+	if origin != "" {
+		w.Header().Set(upstreamHeader, origin)
+	}
+}
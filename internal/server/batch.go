@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+const (
+	// maxBatchProviders limits the providers of one batch request
+	maxBatchProviders = 500
+
+	// batchConcurrency limits the version lists a batch request fetches at once
+	batchConcurrency = 8
+)
+
+// batchRequest is the body of POST /api/batch/versions
+type batchRequest struct {
+	Providers []string `json:"providers"` // "[hostname/]namespace/type"
+}
+
+// batchVersions is the result for one provider of a batch request
+type batchVersions struct {
+	Provider string   `json:"provider"`
+	Versions []string `json:"versions"`
+
+	// Stale is set when upstream failed and the versions come from the latest stored snapshot
+	Stale bool `json:"stale,omitempty"`
+
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// handleBatchVersions handles POST /api/batch/versions — version lists of many providers in one request
+// Each provider is checked and filtered like its index.json; failures are reported per provider
+func (s *Server) handleBatchVersions(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, badRequest("invalid request body: "+err.Error()))
+		return
+	}
+	if len(req.Providers) == 0 {
+		writeError(w, badRequest("providers is required"))
+		return
+	}
+	if len(req.Providers) > maxBatchProviders {
+		writeError(w, badRequest("at most "+strconv.Itoa(maxBatchProviders)+" providers per request"))
+		return
+	}
+
+	results := make([]batchVersions, len(req.Providers))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(len(req.Providers), batchConcurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				results[j] = s.batchProvider(r, req.Providers[j])
+			}
+		}()
+	}
+	for i := range req.Providers {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	writeJSON(w, map[string]any{"providers": results})
+}
+
+// batchProvider returns the versions of one provider address of a batch request
+func (s *Server) batchProvider(r *http.Request, address string) batchVersions {
+	result := batchVersions{Provider: address}
+
+	list, stale, err := s.batchList(r, address)
+	if err != nil {
+		apiErr := toAPIError(err)
+		result.Error, result.Code = apiErr.message, apiErr.code
+		return result
+	}
+
+	result.Versions = list
+	result.Stale = stale
+	return result
+}
+
+// batchList resolves and checks a provider address like a Mirror Protocol request and returns its sorted versions
+// and whether they come from a stored snapshot
+func (s *Server) batchList(r *http.Request, address string) ([]string, bool, error) {
+	parts := strings.Split(address, "/")
+	if len(parts) == 3 {
+		if _, err := s.checkHostname(parts[0]); err != nil {
+			return nil, false, err
+		}
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return nil, false, badRequest("expected [hostname/]namespace/type")
+	}
+
+	namespace, name, err := s.resolveProvider(r, parts[0], parts[1])
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx := r.Context()
+	stale := false
+	data, err := s.versionsDocument(ctx, namespace, name)
+	if err != nil && s.useLatestSnapshot(ctx, err) {
+		data, err = s.versionsDocument(registry.WithSnapshot(ctx, time.Now()), namespace, name)
+		stale = err == nil
+	}
+	if err != nil {
+		s.logger.Warn("failed to fetch versions for batch", "provider", namespace+"/"+name, "error", err)
+		return nil, false, err
+	}
+
+	var resp providersv1.MirrorVersionsResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, false, err
+	}
+	list := make([]string, 0, len(resp.Versions))
+	for v := range resp.Versions {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return versions.Compare(list[i], list[j]) < 0 })
+	return list, stale, nil
+}
+
+// useLatestSnapshot reports whether a failed upstream version list may be answered from the
+// latest stored snapshot: snapshots are recorded and the request did not select one itself
+func (s *Server) useLatestSnapshot(ctx context.Context, err error) bool {
+	if !s.snapshotsEnabled() || registry.SnapshotSelected(ctx) {
+		return false
+	}
+	var apiErr *apiError
+	return !errors.As(err, &apiErr) && !errors.Is(err, registry.ErrNotFound) && ctx.Err() == nil
+}
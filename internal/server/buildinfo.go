@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/buildinfo"
+	"github.com/scinfra-pro/terraform-mirror/internal/config"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/hooks"
+)
+
+// versionResponse is the body of GET /version
+type versionResponse struct {
+	buildinfo.Info
+	Features    []string `json:"features"`
+	HashSchemes []string `json:"hash_schemes"`
+}
+
+// hashSchemes lists the IDs of the registered hash schemes
+func hashSchemes() []string {
+	ids := []string{}
+	for _, s := range hash.Schemes() {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+// enabledFeatures lists the optional features of this instance, for telling a fleet of mirrors apart
+// Compiled-in hooks are listed as "hook:{name}"
+func enabledFeatures(cfg *config.Config, registered []hooks.Hook) []string {
+	features := []string{}
+	add := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+
+	add("cache", cfg.CacheEnabled)
+	add("object-store", cfg.CacheEnabled && cfg.ObjectStoreURL != "")
+	add("object-store-redirect", cfg.CacheEnabled && len(cfg.ObjectStoreRedirect) > 0)
+	add("tls", cfg.TLSCert != "")
+	add("client-certificates", cfg.TLSClientCA != "")
+	add("h2c", cfg.HTTP2Enabled)
+	add("github", len(cfg.GitHubProviders) > 0)
+	add("oci", len(cfg.OCIProviders) > 0)
+	add("socks5", cfg.SOCKS5Addr != "")
+	add("custom-dns", len(cfg.UpstreamDNS) > 0)
+	add("doh", cfg.UpstreamDoH != "")
+	add("upstream-http2", cfg.UpstreamHTTP2)
+	add("record", cfg.UpstreamRecordDir != "")
+	add("replay", cfg.UpstreamReplayDir != "")
+	add("prewarm", cfg.PrewarmHashes)
+	add("background-hashing", cfg.HashWorkers > 0)
+	add("parallel-hashing", cfg.HashConcurrency != 1)
+	add("prefetch", cfg.PrefetchFile != "")
+	add("docs", cfg.DocsEnabled)
+	add("deny-list", cfg.DenyList != "")
+	add("deprecations", cfg.DeprecationsFile != "")
+	add("client-rules", cfg.ClientRulesFile != "")
+	add("archive-scanning", cfg.ScanCommand != "" || cfg.ScanURL != "")
+	add("archive-normalization", len(cfg.NormalizeArchives) > 0)
+	add("cache-encryption", cfg.CacheEnabled && (cfg.CacheEncryptionKey != "" || cfg.CacheKeyCommand != ""))
+	add("registry-api", cfg.RegistryAPIEnabled)
+	add("replication", cfg.ReplicateEnabled)
+	add("peers", len(cfg.Peers) > 0)
+	add("sharding", len(cfg.ShardNodes) > 0)
+	add("snapshots", cfg.SnapshotsEnabled)
+	add("freeze", cfg.FreezeEnabled)
+	add("warm-restarts", cfg.StateInterval > 0)
+	add("stats", cfg.StatsEnabled)
+	add("metrics", cfg.MetricsExporter != "" && cfg.MetricsExporter != "none")
+	add("tenants", cfg.TenantsFile != "")
+	add("tokens", cfg.TokenSecret != "")
+	add("oidc", cfg.OIDCIssuer != "" && cfg.TenantsFile != "")
+	add("signing", cfg.SigningKey != "")
+	add("origin-mode", cfg.SignedURLSecret != "")
+	add("download-links", cfg.DownloadLinkSecret != "")
+	add("network-acls", len(cfg.AllowCIDRs)+len(cfg.DenyCIDRs)+len(cfg.AdminAllowCIDRs)+len(cfg.AdminDenyCIDRs) > 0)
+	add("attribution-headers", cfg.AttributionHeaders)
+	add("cors", len(cfg.CORSOrigins) > 0)
+	add("log-anonymization", cfg.LogAnonymize != "none")
+
+	for _, h := range registered {
+		features = append(features, "hook:"+h.Name())
+	}
+	return features
+}
+
+// handleBuildInfo handles GET /version
+func (s *Server) handleBuildInfo(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(versionResponse{Info: buildinfo.Get(), Features: s.features, HashSchemes: hashSchemes()})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// checksumResponse — body of the checksum endpoint
+type checksumResponse struct {
+	Filename string   `json:"filename"`
+	SHA256   string   `json:"sha256,omitempty"`
+	Hashes   []string `json:"hashes"`
+}
+
+// handleChecksum handles GET /v1/providers/{hostname}/{namespace}/{name}/{version}/sha256/{os}/{arch}
+// Non-standard: the known hashes (h1, zh and other registered schemes) of one archive, so CI can verify a file it
+// already has; ?format=text returns a sha256sum-compatible line
+func (s *Server) handleChecksum(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.checkHostname(r.PathValue("hostname")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	namespace, name, err := s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, osName, arch := r.PathValue("version"), r.PathValue("os"), r.PathValue("arch")
+
+	if err := s.checkVersion(namespace, name, version); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := registry.ValidatePlatform(osName, arch); err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+
+	filename := registry.ZipFilename(name, version, osName, arch)
+	resp := checksumResponse{Filename: filename, Hashes: []string{}}
+
+	resp.Hashes = append(resp.Hashes, s.hashCache.Hashes(namespace, name, version)[osName+"_"+arch]...)
+
+	// SHA256SUMS is served from the artifact cache once it has been fetched; normalized
+	// archives no longer match it
+	if !s.registry.Normalizes(namespace) {
+		if data, err := s.registry.Artifact(r.Context(), namespace, name, version, false); err == nil {
+			resp.SHA256 = registry.ParseShasums(data)[filename]
+		} else {
+			s.logger.Debug("SHA256SUMS unavailable", "provider", namespace+"/"+name, "version", version, "error", err)
+		}
+	}
+	if resp.SHA256 != "" {
+		resp.Hashes = append(resp.Hashes, "zh:"+resp.SHA256)
+	}
+
+	if len(resp.Hashes) == 0 {
+		writeError(w, notFound("no checksums known for "+filename))
+		return
+	}
+
+	if strings.EqualFold(r.URL.Query().Get("format"), "text") {
+		if resp.SHA256 == "" {
+			writeError(w, notFound("no sha256 known for "+filename))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s  %s\n", resp.SHA256, filename)
+		return
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// cliConfigResponse is the body of GET /api/cli-config
+type cliConfigResponse struct {
+	URL           string   `json:"url"`
+	Include       []string `json:"include"`
+	DirectExclude []string `json:"direct_exclude,omitempty"`
+	Terraformrc   string   `json:"terraformrc"`
+}
+
+// handleCLIConfig handles GET /api/cli-config — the provider_installation block for this mirror
+// Include patterns cover the mirrored hostnames, narrowed to the caller's tenant providers;
+// ?direct=true adds a direct block for everything else, ?format=hcl returns the block alone
+func (s *Server) handleCLIConfig(w http.ResponseWriter, r *http.Request) {
+	resp := cliConfigResponse{
+		URL:     s.baseURL(r) + providersv1.Prefix,
+		Include: s.mirrorPatterns(tenant.FromContext(r.Context())),
+	}
+	if direct, _ := strconv.ParseBool(r.URL.Query().Get("direct")); direct {
+		resp.DirectExclude = resp.Include
+	}
+	resp.Terraformrc = renderProviderInstallation(resp)
+
+	switch format := r.URL.Query().Get("format"); {
+	case strings.EqualFold(format, "hcl"):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(resp.Terraformrc))
+	case format == "" || strings.EqualFold(format, "json"):
+		writeJSON(w, resp)
+	default:
+		writeError(w, badRequest("format must be json or hcl"))
+	}
+}
+
+// mirrorPatterns returns the provider source patterns served to a tenant (t is nil without tenants)
+func (s *Server) mirrorPatterns(t *tenant.Tenant) []string {
+	providers := []string{"*/*"}
+	if t != nil && len(t.Providers) > 0 && !slices.Contains(t.Providers, "*") {
+		providers = nil
+		for _, p := range t.Providers {
+			providers = append(providers, strings.ToLower(p))
+		}
+		// Old addresses of aliased providers resolve to the tenant's providers
+		for old, target := range s.cfg.ProviderAliases {
+			ns, name, _ := strings.Cut(target, "/")
+			if t.Allows(ns, name) {
+				providers = append(providers, strings.ToLower(old))
+			}
+		}
+	}
+
+	var patterns []string
+	for hostname := range s.allowedHosts {
+		for _, p := range providers {
+			patterns = append(patterns, hostname+"/"+p)
+		}
+	}
+	sort.Strings(patterns)
+	return slices.Compact(patterns)
+}
+
+// renderProviderInstallation writes the .terraformrc provider_installation block of a response
+func renderProviderInstallation(resp cliConfigResponse) string {
+	var b strings.Builder
+	b.WriteString("provider_installation {\n")
+	b.WriteString("  network_mirror {\n")
+	b.WriteString("    url     = " + strconv.Quote(resp.URL) + "\n")
+	b.WriteString("    include = " + hclList(resp.Include) + "\n")
+	b.WriteString("  }\n")
+	if len(resp.DirectExclude) > 0 {
+		b.WriteString("  direct {\n")
+		b.WriteString("    exclude = " + hclList(resp.DirectExclude) + "\n")
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func hclList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses CIDR ranges or single addresses (TF_MIRROR_TRUSTED_PROXIES, TF_MIRROR_OBJECT_STORE_REDIRECT)
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// trustedProxy reports whether a request may come through addr with forwarding headers
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	return containsAddr(s.trustedProxies, addr)
+}
+
+// fromTrustedProxy reports whether a request's connection comes from a trusted proxy
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	peer, err := netip.ParseAddr(remoteHost(r))
+	return err == nil && s.trustedProxy(peer)
+}
+
+// containsAddr reports whether any of prefixes contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// withClientIP records the client address for logs, statistics and rate limits
+// Behind a trusted proxy it is taken from X-Forwarded-For (the rightmost address that is not a
+// trusted proxy) or X-Real-IP; forwarding headers from other peers are ignored
+func (s *Server) withClientIP(next http.Handler) http.Handler {
+	if len(s.trustedProxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := s.forwardedClient(r); ip != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey, ip))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClient returns the client address from the forwarding headers of a trusted proxy ("" for none)
+func (s *Server) forwardedClient(r *http.Request) string {
+	if !s.fromTrustedProxy(r) {
+		return ""
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap().String()
+			if !s.trustedProxy(addr) {
+				break
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return ""
+}
+
+// clientIP returns the address of the client that made a request,
+// resolved through trusted proxies
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+// remoteHost returns the host part of the connection's remote address
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
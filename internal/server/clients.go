@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/policy"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// checkClient refuses requests from CLI versions denied by a client rule
+func (s *Server) checkClient(r *http.Request) error {
+	if s.clientRules == nil {
+		return nil
+	}
+	client, ok := policy.ParseUserAgent(r.UserAgent())
+	if !ok {
+		return nil
+	}
+	for _, rule := range s.clientRules.Match(client) {
+		if rule.Deny != "" {
+			s.logger.Warn("client version denied", "cli", client.String(), "client", s.logClient(r), "path", r.URL.Path)
+			return policyDenied(rule.Deny)
+		}
+	}
+	return nil
+}
+
+// omittedHashes returns the hash schemes left out of {version}.json for the requesting CLI
+// and marks the response as varying by User-Agent when any rule removes hashes
+func (s *Server) omittedHashes(w http.ResponseWriter, r *http.Request) []string {
+	if s.clientRules == nil || !s.clientRules.OmitsHashes() {
+		return nil
+	}
+	w.Header().Add("Vary", "User-Agent")
+
+	client, ok := policy.ParseUserAgent(r.UserAgent())
+	if !ok {
+		return nil
+	}
+	var omit []string
+	for _, rule := range s.clientRules.Match(client) {
+		omit = append(omit, rule.OmitHashes...)
+	}
+	return omit
+}
+
+// omitHashes removes hashes of the given schemes from a {version}.json document
+func omitHashes(data []byte, schemes []string) []byte {
+	if len(schemes) == 0 {
+		return data
+	}
+
+	var resp providersv1.MirrorVersionResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return data
+	}
+	for platform, archive := range resp.Archives {
+		kept := archive.Hashes[:0]
+		for _, h := range archive.Hashes {
+			scheme, _, _ := strings.Cut(h, ":")
+			if !slices.Contains(schemes, scheme) {
+				kept = append(kept, h)
+			}
+		}
+		archive.Hashes = kept
+		resp.Archives[platform] = archive
+	}
+
+	filtered, err := json.Marshal(resp)
+	if err != nil {
+		return data
+	}
+	return filtered
+}
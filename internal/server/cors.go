@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers of the mirror that browser code may read
+var corsExposedHeaders = strings.Join([]string{"Retry-After", "Warning", signatureHeader, signatureKeyIDHeader, cacheHeader, upstreamHeader}, ", ")
+
+// withHeaders adds the configured response headers to every response and answers CORS requests
+// from the allowed origins, so browser tools can call the JSON endpoints.
+// Preflight requests are answered here, before authentication, as browsers send them without credentials.
+func (s *Server) withHeaders(next http.Handler) http.Handler {
+	extra := s.cfg.ResponseHeaders
+	if len(extra) == 0 && len(s.cfg.CORSOrigins) == 0 {
+		return next
+	}
+
+	anyOrigin := false
+	origins := make(map[string]bool, len(s.cfg.CORSOrigins))
+	for _, o := range s.cfg.CORSOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	methods := strings.Join(s.cfg.CORSMethods, ", ")
+	headers := strings.Join(s.cfg.CORSHeaders, ", ")
+	maxAge := strconv.Itoa(int(s.cfg.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		for k, v := range extra {
+			h.Set(k, v)
+		}
+
+		origin := r.Header.Get("Origin")
+		if len(origins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !anyOrigin {
+			// Responses differ per origin, so shared caches must keep them apart
+			h.Add("Vary", "Origin")
+			if !origins[strings.ToLower(origin)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
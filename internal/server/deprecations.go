@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/stats"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// deprecatedUsage is a deprecated provider version that is still downloaded
+type deprecatedUsage struct {
+	Provider string `json:"provider"`
+	Version  string `json:"version"`
+	Message  string `json:"message,omitempty"`
+	stats.Usage
+}
+
+// warnDeprecated adds a Warning header to responses for deprecated providers and versions
+// and logs downloads of deprecated archives
+func (s *Server) warnDeprecated(w http.ResponseWriter, r *http.Request, namespace, name, file string) {
+	if s.deprecations == nil {
+		return
+	}
+
+	version := fileVersion(file)
+	var warning string
+	if version == "" {
+		dep, ok := s.deprecations.CheckProvider(namespace, name)
+		if !ok {
+			return
+		}
+		warning = dep.Warning(namespace, name, "")
+	} else {
+		dep, ok := s.deprecations.Check(namespace, name, version)
+		if !ok {
+			return
+		}
+		warning = dep.Warning(namespace, name, version)
+	}
+	w.Header().Add("Warning", "299 - "+strconv.Quote(warning))
+
+	if strings.HasSuffix(file, ".zip") {
+		tenantName := ""
+		if t := tenant.FromContext(r.Context()); t != nil {
+			tenantName = t.Name
+		}
+		s.logger.Warn("deprecated provider version downloaded", "provider", namespace+"/"+name, "version", version,
+			"file", file, "tenant", tenantName, "client", s.logClient(r))
+	}
+}
+
+// fileVersion returns the provider version a mirror file belongs to ("" for index.json)
+func fileVersion(file string) string {
+	switch {
+	case strings.HasSuffix(file, ".zip"):
+		_, version, _, _, err := registry.ParseZipFilename(file)
+		if err != nil {
+			return ""
+		}
+		return version
+	case strings.HasSuffix(file, "_SHA256SUMS"), strings.HasSuffix(file, "_SHA256SUMS.sig"):
+		_, version, _, err := registry.ParseArtifactFilename(file)
+		if err != nil {
+			return ""
+		}
+		return version
+	case file == "index.json", file == "index.json.sig":
+		return ""
+	default:
+		return strings.TrimSuffix(strings.TrimSuffix(file, ".sig"), ".json")
+	}
+}
+
+// deprecatedDownloads lists the deprecated versions in a stats report, with the tenants still using them
+func (s *Server) deprecatedDownloads(report *stats.Report) []deprecatedUsage {
+	result := []deprecatedUsage{}
+	for _, p := range report.Providers {
+		for _, v := range p.Versions {
+			dep, ok := s.deprecations.Check(p.Namespace, p.Name, v.Version)
+			if !ok {
+				continue
+			}
+			result = append(result, deprecatedUsage{
+				Provider: p.Namespace + "/" + p.Name,
+				Version:  v.Version,
+				Message:  dep.Message,
+				Usage:    v.Usage,
+			})
+		}
+	}
+	return result
+}
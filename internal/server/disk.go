@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/disk"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+)
+
+// diskCheckInterval is how often disk usage is sampled for metrics and low-space warnings
+const diskCheckInterval = time.Minute
+
+// volumeUsage is the space of the filesystem holding a directory
+type volumeUsage struct {
+	Dir string `json:"dir"`
+	disk.Space
+	MinFree int64 `json:"min_free_bytes"`
+	Low     bool  `json:"low"`
+}
+
+// cacheUsage is the disk usage of the archive cache
+type cacheUsage struct {
+	volumeUsage
+	Archives     int   `json:"archives"`
+	ArchiveBytes int64 `json:"archive_bytes"`
+}
+
+// spoolUsage is the disk usage of spooled downloads
+type spoolUsage struct {
+	volumeUsage
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// diskUsage is the response of GET /admin/disk
+type diskUsage struct {
+	Cache *cacheUsage `json:"cache,omitempty"` // nil without the archive cache
+	Spool spoolUsage  `json:"spool"`
+}
+
+// volume returns the space of dir's filesystem and whether it is below minFree
+func volume(dir string, minFree int64) volumeUsage {
+	v := volumeUsage{Dir: dir, MinFree: minFree}
+	if space, ok := disk.Stat(dir); ok {
+		v.Space = space
+		v.Low = minFree > 0 && space.Free < minFree
+	}
+	return v
+}
+
+// diskUsage measures the cache and spool directories
+func (s *Server) diskUsage() (diskUsage, error) {
+	var usage diskUsage
+
+	if s.archiveCache != nil {
+		namespaces, err := s.archiveCache.Usage()
+		if err != nil {
+			return usage, err
+		}
+		usage.Cache = &cacheUsage{volumeUsage: volume(s.cfg.CacheDir, s.archiveCache.MinFree())}
+		for _, u := range namespaces {
+			usage.Cache.Archives += u.Archives
+			usage.Cache.ArchiveBytes += u.Bytes
+		}
+	}
+
+	dir := s.cfg.TmpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	files, bytes, err := spool.Usage(dir)
+	if err != nil {
+		return usage, err
+	}
+	usage.Spool = spoolUsage{volumeUsage: volume(dir, s.cfg.TmpMinFree), Files: files, Bytes: bytes}
+	return usage, nil
+}
+
+// runDiskMonitor reports disk usage as gauges every diskCheckInterval
+// and logs when a volume drops below (or recovers above) its minimum free space
+func (s *Server) runDiskMonitor(ctx context.Context) {
+	var cacheLow, spoolLow bool
+	for {
+		usage, err := s.diskUsage()
+		if err != nil {
+			s.logger.Error("failed to measure disk usage", "error", err)
+		} else {
+			if c := usage.Cache; c != nil {
+				s.metrics.Gauge(metrics.CacheBytes, float64(c.ArchiveBytes))
+				if c.Total > 0 {
+					s.metrics.Gauge(metrics.DiskFree, float64(c.Free), "volume:cache")
+				}
+				if c.Low != cacheLow {
+					cacheLow = c.Low
+					if c.Low {
+						s.logger.Warn("cache volume below minimum free space, new archives are served but not cached", "dir", c.Dir, "free", c.Free, "min_free", c.MinFree)
+					} else {
+						s.logger.Info("cache volume free space recovered, caching archives again", "dir", c.Dir, "free", c.Free)
+					}
+				}
+			}
+
+			sp := usage.Spool
+			s.metrics.Gauge(metrics.SpoolBytes, float64(sp.Bytes))
+			if sp.Total > 0 {
+				s.metrics.Gauge(metrics.DiskFree, float64(sp.Free), "volume:spool")
+			}
+			if sp.Low != spoolLow {
+				spoolLow = sp.Low
+				if sp.Low {
+					s.logger.Warn("spool directory below minimum free space, downloads that do not fit are refused", "dir", sp.Dir, "free", sp.Free, "min_free", sp.MinFree)
+				} else {
+					s.logger.Info("spool directory free space recovered", "dir", sp.Dir, "free", sp.Free)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(diskCheckInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleAdminDisk handles GET /admin/disk — cache and spool disk usage and free space
+func (s *Server) handleAdminDisk(w http.ResponseWriter, _ *http.Request) {
+	usage, err := s.diskUsage()
+	if err != nil {
+		s.logger.Error("failed to measure disk usage", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	writeJSON(w, usage)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+var docsIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Namespace}}/{{.Name}} {{.Version}}</title></head>
+<body>
+<h1>{{.Namespace}}/{{.Name}} {{.Version}}</h1>
+{{range .Categories}}<h2>{{.Name}}</h2>
+<ul>
+{{range .Docs}}<li><a href="{{$.Version}}/{{.ID}}">{{.Title}}</a>{{if .Subcategory}} <small>({{.Subcategory}})</small>{{end}}</li>
+{{end}}</ul>
+{{else}}<p>No documentation published for this version.</p>
+{{end}}</body>
+</html>
+`))
+
+var docsPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Doc.Title}} - {{.Namespace}}/{{.Name}} {{.Version}}</title></head>
+<body>
+<p><a href="../{{.Version}}">{{.Namespace}}/{{.Name}} {{.Version}}</a> / {{.Doc.Category}}</p>
+<h1>{{.Doc.Title}}</h1>
+<pre style="white-space: pre-wrap">{{.Doc.Content}}</pre>
+</body>
+</html>
+`))
+
+type docsCategory struct {
+	Name string
+	Docs []registry.ProviderDoc
+}
+
+// handleDocsIndex handles GET /docs/{namespace}/{name}/{version} — list of doc pages
+func (s *Server) handleDocsIndex(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, err := s.docsProvider(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	docs, err := s.docs.Index(r.Context(), namespace, name, version)
+	if err != nil {
+		s.logger.Error("failed to fetch docs index", "provider", namespace+"/"+name, "version", version, "error", err)
+		writeError(w, err)
+		return
+	}
+
+	// Keep the registry's category order
+	var categories []docsCategory
+	for _, doc := range docs {
+		if len(categories) == 0 || categories[len(categories)-1].Name != doc.Category {
+			categories = append(categories, docsCategory{Name: doc.Category})
+		}
+		last := &categories[len(categories)-1]
+		last.Docs = append(last.Docs, doc)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = docsIndexTemplate.Execute(w, map[string]any{
+		"Namespace":  namespace,
+		"Name":       name,
+		"Version":    version,
+		"Categories": categories,
+	})
+}
+
+// handleDocsPage handles GET /docs/{namespace}/{name}/{version}/{id} — a single doc page
+func (s *Server) handleDocsPage(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, err := s.docsProvider(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id := r.PathValue("id")
+	if !isDigits(id) {
+		writeError(w, badRequest("invalid doc id"))
+		return
+	}
+
+	doc, err := s.docs.Doc(r.Context(), id)
+	if err != nil {
+		s.logger.Error("failed to fetch doc", "id", id, "error", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = docsPageTemplate.Execute(w, map[string]any{
+		"Namespace": namespace,
+		"Name":      name,
+		"Version":   version,
+		"Doc":       doc,
+	})
+}
+
+// docsProvider reads the provider and version of a docs page from the path, resolved and
+// checked like those of the mirror protocol, as the version becomes part of a cache path
+func (s *Server) docsProvider(r *http.Request) (namespace, name, version string, err error) {
+	namespace, name, err = s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		return "", "", "", err
+	}
+	version = r.PathValue("version")
+	if err := registry.ValidateVersion(version); err != nil {
+		return "", "", "", badRequest(err.Error())
+	}
+	return namespace, name, version, nil
+}
+
+// handleProviderDoc handles GET /v2/provider-docs/{id} — registry docs API passthrough
+func (s *Server) handleProviderDoc(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !isDigits(id) {
+		writeError(w, badRequest("invalid doc id"))
+		return
+	}
+
+	data, err := s.docs.Raw(r.Context(), id)
+	if err != nil {
+		s.logger.Error("failed to fetch doc", "id", id, "error", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// Download links (TF_MIRROR_DOWNLOAD_LINK_SECRET)
+//
+// Build systems with credentials mint time-limited URLs of specific archives and hand them to
+// runners that have none, e.g. air-gapped ones. A link carries an HMAC signature of one archive
+// with an expiry, in the query format of origin mode; archive requests with a valid link are
+// served without tenant credentials or an origin mode signature until it expires.
+
+// maxDownloadLinks limits the archives of one request
+const maxDownloadLinks = 100
+
+// downloadLinksRequest — body of POST /api/download-links
+type downloadLinksRequest struct {
+	Hostname string   `json:"hostname"` // "" for the first mirrored hostname
+	Archives []string `json:"archives"` // {namespace}/{type}/{version}/{os}_{arch}
+	TTL      string   `json:"ttl"`      // at most TF_MIRROR_DOWNLOAD_LINK_TTL, which is the default
+}
+
+// downloadLink — a minted link in the POST /api/download-links response
+type downloadLink struct {
+	Archive string `json:"archive"`
+	URL     string `json:"url"`
+}
+
+// downloadLinksResponse — the POST /api/download-links response
+type downloadLinksResponse struct {
+	Links     []downloadLink `json:"links"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// handleDownloadLinks handles POST /api/download-links — signed URLs of archives for runners without credentials
+// The caller's tenant must be allowed the providers; withdrawn and denied versions get no link
+func (s *Server) handleDownloadLinks(w http.ResponseWriter, r *http.Request) {
+	var req downloadLinksRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, badRequest("invalid JSON body"))
+		return
+	}
+	if len(req.Archives) == 0 || len(req.Archives) > maxDownloadLinks {
+		writeError(w, badRequest(fmt.Sprintf("expected 1 to %d archives", maxDownloadLinks)))
+		return
+	}
+
+	ttl := s.cfg.DownloadLinkTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > s.cfg.DownloadLinkTTL {
+			writeError(w, badRequest("ttl must be a positive duration of at most "+s.cfg.DownloadLinkTTL.String()))
+			return
+		}
+		ttl = d
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+
+	if req.Hostname == "" {
+		req.Hostname = s.cfg.AllowedHostnames[0]
+	}
+	hostname, err := s.checkHostname(req.Hostname)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	base := s.archiveBaseURL()
+	if base == "" {
+		base = s.baseURL(r)
+	}
+	resp := downloadLinksResponse{Links: []downloadLink{}, ExpiresAt: expires.UTC()}
+	for _, archive := range req.Archives {
+		namespace, name, filename, err := s.linkedArchive(r, archive)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resp.Links = append(resp.Links, downloadLink{
+			Archive: archive,
+			URL:     base + providersv1.MirrorPath(hostname, namespace, name, filename) + "?" + s.linkSigner.Sign(signedPath(namespace, name, filename), expires),
+		})
+	}
+
+	attrs := []any{"archives", len(resp.Links), "expires_at", resp.ExpiresAt, "client", s.logClient(r)}
+	if t := tenant.FromContext(r.Context()); t != nil {
+		attrs = append(attrs, "tenant", t.Name)
+	}
+	s.logger.Info("download links issued", attrs...)
+	writeJSON(w, resp)
+}
+
+// linkedArchive checks an archive of a download links request, {namespace}/{type}/{version}/{os}_{arch},
+// and returns its resolved provider and filename
+func (s *Server) linkedArchive(r *http.Request, archive string) (namespace, name, filename string, err error) {
+	parts := strings.Split(archive, "/")
+	if len(parts) != 4 {
+		return "", "", "", badRequest(fmt.Sprintf("archive %q: expected {namespace}/{type}/{version}/{os}_{arch}", archive))
+	}
+	osName, arch, ok := strings.Cut(parts[3], "_")
+	if !ok {
+		return "", "", "", badRequest(fmt.Sprintf("archive %q: expected {namespace}/{type}/{version}/{os}_{arch}", archive))
+	}
+	if err := registry.ValidatePlatform(osName, arch); err != nil {
+		return "", "", "", badRequest(err.Error())
+	}
+
+	namespace, name, err = s.resolveProvider(r, parts[0], parts[1])
+	if err != nil {
+		return "", "", "", err
+	}
+	if err := s.checkVersion(namespace, name, parts[2]); err != nil {
+		return "", "", "", err
+	}
+	return namespace, name, registry.ZipFilename(name, parts[2], osName, arch), nil
+}
+
+// validDownloadLink reports whether an archive request carries an unexpired download link for it
+func (s *Server) validDownloadLink(r *http.Request, namespace, name, filename string) bool {
+	return s.linkSigner != nil && s.linkSigner.Verify(signedPath(namespace, name, filename), r.URL.Query()) == nil
+}
+
+// isDownloadLink reports whether a request is for an archive under a valid download link,
+// judged from the path as requested (links are minted for resolved provider names)
+func (s *Server) isDownloadLink(r *http.Request) bool {
+	if s.linkSigner == nil || !r.URL.Query().Has("signature") {
+		return false
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, providersv1.Prefix)
+	parts := strings.Split(rest, "/")
+	if !ok || len(parts) != 4 || !strings.HasSuffix(parts[3], ".zip") {
+		return false
+	}
+	return s.validDownloadLink(r, parts[1], parts[2], parts[3])
+}
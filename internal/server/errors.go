@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/fetcher"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// Error codes returned in the "code" field of error responses
+const (
+	codeBadRequest   = "bad_request"
+	codeUnauthorized = "unauthorized"
+	codeNotFound     = "not_found"
+	codeGone         = "gone"
+	codePolicyDenied = "policy_denied"
+	codeUpstream     = "upstream_error"
+	codeInternal     = "internal_error"
+	codeStorage      = "insufficient_storage"
+	codeOverloaded   = "overloaded"
+	codeConflict     = "conflict"
+)
+
+// saturatedRetryAfter is the Retry-After sent when the download queue is full
+const saturatedRetryAfter = 10 * time.Second
+
+// apiError is an error that is safe to show to clients
+type apiError struct {
+	status  int
+	code    string
+	message string
+
+	// Sent as Retry-After when set
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// errorResponse — JSON body of error responses
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func badRequest(message string) *apiError {
+	return &apiError{status: http.StatusBadRequest, code: codeBadRequest, message: message}
+}
+
+func unauthorized() *apiError {
+	return &apiError{status: http.StatusUnauthorized, code: codeUnauthorized, message: "authentication required"}
+}
+
+func notFound(message string) *apiError {
+	return &apiError{status: http.StatusNotFound, code: codeNotFound, message: message}
+}
+
+func gone(message string) *apiError {
+	return &apiError{status: http.StatusGone, code: codeGone, message: message}
+}
+
+func policyDenied(message string) *apiError {
+	return &apiError{status: http.StatusForbidden, code: codePolicyDenied, message: message}
+}
+
+func conflict(message string) *apiError {
+	return &apiError{status: http.StatusConflict, code: codeConflict, message: message}
+}
+
+func upstreamError() *apiError {
+	return &apiError{status: http.StatusBadGateway, code: codeUpstream, message: "upstream registry request failed"}
+}
+
+func internalError() *apiError {
+	return &apiError{status: http.StatusInternalServerError, code: codeInternal, message: "internal error"}
+}
+
+// toAPIError maps an error to a client-safe apiError
+// Anything not explicitly classified is reported as an upstream failure
+// without details, so internal URLs never reach the client
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	if errors.Is(err, registry.ErrNotFound) {
+		return notFound(err.Error())
+	}
+
+	if errors.Is(err, upstream.ErrHostNotAllowed) {
+		return policyDenied("upstream download host is not allowed")
+	}
+
+	if errors.Is(err, spool.ErrInsufficientSpace) {
+		return &apiError{status: http.StatusInsufficientStorage, code: codeStorage, message: "mirror is out of temporary disk space"}
+	}
+
+	if errors.Is(err, fetcher.ErrHashFailed) || errors.Is(err, fetcher.ErrShasumMismatch) {
+		return &apiError{status: http.StatusBadGateway, code: codeUpstream, message: "archive from upstream failed hash verification"}
+	}
+
+	if errors.Is(err, registry.ErrMalformedArchive) {
+		return &apiError{status: http.StatusBadGateway, code: codeUpstream, message: "archive from upstream is malformed, Terraform could not install it"}
+	}
+
+	if errors.Is(err, fetcher.ErrQuarantined) {
+		return policyDenied("archive was rejected by the archive scanner and is quarantined")
+	}
+
+	if errors.Is(err, fetcher.ErrFrozen) {
+		return policyDenied("archive is not cached and the mirror is frozen")
+	}
+
+	if errors.Is(err, fetcher.ErrSaturated) {
+		return &apiError{status: http.StatusServiceUnavailable, code: codeOverloaded, message: "too many downloads in progress, retry later", retryAfter: saturatedRetryAfter}
+	}
+
+	// Details of schema drift stay in logs and metrics (see registry/drift.go)
+	var schemaErr *providersv1.SchemaError
+	if errors.As(err, &schemaErr) {
+		return &apiError{status: http.StatusBadGateway, code: codeUpstream, message: "upstream returned an invalid response"}
+	}
+
+	var rateErr *upstream.RateLimitError
+	if errors.As(err, &rateErr) {
+		return &apiError{status: http.StatusServiceUnavailable, code: codeUpstream, message: "upstream registry rate limit reached, retry later", retryAfter: max(rateErr.RetryAfter.Round(time.Second), time.Second)}
+	}
+
+	if errors.Is(err, upstream.ErrCircuitOpen) {
+		return &apiError{status: http.StatusServiceUnavailable, code: codeUpstream, message: "upstream registry temporarily unavailable"}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &apiError{status: http.StatusGatewayTimeout, code: codeUpstream, message: "upstream registry request timed out"}
+	}
+
+	return upstreamError()
+}
+
+// writeError renders err as a JSON error response
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if apiErr.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(apiErr.retryAfter.Seconds())))
+	}
+	w.WriteHeader(apiErr.status)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Error: apiErr.message,
+		Code:  apiErr.code,
+	})
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// freezeStateKey is where a freeze set through the admin API is kept in the state bucket
+const freezeStateKey = "server.freeze"
+
+// freezeStatus describes whether the mirror is frozen, and by whom
+type freezeStatus struct {
+	Frozen bool       `json:"frozen"`
+	Since  *time.Time `json:"since,omitempty"`
+	Actor  string     `json:"actor,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+
+	// Frozen by TF_MIRROR_FREEZE; cannot be lifted through the admin API
+	Config bool `json:"config,omitempty"`
+}
+
+// freezeRequest — body of PUT /admin/freeze
+type freezeRequest struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+}
+
+// mirrorFreeze holds the freeze switch
+// A frozen mirror serves what is already cached and never introduces versions or archives from upstream
+type mirrorFreeze struct {
+	mu     sync.RWMutex
+	status freezeStatus
+}
+
+// frozen reports whether the mirror is frozen
+func (s *Server) frozen() bool {
+	s.freeze.mu.RLock()
+	defer s.freeze.mu.RUnlock()
+	return s.freeze.status.Frozen
+}
+
+// restoreFreeze applies TF_MIRROR_FREEZE or a freeze saved through the admin API
+func (s *Server) restoreFreeze() {
+	var status freezeStatus
+	if s.cfg.FreezeEnabled {
+		now := time.Now().UTC()
+		status = freezeStatus{Frozen: true, Since: &now, Actor: "config", Config: true}
+	} else {
+		saved, err := cache.NewStateStore(s.cfg.CacheDir).Load()
+		if err != nil {
+			s.logger.Warn("failed to read freeze state", "error", err)
+		}
+		if data, ok := saved[freezeStateKey]; ok {
+			if err := json.Unmarshal(data, &status); err != nil {
+				s.logger.Warn("failed to parse freeze state", "error", err)
+			}
+		}
+	}
+
+	s.setFreeze(status)
+	if status.Frozen {
+		s.logger.Warn("mirror is frozen, only cached versions are served", "since", status.Since, "actor", s.logIdentity(status.Actor), "reason", status.Reason)
+	}
+}
+
+// setFreeze switches the mirror and the fetcher
+func (s *Server) setFreeze(status freezeStatus) {
+	s.freeze.mu.Lock()
+	s.freeze.status = status
+	s.freeze.mu.Unlock()
+	s.fetcher.SetFrozen(status.Frozen)
+}
+
+// saveFreeze persists a freeze set through the admin API, so it survives restarts
+func (s *Server) saveFreeze(status freezeStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return cache.NewStateStore(s.cfg.CacheDir).Save(map[string][]byte{freezeStateKey: data})
+}
+
+// versionCached reports whether a frozen mirror can serve a provider version:
+// some archive of it is cached, or (without the archive cache) some hash is known
+func (s *Server) versionCached(namespace, name, version string) bool {
+	if s.archiveCache != nil {
+		return s.archiveCache.HasVersion(namespace, name, version)
+	}
+	return len(s.hashCache.GetAll(namespace, name, version)) > 0
+}
+
+// platformCached is versionCached for a single platform
+func (s *Server) platformCached(namespace, name, version, platform string) bool {
+	if s.archiveCache != nil {
+		osName, arch, _ := strings.Cut(platform, "_")
+		return s.archiveCache.Has(namespace, name, version, registry.ZipFilename(name, version, osName, arch))
+	}
+	_, ok := s.hashCache.Get(namespace, name, version, platform)
+	return ok
+}
+
+// filterFrozenPlatforms removes platforms that are not cached from a {version}.json response
+func (s *Server) filterFrozenPlatforms(namespace, name, version string, data []byte) []byte {
+	var resp providersv1.MirrorVersionResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return data
+	}
+
+	removed := false
+	for platform := range resp.Archives {
+		if !s.platformCached(namespace, name, version, platform) {
+			delete(resp.Archives, platform)
+			removed = true
+		}
+	}
+	if !removed {
+		return data
+	}
+
+	filtered, err := json.Marshal(resp)
+	if err != nil {
+		return data
+	}
+	return filtered
+}
+
+// handleAdminFreeze handles GET /admin/freeze
+func (s *Server) handleAdminFreeze(w http.ResponseWriter, _ *http.Request) {
+	s.freeze.mu.RLock()
+	status := s.freeze.status
+	s.freeze.mu.RUnlock()
+	writeJSON(w, status)
+}
+
+// handleFreeze handles PUT /admin/freeze; body {"reason": "...", "actor": "..."}
+func (s *Server) handleFreeze(w http.ResponseWriter, r *http.Request) {
+	var req freezeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeError(w, badRequest("invalid JSON body"))
+			return
+		}
+	}
+	if req.Reason == "" {
+		writeError(w, badRequest("reason is required"))
+		return
+	}
+	if s.frozen() {
+		s.handleAdminFreeze(w, r)
+		return
+	}
+
+	now := time.Now().UTC()
+	status := freezeStatus{Frozen: true, Since: &now, Actor: adminActor(r, req.Actor), Reason: req.Reason}
+	if err := s.saveFreeze(status); err != nil {
+		s.logger.Error("failed to save freeze state", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	s.setFreeze(status)
+
+	s.logger.Warn("mirror frozen", "actor", status.Actor, "client", s.logClient(r), "reason", status.Reason)
+	writeJSON(w, status)
+}
+
+// handleUnfreeze handles DELETE /admin/freeze
+func (s *Server) handleUnfreeze(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.FreezeEnabled {
+		writeError(w, badRequest("mirror is frozen by TF_MIRROR_FREEZE"))
+		return
+	}
+	if !s.frozen() {
+		writeError(w, notFound("mirror is not frozen"))
+		return
+	}
+
+	if err := s.saveFreeze(freezeStatus{}); err != nil {
+		s.logger.Error("failed to save freeze state", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	s.setFreeze(freezeStatus{})
+
+	s.logger.Info("mirror unfrozen", "actor", s.logIdentity(adminActor(r, "")), "client", s.logClient(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hooks"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// hookError maps an error returned by a hook to a client-safe apiError
+func (s *Server) hookError(err error) *apiError {
+	var hookErr *hooks.Error
+	if !errors.As(err, &hookErr) {
+		s.logger.Error("hook failed", "error", err)
+		return internalError()
+	}
+
+	// A refusal always refuses; a status WriteHeader would reject or a client read as success is 403
+	if hookErr.Status < 400 || hookErr.Status > 599 {
+		s.logger.Warn("hook refused with an invalid status, sending 403", "status", hookErr.Status)
+		return policyDenied(hookErr.Message)
+	}
+
+	code := codeBadRequest
+	switch {
+	case hookErr.Status == http.StatusUnauthorized:
+		code = codeUnauthorized
+	case hookErr.Status == http.StatusForbidden:
+		code = codePolicyDenied
+	case hookErr.Status == http.StatusNotFound:
+		code = codeNotFound
+	case hookErr.Status == http.StatusGone:
+		code = codeGone
+	case hookErr.Status >= 500:
+		code = codeInternal
+	}
+	return &apiError{status: hookErr.Status, code: code, message: hookErr.Message}
+}
+
+// authorizeDownload runs download authorization hooks for an archive request
+// Unparseable filenames are left to handleDownload to reject
+func (s *Server) authorizeDownload(r *http.Request, namespace, name, filename string) error {
+	_, version, osName, arch, err := registry.ParseZipFilename(filename)
+	if err != nil {
+		return nil
+	}
+
+	err = s.hooks.AuthorizeDownload(r.Context(), r, hooks.Download{
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		OS:        osName,
+		Arch:      arch,
+		Filename:  filename,
+	})
+	if err != nil {
+		s.logger.Warn("download refused by hook", "provider", namespace+"/"+name, "file", filename, "reason", err)
+		return s.hookError(err)
+	}
+	return nil
+}
+
+// hookWriter lets response hooks adjust the status and headers before they are sent
+type hookWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	chain       *hooks.Chain
+	wroteHeader bool
+}
+
+func (w *hookWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	status = w.chain.MutateResponse(w.r, status, w.Header())
+	if status < 200 || status > 599 {
+		// Not a final status; WriteHeader would panic on some and send others as informational
+		status = http.StatusInternalServerError
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps io.Copy of archives on the underlying writer's sendfile path
+func (w *hookWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *hookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withHooks applies response hooks to every response
+func (s *Server) withHooks(next http.Handler) http.Handler {
+	if !s.hooks.MutatesResponses() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&hookWriter{ResponseWriter: w, r: r, chain: s.hooks}, r)
+	})
+}
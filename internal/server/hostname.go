@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizeHostname converts the {hostname} path segment to its canonical form
+// Unicode names are converted to punycode, letters are lowercased and
+// the default HTTPS port is dropped, so "Registry.Terraform.IO:443" and
+// "registry.terraform.io" are the same host
+func normalizeHostname(hostname string) (string, error) {
+	host, port := hostname, ""
+	if h, p, err := net.SplitHostPort(hostname); err == nil {
+		host, port = h, p
+	}
+
+	if port == "443" {
+		port = ""
+	}
+	if port != "" && !isDigits(port) {
+		return "", fmt.Errorf("invalid hostname port %q", port)
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil || ascii == "" {
+		return "", fmt.Errorf("invalid hostname %q", hostname)
+	}
+	ascii = strings.ToLower(ascii)
+
+	if port != "" {
+		return ascii + ":" + port, nil
+	}
+	return ascii, nil
+}
+
+// checkHostname normalizes a {hostname} path segment and refuses hosts that are not mirrored
+func (s *Server) checkHostname(raw string) (string, error) {
+	hostname, err := normalizeHostname(raw)
+	if err != nil {
+		return "", badRequest(err.Error())
+	}
+	if _, ok := s.allowedHosts[hostname]; !ok {
+		s.logger.Warn("hostname not allowed", "hostname", hostname)
+		return "", policyDenied("hostname " + hostname + " is not mirrored")
+	}
+	return hostname, nil
+}
+
+// hostnameSet builds a lookup set of normalized hostnames
+// Invalid entries are returned as an error so misconfiguration is visible at startup
+func hostnameSet(hostnames []string) (map[string]struct{}, error) {
+	set := make(map[string]struct{}, len(hostnames))
+	for _, h := range hostnames {
+		normalized, err := normalizeHostname(h)
+		if err != nil {
+			return nil, err
+		}
+		set[normalized] = struct{}{}
+	}
+	return set, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
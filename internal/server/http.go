@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+)
+
+// publicHandler wraps the mirror routes in the middleware chain
+func (s *Server) publicHandler() http.Handler {
+	return s.withClientIP(s.withPathPrefix(s.withHeaders(s.withNetworkACL(s.withMetrics(s.withHooks(s.withClientIdentity(s.withTenant(s.withSnapshot(s.withTimeouts(s.mux))))))))))
+}
+
+// adminHandler wraps the routes of the separate admin listener
+// Response hooks and the base path only apply to the mirror routes
+func (s *Server) adminHandler() http.Handler {
+	return s.withClientIP(s.withHeaders(s.withNetworkACL(s.withMetrics(s.withClientIdentity(s.withTimeouts(s.adminMux))))))
+}
+
+// httpServer builds an HTTP server with keep-alive, header and HTTP/2 settings
+func (s *Server) httpServer(handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{
+		ReadTimeout:       s.cfg.ReadTimeout,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+		MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+		ConnState:         s.trackConn,
+		TLSConfig:         tlsConfig,
+	}
+
+	// HTTP/2 is always negotiated over TLS; cleartext h2c is opt-in
+	if tlsConfig != nil || s.cfg.HTTP2Enabled {
+		h2s := &http2.Server{
+			MaxConcurrentStreams: uint32(s.cfg.HTTP2MaxStreams),
+			IdleTimeout:          s.cfg.IdleTimeout,
+		}
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			return nil, err
+		}
+		if s.cfg.HTTP2Enabled {
+			// Accept HTTP/2 without TLS (prior knowledge or Upgrade: h2c), e.g. behind a TLS-terminating proxy
+			handler = h2c.NewHandler(handler, h2s)
+		}
+	}
+
+	srv.Handler = handler
+	return srv, nil
+}
+
+// listen opens a listener per address, limiting concurrent connections when configured
+// and terminating TLS when a certificate is configured
+// Sockets inherited from systemd are used instead of the addresses when there are any
+func (s *Server) listen(addrs []string, inherited []net.Listener, tlsConfig *tls.Config) ([]net.Listener, error) {
+	raw := inherited
+	if len(raw) == 0 {
+		for _, addr := range addrs {
+			ln, err := net.Listen(listenNetwork(addr), addr)
+			if err != nil {
+				closeListeners(raw)
+				return nil, err
+			}
+			raw = append(raw, ln)
+		}
+	}
+
+	var listeners []net.Listener
+	for _, ln := range raw {
+		if s.cfg.MaxConnections > 0 {
+			ln = netutil.LimitListener(ln, s.cfg.MaxConnections)
+		}
+		if s.cfg.TLSCert != "" {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// listenNetwork returns the network for a listen address
+// IP literals get a single-family socket, so "[::]:8080" and "0.0.0.0:8080" can be combined;
+// a host name or an empty host (":8080") listens on both families
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, ln := range listeners {
+		ln.Close()
+	}
+}
+
+// trackConn records connection metrics
+func (s *Server) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.metrics.Count(metrics.ConnsOpened, 1)
+		s.metrics.Gauge(metrics.ConnsActive, float64(atomic.AddInt64(&s.activeConns, 1)))
+	case http.StateClosed, http.StateHijacked:
+		s.metrics.Gauge(metrics.ConnsActive, float64(atomic.AddInt64(&s.activeConns, -1)))
+	}
+}
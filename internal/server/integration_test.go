@@ -0,0 +1,1813 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/config"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/hooks"
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/jobs"
+	"github.com/scinfra-pro/terraform-mirror/internal/scan"
+	"github.com/scinfra-pro/terraform-mirror/internal/testutil"
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// mirrorBase is the Mirror Protocol path of the provider used by the integration tests
+const mirrorBase = "/v1/providers/registry.terraform.io/hashicorp/random/"
+
+// newTestMirror starts a mirror in front of a fake registry with its cache in cacheDir
+// env holds additional TF_MIRROR_* settings as "KEY=value"
+func newTestMirror(t *testing.T, upstream *testutil.Registry, cacheDir string, env ...string) *httptest.Server {
+	t.Helper()
+
+	s := New(loadTestConfig(t, upstream, cacheDir, env...), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	mirror := httptest.NewServer(s.publicHandler())
+	t.Cleanup(func() { cache.CloseMetadata(cacheDir) })
+	t.Cleanup(mirror.Close)
+	return mirror
+}
+
+// loadTestConfig returns the configuration of a test mirror (see newTestMirror)
+func loadTestConfig(t *testing.T, upstream *testutil.Registry, cacheDir string, env ...string) *config.Config {
+	t.Helper()
+
+	settings := []string{
+		"TF_MIRROR_UPSTREAM_URL=" + upstream.URL,
+		"TF_MIRROR_ALLOWED_HOSTNAMES=registry.terraform.io",
+		"TF_MIRROR_DOWNLOAD_ALLOWED_HOSTS=127.0.0.1",
+		"TF_MIRROR_CACHE_DIR=" + cacheDir,
+		"TF_MIRROR_CACHE_MIN_FREE=0",
+		"TF_MIRROR_TMP_DIR=" + t.TempDir(),
+		"TF_MIRROR_TMP_MIN_FREE=0",
+		"TF_MIRROR_HASH_WORKERS=0",
+		"TF_MIRROR_STATS_ENABLED=false",
+		"TF_MIRROR_ADMIN_TOKEN=" + testAdminToken,
+	}
+	for _, kv := range append(settings, env...) {
+		key, value, _ := strings.Cut(kv, "=")
+		t.Setenv(key, value)
+	}
+
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test configuration: %v", err)
+	}
+	return cfg
+}
+
+// testAdminToken is the admin token of test mirrors, sent by the helpers outside the mirror protocol
+const testAdminToken = "test-admin-token"
+
+// do sends a request to the mirror and returns the status and response body
+func do(t *testing.T, mirror *httptest.Server, method, path string, body io.Reader) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, mirror.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isProtocolPath(path) {
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// get requests a path from the mirror and returns the status and body
+func get(t *testing.T, mirror *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+	return do(t, mirror, http.MethodGet, path, nil)
+}
+
+// mustGet is get for requests that must succeed
+func mustGet(t *testing.T, mirror *httptest.Server, path string) []byte {
+	t.Helper()
+
+	status, body := get(t, mirror, path)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, status, body)
+	}
+	return body
+}
+
+// logBuffer collects the log output of concurrent requests
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// post sends a body to the mirror and returns the status and response body
+func post(t *testing.T, mirror *httptest.Server, path, body string) (int, []byte) {
+	t.Helper()
+	return do(t, mirror, http.MethodPost, path, strings.NewReader(body))
+}
+
+func newTestRegistry(t *testing.T) *testutil.Registry {
+	upstream := testutil.NewRegistry(t)
+	upstream.AddVersion("hashicorp", "random", "3.5.1", "linux_amd64")
+	upstream.AddVersion("hashicorp", "random", "3.6.0", "darwin_arm64", "linux_amd64")
+	return upstream
+}
+
+func TestMirrorProtocol(t *testing.T) {
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true")
+
+	testutil.Golden(t, "index", mustGet(t, mirror, mirrorBase+"index.json"))
+
+	// Before any download only the zh hashes from SHA256SUMS are known
+	testutil.Golden(t, "version_zh", mustGet(t, mirror, mirrorBase+"3.6.0.json"))
+
+	archive := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	want := testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")
+	if got := mustGet(t, mirror, mirrorBase+archive); !bytes.Equal(got, want) {
+		t.Fatalf("archive differs from upstream: got %d bytes, want %d", len(got), len(want))
+	}
+
+	// The download added the h1 hash of that platform
+	testutil.Golden(t, "version_h1", mustGet(t, mirror, mirrorBase+"3.6.0.json"))
+
+	// Repeated downloads are served from the archive cache
+	if got := mustGet(t, mirror, mirrorBase+archive); !bytes.Equal(got, want) {
+		t.Fatal("cached archive differs from upstream")
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("archive downloaded from upstream %d times, want 1", n)
+	}
+
+	testutil.Golden(t, "shasums", mustGet(t, mirror, mirrorBase+"terraform-provider-random_3.6.0_SHA256SUMS"))
+}
+
+func TestProtocolVersions(t *testing.T) {
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_REGISTRY_API=true")
+
+	// Each served revision is advertised with its own prefix
+	var services map[string]any
+	if err := json.Unmarshal(mustGet(t, mirror, "/.well-known/terraform.json"), &services); err != nil {
+		t.Fatal(err)
+	}
+	if got := services["providers.v1"]; got != mirror.URL+"/v1/providers/" {
+		t.Errorf("providers.v1 = %v", got)
+	}
+
+	// Registry and mirror protocol routes of v1 share the prefix
+	var versions struct {
+		Versions []struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+	if err := json.Unmarshal(mustGet(t, mirror, "/v1/providers/hashicorp/random/versions"), &versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) == 0 {
+		t.Error("registry protocol lists no versions")
+	}
+	mustGet(t, mirror, mirrorBase+"index.json")
+
+	if status, body := get(t, mirror, "/v1/providers/registry.terraform.io/hashicorp"); status != http.StatusBadRequest || !strings.Contains(string(body), "/v1/providers/{hostname}") {
+		t.Errorf("unrouted v1 path: status %d: %s", status, body)
+	}
+}
+
+func TestHashesSurviveRestart(t *testing.T) {
+	upstream := newTestRegistry(t)
+	cacheDir := t.TempDir()
+
+	mirror := newTestMirror(t, upstream, cacheDir)
+	mustGet(t, mirror, mirrorBase+testutil.ArchiveFilename("random", "3.6.0", "linux_amd64"))
+	mirror.Close()
+	if err := cache.CloseMetadata(cacheDir); err != nil {
+		t.Fatalf("closing metadata database: %v", err)
+	}
+
+	// A new mirror on the same cache serves the h1 hash without downloading again
+	mirror = newTestMirror(t, upstream, cacheDir)
+	testutil.Golden(t, "version_h1", mustGet(t, mirror, mirrorBase+"3.6.0.json"))
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("archive downloaded from upstream %d times, want 1", n)
+	}
+}
+
+func TestStatsSharedCache(t *testing.T) {
+	upstream := newTestRegistry(t)
+	cacheDir := t.TempDir()
+
+	first := newTestMirror(t, upstream, cacheDir, "TF_MIRROR_STATS_ENABLED=true")
+	if body := mustGet(t, first, "/admin/stats"); strings.Contains(string(body), `"enabled":false`) {
+		t.Fatalf("first mirror: stats disabled: %s", body)
+	}
+
+	// stats.db is locked by the first mirror; a replica on the same cache starts without stats
+	second := newTestMirror(t, upstream, cacheDir, "TF_MIRROR_STATS_ENABLED=true")
+	if body := mustGet(t, second, "/admin/stats"); !strings.Contains(string(body), `"enabled":false`) {
+		t.Errorf("second mirror: got %s, want stats disabled", body)
+	}
+}
+
+func TestObjectStoreTier(t *testing.T) {
+	upstream := newTestRegistry(t)
+	store := testutil.NewObjectStore(t)
+	archive := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	key := "hashicorp/random/3.6.0/" + archive
+	want := testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")
+
+	// The first replica downloads the archive and writes it through to the object store
+	first := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_OBJECT_STORE_URL="+store.URL)
+	mustGet(t, first, mirrorBase+archive)
+	if data, ok := store.Object(key); !ok || !bytes.Equal(data, want) {
+		t.Fatalf("archive not uploaded to the object store")
+	}
+
+	// A second replica with an empty local cache promotes it instead of downloading upstream
+	secondDir := t.TempDir()
+	second := newTestMirror(t, upstream, secondDir, "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_OBJECT_STORE_URL="+store.URL)
+	for range 2 {
+		if got := mustGet(t, second, mirrorBase+archive); !bytes.Equal(got, want) {
+			t.Fatal("promoted archive differs from upstream")
+		}
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("archive downloaded from upstream %d times, want 1", n)
+	}
+	if n := store.Gets(key); n != 1 {
+		t.Errorf("archive read from the object store %d times, want 1", n)
+	}
+	if _, err := os.Stat(filepath.Join(secondDir, "archives", key)); err != nil {
+		t.Errorf("archive not promoted to local disk: %v", err)
+	}
+}
+
+func TestObjectStoreRedirect(t *testing.T) {
+	upstream := newTestRegistry(t)
+	store := testutil.NewObjectStore(t)
+	mirror := newTestMirror(t, upstream, t.TempDir(),
+		"TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_OBJECT_STORE_URL="+store.URL, "TF_MIRROR_OBJECT_STORE_REDIRECT=127.0.0.0/8")
+	archive := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// The first download goes through the mirror, which stores the archive in the bucket
+	resp, err := client.Get(mirror.URL + mirrorBase + archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first download: status %d, want 200", resp.StatusCode)
+	}
+
+	// Later downloads are sent to the bucket
+	resp, err = client.Get(mirror.URL + mirrorBase + archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := store.URL + "/hashicorp/random/3.6.0/" + archive; resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != want {
+		t.Fatalf("second download: status %d, Location %q, want 302 to %s", resp.StatusCode, resp.Header.Get("Location"), want)
+	}
+	if got := mustGet(t, mirror, mirrorBase+archive); !bytes.Equal(got, testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")) {
+		t.Fatal("archive from the object store differs from upstream")
+	}
+}
+
+func TestSharding(t *testing.T) {
+	upstream := newTestRegistry(t)
+
+	// Replicas need each other's URLs before they are configured
+	var handlers [2]http.Handler
+	var nodes []string
+	for i := range handlers {
+		replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(replica.Close)
+		nodes = append(nodes, replica.URL)
+	}
+	for i, node := range nodes {
+		mirror := newTestMirror(t, upstream, t.TempDir(),
+			"TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_SHARD_NODES="+strings.Join(nodes, ","), "TF_MIRROR_SHARD_SELF="+node, "TF_MIRROR_PEER_SECRET=secret")
+		handlers[i] = mirror.Config.Handler
+	}
+
+	// Whichever replica is asked first, the archive is downloaded from upstream once, by its owner
+	archive := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	want := testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")
+	for _, node := range nodes {
+		resp, err := http.Get(node + mirrorBase + archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(got, want) {
+			t.Fatalf("GET %s: status %d, %d bytes", node, resp.StatusCode, len(got))
+		}
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("archive downloaded from upstream %d times, want 1", n)
+	}
+
+	// An unsigned shard header does not make a replica act as the owner
+	archive = testutil.ArchiveFilename("random", "3.6.0", "darwin_arm64")
+	for _, node := range nodes {
+		req, _ := http.NewRequest(http.MethodGet, node+mirrorBase+archive, nil)
+		req.Header.Set("X-Tf-Mirror-Shard", "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s with an unsigned shard header: status %d", node, resp.StatusCode)
+		}
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "darwin_arm64")); n != 1 {
+		t.Errorf("archive downloaded from upstream %d times, want 1", n)
+	}
+}
+
+func TestPeerRequests(t *testing.T) {
+	origin := newTestRegistry(t)
+	mirror := newTestMirror(t, origin, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_PEER_SECRET=secret")
+	archive := mirrorBase + testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+
+	peerGet := func(value string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, mirror.URL+archive, nil)
+		req.Header.Set(upstream.PeerHeader, value)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	signed := token.NewURLSigner("secret", 0).Sign(upstream.PeerSignedPath(upstream.PeerHeader, archive), time.Now().Add(time.Minute))
+
+	// A signed peer request is answered from the cache only
+	if status := peerGet(signed); status != http.StatusNotFound {
+		t.Fatalf("signed peer request for an uncached archive: status %d, want 404", status)
+	}
+
+	// A header without a valid signature is a client download
+	if status := peerGet("1"); status != http.StatusOK {
+		t.Fatalf("unsigned peer request: status %d, want 200", status)
+	}
+	if n := origin.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("archive downloaded from upstream %d times, want 1", n)
+	}
+	if status := peerGet(signed); status != http.StatusOK {
+		t.Fatalf("signed peer request for a cached archive: status %d, want 200", status)
+	}
+}
+
+func TestVersionCache(t *testing.T) {
+	const versionsPath = "/v1/providers/hashicorp/random/versions"
+
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir())
+
+	// Repeated requests are served from the rendered document
+	mustGet(t, mirror, mirrorBase+"3.6.0.json")
+	requests := upstream.Requests(versionsPath)
+	testutil.Golden(t, "version_zh", mustGet(t, mirror, mirrorBase+"3.6.0.json"))
+	if n := upstream.Requests(versionsPath); n != requests {
+		t.Errorf("versions requested %d times, want %d", n, requests)
+	}
+
+	// Storing an h1 hash drops the document
+	mustGet(t, mirror, mirrorBase+testutil.ArchiveFilename("random", "3.6.0", "linux_amd64"))
+	testutil.Golden(t, "version_h1", mustGet(t, mirror, mirrorBase+"3.6.0.json"))
+}
+
+func TestNewReleaseRefresh(t *testing.T) {
+	const versionsPath = "/v1/providers/hashicorp/random/versions"
+
+	upstream := newTestRegistry(t)
+	upstream.CacheVersions()
+	mirror := newTestMirror(t, upstream, t.TempDir())
+	mustGet(t, mirror, mirrorBase+"index.json")
+
+	// A release published after the versions list was cached upstream is found by revalidating it
+	upstream.AddVersion("hashicorp", "random", "3.7.0", "linux_amd64")
+	if status, body := get(t, mirror, mirrorBase+"3.7.0.json"); status != http.StatusOK {
+		t.Fatalf("new release: status %d, want %d: %s", status, http.StatusOK, body)
+	}
+
+	// The list was just refreshed, so misses within a minute do not refresh it again
+	requests := upstream.Requests(versionsPath)
+	for i := 0; i < 3; i++ {
+		if status, body := get(t, mirror, mirrorBase+"9.9.9.json"); status != http.StatusNotFound {
+			t.Fatalf("missing version: status %d, want %d: %s", status, http.StatusNotFound, body)
+		}
+	}
+	if n := upstream.Requests(versionsPath) - requests; n != 3 {
+		t.Errorf("versions requested %d times for 3 misses, want 3", n)
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir())
+	const latest = "/api/providers/registry.terraform.io/hashicorp/random/latest"
+
+	testutil.Golden(t, "latest", mustGet(t, mirror, latest))
+
+	var resp latestResponse
+	if err := json.Unmarshal(mustGet(t, mirror, latest+"?constraints=~%3E+3.5.0"), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "3.5.1" || len(resp.Platforms) != 1 {
+		t.Errorf("~> 3.5.0: got %s %v, want 3.5.1 [linux_amd64]", resp.Version, resp.Platforms)
+	}
+
+	if status, body := get(t, mirror, latest+"?constraints=%3E%3D+4.0"); status != http.StatusNotFound {
+		t.Errorf(">= 4.0: status %d, want %d: %s", status, http.StatusNotFound, body)
+	}
+	if status, body := get(t, mirror, latest+"?constraints=latest"); status != http.StatusBadRequest {
+		t.Errorf("invalid constraints: status %d, want %d: %s", status, http.StatusBadRequest, body)
+	}
+}
+
+// runJob starts an admin job and waits until it has succeeded
+func runJob(t *testing.T, mirror *httptest.Server, kind string) jobs.Job {
+	t.Helper()
+	status, body := post(t, mirror, "/admin/jobs/"+kind, "")
+	var job jobs.Job
+	if err := json.Unmarshal(body, &job); err != nil || status != http.StatusAccepted {
+		t.Fatalf("POST /admin/jobs/%s: status %d, %v", kind, status, err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); !job.Finished(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still running", job.ID)
+		}
+		if err := json.Unmarshal(mustGet(t, mirror, "/admin/jobs/"+job.ID), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.State != jobs.StateSucceeded {
+		t.Fatalf("job %s %s: %s", kind, job.State, job.Error)
+	}
+	return job
+}
+
+func TestAdminJobs(t *testing.T) {
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true")
+	mustGet(t, mirror, mirrorBase+testutil.ArchiveFilename("random", "3.6.0", "linux_amd64"))
+
+	verify := runJob(t, mirror, "verify")
+	if string(verify.Result) != `{"checked":1,"unhashed":0}` || verify.Progress.Done != 1 || verify.Progress.Total != 1 {
+		t.Errorf("verify: result %s, progress %+v", verify.Result, verify.Progress)
+	}
+
+	export := runJob(t, mirror, "export?format=csv")
+	if body := mustGet(t, mirror, "/admin/jobs/"+export.ID+"/output"); !bytes.Contains(body, []byte("hashicorp,random,3.6.0,linux_amd64")) {
+		t.Errorf("export output: %s", body)
+	}
+
+	var list struct {
+		Jobs []jobs.Job `json:"jobs"`
+	}
+	if err := json.Unmarshal(mustGet(t, mirror, "/admin/jobs"), &list); err != nil || len(list.Jobs) != 2 || list.Jobs[0].ID != export.ID {
+		t.Errorf("job list: %+v, %v", list.Jobs, err)
+	}
+
+	if status, _ := do(t, mirror, http.MethodDelete, "/admin/jobs/"+verify.ID, nil); status != http.StatusConflict {
+		t.Errorf("canceling a finished job: status %d, want %d", status, http.StatusConflict)
+	}
+	if status, _ := get(t, mirror, "/admin/jobs/nope"); status != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestPrefetchManifests(t *testing.T) {
+	upstream := newTestRegistry(t)
+	upstream.AddVersion("acme", "tool", "1.0.0", "linux_amd64")
+	upstream.AddVersion("acme", "tool", "1.1.0", "linux_amd64")
+	dir := t.TempDir()
+
+	manifests := map[string]string{
+		// terraform-bundle, with the 0.12 and 0.13 provider syntax
+		"terraform-bundle.hcl": `
+terraform {
+  version = "1.5.7"
+}
+
+providers {
+  random = ["~> 3.5.0"]
+  tool = {
+    versions = ["1.0.0", "~> 1.0"]
+    source   = "registry.terraform.io/acme/tool"
+  }
+}
+`,
+		// required_providers, e.g. the versions.tf generated by Terragrunt
+		"versions.tf": `
+terraform {
+  required_version = ">= 1.5"
+  required_providers {
+    random = "~> 3.5.0"
+    tool = {
+      source  = "acme/tool"
+      version = ">= 1.0, < 1.1"
+    }
+    other = { source = "acme/tool" }
+  }
+}
+`,
+	}
+	for file, manifest := range manifests {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_PREFETCH_FILE="+path)
+
+		// random 3.5.1, tool 1.0.0 and tool 1.1.0
+		job := runJob(t, mirror, "prefetch")
+		var summary struct {
+			Providers int `json:"providers"`
+			Archives  int `json:"archives"`
+			Fetched   int `json:"fetched"`
+		}
+		if err := json.Unmarshal(job.Result, &summary); err != nil || summary.Archives != 3 || summary.Fetched != 3 {
+			t.Errorf("%s: result %s, %v", file, job.Result, err)
+		}
+	}
+}
+
+func TestPrefetchSkipsInvalidEntries(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "providers.txt")
+	err := os.WriteFile(list, []byte("acme/missing\nhashicorp/random >= 9.0\nhashicorp/random 3.5.1\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_PREFETCH_FILE="+list)
+
+	job := runJob(t, mirror, "prefetch")
+	var summary struct {
+		Fetched int `json:"fetched"`
+		Invalid int `json:"invalid"`
+	}
+	if err := json.Unmarshal(job.Result, &summary); err != nil || summary.Fetched != 1 || summary.Invalid != 2 {
+		t.Errorf("result %s, %v; want 1 fetched and 2 invalid", job.Result, err)
+	}
+}
+
+func TestMirrorErrors(t *testing.T) {
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir())
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/v1/providers/registry.terraform.io/hashicorp", http.StatusBadRequest, codeBadRequest},
+		{mirrorBase + "index.json/extra", http.StatusBadRequest, codeBadRequest},
+		{mirrorBase + "README.md", http.StatusBadRequest, codeBadRequest},
+		{"/v1/providers/example.com/hashicorp/random/index.json", http.StatusForbidden, codePolicyDenied},
+		{"/v1/providers/registry.terraform.io/hashicorp/ran%20dom/index.json", http.StatusBadRequest, codeBadRequest},
+		{"/v1/providers/registry.terraform.io/hashicorp/missing/index.json", http.StatusNotFound, codeNotFound},
+		{mirrorBase + "9.9.9.json", http.StatusNotFound, codeNotFound},
+		{mirrorBase + "terraform-provider-random_3.6.0_windows_amd64.zip", http.StatusNotFound, codeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			status, body := get(t, mirror, tt.path)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d: %s", status, tt.status, body)
+			}
+			var resp struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatalf("error response is not JSON: %s", body)
+			}
+			if resp.Code != tt.code {
+				t.Errorf("code %q, want %q", resp.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestUpstreamUnavailable(t *testing.T) {
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir())
+
+	upstream.SetFailing(true)
+	status, body := get(t, mirror, mirrorBase+"index.json")
+	if status != http.StatusBadGateway {
+		t.Fatalf("status %d, want %d: %s", status, http.StatusBadGateway, body)
+	}
+}
+
+func TestUpstreamResolver(t *testing.T) {
+	upstream := newTestRegistry(t)
+	dns := testutil.NewDNS(t, map[string]string{"registry.test": "127.0.0.1"})
+	upstreamURL := strings.Replace(upstream.URL, "127.0.0.1", "registry.test", 1)
+
+	for _, setting := range []string{"TF_MIRROR_UPSTREAM_DNS=" + dns.Addr, "TF_MIRROR_UPSTREAM_DOH=" + dns.DoHURL} {
+		t.Run(strings.SplitN(setting, "=", 2)[0], func(t *testing.T) {
+			queries := dns.Queries("registry.test")
+			mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_UPSTREAM_URL="+upstreamURL, setting)
+			testutil.Golden(t, "index", mustGet(t, mirror, mirrorBase+"index.json"))
+			if dns.Queries("registry.test") == queries {
+				t.Error("upstream host was not resolved by the configured resolver")
+			}
+		})
+	}
+}
+
+func TestUpstreamConnectionLimit(t *testing.T) {
+	upstream := newTestRegistry(t)
+	names := []string{"random", "null", "local", "tls", "time", "http", "external", "archive"}
+	for _, name := range names[1:] {
+		upstream.AddVersion("hashicorp", name, "1.0.0", "linux_amd64")
+	}
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_UPSTREAM_MAX_CONNS_PER_HOST=1", "TF_MIRROR_UPSTREAM_MAX_IDLE_CONNS_PER_HOST=1")
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, body := get(t, mirror, "/v1/providers/registry.terraform.io/hashicorp/"+name+"/index.json"); status != http.StatusOK {
+				t.Errorf("%s: status %d: %s", name, status, body)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := upstream.Connections(); n != 1 {
+		t.Errorf("%d upstream connections, want 1", n)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	upstream := newTestRegistry(t)
+	recording := t.TempDir()
+	archive := mirrorBase + testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_UPSTREAM_RECORD="+recording)
+	mustGet(t, mirror, mirrorBase+"index.json")
+	mustGet(t, mirror, mirrorBase+"3.6.0.json")
+	mustGet(t, mirror, archive)
+	mirror.Close()
+
+	// With upstream gone, a mirror with an empty cache answers from the recording
+	upstream.SetFailing(true)
+	mirror = newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_UPSTREAM_RECORD=", "TF_MIRROR_UPSTREAM_REPLAY="+recording)
+	testutil.Golden(t, "index", mustGet(t, mirror, mirrorBase+"index.json"))
+	testutil.Golden(t, "version_zh", mustGet(t, mirror, mirrorBase+"3.6.0.json"))
+	if got, want := mustGet(t, mirror, archive), testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64"); !bytes.Equal(got, want) {
+		t.Fatal("replayed archive differs from upstream")
+	}
+
+	// Requests that were never recorded fail like an unreachable upstream
+	if status, body := get(t, mirror, "/v1/providers/registry.terraform.io/hashicorp/null/index.json"); status != http.StatusBadGateway {
+		t.Fatalf("unrecorded request: status %d, want %d: %s", status, http.StatusBadGateway, body)
+	}
+}
+
+func TestOCIProvider(t *testing.T) {
+	oci := testutil.NewOCIRegistry(t)
+	oci.AddVersion("providers/acme/oci", "acme", "oci", "1.0.0", "darwin_arm64", "linux_amd64")
+	oci.AddVersion("providers/acme/oci", "acme", "oci", "1.1.0", "linux_amd64")
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(),
+		"TF_MIRROR_OCI_PROVIDERS=acme/oci="+oci.Repository("providers/acme/oci"))
+	base := "/v1/providers/registry.terraform.io/acme/oci/"
+
+	testutil.Golden(t, "oci_index", mustGet(t, mirror, base+"index.json"))
+
+	// zh hashes come from the archive layer digests
+	testutil.Golden(t, "oci_version", mustGet(t, mirror, base+"1.0.0.json"))
+	testutil.Golden(t, "oci_shasums", mustGet(t, mirror, base+"terraform-provider-oci_1.0.0_SHA256SUMS"))
+
+	archive := testutil.ArchiveFilename("oci", "1.0.0", "linux_amd64")
+	if got, want := mustGet(t, mirror, base+archive), testutil.Archive("acme", "oci", "1.0.0", "linux_amd64"); !bytes.Equal(got, want) {
+		t.Fatal("archive differs from the OCI blob")
+	}
+
+	if status, body := get(t, mirror, base+"terraform-provider-oci_1.0.0_SHA256SUMS.sig"); status != http.StatusNotFound {
+		t.Errorf("SHA256SUMS.sig: status %d, want %d: %s", status, http.StatusNotFound, body)
+	}
+}
+
+func TestUpstreamRateLimited(t *testing.T) {
+	const versionsPath = "/v1/providers/hashicorp/random/versions"
+
+	t.Run("retry after", func(t *testing.T) {
+		upstream := newTestRegistry(t)
+		mirror := newTestMirror(t, upstream, t.TempDir())
+
+		// A short Retry-After is waited out instead of failing the request
+		upstream.SetRateLimited(1, "1")
+		testutil.Golden(t, "index", mustGet(t, mirror, mirrorBase+"index.json"))
+		if n := upstream.Requests(versionsPath); n != 2 {
+			t.Errorf("versions requested %d times, want 2", n)
+		}
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		upstream := newTestRegistry(t)
+		mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_UPSTREAM_RATE_WAIT=5s", "TF_MIRROR_SNAPSHOTS=false")
+
+		upstream.SetRateLimited(1, "60")
+		resp, err := http.Get(mirror.URL + mirrorBase + "index.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "60" {
+			t.Fatalf("status %d, Retry-After %q, want %d and 60", resp.StatusCode, resp.Header.Get("Retry-After"), http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("stale snapshot", func(t *testing.T) {
+		upstream := newTestRegistry(t)
+		mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_UPSTREAM_RATE_WAIT=5s")
+		mustGet(t, mirror, mirrorBase+"index.json")
+
+		// The version list recorded before is served while upstream rate limits
+		upstream.SetRateLimited(1, "60")
+		resp, err := http.Get(mirror.URL + mirrorBase + "index.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Warning") == "" {
+			t.Fatalf("status %d, Warning %q, want %d with a Warning", resp.StatusCode, resp.Header.Get("Warning"), http.StatusOK)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		testutil.Golden(t, "index", body)
+	})
+}
+
+func TestUpstreamSchemaDrift(t *testing.T) {
+	t.Run("unknown fields", func(t *testing.T) {
+		upstream := newTestRegistry(t)
+		mirror := newTestMirror(t, upstream, t.TempDir())
+
+		// Fields added to the registry API are tolerated
+		upstream.SetVersionsFields(map[string]any{"deprecation": map[string]any{"reason": "renamed"}})
+		testutil.Golden(t, "index", mustGet(t, mirror, mirrorBase+"index.json"))
+	})
+
+	t.Run("changed shape", func(t *testing.T) {
+		upstream := newTestRegistry(t)
+		mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_SNAPSHOTS=false")
+
+		// A changed shape fails without its details, which are only logged
+		upstream.SetVersionsFields(map[string]any{"versions": []any{map[string]any{"version": "3.6.0", "platforms": "linux_amd64"}}})
+		status, body := get(t, mirror, mirrorBase+"index.json")
+		if status != http.StatusBadGateway || !strings.Contains(string(body), "upstream returned an invalid response") || strings.Contains(string(body), "platforms") {
+			t.Fatalf("status %d, body %s, want %d with a generic message", status, body, http.StatusBadGateway)
+		}
+	})
+}
+
+func TestCLIConfig(t *testing.T) {
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	err := os.WriteFile(tenants, []byte(`{"tenants": [
+		{"name": "ci", "tokens": ["ci-token"]},
+		{"name": "team", "tokens": ["team-token"], "providers": ["hashicorp/random", "acme/*"]}
+	]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TENANTS_FILE="+tenants,
+		"TF_MIRROR_EXTERNAL_URL=https://mirror.example.com", "TF_MIRROR_PROVIDER_ALIASES=hashicorp/rand=hashicorp/random")
+
+	config := func(token, query string) []byte {
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+"/api/cli-config"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /api/cli-config%s as %q: status %d: %s", query, token, resp.StatusCode, body)
+		}
+		return body
+	}
+
+	testutil.Golden(t, "cli_config", config("ci-token", ""))
+	testutil.Golden(t, "cli_config_tenant", config("team-token", "?format=hcl&direct=true"))
+}
+
+func TestOIDCAuthentication(t *testing.T) {
+	idp := testutil.NewIdentityProvider(t)
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	err := os.WriteFile(tenants, []byte(`{"tenants": [
+		{"name": "infra", "subjects": ["repo:acme/infra:*"], "providers": ["hashicorp/random"]}
+	]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TENANTS_FILE="+tenants,
+		"TF_MIRROR_OIDC_ISSUER="+idp.URL, "TF_MIRROR_OIDC_AUDIENCE=https://mirror.example.com")
+
+	request := func(path, token string) int {
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	const audience = "https://mirror.example.com"
+	valid := idp.Token(t, "repo:acme/infra:ref:refs/heads/main", audience, time.Hour, nil)
+	for _, tc := range []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"matching subject", mirrorBase + "index.json", valid, http.StatusOK},
+		{"provider outside tenant policy", "/v1/providers/registry.terraform.io/acme/tool/index.json", valid, http.StatusForbidden},
+		{"other subject", mirrorBase + "index.json", idp.Token(t, "repo:acme/web:ref:refs/heads/main", audience, time.Hour, nil), http.StatusUnauthorized},
+		{"other audience", mirrorBase + "index.json", idp.Token(t, "repo:acme/infra:ref:refs/heads/main", "sts.amazonaws.com", time.Hour, nil), http.StatusUnauthorized},
+		{"other issuer", mirrorBase + "index.json", idp.Token(t, "repo:acme/infra:ref:refs/heads/main", audience, time.Hour, map[string]any{"iss": "https://evil.example.com"}), http.StatusUnauthorized},
+		{"expired", mirrorBase + "index.json", idp.Token(t, "repo:acme/infra:ref:refs/heads/main", audience, -time.Hour, nil), http.StatusUnauthorized},
+		{"tampered", mirrorBase + "index.json", valid[:len(valid)-4] + "AAAA", http.StatusUnauthorized},
+	} {
+		if status := request(tc.path, tc.token); status != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.want)
+		}
+	}
+	if n := idp.JWKSRequests(); n != 1 {
+		t.Errorf("signing keys fetched %d times, want 1 (cached)", n)
+	}
+}
+
+func TestNetworkACL(t *testing.T) {
+	// Clients are simulated through X-Forwarded-For from the test client as a trusted proxy
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TRUSTED_PROXIES=127.0.0.1",
+		"TF_MIRROR_ALLOW_CIDRS=10.0.0.0/8", "TF_MIRROR_DENY_CIDRS=10.66.0.0/16",
+		"TF_MIRROR_ADMIN_ALLOW_CIDRS=10.1.0.0/16", "TF_MIRROR_ADMIN_TOKEN=admin-token")
+
+	for _, tc := range []struct {
+		client string
+		path   string
+		want   int
+	}{
+		{"10.2.3.4", mirrorBase + "index.json", http.StatusOK},
+		{"10.66.1.1", mirrorBase + "index.json", http.StatusForbidden},
+		{"192.0.2.1", mirrorBase + "index.json", http.StatusForbidden},
+		{"192.0.2.1", "/health", http.StatusOK},
+		{"10.2.3.4", "/admin/freeze", http.StatusForbidden},
+		{"10.1.2.3", "/admin/freeze", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", tc.client)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("GET %s from %s: status %d, want %d", tc.path, tc.client, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestLockReport(t *testing.T) {
+	// Lock files name each provider once, so aliases give the test several addresses of one provider
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(),
+		"TF_MIRROR_PROVIDER_ALIASES=hashicorp/rand=hashicorp/random,hashicorp/random-next=hashicorp/random")
+	zh := func(version, platform string) string {
+		return "zh:" + testutil.Shasum("hashicorp", "random", version, platform)
+	}
+
+	lock := `# This file is maintained automatically by "terraform init".
+provider "registry.terraform.io/hashicorp/random" {
+  version     = "3.6.0"
+  constraints = "~> 3.6"
+  hashes = [
+    "` + zh("3.6.0", "linux_amd64") + `",
+  ]
+}
+
+provider "registry.terraform.io/hashicorp/rand" {
+  version = "3.5.1"
+  hashes  = ["zh:` + strings.Repeat("0", 64) + `"]
+}
+
+provider "registry.terraform.io/hashicorp/random-next" {
+  version = "9.9.9"
+}
+
+provider "registry.opentofu.org/hashicorp/random" {
+  version = "3.6.0"
+}
+`
+	if status, body := post(t, mirror, "/api/lock-reports", lock+lock); status != http.StatusBadRequest {
+		t.Errorf("duplicate provider blocks: status %d, want %d: %s", status, http.StatusBadRequest, body)
+	}
+
+	status, body := post(t, mirror, "/api/lock-reports?name=infra/prod", lock)
+	if status != http.StatusCreated {
+		t.Fatalf("status %d, want %d: %s", status, http.StatusCreated, body)
+	}
+	var report lockReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ status, code string }{
+		{lockOK, ""},
+		{lockMismatch, ""},
+		{lockUnavailable, codeNotFound},
+		{lockUnavailable, codePolicyDenied},
+	}
+	if len(report.Providers) != len(want) {
+		t.Fatalf("got %d providers, want %d", len(report.Providers), len(want))
+	}
+	for i, w := range want {
+		if p := report.Providers[i]; p.Status != w.status || p.Code != w.code {
+			t.Errorf("%s %s: status %s (%s), want %s (%s)", p.Provider, p.Version, p.Status, p.Code, w.status, w.code)
+		}
+	}
+	if p := report.Providers[0]; len(p.Missing) != 1 || p.Missing[0] != zh("3.6.0", "darwin_arm64") {
+		t.Errorf("missing hashes of 3.6.0: got %v, want the darwin_arm64 zh hash", p.Missing)
+	}
+	if !report.Drift || report.Name != "infra/prod" {
+		t.Errorf("got drift %v, name %q; want true, infra/prod", report.Drift, report.Name)
+	}
+
+	var list struct{ Reports []lockReport }
+	if err := json.Unmarshal(mustGet(t, mirror, "/admin/lock-reports"), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Reports) != 1 || list.Reports[0].ID != report.ID {
+		t.Errorf("listed reports %+v, want %s", list.Reports, report.ID)
+	}
+}
+
+func TestArchiveScan(t *testing.T) {
+	// The scanner rejects linux_amd64 archives and logs every scan
+	dir := t.TempDir()
+	scans := filepath.Join(dir, "scans.log")
+	scanner := filepath.Join(dir, "scanner.sh")
+	script := `#!/bin/sh
+echo "$TF_MIRROR_SCAN_PLATFORM" >> ` + scans + `
+if [ "$TF_MIRROR_SCAN_PLATFORM" = linux_amd64 ]; then
+	echo '{"findings": ["EICAR-Test-Signature"]}'
+	echo "EICAR-Test-Signature found" >&2
+	exit 1
+fi
+echo '{"bomFormat": "CycloneDX"}'
+`
+	if err := os.WriteFile(scanner, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	scanned := func() string {
+		data, _ := os.ReadFile(scans)
+		return strings.Join(strings.Fields(string(data)), ",")
+	}
+
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_SCAN_COMMAND="+scanner)
+	clean := testutil.ArchiveFilename("random", "3.6.0", "darwin_arm64")
+	infected := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+
+	mustGet(t, mirror, mirrorBase+clean)
+	mustGet(t, mirror, mirrorBase+clean)
+	for i := 0; i < 2; i++ {
+		if status, body := get(t, mirror, mirrorBase+infected); status != http.StatusForbidden || !bytes.Contains(body, []byte("quarantined")) {
+			t.Fatalf("quarantined archive: status %d: %s", status, body)
+		}
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("quarantined archive downloaded %d times, want 1", n)
+	}
+	if got := scanned(); got != "darwin_arm64,linux_amd64" {
+		t.Errorf("scanned %s", got)
+	}
+	if body := mustGet(t, mirror, mirrorBase+"3.6.0.json"); bytes.Count(body, []byte("h1:")) != 1 {
+		t.Errorf("quarantined archive was hashed: %s", body)
+	}
+
+	var list struct {
+		Scans []scan.Result `json:"scans"`
+	}
+	if err := json.Unmarshal(mustGet(t, mirror, "/admin/scans?status=quarantined"), &list); err != nil || len(list.Scans) != 1 ||
+		list.Scans[0].Platform != "linux_amd64" || list.Scans[0].Reason != "scanner exited with status 1: EICAR-Test-Signature found" {
+		t.Fatalf("quarantined archives: %+v, %v", list.Scans, err)
+	}
+	report := "/admin/scans/hashicorp/random/3.6.0/linux_amd64/report"
+	if body := mustGet(t, mirror, report); !bytes.Contains(body, []byte("EICAR-Test-Signature")) {
+		t.Errorf("scan report: %s", body)
+	}
+	if body := mustGet(t, mirror, "/admin/inventory?format=cyclonedx"); !bytes.Contains(body, []byte(`"value": "passed"`)) {
+		t.Errorf("inventory lacks the scan status: %s", body)
+	}
+
+	release := "/admin/scans/hashicorp/random/3.6.0/linux_amd64/release"
+	if status, body := post(t, mirror, release, `{}`); status != http.StatusBadRequest {
+		t.Errorf("release without a reason: status %d: %s", status, body)
+	}
+	if status, body := post(t, mirror, release, `{"reason": "false positive, see SEC-42"}`); status != http.StatusOK {
+		t.Fatalf("release: status %d: %s", status, body)
+	}
+	mustGet(t, mirror, mirrorBase+infected)
+	mustGet(t, mirror, mirrorBase+infected)
+	if got := scanned(); got != "darwin_arm64,linux_amd64" {
+		t.Errorf("released archive scanned again: %s", got)
+	}
+	if status, _ := post(t, mirror, release, `{"reason": "again"}`); status != http.StatusNotFound {
+		t.Errorf("releasing twice: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestArchiveChecks(t *testing.T) {
+	upstream := newTestRegistry(t)
+	upstream.AddVersion("acme", "tool", "1.0.0", "linux_amd64", "windows_amd64")
+	upstream.PackOnWindows("acme", "tool", "1.0.0")
+	const base = "/v1/providers/registry.terraform.io/acme/tool/"
+
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true")
+	if status, body := get(t, mirror, base+testutil.ArchiveFilename("tool", "1.0.0", "linux_amd64")); status != http.StatusBadGateway {
+		t.Errorf("archive without executable bit: status %d: %s", status, body)
+	}
+	// Windows has no executable bit to lose
+	if status, body := get(t, mirror, base+testutil.ArchiveFilename("tool", "1.0.0", "windows_amd64")); status != http.StatusOK {
+		t.Errorf("windows archive: status %d: %s", status, body)
+	}
+
+	unchecked := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_CHECK_ARCHIVES=false")
+	if status, body := get(t, unchecked, base+testutil.ArchiveFilename("tool", "1.0.0", "linux_amd64")); status != http.StatusOK {
+		t.Errorf("unchecked archive: status %d: %s", status, body)
+	}
+}
+
+func TestArchiveNormalization(t *testing.T) {
+	upstream := newTestRegistry(t)
+	upstream.AddVersion("acme", "tool", "1.0.0", "linux_amd64")
+	upstream.AddExtraFiles("acme", "tool", "1.0.0", "LICENSE", "README.md", "build/info.json")
+	const base = "/v1/providers/registry.terraform.io/acme/tool/"
+	filename := testutil.ArchiveFilename("tool", "1.0.0", "linux_amd64")
+
+	first := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_NORMALIZE_NAMESPACES=acme")
+	normalized := mustGet(t, first, base+filename)
+	zr, err := zip.NewReader(bytes.NewReader(normalized), int64(len(normalized)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, f := range zr.File {
+		entries = append(entries, fmt.Sprintf("%s %s %s", f.Name, f.Mode(), f.Modified.UTC().Format(time.DateOnly)))
+	}
+	if want := "LICENSE -rw-r--r-- 1980-01-01, terraform-provider-tool_v1.0.0 -rwxr-xr-x 1980-01-01"; strings.Join(entries, ", ") != want {
+		t.Errorf("normalized entries %q, want %q", entries, want)
+	}
+
+	// Only the h1 hash of the normalized archive is listed; SHA256SUMS describes the upstream one
+	var doc struct {
+		Archives map[string]struct {
+			Hashes []string `json:"hashes"`
+		} `json:"archives"`
+	}
+	if err := json.Unmarshal(mustGet(t, first, base+"1.0.0.json"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	h1, err := hash.CalculateH1FromReaderAt(bytes.NewReader(normalized), int64(len(normalized)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes := doc.Archives["linux_amd64"].Hashes; len(hashes) != 1 || hashes[0] != h1 {
+		t.Errorf("hashes %v, want [%s]", hashes, h1)
+	}
+
+	// Another build with different extra files and timestamps normalizes to the same bytes
+	upstream.AddExtraFiles("acme", "tool", "1.0.0", "CHANGELOG.md", "LICENSE")
+	second := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_NORMALIZE_NAMESPACES=*")
+	if again := mustGet(t, second, base+filename); !bytes.Equal(again, normalized) {
+		t.Error("normalized archives of two builds differ")
+	}
+
+	// Other namespaces are served as published
+	if body := mustGet(t, first, mirrorBase+testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")); !bytes.Equal(body, testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")) {
+		t.Error("archive of a namespace without normalization was changed")
+	}
+}
+
+func TestArchiveCaching(t *testing.T) {
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true")
+	filename := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	etag := `"zh:` + testutil.Shasum("hashicorp", "random", "3.6.0", "linux_amd64") + `"`
+
+	download := func(header, value string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+mirrorBase+filename, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Both the first download and cache hits are cacheable downstream
+	for i := 0; i < 2; i++ {
+		resp := download("", "")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "public, max-age=31536000, immutable" || resp.Header.Get("ETag") != etag {
+			t.Errorf("download %d: status %d, Cache-Control %q, ETag %q", i+1, resp.StatusCode, resp.Header.Get("Cache-Control"), resp.Header.Get("ETag"))
+		}
+	}
+	if resp := download("If-None-Match", `"zh:0000", `+etag); resp.StatusCode != http.StatusNotModified || resp.Header.Get("ETag") != etag {
+		t.Errorf("revalidation: status %d, ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	if resp := download("If-None-Match", `"zh:0000"`); resp.StatusCode != http.StatusOK {
+		t.Errorf("stale revalidation: status %d", resp.StatusCode)
+	}
+	if resp := download("Authorization", "Bearer token"); !strings.HasPrefix(resp.Header.Get("Cache-Control"), "private,") {
+		t.Errorf("authenticated download: Cache-Control %q", resp.Header.Get("Cache-Control"))
+	}
+}
+
+func TestAttributionHeaders(t *testing.T) {
+	upstream := newTestRegistry(t)
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	filename := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+
+	attribution := func(mirror *httptest.Server, path string) string {
+		t.Helper()
+		resp, err := http.Get(mirror.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		return strings.TrimSpace(resp.Header.Get("X-Mirror-Cache") + " " + resp.Header.Get("X-Mirror-Upstream"))
+	}
+
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true")
+	for _, tc := range []struct{ path, want string }{
+		{"index.json", "MISS " + host},
+		{"3.6.0.json", "MISS " + host},
+		{"3.6.0.json", "HIT"},
+		{filename, "MISS " + host},
+		{filename, "HIT"},
+	} {
+		if got := attribution(mirror, mirrorBase+tc.path); got != tc.want {
+			t.Errorf("%s: attribution %q, want %q", tc.path, got, tc.want)
+		}
+	}
+
+	disabled := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_ATTRIBUTION_HEADERS=false")
+	if got := attribution(disabled, mirrorBase+filename); got != "" {
+		t.Errorf("disabled: attribution %q", got)
+	}
+}
+
+func TestOriginMode(t *testing.T) {
+	upstream := newTestRegistry(t)
+	mirror := newTestMirror(t, upstream, t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true",
+		"TF_MIRROR_CDN_URL=https://cdn.example.com", "TF_MIRROR_SIGNED_URL_SECRET=secret")
+	filename := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+
+	var doc struct {
+		Archives map[string]struct {
+			URL string `json:"url"`
+		} `json:"archives"`
+	}
+	if err := json.Unmarshal(mustGet(t, mirror, mirrorBase+"3.6.0.json"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	signed, query, _ := strings.Cut(doc.Archives["linux_amd64"].URL, "?")
+	if signed != "https://cdn.example.com"+mirrorBase+filename || !strings.Contains(query, "signature=") {
+		t.Fatalf("archive URL %q is not a signed CDN URL", doc.Archives["linux_amd64"].URL)
+	}
+
+	// The CDN forwards the signed URL to the origin
+	req, err := http.NewRequest(http.MethodGet, mirror.URL+mirrorBase+filename+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	var maxAge int
+	if _, err := fmt.Sscanf(resp.Header.Get("Cache-Control"), "public, max-age=%d", &maxAge); resp.StatusCode != http.StatusOK || err != nil || maxAge > 7200 {
+		t.Errorf("signed download: status %d, Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+
+	expired := token.NewURLSigner("secret", time.Hour).Sign("hashicorp/random/"+filename, time.Now().Add(-time.Minute))
+	other := testutil.ArchiveFilename("random", "3.5.1", "linux_amd64")
+	for _, path := range []string{
+		mirrorBase + filename,
+		mirrorBase + filename + "?" + expired,
+		mirrorBase + other + "?" + query,
+		mirrorBase + filename + "?" + strings.Replace(query, "expires=", "expires=1", 1),
+	} {
+		if status, body := get(t, mirror, path); status != http.StatusForbidden {
+			t.Errorf("GET %s: status %d: %s", path, status, body)
+		}
+	}
+}
+
+func TestDownloadLinks(t *testing.T) {
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	err := os.WriteFile(tenants, []byte(`{"tenants": [{"name": "ci", "tokens": ["ci-token"], "providers": ["hashicorp/random"]}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TENANTS_FILE="+tenants,
+		"TF_MIRROR_DOWNLOAD_LINK_SECRET=link-secret", "TF_MIRROR_DOWNLOAD_LINK_TTL=30m")
+	filename := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+
+	mint := func(token, body string) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, mirror.URL+"/api/download-links", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	status, body := mint("ci-token", `{"archives": ["hashicorp/random/3.6.0/linux_amd64"], "ttl": "10m"}`)
+	if status != http.StatusOK {
+		t.Fatalf("mint: status %d: %s", status, body)
+	}
+	var resp struct {
+		Links []struct {
+			Archive string `json:"archive"`
+			URL     string `json:"url"`
+		} `json:"links"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Links) != 1 || !strings.HasPrefix(resp.Links[0].URL, mirror.URL+mirrorBase+filename+"?") {
+		t.Fatalf("links %+v", resp.Links)
+	}
+	if d := time.Until(resp.ExpiresAt); d <= 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("link expires in %s, want 10m", d)
+	}
+
+	// A runner without credentials downloads the linked archive, and nothing else
+	link := strings.TrimPrefix(resp.Links[0].URL, mirror.URL)
+	if got := mustGet(t, mirror, link); !bytes.Equal(got, testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")) {
+		t.Error("linked archive differs from upstream")
+	}
+	_, query, _ := strings.Cut(link, "?")
+	expired := token.NewURLSigner("link-secret", time.Hour).Sign("hashicorp/random/"+filename, time.Now().Add(-time.Minute))
+	for _, path := range []string{
+		mirrorBase + filename,
+		mirrorBase + testutil.ArchiveFilename("random", "3.5.1", "linux_amd64") + "?" + query,
+		mirrorBase + "3.6.0.json?" + query,
+		mirrorBase + filename + "?" + expired,
+	} {
+		if status, body := get(t, mirror, path); status != http.StatusUnauthorized {
+			t.Errorf("GET %s: status %d: %s", path, status, body)
+		}
+	}
+
+	for _, tt := range []struct {
+		token, body string
+		status      int
+	}{
+		{"", `{"archives": ["hashicorp/random/3.6.0/linux_amd64"]}`, http.StatusUnauthorized},
+		{"ci-token", `{"archives": ["hashicorp/aws/5.0.0/linux_amd64"]}`, http.StatusForbidden},
+		{"ci-token", `{"archives": ["hashicorp/random/3.6.0"]}`, http.StatusBadRequest},
+		{"ci-token", `{"archives": ["hashicorp/random/3.6.0/linux_amd64"], "ttl": "2h"}`, http.StatusBadRequest},
+	} {
+		if status, body := mint(tt.token, tt.body); status != tt.status {
+			t.Errorf("mint %s as %q: status %d, want %d: %s", tt.body, tt.token, status, tt.status, body)
+		}
+	}
+}
+
+func TestCacheEncryption(t *testing.T) {
+	upstream := newTestRegistry(t)
+	upstream.AddVersion("acme", "tool", "1.0.0", "linux_amd64")
+	cacheDir := t.TempDir()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	mirror := newTestMirror(t, upstream, cacheDir, "TF_MIRROR_CACHE_ENABLED=true",
+		"TF_MIRROR_CACHE_ENCRYPTION_KEY="+key, "TF_MIRROR_CACHE_ENCRYPTION_NAMESPACES=hashicorp")
+	filename := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	archive := testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")
+	cached := filepath.Join(cacheDir, "archives", "hashicorp", "random", "3.6.0", filename)
+
+	for i := 0; i < 2; i++ {
+		if body := mustGet(t, mirror, mirrorBase+filename); !bytes.Equal(body, archive) {
+			t.Fatalf("download %d differs from the upstream archive", i+1)
+		}
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 1 {
+		t.Errorf("archive downloaded %d times, want 1", n)
+	}
+	data, err := os.ReadFile(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("fake provider")) || !bytes.HasPrefix(data, []byte("TFMENC2")) {
+		t.Errorf("cached archive is not encrypted: %q", data[:16])
+	}
+
+	// Other namespaces are cached as downloaded
+	other := testutil.ArchiveFilename("tool", "1.0.0", "linux_amd64")
+	mustGet(t, mirror, "/v1/providers/registry.terraform.io/acme/tool/"+other)
+	plain, err := os.ReadFile(filepath.Join(cacheDir, "archives", "acme", "tool", "1.0.0", other))
+	if err != nil || !bytes.Equal(plain, testutil.Archive("acme", "tool", "1.0.0", "linux_amd64")) {
+		t.Errorf("archive of an unencrypted namespace: %v", err)
+	}
+
+	// The inventory lists archive sizes as served, which replicas compare against
+	var inv struct {
+		Providers []inventory.Item `json:"providers"`
+	}
+	if err := json.Unmarshal(mustGet(t, mirror, "/admin/inventory"), &inv); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range inv.Providers {
+		if it.Namespace == "hashicorp" && it.Platform == "linux_amd64" && it.Version == "3.6.0" && it.Size != int64(len(archive)) {
+			t.Errorf("inventory size %d, want %d", it.Size, len(archive))
+		}
+	}
+
+	// A tampered archive fails its integrity check and is downloaded again
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(cached, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if body := mustGet(t, mirror, mirrorBase+filename); !bytes.Equal(body, archive) {
+		t.Fatal("tampered archive served")
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 2 {
+		t.Errorf("tampered archive: downloaded %d times, want 2", n)
+	}
+
+	// So does another archive's valid file moved to its path
+	darwin := testutil.ArchiveFilename("random", "3.6.0", "darwin_arm64")
+	mustGet(t, mirror, mirrorBase+darwin)
+	swapped, err := os.ReadFile(filepath.Join(cacheDir, "archives", "hashicorp", "random", "3.6.0", darwin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cached, swapped, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if body := mustGet(t, mirror, mirrorBase+filename); !bytes.Equal(body, archive) {
+		t.Fatal("archive of another platform served")
+	}
+	if n := upstream.Requests(testutil.ArchivePath("hashicorp", "random", "3.6.0", "linux_amd64")); n != 3 {
+		t.Errorf("swapped archive: downloaded %d times, want 3", n)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	var logs logBuffer
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelWarn)
+	s := New(loadTestConfig(t, newTestRegistry(t), t.TempDir()), slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: level})))
+	s.SetLogLevel(level)
+	mirror := httptest.NewServer(s.publicHandler())
+	t.Cleanup(mirror.Close)
+
+	setLevel := func(body string) (int, []byte) {
+		return do(t, mirror, http.MethodPut, "/admin/loglevel", strings.NewReader(body))
+	}
+
+	mustGet(t, mirror, mirrorBase+"index.json")
+	if strings.Contains(logs.String(), "level=DEBUG") {
+		t.Fatalf("debug output at level warn:\n%s", logs.String())
+	}
+	if status, body := setLevel(`{"level": "verbose"}`); status != http.StatusBadRequest {
+		t.Errorf("unknown level: status %d: %s", status, body)
+	}
+	if status, body := setLevel(`{"level": "debug", "actor": "alice"}`); status != http.StatusOK {
+		t.Fatalf("set level: status %d: %s", status, body)
+	}
+	if body := mustGet(t, mirror, "/admin/loglevel"); !bytes.Contains(body, []byte(`"level":"debug"`)) {
+		t.Errorf("log level: %s", body)
+	}
+	mustGet(t, mirror, mirrorBase+"index.json")
+	if out := logs.String(); !strings.Contains(out, "level=DEBUG") || !strings.Contains(out, `msg="log level changed" from=warn to=debug actor=alice`) {
+		t.Errorf("no debug output after changing the level:\n%s", out)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	err := os.WriteFile(tenants, []byte(`{"tenants": [
+		{"name": "ci", "tokens": ["ci-token"]},
+		{"name": "release", "tokens": ["release-token"], "role": "publish"},
+		{"name": "ops", "tokens": ["ops-token"], "role": "admin"}
+	]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TENANTS_FILE="+tenants, "TF_MIRROR_ADMIN_TOKEN=admin-token")
+
+	tests := []struct {
+		method, path, token, body string
+		status                    int
+	}{
+		{http.MethodGet, mirrorBase + "index.json", "", "", http.StatusUnauthorized},
+		{http.MethodGet, mirrorBase + "index.json", "ci-token", "", http.StatusOK},
+		{http.MethodGet, "/admin/cache", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/cache", "ci-token", "", http.StatusForbidden},
+		{http.MethodGet, "/admin/cache", "release-token", "", http.StatusForbidden},
+		{http.MethodGet, "/admin/cache", "ops-token", "", http.StatusOK},
+		{http.MethodGet, "/admin/cache", "admin-token", "", http.StatusOK},
+		{http.MethodPut, "/admin/tombstones/hashicorp/random/3.5.1", "ci-token", `{"reason": "broken"}`, http.StatusForbidden},
+		{http.MethodPut, "/admin/tombstones/hashicorp/random/3.5.1", "release-token", `{"reason": "broken"}`, http.StatusOK},
+		{http.MethodDelete, "/admin/tombstones/hashicorp/random/3.5.1", "ops-token", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, mirror.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s as %q: status %d, want %d: %s", tt.method, tt.path, tt.token, resp.StatusCode, tt.status, body)
+		}
+	}
+
+	// Tenants without an admin role and no admin token leave nobody an admin
+	readers := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(readers, []byte(`{"tenants": [{"name": "ci", "tokens": ["ci-token"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mirror = newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TENANTS_FILE="+readers, "TF_MIRROR_ADMIN_TOKEN=")
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "ci-token": http.StatusForbidden} {
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+"/admin/cache", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET /admin/cache as %q with read tenants only: status %d, want %d", token, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestAdminWithoutCredentials(t *testing.T) {
+	// Nothing grants a role above read, so the admin API is refused and the read routes stay open
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_ADMIN_TOKEN=")
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/admin/cache", http.StatusForbidden},
+		{http.MethodPut, "/admin/freeze", http.StatusForbidden},
+		{http.MethodGet, "/admin/tombstones", http.StatusForbidden},
+		{http.MethodPost, "/api/lock-reports", http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest(tt.method, mirror.URL+tt.path, strings.NewReader(`{"reason": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, resp.StatusCode, tt.status, body)
+		}
+	}
+	if status, _ := get(t, mirror, "/admin/freeze"); status != http.StatusForbidden {
+		t.Errorf("GET /admin/freeze with an unknown token: status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestDocsPathValidation(t *testing.T) {
+	// The version becomes part of the docs cache path
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_DOCS_ENABLED=true")
+
+	for _, path := range []string{
+		"/docs/hashicorp/random/..%2F..%2F..%2Fetc",
+		"/docs/hashicorp/random/..%2F..%2F..%2Fetc/123",
+		"/docs/hashicorp/ran..dom/3.6.0",
+	} {
+		if status, body := get(t, mirror, path); status != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want %d: %s", path, status, http.StatusBadRequest, body)
+		}
+	}
+}
+
+func TestResponseSigning(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_SIGNING_KEY="+keyFile)
+
+	for _, file := range []string{"index.json", "3.6.0.json"} {
+		resp, err := http.Get(mirror.URL + mirrorBase + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		header, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Mirror-Signature"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		detached := mustGet(t, mirror, mirrorBase+file+".sig")
+
+		// The signature names the provider, so it does not verify for another one
+		signed := append([]byte("registry.terraform.io/hashicorp/random/"+file+"\n"), body...)
+		replayed := append([]byte("registry.terraform.io/acme/random/"+file+"\n"), body...)
+		for name, sig := range map[string][]byte{"header": header, "detached": detached} {
+			if !ed25519.Verify(pub, signed, sig) {
+				t.Errorf("%s: %s signature does not verify", file, name)
+			}
+			if ed25519.Verify(pub, replayed, sig) || ed25519.Verify(pub, body, sig) {
+				t.Errorf("%s: %s signature verifies without its provider address", file, name)
+			}
+		}
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(tenants, []byte(`{"tenants": [{"name": "ci", "tokens": ["ci-token"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_TENANTS_FILE="+tenants,
+		"TF_MIRROR_TOKEN_SECRET=token-secret", "TF_MIRROR_TOKEN_MAX_AGE=1000h")
+
+	refresh := func(credential string) (int, tokenResponse) {
+		req, err := http.NewRequest(http.MethodPost, mirror.URL+"/api/tokens/refresh", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+credential)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var issued tokenResponse
+		_ = json.NewDecoder(resp.Body).Decode(&issued)
+		return resp.StatusCode, issued
+	}
+
+	status, first := refresh("ci-token")
+	if status != http.StatusOK {
+		t.Fatalf("refresh with the tenant token: status %d", status)
+	}
+	status, second := refresh(first.Token)
+	if status != http.StatusOK {
+		t.Fatalf("refresh with a mirror token: status %d", status)
+	}
+	a, _ := token.Parse(first.Token)
+	b, _ := token.Parse(second.Token)
+	if !b.AuthTime.Equal(a.AuthTime) {
+		t.Errorf("refreshed token authenticated at %s, want %s", b.AuthTime, a.AuthTime)
+	}
+
+	// A still valid token from a login longer ago than TF_MIRROR_TOKEN_MAX_AGE is not refreshed
+	old, _, err := token.NewIssuer("token-secret", 1000*time.Hour, 2000*time.Hour).Refresh(token.Claims{
+		Subject: "ci", Tenant: "ci", AuthTime: time.Now().Add(-1500 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, mirror.URL+mirrorBase+"index.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+old)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("request with the old token: status %d", resp.StatusCode)
+	}
+	if status, _ := refresh(old); status != http.StatusUnauthorized {
+		t.Errorf("refresh past the maximum age: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	upstream := newTestRegistry(t)
+	for _, tc := range []struct {
+		env  string
+		want string
+	}{
+		{"TF_MIRROR_TRUSTED_PROXIES=", "http://127.0.0.1"},
+		{"TF_MIRROR_TRUSTED_PROXIES=127.0.0.1", "https://mirror.example.com/"},
+	} {
+		mirror := newTestMirror(t, upstream, t.TempDir(), tc.env)
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+"/api/cli-config?format=hcl", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "mirror.example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !bytes.Contains(body, []byte(`"`+tc.want)) {
+			t.Errorf("%s: CLI configuration without %s:\n%s", tc.env, tc.want, body)
+		}
+	}
+}
+
+func TestTenantInventory(t *testing.T) {
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	err := os.WriteFile(tenants, []byte(`{"tenants": [
+		{"name": "spoke", "tokens": ["spoke-token"], "providers": ["hashicorp/random"]},
+		{"name": "team", "tokens": ["team-token"], "providers": ["acme/*"]}
+	]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newTestMirror(t, newTestRegistry(t), t.TempDir(), "TF_MIRROR_CACHE_ENABLED=true", "TF_MIRROR_TENANTS_FILE="+tenants)
+
+	request := func(path, credential string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, mirror.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credential != "" {
+			req.Header.Set("Authorization", "Bearer "+credential)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+	if status, body := request(mirrorBase+testutil.ArchiveFilename("random", "3.6.0", "linux_amd64"), "spoke-token"); status != http.StatusOK {
+		t.Fatalf("archive: status %d: %s", status, body)
+	}
+
+	// A replica reads the inventory with a tenant token, limited to the tenant's providers
+	for credential, want := range map[string]int{"spoke-token": 1, "team-token": 0} {
+		status, body := request("/api/inventory", credential)
+		var inv struct {
+			Providers []inventory.Item `json:"providers"`
+		}
+		if err := json.Unmarshal(body, &inv); err != nil || status != http.StatusOK {
+			t.Fatalf("inventory as %s: status %d, %v", credential, status, err)
+		}
+		if len(inv.Providers) != want {
+			t.Errorf("inventory as %s: %d providers, want %d", credential, len(inv.Providers), want)
+		}
+	}
+	if status, _ := request("/api/inventory", ""); status != http.StatusUnauthorized {
+		t.Errorf("inventory without credentials: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, _ := request("/admin/inventory", "spoke-token"); status != http.StatusForbidden {
+		t.Errorf("admin inventory as a read tenant: status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+// badStatusHook refuses acme providers and answers /health with statuses that are not valid
+type badStatusHook struct{}
+
+func (badStatusHook) Name() string { return "bad-status" }
+
+func (badStatusHook) ResolveProvider(_ context.Context, _ *http.Request, namespace, name string) (string, string, error) {
+	if namespace == "acme" {
+		return "", "", hooks.Deny(0, "acme providers are blocked")
+	}
+	return namespace, name, nil
+}
+
+func (badStatusHook) MutateResponse(r *http.Request, status int, _ http.Header) int {
+	if r.URL.Path == "/health" {
+		return 42
+	}
+	return status
+}
+
+func TestHookStatuses(t *testing.T) {
+	upstream := newTestRegistry(t)
+	upstream.AddVersion("acme", "tool", "1.0.0", "linux_amd64")
+	s := New(loadTestConfig(t, upstream, t.TempDir()), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.hooks = hooks.NewChain([]hooks.Hook{badStatusHook{}})
+	mirror := httptest.NewServer(s.publicHandler())
+	t.Cleanup(mirror.Close)
+
+	if status, body := get(t, mirror, "/v1/providers/registry.terraform.io/acme/tool/index.json"); status != http.StatusForbidden {
+		t.Errorf("refusal with status 0: status %d, want %d: %s", status, http.StatusForbidden, body)
+	}
+	if status, _ := get(t, mirror, "/health"); status != http.StatusInternalServerError {
+		t.Errorf("response hook status 42: status %d, want %d", status, http.StatusInternalServerError)
+	}
+
+	// Archives are streamed through the hook writer unchanged
+	filename := testutil.ArchiveFilename("random", "3.6.0", "linux_amd64")
+	if body := mustGet(t, mirror, mirrorBase+filename); !bytes.Equal(body, testutil.Archive("hashicorp", "random", "3.6.0", "linux_amd64")) {
+		t.Error("archive served through a response hook differs from the upstream archive")
+	}
+}
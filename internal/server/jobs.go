@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/jobs"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// Kinds of admin jobs (POST /admin/jobs/{kind})
+const (
+	jobVerify   = "verify"   // re-hash cached archives and compare with the stored h1 hashes
+	jobGC       = "gc"       // evict archives beyond TF_MIRROR_CACHE_MAX_SIZE and namespace quotas
+	jobPrefetch = "prefetch" // run the prefetch list now
+	jobExport   = "export"   // write the inventory to a file (?format=json|csv|cyclonedx)
+)
+
+// exportsDir holds inventory exports below the cache directory
+const exportsDir = "exports"
+
+// verifyResult is the result of a verify job
+type verifyResult struct {
+	Checked    int      `json:"checked"`
+	Unhashed   int      `json:"unhashed"`             // archives without a stored h1 hash
+	Mismatched []string `json:"mismatched,omitempty"` // archives whose h1 differs from the stored one
+	Unreadable []string `json:"unreadable,omitempty"`
+}
+
+// handleStartJob handles POST /admin/jobs/{kind} — starts an admin job and returns it with 202
+func (s *Server) handleStartJob(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	params := map[string]string{}
+
+	var fn jobs.Func
+	switch kind {
+	case jobVerify:
+		fn = s.verifyJob
+	case jobGC:
+		fn = s.gcJob
+	case jobPrefetch:
+		if s.prefetcher == nil {
+			writeError(w, badRequest("prefetch jobs require TF_MIRROR_PREFETCH_FILE"))
+			return
+		}
+		fn = s.prefetchJob
+	case jobExport:
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = inventory.FormatJSON
+		}
+		if format != inventory.FormatJSON && format != inventory.FormatCSV && format != inventory.FormatCycloneDX {
+			writeError(w, badRequest("unknown format "+format))
+			return
+		}
+		params["format"] = format
+		fn = s.exportJob(format)
+	default:
+		writeError(w, notFound("unknown job kind "+kind))
+		return
+	}
+	if (kind == jobVerify || kind == jobGC) && s.archiveCache == nil {
+		writeError(w, badRequest(kind+" jobs require TF_MIRROR_CACHE_ENABLED"))
+		return
+	}
+
+	job, err := s.jobs.Start(kind, adminActor(r, ""), params, fn)
+	if errors.Is(err, jobs.ErrBusy) {
+		writeError(w, conflict("a "+kind+" job is already running: "+job.ID))
+		return
+	}
+	if err != nil {
+		writeError(w, &apiError{status: http.StatusServiceUnavailable, code: codeOverloaded, message: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// handleListJobs handles GET /admin/jobs — running and recent jobs, newest first
+func (s *Server) handleListJobs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{"jobs": s.jobs.List()})
+}
+
+// handleGetJob handles GET /admin/jobs/{id} — state, progress and result of a job
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, notFound("job "+r.PathValue("id")+" not found"))
+		return
+	}
+	writeJSON(w, job)
+}
+
+// handleCancelJob handles DELETE /admin/jobs/{id} — cancels a running job
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, err := s.jobs.Cancel(id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, notFound("job "+id+" not found"))
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		writeError(w, conflict("job "+id+" has already finished"))
+		return
+	}
+	s.logger.Info("job canceled", "job", id, "kind", job.Kind, "actor", s.logIdentity(adminActor(r, "")))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// handleJobOutput handles GET /admin/jobs/{id}/output — the file written by an export job
+func (s *Server) handleJobOutput(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		writeError(w, notFound("job "+id+" not found"))
+		return
+	}
+	if job.State != jobs.StateSucceeded || job.Output == "" {
+		writeError(w, notFound("job "+id+" has no output"))
+		return
+	}
+
+	f, err := os.Open(job.Output)
+	if err != nil {
+		writeError(w, notFound("output of job "+id+" is no longer available"))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, internalError())
+		return
+	}
+	w.Header().Set("Content-Type", inventory.ContentType(job.Params["format"]))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(job.Output)+`"`)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// verifyJob re-hashes every cached archive and compares it with its stored h1 hash
+func (s *Server) verifyJob(ctx context.Context, t *jobs.Task) (any, error) {
+	archives, err := s.archiveCache.List()
+	if err != nil {
+		return nil, err
+	}
+	t.SetTotal(int64(len(archives)))
+
+	result := &verifyResult{}
+	for _, a := range archives {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		file := a.Namespace + "/" + a.Name + "/" + a.Version + "/" + a.Filename
+		t.SetMessage(file)
+
+		_, _, osName, arch, err := registry.ParseZipFilename(a.Filename)
+		if err != nil {
+			t.Add(1)
+			continue
+		}
+		h1, err := s.verifyArchive(a)
+		result.Checked++
+		stored, known := s.hashCache.Get(a.Namespace, a.Name, a.Version, osName+"_"+arch)
+		switch {
+		case err != nil:
+			result.Unreadable = append(result.Unreadable, file)
+			s.logger.Warn("cached archive unreadable", "file", file, "error", err)
+		case !known:
+			result.Unhashed++
+		case stored != h1:
+			result.Mismatched = append(result.Mismatched, file)
+			s.logger.Error("cached archive does not match its h1 hash", "file", file, "stored", stored, "calculated", h1)
+		}
+		t.Add(1)
+	}
+	t.SetMessage("")
+	return result, nil
+}
+
+// verifyArchive calculates the h1 hash of a cached archive
+func (s *Server) verifyArchive(a cache.ArchiveInfo) (string, error) {
+	f, size, err := s.archiveCache.OpenLocal(a.Namespace, a.Name, a.Version, a.Filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hash.CalculateH1FromReaderAt(f, size)
+}
+
+// gcJob evicts archives until the cache is within its size limit and quotas
+func (s *Server) gcJob(_ context.Context, t *jobs.Task) (any, error) {
+	t.SetTotal(1)
+	evicted, err := s.archiveCache.Enforce()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(evicted)
+	t.Add(1)
+	return map[string]any{"evicted": len(evicted), "files": evicted}, nil
+}
+
+// prefetchJob runs the prefetch list once
+func (s *Server) prefetchJob(ctx context.Context, t *jobs.Task) (any, error) {
+	summary, err := s.prefetcher.RunOnce(ctx, func(done, total int) {
+		t.SetTotal(int64(total))
+		t.Add(1)
+	})
+	return summary, err
+}
+
+// exportJob writes the inventory to {cache_dir}/exports/{id}.{ext}, served by GET /admin/jobs/{id}/output
+func (s *Server) exportJob(format string) jobs.Func {
+	return func(_ context.Context, t *jobs.Task) (any, error) {
+		items, err := inventory.Collect(s.hashCache, s.archiveCache, s.artifactCache, s.cfg.NormalizeArchives)
+		if err != nil {
+			return nil, err
+		}
+		s.annotateScans(items)
+
+		dir := filepath.Join(s.cfg.CacheDir, exportsDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		ext := "." + format
+		if format == inventory.FormatCycloneDX {
+			ext = ".cdx.json"
+		}
+		path := filepath.Join(dir, "inventory-"+t.ID()+ext)
+
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := inventory.Write(f, format, items); err != nil {
+			f.Close()
+			os.Remove(path)
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+		t.SetOutput(path)
+		return map[string]any{"items": len(items), "format": format}, nil
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/versions"
+)
+
+// latestResponse is the body of GET /api/providers/{hostname}/{namespace}/{name}/latest
+type latestResponse struct {
+	Hostname    string   `json:"hostname"`
+	Namespace   string   `json:"namespace"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Constraints string   `json:"constraints,omitempty"`
+	Platforms   []string `json:"platforms"` // "{os}_{arch}"
+}
+
+// handleLatestVersion handles GET /api/providers/{hostname}/{namespace}/{name}/latest
+// The newest version the mirror serves, optionally within ?constraints= (e.g. "~> 5.0")
+// Versions are filtered like index.json; prereleases only match an exact constraint
+func (s *Server) handleLatestVersion(w http.ResponseWriter, r *http.Request) {
+	hostname, err := s.checkHostname(r.PathValue("hostname"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	namespace, name, err := s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	raw := r.URL.Query().Get("constraints")
+	constraints, err := versions.ParseConstraints(raw)
+	if err != nil {
+		writeError(w, badRequest("invalid constraints: "+err.Error()))
+		return
+	}
+
+	ctx := r.Context()
+	data, err := s.versionsDocument(ctx, namespace, name)
+	if err != nil {
+		s.logger.Error("failed to fetch versions", "error", err)
+		writeError(w, err)
+		return
+	}
+	var list providersv1.MirrorVersionsResponse
+	if err := json.Unmarshal(data, &list); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	latest := ""
+	for v := range list.Versions {
+		if constraints.Check(v) && (latest == "" || versions.Compare(v, latest) > 0) {
+			latest = v
+		}
+	}
+	if latest == "" {
+		message := "no version of " + namespace + "/" + name
+		if raw != "" {
+			message += " matches " + raw
+		}
+		writeError(w, notFound(message))
+		return
+	}
+
+	doc, _, err := s.versionDocument(ctx, hostname, namespace, name, latest)
+	if err != nil {
+		s.logger.Error("failed to fetch version", "error", err)
+		writeError(w, err)
+		return
+	}
+	var version providersv1.MirrorVersionResponse
+	if err := json.Unmarshal(doc, &version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	platforms := make([]string, 0, len(version.Archives))
+	for platform := range version.Archives {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	writeJSON(w, latestResponse{
+		Hostname:    hostname,
+		Namespace:   namespace,
+		Name:        name,
+		Version:     latest,
+		Constraints: raw,
+		Platforms:   platforms,
+	})
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/lockfile"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+const (
+	// maxLockFileSize limits uploaded lock files
+	maxLockFileSize = 1 << 20
+
+	// maxLockReports is the number of recent drift reports kept for GET /admin/lock-reports
+	maxLockReports = 100
+)
+
+// Drift of a locked provider
+const (
+	lockOK          = "ok"            // version served and every locked hash known to the mirror
+	lockUnverified  = "unverified"    // some locked hashes are not known yet, e.g. h1 of archives never downloaded
+	lockMismatch    = "hash_mismatch" // a locked zh hash is missing from the version's SHA256SUMS
+	lockUnavailable = "unavailable"   // the mirror does not serve the version (see code and error)
+)
+
+// lockReport is the drift of an uploaded .terraform.lock.hcl against the mirror
+type lockReport struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name,omitempty"` // ?name= of the upload, e.g. a repository path
+	Tenant    string         `json:"tenant,omitempty"`
+	Created   time.Time      `json:"created"`
+	Drift     bool           `json:"drift"`   // any provider is not ok
+	Summary   map[string]int `json:"summary"` // providers by status
+	Providers []lockProvider `json:"providers,omitempty"`
+}
+
+// lockProvider is the drift of one provider block
+type lockProvider struct {
+	Provider string `json:"provider"`
+	Version  string `json:"version"`
+	Status   string `json:"status"`
+
+	Verified []string `json:"verified,omitempty"` // locked hashes the mirror knows
+	Unknown  []string `json:"unknown,omitempty"`  // locked hashes the mirror does not know
+
+	// Hashes the mirror knows that the lock file lacks, e.g. of platforms added since it was written
+	Missing []string `json:"missing,omitempty"`
+
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// lockReports keeps the most recent drift reports in memory
+type lockReports struct {
+	mu      sync.Mutex
+	reports []*lockReport // oldest first
+}
+
+func (l *lockReports) add(report *lockReport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reports = append(l.reports, report)
+	if len(l.reports) > maxLockReports {
+		l.reports = slices.Delete(l.reports, 0, len(l.reports)-maxLockReports)
+	}
+}
+
+func (l *lockReports) get(id string) (*lockReport, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, report := range l.reports {
+		if report.ID == id {
+			return report, true
+		}
+	}
+	return nil, false
+}
+
+// list returns the reports newest first, without their providers
+func (l *lockReports) list() []lockReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]lockReport, 0, len(l.reports))
+	for i := len(l.reports) - 1; i >= 0; i-- {
+		summary := *l.reports[i]
+		summary.Providers = nil
+		list = append(list, summary)
+	}
+	return list
+}
+
+// handleLockReport handles POST /api/lock-reports — checks an uploaded .terraform.lock.hcl against the mirror
+// Each locked provider is checked like a Mirror Protocol request of the caller and its hashes are compared
+// with those of {version}.json, to find what would break when clients install from the mirror only
+func (s *Server) handleLockReport(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLockFileSize))
+	if err != nil {
+		writeError(w, badRequest("invalid request body: "+err.Error()))
+		return
+	}
+	providers, err := lockfile.Parse(data)
+	if err != nil {
+		writeError(w, badRequest("invalid lock file: "+err.Error()))
+		return
+	}
+	if len(providers) == 0 {
+		writeError(w, badRequest("lock file has no provider blocks"))
+		return
+	}
+
+	report := &lockReport{
+		ID:      newLockReportID(),
+		Name:    r.URL.Query().Get("name"),
+		Created: time.Now().UTC(),
+		Summary: map[string]int{},
+	}
+	if t := tenant.FromContext(r.Context()); t != nil {
+		report.Tenant = t.Name
+	}
+	for _, p := range providers {
+		result := s.lockDrift(r, p)
+		report.Providers = append(report.Providers, result)
+		report.Summary[result.Status]++
+		report.Drift = report.Drift || result.Status != lockOK
+	}
+	s.lockReports.add(report)
+
+	s.logger.Info("lock file checked", "report", report.ID, "name", report.Name, "client", s.logClient(r), "providers", len(providers), "drift", report.Drift)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// lockDrift compares one locked provider with what the mirror serves
+func (s *Server) lockDrift(r *http.Request, p lockfile.Provider) lockProvider {
+	result := lockProvider{Provider: p.Address, Version: p.Version}
+	known, err := s.lockedVersionHashes(r, p)
+	if err != nil {
+		apiErr := toAPIError(err)
+		result.Status, result.Error, result.Code = lockUnavailable, apiErr.message, apiErr.code
+		return result
+	}
+
+	knownZH := false
+	for h := range known {
+		knownZH = knownZH || hash.SchemeOf(h) == hash.ZH.ID
+	}
+	result.Status = lockOK
+	for _, h := range p.Hashes {
+		switch {
+		case known[h]:
+			result.Verified = append(result.Verified, h)
+		case hash.SchemeOf(h) == hash.ZH.ID && knownZH:
+			// SHA256SUMS lists every platform, so an unknown zh hash is not from this release
+			result.Unknown = append(result.Unknown, h)
+			result.Status = lockMismatch
+		default:
+			result.Unknown = append(result.Unknown, h)
+			if result.Status == lockOK {
+				result.Status = lockUnverified
+			}
+		}
+	}
+	for h := range known {
+		if !slices.Contains(p.Hashes, h) {
+			result.Missing = append(result.Missing, h)
+		}
+	}
+	sort.Strings(result.Missing)
+	return result
+}
+
+// lockedVersionHashes resolves a locked provider version like a {version}.json request and returns its known hashes
+func (s *Server) lockedVersionHashes(r *http.Request, p lockfile.Provider) (map[string]bool, error) {
+	parts := strings.Split(p.Address, "/")
+	if len(parts) != 3 {
+		return nil, badRequest("expected hostname/namespace/type")
+	}
+	hostname, err := s.checkHostname(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	namespace, name, err := s.resolveProvider(r, parts[1], parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkVersion(namespace, name, p.Version); err != nil {
+		return nil, err
+	}
+
+	data, _, err := s.versionDocument(r.Context(), hostname, namespace, name, p.Version)
+	if err != nil {
+		s.logger.Warn("failed to fetch locked version", "provider", namespace+"/"+name, "version", p.Version, "error", err)
+		return nil, err
+	}
+	var doc providersv1.MirrorVersionResponse
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, archive := range doc.Archives {
+		for _, h := range archive.Hashes {
+			known[h] = true
+		}
+	}
+	return known, nil
+}
+
+// handleListLockReports handles GET /admin/lock-reports — recent drift reports, newest first
+func (s *Server) handleListLockReports(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{"reports": s.lockReports.list()})
+}
+
+// handleGetLockReport handles GET /admin/lock-reports/{id}
+func (s *Server) handleGetLockReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.lockReports.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, notFound("lock report "+r.PathValue("id")+" not found"))
+		return
+	}
+	writeJSON(w, report)
+}
+
+// newLockReportID returns a report ID that sorts by creation time
+func newLockReportID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405.000") + "-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// Terraform's login.v1 client settings (see `terraform login`)
+const (
+	loginClientID  = "terraform-cli"
+	loginPortFirst = 10000
+	loginPortLast  = 10010
+
+	// loginCodeTTL is how long an authorization code may be exchanged for a token
+	loginCodeTTL     = time.Minute
+	maxPendingLogins = 1000
+)
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>tf-mirror login</title></head>
+<body>
+<h1>Log in to tf-mirror</h1>
+{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+<form method="post">
+<p>Paste a tenant token or a token issued by your mirror administrator.</p>
+<input type="password" name="token" size="60" autofocus>
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// loginCode is a pending authorization code of the login.v1 flow
+type loginCode struct {
+	tenant      string
+	subject     string
+	challenge   string
+	redirectURI string
+	expires     time.Time
+}
+
+// loginCodes holds authorization codes until they are exchanged or expire
+type loginCodes struct {
+	mu    sync.Mutex
+	codes map[string]loginCode
+}
+
+func newLoginCodes() *loginCodes {
+	return &loginCodes{codes: make(map[string]loginCode)}
+}
+
+func (l *loginCodes) add(c loginCode) (string, bool) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false
+	}
+	code := hex.EncodeToString(buf)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range l.codes {
+		if now.After(v.expires) {
+			delete(l.codes, k)
+		}
+	}
+	if len(l.codes) >= maxPendingLogins {
+		return "", false
+	}
+	l.codes[code] = c
+	return code, true
+}
+
+// take returns and removes a code; codes are single use
+func (l *loginCodes) take(code string) (loginCode, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.codes[code]
+	delete(l.codes, code)
+	if !ok || time.Now().After(c.expires) {
+		return loginCode{}, false
+	}
+	return c, true
+}
+
+// handleDiscovery handles GET /.well-known/terraform.json — Terraform service discovery
+// Advertises the Registry API of every protocol revision (when enabled), login.v1 and the token
+// endpoints used by the credentials helper
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+	services := map[string]any{}
+	if s.cfg.RegistryAPIEnabled {
+		for _, p := range protocols {
+			services[p.service] = base + p.prefix
+		}
+	}
+	if s.tokens != nil {
+		services["login.v1"] = map[string]any{
+			"client":      loginClientID,
+			"grant_types": []string{"authz_code"},
+			"authz":       base + "/oauth/authorization",
+			"token":       base + "/oauth/token",
+			"ports":       []int{loginPortFirst, loginPortLast},
+		}
+		services["tf-mirror.tokens.v1"] = base + "/api/tokens/"
+	}
+	writeJSON(w, services)
+}
+
+// handleLoginAuthorize handles GET and POST /oauth/authorization — the login.v1 authorization step
+// Clients with a tenant's certificate are approved at once; others paste a tenant or mirror token
+func (s *Server) handleLoginAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("invalid form"))
+		return
+	}
+	params := map[string]string{}
+	for _, key := range []string{"response_type", "client_id", "redirect_uri", "state", "code_challenge", "code_challenge_method"} {
+		params[key] = r.Form.Get(key)
+	}
+
+	if params["response_type"] != "code" || params["client_id"] != loginClientID {
+		writeError(w, badRequest("unsupported login client"))
+		return
+	}
+	if params["code_challenge"] == "" || params["code_challenge_method"] != "S256" {
+		writeError(w, badRequest("PKCE with S256 is required"))
+		return
+	}
+	if !validLoginRedirect(params["redirect_uri"]) {
+		writeError(w, badRequest("redirect_uri must be a localhost port Terraform listens on"))
+		return
+	}
+
+	var t *tenant.Tenant
+	var subject string
+	if identity := clientIdentity(r); identity != "" {
+		if t, _ = s.tenants.ByIdentity(identity); t != nil {
+			subject = identity
+		}
+	}
+	if t == nil && r.Method == http.MethodPost {
+		t, subject = s.credentialTenant(r.PostForm.Get("token"))
+		if t == nil {
+			s.logger.Warn("login refused", "client", s.logClient(r))
+			s.renderLogin(w, http.StatusUnauthorized, params, "The token was not accepted.")
+			return
+		}
+	}
+	if t == nil {
+		s.renderLogin(w, http.StatusOK, params, "")
+		return
+	}
+
+	code, ok := s.logins.add(loginCode{
+		tenant:      t.Name,
+		subject:     subject,
+		challenge:   params["code_challenge"],
+		redirectURI: params["redirect_uri"],
+		expires:     time.Now().Add(loginCodeTTL),
+	})
+	if !ok {
+		writeError(w, &apiError{status: http.StatusServiceUnavailable, code: codeOverloaded, message: "too many pending logins"})
+		return
+	}
+
+	redirect, _ := url.Parse(params["redirect_uri"])
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", params["state"])
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleLoginToken handles POST /oauth/token — exchanges an authorization code for a mirror token
+// Errors use the OAuth 2.0 format Terraform expects
+func (s *Server) handleLoginToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+
+	c, ok := s.logins.take(r.PostForm.Get("code"))
+	if !ok || r.PostForm.Get("client_id") != loginClientID || r.PostForm.Get("redirect_uri") != c.redirectURI {
+		writeOAuthError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+		writeOAuthError(w, "invalid_grant")
+		return
+	}
+
+	tok, claims, err := s.tokens.Issue(c.subject, c.tenant, 0)
+	if err != nil {
+		s.logger.Error("failed to issue token", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	s.logger.Info("issued login token", "tenant", c.tenant, "subject", s.logIdentity(c.subject), "expires", claims.ExpiresAt)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{
+		"access_token": tok,
+		"token_type":   "bearer",
+		"expires_in":   int(time.Until(claims.ExpiresAt).Seconds()),
+	})
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, status int, params map[string]string, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = loginTemplate.Execute(w, map[string]any{"Params": params, "Error": message})
+}
+
+// validLoginRedirect accepts the loopback callback URLs Terraform listens on
+func validLoginRedirect(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return false
+	}
+	port, err := strconv.Atoi(u.Port())
+	return err == nil && port >= loginPortFirst && port <= loginPortLast
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write([]byte(`{"error":"` + code + `"}`))
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// logLevels maps the names accepted by TF_MIRROR_LOG_LEVEL and PUT /admin/loglevel to levels
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// logLevelRequest — body of PUT /admin/loglevel
+type logLevelRequest struct {
+	Level string `json:"level"`
+	Actor string `json:"actor"`
+}
+
+// SetLogLevel lets the admin API change the verbosity of the logger passed to New at runtime
+// level must be the level of that logger's handler
+func (s *Server) SetLogLevel(level *slog.LevelVar) {
+	s.logLevel = level
+}
+
+// levelName returns the TF_MIRROR_LOG_LEVEL name of a level
+func levelName(level slog.Level) string {
+	for name, l := range logLevels {
+		if l == level {
+			return name
+		}
+	}
+	return level.String()
+}
+
+// handleAdminLogLevel handles GET /admin/loglevel — the current log level
+func (s *Server) handleAdminLogLevel(w http.ResponseWriter, _ *http.Request) {
+	if s.logLevel == nil {
+		writeError(w, notFound("log level cannot be changed at runtime"))
+		return
+	}
+	writeJSON(w, map[string]string{"level": levelName(s.logLevel.Level())})
+}
+
+// handleSetLogLevel handles PUT /admin/loglevel; body {"level": "debug", "actor": "..."}
+// The level applies until it is changed again or the mirror restarts with TF_MIRROR_LOG_LEVEL
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if s.logLevel == nil {
+		writeError(w, notFound("log level cannot be changed at runtime"))
+		return
+	}
+	var req logLevelRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, badRequest("invalid JSON body"))
+		return
+	}
+	level, ok := logLevels[req.Level]
+	if !ok {
+		writeError(w, badRequest("level must be debug, info, warn or error"))
+		return
+	}
+
+	// The change is logged while the more verbose of both levels applies
+	previous := s.logLevel.Level()
+	if level > previous {
+		s.logLogLevel(r, previous, level, req.Actor)
+		s.logLevel.Set(level)
+	} else {
+		s.logLevel.Set(level)
+		s.logLogLevel(r, previous, level, req.Actor)
+	}
+	writeJSON(w, map[string]string{"level": req.Level})
+}
+
+// logLogLevel records a change of the log level in the audit log
+func (s *Server) logLogLevel(r *http.Request, from, to slog.Level, actor string) {
+	s.logger.Warn("log level changed", "from", levelName(from), "to", levelName(to),
+		"actor", s.logIdentity(adminActor(r, actor)), "client", s.logClient(r))
+}
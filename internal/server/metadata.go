@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleProviderMetadata handles GET /api/providers/{hostname}/{namespace}/{name}/{version}
+// Extended upstream metadata (protocols, signing keys, shasum URLs) for tooling
+func (s *Server) handleProviderMetadata(w http.ResponseWriter, r *http.Request) {
+	hostname, err := s.checkHostname(r.PathValue("hostname"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	namespace, name, err := s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version := r.PathValue("version")
+
+	if err := s.checkVersion(namespace, name, version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	meta, err := s.registry.Metadata(r.Context(), namespace, name, version)
+	if err != nil {
+		s.logger.Error("failed to get provider metadata", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	meta.Hostname = hostname
+	writeJSON(w, meta)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+)
+
+// statusWriter records the status code and bytes written
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withMetrics records request counts, duration and bytes served per route
+func (s *Server) withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		route := "route:" + routeName(r.URL.Path)
+		s.metrics.Count(metrics.HTTPRequests, 1, route, "status:"+strconv.Itoa(sw.status))
+		s.metrics.Timing(metrics.HTTPDuration, time.Since(start), route)
+		s.metrics.Count(metrics.HTTPBytesServed, sw.bytes, route)
+	})
+}
+
+// routeName maps a request path to a low-cardinality route tag
+func routeName(path string) string {
+	switch {
+	case path == "/health":
+		return "health"
+	case strings.HasPrefix(path, "/admin/"):
+		return "admin"
+	case strings.HasPrefix(path, "/api/"):
+		return "api"
+	case strings.HasPrefix(path, "/docs/"), strings.HasPrefix(path, "/v2/provider-docs/"):
+		return "docs"
+	case !isProtocolPath(path):
+		return "other"
+	case strings.HasSuffix(path, "/index.json"):
+		return "index"
+	case strings.HasSuffix(path, ".json"):
+		return "version"
+	case strings.HasSuffix(path, ".json.sig"):
+		return "signature"
+	case strings.HasSuffix(path, ".zip"):
+		return "archive"
+	case strings.HasSuffix(path, "_SHA256SUMS"), strings.HasSuffix(path, "_SHA256SUMS.sig"):
+		return "shasums"
+	case strings.Contains(path, "/sha256/"):
+		return "checksum"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// mirrorPath holds the path parameters of a Mirror Protocol request
+// /v1/providers/{hostname}/{namespace}/{name}/{file}
+type mirrorPath struct {
+	hostname  string // normalized, see normalizeHostname
+	namespace string // after aliases and hooks
+	name      string
+	file      string // index.json, 3.6.0.json, *.json.sig, *.zip, or *_SHA256SUMS[.sig]
+}
+
+// mirrorHandler serves a Mirror Protocol request whose provider has been checked
+type mirrorHandler func(w http.ResponseWriter, r *http.Request, p mirrorPath)
+
+// mirrorRoute checks the hostname, provider, tenant and client of a Mirror Protocol request
+// and passes the resolved path to next
+func (s *Server) mirrorRoute(next mirrorHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.resolveMirrorPath(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		s.logger.Debug("provider request",
+			"hostname", p.hostname,
+			"namespace", p.namespace,
+			"name", p.name,
+			"file", p.file,
+		)
+
+		s.warnDeprecated(w, r, p.namespace, p.name, p.file)
+		next(w, r, p)
+	}
+}
+
+// resolveMirrorPath validates the path parameters of a Mirror Protocol request
+// and applies provider aliases and hooks
+func (s *Server) resolveMirrorPath(r *http.Request) (mirrorPath, error) {
+	file := r.PathValue("file")
+	if file == "" {
+		file = "index.json" // the index.json route has no {file} wildcard
+	}
+
+	// Normalize hostname so case and default port variants share cache entries
+	hostname, err := s.checkHostname(r.PathValue("hostname"))
+	if err != nil {
+		return mirrorPath{}, err
+	}
+
+	namespace, name, err := s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		return mirrorPath{}, err
+	}
+
+	if err := s.checkClient(r); err != nil {
+		return mirrorPath{}, err
+	}
+	return mirrorPath{hostname: hostname, namespace: namespace, name: name, file: file}, nil
+}
+
+// resolveProvider validates a provider address, applies aliases and the ResolveProvider hook,
+// and checks the caller's tenant may use the result
+func (s *Server) resolveProvider(r *http.Request, namespace, name string) (string, string, error) {
+	if err := checkProvider(namespace, name); err != nil {
+		return "", "", err
+	}
+
+	// Apply provider aliases so old and new names share cache entries
+	namespace, name = s.registry.Resolve(namespace, name)
+
+	namespace, name, err := s.hooks.ResolveProvider(r.Context(), r, namespace, name)
+	if err != nil {
+		return "", "", s.hookError(err)
+	}
+
+	if err := s.checkTenant(r, namespace, name); err != nil {
+		return "", "", err
+	}
+	return namespace, name, nil
+}
+
+// handleIndex handles GET index.json — available versions
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request, p mirrorPath) {
+	s.handleVersions(r.Context(), w, p.hostname, p.namespace, p.name)
+}
+
+// handleMirrorFile handles the remaining Mirror Protocol files by suffix
+// {version}.json, {file}.json.sig (with TF_MIRROR_SIGNING_KEY), *.zip and *_SHA256SUMS[.sig]
+func (s *Server) handleMirrorFile(w http.ResponseWriter, r *http.Request, p mirrorPath) {
+	ctx := r.Context()
+	file := p.file
+
+	// In origin mode every archive request, including those of peers and shard replicas,
+	// needs a signed URL or credentials
+	if strings.HasSuffix(file, ".zip") {
+		if err := s.checkArchiveSignature(r, p.namespace, p.name, file); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(file, ".json.sig"):
+		s.handleSignature(ctx, w, p.hostname, p.namespace, p.name, strings.TrimSuffix(file, ".sig"), s.omittedHashes(w, r))
+
+	case strings.HasSuffix(file, ".json"):
+		version := strings.TrimSuffix(file, ".json")
+		s.handleVersion(ctx, w, p.hostname, p.namespace, p.name, version, s.omittedHashes(w, r))
+
+	case strings.HasSuffix(file, ".zip"):
+		if err := s.authorizeDownload(r, p.namespace, p.name, file); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// Requests of other mirrors are counted where their client downloaded the archive
+		if s.fromPeer(r, upstream.ShardHeader) {
+			s.handleShardDownload(w, r, p.namespace, p.name, file)
+			return
+		}
+		if s.fromPeer(r, upstream.PeerHeader) {
+			s.handlePeerDownload(w, p.namespace, p.name, file)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		s.handleDownload(sw, r, p.namespace, p.name, file, s.redirectsToObjectStore(r))
+		s.recordDownload(r, sw, p.namespace, p.name, file)
+
+	case strings.HasSuffix(file, "_SHA256SUMS"), strings.HasSuffix(file, "_SHA256SUMS.sig"):
+		s.handleArtifact(ctx, w, p.namespace, p.name, file)
+
+	default:
+		writeError(w, badRequest("unknown file type"))
+	}
+}
+
+// handleInvalidMirrorPath answers /v1/providers/ paths that match no route
+func (s *Server) handleInvalidMirrorPath(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, badRequest("invalid path, expected "+providersv1.Prefix+"{hostname}/{namespace}/{type}/{file}"))
+}
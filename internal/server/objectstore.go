@@ -0,0 +1,16 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// redirectsToObjectStore reports whether a client is sent to presigned object store URLs for archives
+// (TF_MIRROR_OBJECT_STORE_REDIRECT); clients that cannot reach the bucket receive the bytes through the mirror
+func (s *Server) redirectsToObjectStore(r *http.Request) bool {
+	if len(s.redirectClients) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(clientIP(r))
+	return err == nil && containsAddr(s.redirectClients, addr)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
+)
+
+// Origin mode (TF_MIRROR_SIGNED_URL_SECRET)
+//
+// The mirror sits behind a CDN and only serves archives through URLs it handed out: the
+// archive URLs of {version}.json and registry download responses carry an HMAC signature
+// with an expiry, and archive requests without a valid one are refused. Requests with
+// credentials granting the read role (tenants, client certificates, the admin token) or a
+// download link (see downloadlinks.go) need no signature, so authenticated clients, replicas
+// and runners keep working.
+
+// signedPath is the part of an archive URL covered by its signature
+func signedPath(namespace, name, filename string) string {
+	return namespace + "/" + name + "/" + filename
+}
+
+// signArchiveURL appends the signature query to an archive URL in origin mode
+func (s *Server) signArchiveURL(rawURL, namespace, name, filename string) string {
+	if s.urlSigner == nil {
+		return rawURL
+	}
+	return rawURL + "?" + s.urlSigner.Sign(signedPath(namespace, name, filename), s.urlSigner.Expiry())
+}
+
+// archiveBaseURL returns the base of absolute archive URLs: the CDN URL, else the external URL
+// ("" for URLs relative to the metadata response)
+func (s *Server) archiveBaseURL() string {
+	if s.cfg.CDNURL != "" {
+		return s.cfg.CDNURL
+	}
+	return s.cfg.ExternalURL
+}
+
+// checkArchiveSignature refuses archive requests without a valid signature in origin mode
+func (s *Server) checkArchiveSignature(r *http.Request, namespace, name, filename string) error {
+	if s.urlSigner == nil {
+		return nil
+	}
+	if granted, _ := s.requestRole(r); granted >= roleRead {
+		return nil
+	}
+	if s.validDownloadLink(r, namespace, name, filename) {
+		return nil
+	}
+
+	err := s.urlSigner.Verify(signedPath(namespace, name, filename), r.URL.Query())
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, token.ErrExpired):
+		return policyDenied("archive URL has expired, fetch the version metadata again")
+	default:
+		s.logger.Warn("unsigned archive request refused", "provider", namespace+"/"+name, "file", filename, "client", s.logClient(r))
+		return policyDenied("archive URLs must be signed by the mirror")
+	}
+}
+
+// signatureLifetime returns how long the signed URL of a request remains valid;
+// ok is false outside origin mode or for requests without a signature
+func (s *Server) signatureLifetime(r *http.Request) (time.Duration, bool) {
+	if s.urlSigner == nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return max(time.Until(time.Unix(expires, 0)), 0), true
+}
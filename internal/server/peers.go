@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/fetcher"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// fromPeer reports whether a request carries a peer or shard header (upstream.PeerHeader,
+// upstream.ShardHeader) signed with the peer secret; other requests are client downloads
+func (s *Server) fromPeer(r *http.Request, header string) bool {
+	value := r.Header.Get(header)
+	if s.peerSigner == nil || value == "" {
+		return false
+	}
+	query, err := url.ParseQuery(value)
+	return err == nil && s.peerSigner.Verify(upstream.PeerSignedPath(header, r.URL.Path), query) == nil
+}
+
+// handlePeerDownload serves an archive to a sibling mirror from the archive cache only
+// Misses are answered with 404 instead of going upstream, so peers never fetch on each other's behalf
+func (s *Server) handlePeerDownload(w http.ResponseWriter, namespace, providerName, filename string) {
+	_, version, osName, arch, err := registry.ParseZipFilename(filename)
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+	filename = registry.ZipFilename(providerName, version, osName, arch)
+
+	if err := s.checkVersion(namespace, providerName, version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if s.archiveCache == nil {
+		writeError(w, notFound("archive cache is disabled"))
+		return
+	}
+	f, size, ok := s.archiveCache.Open(namespace, providerName, version, filename)
+	if !ok {
+		writeError(w, notFound(filename+" is not cached"))
+		return
+	}
+	defer f.Close()
+
+	s.logger.Debug("serving archive to peer", "file", filename)
+	serveArchive(w, f, size)
+}
+
+// handleShardDownload serves an archive another replica forwarded to this one as the owner of its provider
+// Misses are downloaded from upstream like client downloads, but never forwarded to a further replica
+func (s *Server) handleShardDownload(w http.ResponseWriter, r *http.Request, namespace, providerName, filename string) {
+	s.handleDownload(w, r.WithContext(fetcher.AsShardOwner(r.Context())), namespace, providerName, filename, false)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// versionBlock returns an error when a provider version may not be served:
+// tombstoned versions are gone, versions with advisories or not cached by a frozen mirror are denied
+func (s *Server) versionBlock(namespace, name, version string) error {
+	if ts, ok := s.tombstones.Get(namespace, name, version); ok {
+		return gone(namespace + "/" + name + " " + version + " has been withdrawn: " + ts.Reason)
+	}
+
+	if s.frozen() && !s.versionCached(namespace, name, version) {
+		return policyDenied(namespace + "/" + name + " " + version + " is not cached and the mirror is frozen")
+	}
+
+	if s.denyList == nil {
+		return nil
+	}
+	if advisory, ok := s.denyList.Check(namespace, name, version); ok {
+		msg := namespace + "/" + name + " " + version + " is blocked by " + advisory.Reference()
+		if advisory.Summary != "" {
+			msg += ": " + advisory.Summary
+		}
+		return policyDenied(msg)
+	}
+	return nil
+}
+
+// checkProvider rejects namespace and type segments that are not valid provider addresses
+func checkProvider(namespace, name string) error {
+	if err := registry.ValidateProvider(namespace, name); err != nil {
+		return badRequest(err.Error())
+	}
+	return nil
+}
+
+// checkVersion is versionBlock for a single request, logging refusals
+// Malformed versions are rejected before they reach upstream URLs or cache paths
+func (s *Server) checkVersion(namespace, name, version string) error {
+	if err := registry.ValidateVersion(version); err != nil {
+		return badRequest(err.Error())
+	}
+
+	err := s.versionBlock(namespace, name, version)
+	if err != nil {
+		s.logger.Warn("refused provider version", "provider", namespace+"/"+name, "version", version, "reason", err)
+	}
+	return err
+}
+
+// filterVersions removes versions that may not be served from an index.json response
+func (s *Server) filterVersions(namespace, name string, data []byte) []byte {
+	var resp providersv1.MirrorVersionsResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return data
+	}
+
+	removed := false
+	for version := range resp.Versions {
+		if s.versionBlock(namespace, name, version) != nil {
+			delete(resp.Versions, version)
+			removed = true
+		}
+	}
+	if !removed {
+		return data
+	}
+
+	filtered, err := json.Marshal(resp)
+	if err != nil {
+		return data
+	}
+	return filtered
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// protocol is a revision of the provider protocols, served under its own path prefix next to
+// the others, so the clients of one revision never see the responses of another
+type protocol struct {
+	service string          // service discovery ID of its registry protocol
+	prefix  string          // path prefix of its routes
+	routes  func(s *Server) // registers its routes on the public mux
+}
+
+// protocols are the served revisions; a later one (e.g. with OpenTofu extensions) is added here,
+// with a package for its paths and wire types like providersv1
+var protocols = []protocol{
+	{service: providersv1.Service, prefix: providersv1.Prefix, routes: (*Server).providersV1Routes},
+}
+
+// isProtocolPath reports whether a path belongs to a served protocol revision
+func isProtocolPath(path string) bool {
+	for _, p := range protocols {
+		if strings.HasPrefix(path, p.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// providersV1Routes registers the routes of the v1 registry and network mirror protocols
+func (s *Server) providersV1Routes() {
+	// Registry API for downstream mirrors (optional)
+	if s.cfg.RegistryAPIEnabled {
+		s.mux.HandleFunc(providersv1.VersionsRoute, s.handleRegistryVersions)
+		s.mux.HandleFunc(providersv1.DownloadRoute, s.handleRegistryDownload)
+	}
+
+	// Archive checksums for CI (non-standard)
+	s.mux.HandleFunc(providersv1.ChecksumRoute, s.handleChecksum)
+
+	// Mirror Protocol endpoints
+	s.mux.HandleFunc(providersv1.IndexRoute, s.mirrorRoute(s.handleIndex))
+	s.mux.HandleFunc(providersv1.FileRoute, s.mirrorRoute(s.handleMirrorFile))
+	s.mux.HandleFunc("GET "+providersv1.Prefix, s.handleInvalidMirrorPath)
+}
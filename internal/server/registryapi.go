@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// Registry API endpoints let another tf-mirror use this instance as its upstream
+// Download and SHA256SUMS URLs point back at this mirror, so archives are served from its cache
+
+// handleRegistryVersions handles GET /v1/providers/{namespace}/{name}/versions
+func (s *Server) handleRegistryVersions(w http.ResponseWriter, r *http.Request) {
+	namespace, name, err := s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp, err := s.registry.RegistryVersions(r.Context(), namespace, name)
+	if err != nil {
+		s.logger.Error("failed to get versions", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	// Withdrawn and denied versions are not offered downstream
+	filtered := providersv1.RegistryVersionsResponse{Versions: []providersv1.RegistryVersion{}}
+	for _, v := range resp.Versions {
+		if s.versionBlock(namespace, name, v.Version) == nil {
+			filtered.Versions = append(filtered.Versions, v)
+		}
+	}
+
+	writeJSON(w, filtered)
+}
+
+// handleRegistryDownload handles GET /v1/providers/{namespace}/{name}/{version}/download/{os}/{arch}
+func (s *Server) handleRegistryDownload(w http.ResponseWriter, r *http.Request) {
+	namespace, name, err := s.resolveProvider(r, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, osName, arch := r.PathValue("version"), r.PathValue("os"), r.PathValue("arch")
+
+	if err := s.checkVersion(namespace, name, version); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := registry.ValidatePlatform(osName, arch); err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+
+	info, err := s.registry.DownloadInfo(r.Context(), namespace, name, version, osName, arch)
+	if err != nil {
+		s.logger.Error("failed to get download info", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	// Archives are addressed under the first mirrored hostname
+	hostname, err := normalizeHostname(s.cfg.AllowedHostnames[0])
+	if err != nil {
+		writeError(w, internalError())
+		return
+	}
+
+	base := s.baseURL(r) + providersv1.MirrorPath(hostname, namespace, name, "")
+	shasums := registry.ShasumsFilename(name, version)
+	zip := registry.ZipFilename(name, version, osName, arch)
+	downloadURL := base + zip
+	if s.cfg.CDNURL != "" {
+		downloadURL = s.cfg.CDNURL + providersv1.MirrorPath(hostname, namespace, name, zip)
+	}
+
+	writeJSON(w, providersv1.RegistryDownloadResponse{
+		DownloadURL:         s.signArchiveURL(downloadURL, namespace, name, zip),
+		Filename:            zip,
+		SHA256Sum:           info.SHA256Sum,
+		ShasumsURL:          base + shasums,
+		ShasumsSignatureURL: base + shasums + ".sig",
+		SigningKeys:         info.SigningKeys,
+	})
+}
+
+// requestBaseURL returns the scheme and host the client used to reach the mirror
+// X-Forwarded-Proto and X-Forwarded-Host take precedence when a trusted proxy sent them
+func (s *Server) requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if !s.fromTrustedProxy(r) {
+		return scheme + "://" + host
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host, _, _ = strings.Cut(fwd, ",")
+		host = strings.TrimSpace(host)
+	}
+	return scheme + "://" + host
+}
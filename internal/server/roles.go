@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// role is what a caller may do; each role includes the ones before it
+type role int
+
+const (
+	roleNone    role = iota
+	roleRead         // mirror protocol and API
+	rolePublish      // also withdrawing and restoring versions (tombstones)
+	roleAdmin        // also cache management and the rest of the admin API
+)
+
+func (r role) String() string {
+	switch r {
+	case roleRead:
+		return "read"
+	case rolePublish:
+		return "publish"
+	case roleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// parseRole maps a client policy or tenant role to a role ("" is read)
+func parseRole(name string) role {
+	switch name {
+	case "", policyRead:
+		return roleRead
+	case policyPublish:
+		return rolePublish
+	case policyAdmin:
+		return roleAdmin
+	}
+	return roleNone
+}
+
+// rolesConfigured reports whether any credential carries a role:
+// the admin token, client policies or tenants
+// Without any, the admin API is disabled
+func (s *Server) rolesConfigured() bool {
+	return s.cfg.AdminToken != "" || len(s.cfg.ClientPolicies) > 0 || s.tenants != nil
+}
+
+// isAdminToken reports whether a request carries the admin token as its bearer token
+func (s *Server) isAdminToken(r *http.Request) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(s.cfg.AdminToken)) == 1
+}
+
+// requestRole returns the highest role of a request's credentials: the admin token,
+// the client certificate policy and the role of the tenant the request authenticates as
+// authenticated is false when the request carries no credentials at all
+func (s *Server) requestRole(r *http.Request) (granted role, authenticated bool) {
+	if s.isAdminToken(r) {
+		return roleAdmin, true
+	}
+
+	identity := clientIdentity(r)
+	if identity != "" {
+		granted = parseRole(s.clientPolicy(identity))
+	}
+	if s.tenants != nil {
+		if t, ok := s.requestTenant(r); ok {
+			granted = max(granted, parseRole(t.Role))
+		}
+	}
+	return granted, strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || identity != ""
+}
+
+// requireRole refuses requests whose credentials lack a role
+// Unauthenticated requests get 401, authenticated ones with a lesser role 403
+// Without configured roles, read routes are open and the others refused with 403
+func (s *Server) requireRole(need role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.rolesConfigured() {
+			if need > roleRead {
+				writeError(w, policyDenied("the admin API is disabled, set TF_MIRROR_ADMIN_TOKEN"))
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		granted, authenticated := s.requestRole(r)
+		if granted >= need {
+			next(w, r)
+			return
+		}
+		if !authenticated {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tf-mirror admin"`)
+			writeError(w, unauthorized())
+			return
+		}
+		s.logger.Warn("request refused for role", "client", s.logClient(r), "role", granted.String(), "required", need.String(), "method", r.Method, "path", r.URL.Path)
+		writeError(w, policyDenied("this endpoint requires the "+need.String()+" role"))
+	}
+}
+
+// adminOnly requires the admin role: the admin token, an admin client certificate or an admin tenant
+func (s *Server) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireRole(roleAdmin, next)
+}
+
+// publishOnly requires the publish role, for routes that change which versions are served
+func (s *Server) publishOnly(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireRole(rolePublish, next)
+}
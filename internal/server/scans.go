@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/scan"
+)
+
+// releaseRequest — body of POST /admin/scans/.../release
+type releaseRequest struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+}
+
+// handleListScans handles GET /admin/scans?status=passed|quarantined|released — scan results
+func (s *Server) handleListScans(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", scan.StatusPassed, scan.StatusQuarantined, scan.StatusReleased:
+	default:
+		writeError(w, badRequest("status must be passed, quarantined or released"))
+		return
+	}
+	writeJSON(w, map[string]any{"scans": s.scans.List(status)})
+}
+
+// handleGetScan handles GET /admin/scans/{namespace}/{name}/{version}/{platform}
+func (s *Server) handleGetScan(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, platform, ok := s.parseScanPath(w, r)
+	if !ok {
+		return
+	}
+	result, ok := s.scans.Get(namespace, name, version, platform)
+	if !ok {
+		writeError(w, notFound(namespace+"/"+name+" "+version+" "+platform+" has not been scanned"))
+		return
+	}
+	writeJSON(w, result)
+}
+
+// handleScanReport handles GET /admin/scans/{namespace}/{name}/{version}/{platform}/report —
+// the scanner output of the latest scan as produced, e.g. findings or an SBOM
+func (s *Server) handleScanReport(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, platform, ok := s.parseScanPath(w, r)
+	if !ok {
+		return
+	}
+	result, scanned := s.scans.Get(namespace, name, version, platform)
+	report, ok := s.scans.Report(namespace, name, version, platform)
+	if !scanned || !ok {
+		writeError(w, notFound(namespace+"/"+name+" "+version+" "+platform+" has no scan report"))
+		return
+	}
+	w.Header().Set("Content-Type", result.ReportType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(report)
+}
+
+// handleReleaseScan handles POST /admin/scans/{namespace}/{name}/{version}/{platform}/release
+// The quarantined archive is served from its next download on, without being scanned again
+func (s *Server) handleReleaseScan(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, platform, ok := s.parseScanPath(w, r)
+	if !ok {
+		return
+	}
+	var req releaseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeError(w, badRequest("invalid JSON body"))
+			return
+		}
+	}
+	if req.Reason == "" {
+		writeError(w, badRequest("reason is required"))
+		return
+	}
+	req.Actor = adminActor(r, req.Actor)
+
+	result, released, err := s.scans.Release(namespace, name, version, platform, req.Reason, req.Actor)
+	if err != nil {
+		s.logger.Error("failed to release archive", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	if !released {
+		writeError(w, notFound(namespace+"/"+name+" "+version+" "+platform+" is not quarantined"))
+		return
+	}
+
+	s.logger.Warn("quarantined archive released", "provider", namespace+"/"+name, "version", version, "platform", platform, "actor", s.logIdentity(req.Actor), "client", s.logClient(r), "reason", req.Reason)
+	writeJSON(w, result)
+}
+
+// handleForgetScan handles DELETE /admin/scans/{namespace}/{name}/{version}/{platform}
+// The next download of the archive is scanned again, e.g. after the scanner's rules were updated
+func (s *Server) handleForgetScan(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, platform, ok := s.parseScanPath(w, r)
+	if !ok {
+		return
+	}
+	forgotten, err := s.scans.Forget(namespace, name, version, platform)
+	if err != nil {
+		s.logger.Error("failed to remove scan result", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	if !forgotten {
+		writeError(w, notFound(namespace+"/"+name+" "+version+" "+platform+" has not been scanned"))
+		return
+	}
+
+	s.logger.Info("scan result removed", "provider", namespace+"/"+name, "version", version, "platform", platform, "client", s.logClient(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseScanPath reads the archive of a scan from the path
+func (s *Server) parseScanPath(w http.ResponseWriter, r *http.Request) (namespace, name, version, platform string, ok bool) {
+	namespace, name = r.PathValue("namespace"), r.PathValue("name")
+	version, platform = r.PathValue("version"), r.PathValue("platform")
+
+	osName, arch, _ := strings.Cut(platform, "_")
+	err := registry.ValidateProvider(namespace, name)
+	if err == nil {
+		err = registry.ValidateVersion(version)
+	}
+	if err == nil {
+		err = registry.ValidatePlatform(osName, arch)
+	}
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return "", "", "", "", false
+	}
+	namespace, name = s.registry.Resolve(namespace, name)
+	return namespace, name, version, platform, true
+}
+
+// annotateScans adds the scan status of each archive to an inventory
+func (s *Server) annotateScans(items []inventory.Item) {
+	if s.scans == nil {
+		return
+	}
+	for i, it := range items {
+		if result, ok := s.scans.Get(it.Namespace, it.Name, it.Version, it.Platform); ok {
+			items[i].Scan = result.Status
+		}
+	}
+}
@@ -3,29 +3,151 @@ package server
 import (
 	"context"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/scinfra-pro/terraform-mirror/internal/buildinfo"
 	"github.com/scinfra-pro/terraform-mirror/internal/cache"
 	"github.com/scinfra-pro/terraform-mirror/internal/config"
+	"github.com/scinfra-pro/terraform-mirror/internal/fetcher"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/hooks"
+	"github.com/scinfra-pro/terraform-mirror/internal/jobs"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/objectstore"
+	"github.com/scinfra-pro/terraform-mirror/internal/oidc"
+	"github.com/scinfra-pro/terraform-mirror/internal/policy"
+	"github.com/scinfra-pro/terraform-mirror/internal/prefetch"
 	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/replica"
+	"github.com/scinfra-pro/terraform-mirror/internal/scan"
+	"github.com/scinfra-pro/terraform-mirror/internal/signing"
+	"github.com/scinfra-pro/terraform-mirror/internal/spool"
+	"github.com/scinfra-pro/terraform-mirror/internal/stats"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
 	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
 )
 
+// staleSpoolAge is the age after which spool files are considered abandoned
+const staleSpoolAge = time.Hour
+
 // Server represents the HTTP server
 type Server struct {
-	cfg       *config.Config
-	logger    *slog.Logger
-	mux       *http.ServeMux
-	registry  *registry.Registry
-	upstream  *upstream.Client
-	hashCache *cache.HashCache
+	cfg           *config.Config
+	logger        *slog.Logger
+	mux           *http.ServeMux
+	adminMux      *http.ServeMux // separate admin listener; nil when admin routes are on mux
+	registry      *registry.Registry
+	upstream      *upstream.Client
+	hashCache     *cache.HashCache
+	artifactCache *cache.ArtifactCache
+	archiveCache  *cache.ArchiveCache
+	fetcher       *fetcher.Fetcher
+	docs          *registry.Docs
+	prefetcher    *prefetch.Scheduler
+	jobs          *jobs.Manager
+	hashWorker    *fetcher.HashWorker
+	replicator    *replica.Replicator
+	metrics       metrics.Recorder
+	denyList      *policy.DenyList
+	deprecations  *policy.Deprecations // nil when no deprecations are configured
+	clientRules   *policy.ClientRules  // nil when no client rules are configured
+	tombstones    *policy.Tombstones
+	scans         *scan.Store // nil when archives are not scanned
+	stats         *stats.Store
+	hooks         *hooks.Chain
+	tenants       *tenant.Set       // nil when tenants are not configured
+	state         *cache.StateStore // nil when warm restarts are disabled
+	tokens        *token.Issuer     // nil when mirror tokens are disabled
+	urlSigner     *token.URLSigner  // nil outside origin mode
+	linkSigner    *token.URLSigner  // nil when download links are disabled
+	peerSigner    *token.URLSigner  // nil without a peer secret
+	oidcVerifier  *oidc.Verifier    // nil when JWTs are not accepted
+	logins        *loginCodes
+	signer        *signing.Signer // nil when response signing is disabled
+	anonymizer    *anonymizer     // nil when client data is logged as is
+
+	// Freeze switch (TF_MIRROR_FREEZE or /admin/freeze)
+	freeze mirrorFreeze
+
+	// Recent lock file drift reports (POST /api/lock-reports)
+	lockReports lockReports
+
+	// Version lists are served as of this time when set (TF_MIRROR_SNAPSHOT)
+	snapshot time.Time
+
+	allowedHosts map[string]struct{}
+
+	// Proxies whose forwarding headers name the client (TF_MIRROR_TRUSTED_PROXIES)
+	trustedProxies []netip.Prefix
+
+	// Client networks allowed to use the mirror and the admin API (TF_MIRROR_*ALLOW_CIDRS, TF_MIRROR_*DENY_CIDRS)
+	publicACL networkACL
+	adminACL  networkACL
+
+	// Clients sent to presigned object store URLs for archives (TF_MIRROR_OBJECT_STORE_REDIRECT)
+	redirectClients []netip.Prefix
+
+	// Listening sockets passed by systemd socket activation (nil when not socket-activated)
+	sockets *systemdSockets
+
+	// Optional features enabled on this instance (GET /version)
+	features []string
+
+	// Open client connections (for metrics)
+	activeConns int64
+
+	// Level of the logger, changed by PUT /admin/loglevel (nil when it cannot be changed)
+	logLevel *slog.LevelVar
 }
 
 // New creates a new server
 func New(cfg *config.Config, logger *slog.Logger) *Server {
-	upstreamClient, err := upstream.New(cfg.UpstreamURL, cfg.UpstreamTimeout, cfg.SOCKS5Addr)
+	recorder, err := metrics.New(cfg.MetricsExporter, cfg.StatsDAddr, cfg.MetricsPrefix)
+	if err != nil {
+		logger.Error("failed to create metrics exporter", "error", err)
+		panic(err)
+	}
+	if cfg.MetricsExporter != "" && cfg.MetricsExporter != "none" {
+		logger.Info("metrics exporter enabled", "exporter", cfg.MetricsExporter, "addr", cfg.StatsDAddr)
+	}
+
+	upstreamClient, err := upstream.New(upstream.Options{
+		BaseURL:          cfg.UpstreamURL,
+		Timeout:          cfg.UpstreamTimeout,
+		DownloadTimeout:  cfg.DownloadTimeout,
+		SOCKS5Addr:       cfg.SOCKS5Addr,
+		IPFamily:         cfg.UpstreamIPFamily,
+		DialTimeout:      cfg.UpstreamDialTimeout,
+		FallbackDelay:    cfg.UpstreamFallback,
+		MaxConns:         cfg.UpstreamMaxConns,
+		MaxIdleConns:     cfg.UpstreamMaxIdle,
+		TLSSessions:      cfg.UpstreamTLSSessions,
+		HTTP2:            cfg.UpstreamHTTP2,
+		DNSServers:       cfg.UpstreamDNS,
+		DoHURL:           cfg.UpstreamDoH,
+		DownloadHosts:    cfg.DownloadAllowedHosts,
+		UserAgent:        cfg.UserAgent,
+		Headers:          cfg.UpstreamHeaders,
+		Token:            cfg.UpstreamToken,
+		Username:         cfg.UpstreamUsername,
+		Password:         cfg.UpstreamPassword,
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+		RateLimit:        cfg.UpstreamRateLimit,
+		RateBurst:        cfg.UpstreamRateBurst,
+		RateLimitWait:    cfg.UpstreamRateWait,
+		Metrics:          recorder,
+		PeerSecret:       cfg.PeerSecret,
+		RecordDir:        cfg.UpstreamRecordDir,
+		ReplayDir:        cfg.UpstreamReplayDir,
+	})
 	if err != nil {
 		logger.Error("failed to create upstream client", "error", err)
 		panic(err)
@@ -34,18 +156,403 @@ func New(cfg *config.Config, logger *slog.Logger) *Server {
 	if cfg.SOCKS5Addr != "" {
 		logger.Info("SOCKS5 proxy enabled", "addr", cfg.SOCKS5Addr)
 	}
+	if len(cfg.UpstreamDNS) > 0 {
+		logger.Info("resolving upstream hosts with custom DNS servers", "servers", cfg.UpstreamDNS)
+	}
+	if cfg.UpstreamDoH != "" {
+		logger.Info("resolving upstream hosts with DNS-over-HTTPS", "url", cfg.UpstreamDoH)
+	}
+	if cfg.UpstreamRecordDir != "" {
+		logger.Warn("recording upstream responses", "dir", cfg.UpstreamRecordDir)
+	}
+	if cfg.UpstreamReplayDir != "" {
+		logger.Warn("replaying recorded upstream responses, the network is not used", "dir", cfg.UpstreamReplayDir)
+	}
+
+	allowedHosts, err := hostnameSet(cfg.AllowedHostnames)
+	if err != nil {
+		logger.Error("invalid allowed hostnames", "error", err)
+		panic(err)
+	}
 
+	trustedProxies, err := parsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		logger.Error("invalid trusted proxies", "error", err)
+		panic(err)
+	}
+	publicACL, err := newNetworkACL(cfg.AllowCIDRs, cfg.DenyCIDRs)
+	if err != nil {
+		logger.Error("invalid network ACL", "error", err)
+		panic(err)
+	}
+	adminACL, err := newNetworkACL(cfg.AdminAllowCIDRs, cfg.AdminDenyCIDRs)
+	if err != nil {
+		logger.Error("invalid admin network ACL", "error", err)
+		panic(err)
+	}
+	redirectClients, err := parsePrefixes(cfg.ObjectStoreRedirect)
+	if err != nil {
+		logger.Error("invalid object store redirect clients", "error", err)
+		panic(err)
+	}
+
+	// Spool directory: create it and remove files left behind by crashed processes
+	if cfg.TmpDir != "" {
+		if err := os.MkdirAll(cfg.TmpDir, 0755); err != nil {
+			logger.Error("failed to create temp directory", "dir", cfg.TmpDir, "error", err)
+			panic(err)
+		}
+	}
+	if removed, err := spool.Cleanup(cfg.TmpDir, staleSpoolAge); err != nil {
+		logger.Warn("failed to clean up spool files", "error", err)
+	} else if removed > 0 {
+		logger.Info("removed stale spool files", "count", removed)
+	}
+
+	// Peers and shard owners are asked for archives under the first mirrored hostname
+	var peerHostname string
+	if len(cfg.Peers) > 0 || len(cfg.ShardNodes) > 0 {
+		peerHostname, err = normalizeHostname(cfg.AllowedHostnames[0])
+		if err != nil {
+			logger.Error("invalid allowed hostnames", "error", err)
+			panic(err)
+		}
+	}
+	if len(cfg.Peers) > 0 {
+		logger.Info("peer cache lookup enabled", "peers", cfg.Peers)
+	}
+	if len(cfg.ShardNodes) > 0 {
+		logger.Info("provider sharding enabled", "nodes", cfg.ShardNodes, "self", cfg.ShardSelf)
+	}
+
+	cache.SetFsync(cfg.CacheFsync)
 	hashCache := cache.NewHashCache(cfg.CacheDir)
-	reg := registry.New(upstreamClient, hashCache, logger)
+	indexStart := time.Now()
+	if err := hashCache.Load(); err != nil {
+		logger.Error("failed to index hash cache", "error", err)
+		panic(err)
+	}
+	if n := hashCache.Migrated(); n > 0 {
+		logger.Info("migrated hash files to metadata database", "hashes", n, "file", filepath.Join(cfg.CacheDir, cache.MetadataFile))
+	}
+	if n := hashCache.Dropped(); n > 0 {
+		logger.Warn("discarded invalid hashes", "hashes", n, "file", filepath.Join(cfg.CacheDir, cache.MetadataFile))
+	}
+	logger.Info("indexed hash cache", "hashes", hashCache.Count(), "duration", time.Since(indexStart).Round(time.Millisecond))
+	artifactCache := cache.NewArtifactCache(cfg.CacheDir)
+	reg := registry.New(upstreamClient, hashCache, artifactCache, cfg.ProviderAliases, logger)
+	reg.SetMetrics(recorder)
+	if err := reg.UseUpstreamType(cfg.UpstreamType, cfg.UpstreamRepo); err != nil {
+		logger.Error("invalid upstream type", "error", err)
+		panic(err)
+	}
+	reg.UseGitHubReleases(cfg.GitHubAPIURL, cfg.GitHubToken, cfg.GitHubProviders)
+	if err := reg.UseOCIRepositories(cfg.OCIProviders, cfg.OCIUsername, cfg.OCIPassword); err != nil {
+		logger.Error("invalid OCI providers", "error", err)
+		panic(err)
+	}
+	reg.SetDownloadCacheTTL(cfg.DownloadURLTTL)
+	reg.SetShasumsRetry(cfg.ShasumsRetry)
+	reg.SetVersionCacheTTL(cfg.VersionCacheTTL)
+	reg.SetNormalized(cfg.NormalizeArchives)
+	hash.SetConcurrency(cfg.HashConcurrency)
+
+	// Version list history; a pinned mirror reads it even when recording is disabled
+	var snapshot time.Time
+	if cfg.Snapshot != "" {
+		snapshot, err = registry.ParseSnapshot(cfg.Snapshot)
+		if err != nil {
+			logger.Error("invalid TF_MIRROR_SNAPSHOT", "error", err)
+			panic(err)
+		}
+		logger.Info("serving version lists from snapshot", "at", snapshot.Format(time.RFC3339))
+	}
+	if cfg.SnapshotsEnabled || cfg.Snapshot != "" {
+		reg.SetSnapshots(cache.NewSnapshotStore(cfg.CacheDir))
+	}
+	if len(cfg.GitHubProviders) > 0 {
+		logger.Info("serving providers from GitHub releases", "providers", cfg.GitHubProviders)
+	}
+	if len(cfg.OCIProviders) > 0 {
+		logger.Info("serving providers from OCI registries", "providers", cfg.OCIProviders)
+	}
+
+	var tenants *tenant.Set
+	if cfg.TenantsFile != "" {
+		tenants, err = tenant.Load(cfg.TenantsFile)
+		if err != nil {
+			logger.Error("failed to load tenants", "error", err)
+			panic(err)
+		}
+		logger.Info("multi-tenancy enabled", "tenants", len(tenants.Tenants()))
+	}
+
+	// Mirror tokens authenticate tenants, so they need a tenants file
+	var tokens *token.Issuer
+	if cfg.TokenSecret != "" {
+		if tenants == nil {
+			logger.Warn("TF_MIRROR_TOKEN_SECRET is ignored without TF_MIRROR_TENANTS_FILE")
+		} else {
+			tokens = token.NewIssuer(cfg.TokenSecret, cfg.TokenTTL, cfg.TokenMaxAge)
+		}
+	}
+
+	// OIDC JWTs authenticate tenants by subject, so they need a tenants file too
+	var oidcVerifier *oidc.Verifier
+	if cfg.OIDCIssuer != "" {
+		if tenants == nil {
+			logger.Warn("TF_MIRROR_OIDC_ISSUER is ignored without TF_MIRROR_TENANTS_FILE")
+		} else {
+			oidcVerifier = oidc.NewVerifier(oidc.Options{
+				Issuer:   cfg.OIDCIssuer,
+				Audience: cfg.OIDCAudience,
+				JWKSURL:  cfg.OIDCJWKSURL,
+				KeysTTL:  cfg.OIDCJWKSTTL,
+			})
+			logger.Info("OIDC authentication enabled", "issuer", cfg.OIDCIssuer, "audience", cfg.OIDCAudience, "claim", cfg.OIDCClaim)
+		}
+	}
+
+	// Origin mode: archive URLs are signed and unsigned archive requests refused
+	var urlSigner *token.URLSigner
+	if cfg.SignedURLSecret != "" {
+		urlSigner = token.NewURLSigner(cfg.SignedURLSecret, cfg.SignedURLTTL)
+		logger.Info("origin mode enabled", "cdn_url", cfg.CDNURL, "ttl", cfg.SignedURLTTL)
+	}
+	var linkSigner *token.URLSigner
+	if cfg.DownloadLinkSecret != "" {
+		linkSigner = token.NewURLSigner(cfg.DownloadLinkSecret, cfg.DownloadLinkTTL)
+	}
+	// Peer and shard requests are signed by the upstream client; the server only verifies them
+	var peerSigner *token.URLSigner
+	if cfg.PeerSecret != "" {
+		peerSigner = token.NewURLSigner(cfg.PeerSecret, 0)
+	}
+
+	var signer *signing.Signer
+	if cfg.SigningKey != "" {
+		signer, err = signing.Load(cfg.SigningKey)
+		if err != nil {
+			logger.Error("failed to load signing key", "error", err)
+			panic(err)
+		}
+		logger.Info("response signing enabled", "key_id", signer.KeyID())
+	}
+
+	anonymizer, err := newAnonymizer(cfg.LogAnonymize, cfg.LogAnonymizeSecret)
+	if err != nil {
+		logger.Error("failed to set up log anonymization", "error", err)
+		panic(err)
+	}
+
+	// Archives are stored on disk only when caching is enabled
+	var archiveCache *cache.ArchiveCache
+	if cfg.CacheEnabled {
+		archiveCache = cache.NewArchiveCache(cfg.CacheDir)
+		archiveCache.SetLimits(cfg.CacheMaxSize, cfg.NamespaceQuotas)
+		archiveCache.SetMinFree(cfg.CacheMinFree)
+		if tenants != nil {
+			archiveCache.SetTenantQuotas(tenants.Quotas())
+		}
+		if cfg.ObjectStoreURL != "" {
+			store, err := objectstore.New(objectstore.Options{
+				URL:       cfg.ObjectStoreURL,
+				Region:    cfg.ObjectStoreRegion,
+				AccessKey: cfg.ObjectStoreAccessKey,
+				SecretKey: cfg.ObjectStoreSecret,
+				Timeout:   cfg.ObjectStoreTimeout,
+			})
+			if err != nil {
+				logger.Error("invalid object store", "error", err)
+				panic(err)
+			}
+			archiveCache.SetObjectStore(store, logger)
+			logger.Info("object store tier enabled", "url", store.String())
+		}
+		if cfg.CacheEncryptionKey != "" || cfg.CacheKeyCommand != "" {
+			key, err := cache.LoadEncryptionKey(cfg.CacheEncryptionKey, cfg.CacheKeyCommand)
+			if err == nil {
+				err = archiveCache.SetEncryption(key, cfg.CacheEncryption, logger)
+			}
+			if err != nil {
+				logger.Error("failed to set up cache encryption", "error", err)
+				panic(err)
+			}
+			logger.Info("cache encryption enabled", "namespaces", strings.Join(cfg.CacheEncryption, ","))
+		}
+	}
+
+	// Archive scanning, with verdicts and reports kept in the cache directory
+	var scanner *scan.Scanner
+	var scans *scan.Store
+	if cfg.ScanCommand != "" || cfg.ScanURL != "" {
+		scanner, err = scan.New(scan.Options{
+			Command: strings.Fields(cfg.ScanCommand),
+			URL:     cfg.ScanURL,
+			Timeout: cfg.ScanTimeout,
+			TempDir: cfg.TmpDir,
+		})
+		if err != nil {
+			logger.Error("invalid archive scanner", "error", err)
+			panic(err)
+		}
+		scans, err = scan.NewStore(filepath.Join(cfg.CacheDir, "scans"))
+		if err != nil {
+			logger.Error("failed to load scan results", "error", err)
+			panic(err)
+		}
+		logger.Info("archive scanning enabled", "scanner", scanner.String(), "quarantined", len(scans.List(scan.StatusQuarantined)))
+	}
 
 	s := &Server{
-		cfg:       cfg,
-		logger:    logger,
-		mux:       http.NewServeMux(),
-		registry:  reg,
-		upstream:  upstreamClient,
-		hashCache: hashCache,
+		cfg:           cfg,
+		logger:        logger,
+		mux:           http.NewServeMux(),
+		registry:      reg,
+		upstream:      upstreamClient,
+		hashCache:     hashCache,
+		artifactCache: artifactCache,
+		archiveCache:  archiveCache,
+		fetcher: fetcher.New(upstreamClient, reg, hashCache, archiveCache, fetcher.Options{
+			Concurrency:      cfg.FetchConcurrency,
+			Retries:          cfg.FetchRetries,
+			SpoolDir:         cfg.TmpDir,
+			SpoolMemoryLimit: cfg.SpoolMemoryLimit,
+			SpoolMinFree:     cfg.TmpMinFree,
+			RequireHash:      cfg.RequireHash,
+			CheckArchives:    cfg.CheckArchives,
+			JobTimeout:       cfg.DownloadTimeout,
+			MaxDownloads:     cfg.MaxDownloads,
+			QueueDepth:       cfg.DownloadQueueDepth,
+			Metrics:          recorder,
+			Peers:            cfg.Peers,
+			PeerHostname:     peerHostname,
+			ShardNodes:       cfg.ShardNodes,
+			ShardSelf:        cfg.ShardSelf,
+			Scanner:          scanner,
+			ScanResults:      scans,
+			URLSigner:        urlSigner,
+		}, logger),
+		metrics: recorder,
+		tenants: tenants,
+		tokens:  tokens,
+		logins:  newLoginCodes(),
+		signer:  signer,
+		scans:   scans,
+
+		anonymizer: anonymizer,
+		urlSigner:  urlSigner,
+		linkSigner: linkSigner,
+		peerSigner: peerSigner,
+
+		oidcVerifier: oidcVerifier,
+
+		snapshot: snapshot,
+		docs:     registry.NewDocs(upstreamClient, artifactCache, cache.NewDocCache(cfg.CacheDir), logger),
+
+		allowedHosts:    allowedHosts,
+		trustedProxies:  trustedProxies,
+		publicACL:       publicACL,
+		adminACL:        adminACL,
+		redirectClients: redirectClients,
+	}
+	tombstones, err := policy.NewTombstones(filepath.Join(cfg.CacheDir, "tombstones"))
+	if err != nil {
+		logger.Error("failed to load tombstones", "error", err)
+		panic(err)
+	}
+	s.tombstones = tombstones
+
+	if !s.rolesConfigured() {
+		logger.Warn("admin API is disabled, set TF_MIRROR_ADMIN_TOKEN")
+	}
+
+	s.sockets, err = inheritSystemdSockets()
+	if err != nil {
+		logger.Error("failed to inherit sockets from systemd", "error", err)
+		panic(err)
 	}
+	if s.sockets != nil {
+		logger.Info("using sockets from systemd socket activation", "sockets", len(s.sockets.public), "admin_sockets", len(s.sockets.admin))
+	}
+
+	if cfg.StateInterval > 0 {
+		s.state = cache.NewStateStore(cfg.CacheDir)
+		s.restoreState()
+	}
+	s.restoreFreeze()
+
+	if cfg.StatsEnabled {
+		if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+			logger.Error("failed to create cache directory", "dir", cfg.CacheDir, "error", err)
+			panic(err)
+		}
+		// The database is locked by one process; replicas sharing the cache directory run without stats
+		store, err := stats.Open(filepath.Join(cfg.CacheDir, "stats.db"), cfg.StatsRetention, logger)
+		if err != nil {
+			logger.Warn("download stats disabled", "error", err)
+		} else {
+			s.stats = store
+		}
+	}
+
+	if cfg.DenyList != "" {
+		s.denyList = policy.NewDenyList(cfg.DenyList, logger)
+		if err := s.denyList.Load(context.Background()); err != nil {
+			logger.Error("failed to load deny-list", "source", cfg.DenyList, "error", err)
+			panic(err)
+		}
+	}
+
+	if cfg.DeprecationsFile != "" {
+		s.deprecations, err = policy.LoadDeprecations(cfg.DeprecationsFile)
+		if err != nil {
+			logger.Error("failed to load deprecations", "file", cfg.DeprecationsFile, "error", err)
+			panic(err)
+		}
+		logger.Info("deprecations loaded", "file", cfg.DeprecationsFile, "deprecations", s.deprecations.Len())
+	}
+
+	if cfg.ClientRulesFile != "" {
+		s.clientRules, err = policy.LoadClientRules(cfg.ClientRulesFile)
+		if err != nil {
+			logger.Error("failed to load client rules", "file", cfg.ClientRulesFile, "error", err)
+			panic(err)
+		}
+		logger.Info("client rules loaded", "file", cfg.ClientRulesFile, "rules", s.clientRules.Len())
+	}
+
+	// Hooks compiled into the binary
+	registered := hooks.Registered()
+	for _, h := range registered {
+		logger.Info("hook enabled", "name", h.Name())
+	}
+	s.hooks = hooks.NewChain(registered)
+
+	s.features = enabledFeatures(cfg, registered)
+	build := buildinfo.Get()
+	logger.Info("build info", "version", build.Version, "commit", build.Commit, "date", build.Date, "go", build.GoVersion, "features", s.features)
+
+	if cfg.PrefetchFile != "" {
+		s.prefetcher = prefetch.NewScheduler(s.fetcher, reg, cfg.PrefetchFile, cfg.PrefetchPlatforms, cfg.PrefetchInterval, logger)
+	}
+
+	// Admin jobs (verification, GC, prefetch, export) with a history in the metadata database
+	s.jobs, err = jobs.NewManager(cache.NewJobStore(cfg.CacheDir), cfg.JobHistory, logger)
+	if err != nil {
+		logger.Error("failed to load job history", "error", err)
+		panic(err)
+	}
+
+	if archiveCache != nil && cfg.HashWorkers > 0 {
+		s.hashWorker = fetcher.NewHashWorker(s.fetcher, cfg.HashWorkers, cfg.HashWorkerInterval, logger)
+	}
+
+	if cfg.ReplicateEnabled {
+		s.replicator = replica.New(upstreamClient, s.fetcher, hashCache, cfg.ReplicateToken, cfg.ReplicateInterval, logger)
+		logger.Info("replicating from upstream mirror", "upstream", cfg.UpstreamURL, "interval", cfg.ReplicateInterval)
+	}
+
 	s.setupRoutes()
 	return s
 }
@@ -53,83 +560,202 @@ func New(cfg *config.Config, logger *slog.Logger) *Server {
 // setupRoutes configures the routes
 func (s *Server) setupRoutes() {
 	s.mux.HandleFunc("GET /health", s.handleHealth)
+	s.mux.HandleFunc("GET /version", s.handleBuildInfo)
 
-	// Mirror Protocol endpoints
-	// /v1/providers/{hostname}/{namespace}/{type}/...
-	s.mux.HandleFunc("GET /v1/providers/", s.handleProviders)
-}
-
-// handleProviders handles Mirror Protocol requests
-// /v1/providers/{hostname}/{namespace}/{type}/index.json
-// /v1/providers/{hostname}/{namespace}/{type}/{version}.json
-// /v1/providers/{hostname}/{namespace}/{type}/*.zip
-func (s *Server) handleProviders(w http.ResponseWriter, r *http.Request) {
-	// Parse path: /v1/providers/{hostname}/{namespace}/{type}/{file}
-	path := strings.TrimPrefix(r.URL.Path, "/v1/providers/")
-	parts := strings.Split(path, "/")
-
-	if len(parts) < 4 {
-		http.Error(w, "invalid path", http.StatusBadRequest)
-		return
+	// Admin API, on its own listener when TF_MIRROR_ADMIN_LISTEN is set or systemd passes an admin socket
+	admin := s.mux
+	if len(s.cfg.AdminListenAddrs) > 0 || (s.sockets != nil && len(s.sockets.admin) > 0) {
+		s.adminMux = http.NewServeMux()
+		s.adminMux.HandleFunc("GET /health", s.handleHealth)
+		s.adminMux.HandleFunc("GET /version", s.handleBuildInfo)
+		admin = s.adminMux
+	}
+	admin.HandleFunc("GET /admin/upstream", s.adminOnly(s.handleAdminUpstream))
+	admin.HandleFunc("GET /admin/cache", s.adminOnly(s.handleAdminCache))
+	admin.HandleFunc("GET /admin/disk", s.adminOnly(s.handleAdminDisk))
+	admin.HandleFunc("GET /admin/inventory", s.adminOnly(s.handleInventory))
+	admin.HandleFunc("GET /admin/stats", s.adminOnly(s.handleAdminStats))
+	admin.HandleFunc("GET /admin/tenants", s.adminOnly(s.handleAdminTenants))
+	if s.tokens != nil {
+		admin.HandleFunc("POST /admin/tokens", s.adminOnly(s.handleAdminIssueToken))
 	}
 
-	hostname := parts[0]  // registry.terraform.io
-	namespace := parts[1] // hashicorp
-	name := parts[2]      // random
-	file := parts[3]      // index.json, 3.6.0.json, or *.zip
-
-	s.logger.Debug("provider request",
-		"hostname", hostname,
-		"namespace", namespace,
-		"name", name,
-		"file", file,
-	)
-
-	ctx := r.Context()
-
-	switch {
-	case file == "index.json":
-		s.handleVersions(ctx, w, namespace, name)
+	// Service discovery, terraform login and token refresh for the credentials helper
+	s.mux.HandleFunc("GET /.well-known/terraform.json", s.handleDiscovery)
+	if s.tokens != nil {
+		s.mux.HandleFunc("GET /oauth/authorization", s.handleLoginAuthorize)
+		s.mux.HandleFunc("POST /oauth/authorization", s.handleLoginAuthorize)
+		s.mux.HandleFunc("POST /oauth/token", s.handleLoginToken)
+		s.mux.HandleFunc("POST /api/tokens/refresh", s.handleTokenRefresh)
+		s.mux.HandleFunc("GET /api/tokens/verify", s.handleTokenVerify)
+	}
+	if s.signer != nil {
+		s.mux.HandleFunc("GET /api/signing-key", s.handleSigningKey)
+	}
+	admin.HandleFunc("GET /admin/loglevel", s.adminOnly(s.handleAdminLogLevel))
+	admin.HandleFunc("PUT /admin/loglevel", s.adminOnly(s.handleSetLogLevel))
+	admin.HandleFunc("GET /admin/freeze", s.adminOnly(s.handleAdminFreeze))
+	admin.HandleFunc("PUT /admin/freeze", s.adminOnly(s.handleFreeze))
+	admin.HandleFunc("DELETE /admin/freeze", s.adminOnly(s.handleUnfreeze))
+	if s.snapshotsEnabled() {
+		admin.HandleFunc("GET /admin/snapshots/{namespace}/{name}", s.adminOnly(s.handleAdminSnapshots))
+	}
+	admin.HandleFunc("GET /admin/hash-failures", s.adminOnly(s.handleAdminHashFailures))
+	admin.HandleFunc("GET /admin/jobs", s.adminOnly(s.handleListJobs))
+	admin.HandleFunc("POST /admin/jobs/{kind}", s.adminOnly(s.handleStartJob))
+	admin.HandleFunc("GET /admin/jobs/{id}", s.adminOnly(s.handleGetJob))
+	admin.HandleFunc("DELETE /admin/jobs/{id}", s.adminOnly(s.handleCancelJob))
+	admin.HandleFunc("GET /admin/jobs/{id}/output", s.adminOnly(s.handleJobOutput))
+	admin.HandleFunc("GET /admin/tombstones", s.publishOnly(s.handleListTombstones))
+	admin.HandleFunc("GET /admin/tombstones/history", s.publishOnly(s.handleTombstoneHistory))
+	admin.HandleFunc("PUT /admin/tombstones/{namespace}/{name}/{version}", s.publishOnly(s.handleAddTombstone))
+	admin.HandleFunc("DELETE /admin/tombstones/{namespace}/{name}/{version}", s.publishOnly(s.handleRestoreTombstone))
+	if s.scans != nil {
+		admin.HandleFunc("GET /admin/scans", s.adminOnly(s.handleListScans))
+		admin.HandleFunc("GET /admin/scans/{namespace}/{name}/{version}/{platform}", s.adminOnly(s.handleGetScan))
+		admin.HandleFunc("GET /admin/scans/{namespace}/{name}/{version}/{platform}/report", s.adminOnly(s.handleScanReport))
+		admin.HandleFunc("POST /admin/scans/{namespace}/{name}/{version}/{platform}/release", s.adminOnly(s.handleReleaseScan))
+		admin.HandleFunc("DELETE /admin/scans/{namespace}/{name}/{version}/{platform}", s.adminOnly(s.handleForgetScan))
+	}
 
-	case strings.HasSuffix(file, ".json"):
-		version := strings.TrimSuffix(file, ".json")
-		s.handleVersion(ctx, w, namespace, name, version)
+	// Extended provider metadata
+	s.mux.HandleFunc("GET /api/providers/{hostname}/{namespace}/{name}/{version}", s.handleProviderMetadata)
+	s.mux.HandleFunc("GET /api/providers/{hostname}/{namespace}/{name}/latest", s.handleLatestVersion)
+	s.mux.HandleFunc("POST /api/batch/versions", s.handleBatchVersions)
+	s.mux.HandleFunc("GET /api/cli-config", s.handleCLIConfig)
+	s.mux.HandleFunc("GET /api/inventory", s.requireRole(roleRead, s.handleInventory))
+	s.mux.HandleFunc("POST /api/lock-reports", s.requireRole(roleRead, s.handleLockReport))
+	if s.linkSigner != nil {
+		s.mux.HandleFunc("POST /api/download-links", s.requireRole(roleRead, s.handleDownloadLinks))
+	}
+	admin.HandleFunc("GET /admin/lock-reports", s.adminOnly(s.handleListLockReports))
+	admin.HandleFunc("GET /admin/lock-reports/{id}", s.adminOnly(s.handleGetLockReport))
 
-	case strings.HasSuffix(file, ".zip"):
-		s.handleDownload(ctx, w, namespace, name, file)
+	// Provider protocols, with the routes of each revision under its own prefix
+	for _, p := range protocols {
+		p.routes(s)
+	}
 
-	default:
-		http.Error(w, "unknown file type", http.StatusBadRequest)
+	// Provider documentation (optional)
+	if s.cfg.DocsEnabled {
+		s.mux.HandleFunc("GET /docs/{namespace}/{name}/{version}", s.requireRole(roleRead, s.handleDocsIndex))
+		s.mux.HandleFunc("GET /docs/{namespace}/{name}/{version}/{id}", s.requireRole(roleRead, s.handleDocsPage))
+		s.mux.HandleFunc("GET /v2/provider-docs/{id}", s.requireRole(roleRead, s.handleProviderDoc))
 	}
 }
 
 // Run starts the server with graceful shutdown
 func (s *Server) Run(ctx context.Context) error {
-	srv := &http.Server{
-		Addr:         s.cfg.ListenAddr,
-		Handler:      s.mux,
-		ReadTimeout:  s.cfg.ReadTimeout,
-		WriteTimeout: s.cfg.WriteTimeout,
-	}
-
-	// Start server in goroutine
-	errCh := make(chan error, 1)
-	go func() {
-		s.logger.Info("starting server", "addr", s.cfg.ListenAddr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	srv, err := s.httpServer(s.publicHandler())
+	if err != nil {
+		return err
+	}
+	var inherited, inheritedAdmin []net.Listener
+	if s.sockets != nil {
+		inherited, inheritedAdmin = s.sockets.public, s.sockets.admin
+	}
+	listeners, err := s.listen(s.cfg.ListenAddrs, inherited, srv.TLSConfig)
+	if err != nil {
+		return err
+	}
+
+	var adminSrv *http.Server
+	var adminListeners []net.Listener
+	if s.adminMux != nil {
+		if adminSrv, err = s.httpServer(s.adminHandler()); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		if adminListeners, err = s.listen(s.cfg.AdminListenAddrs, inheritedAdmin, adminSrv.TLSConfig); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+	}
+
+	// Start servers in goroutines, one per listener
+	errCh := make(chan error, len(listeners)+len(adminListeners))
+	serve := func(srv *http.Server, ln net.Listener) {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			errCh <- err
 		}
+	}
+	for _, ln := range listeners {
+		s.logger.Info("starting server", "addr", ln.Addr().String(), "tls", s.cfg.TLSCert != "", "h2c", s.cfg.HTTP2Enabled, "max_connections", s.cfg.MaxConnections)
+		go serve(srv, ln)
+	}
+	for _, ln := range adminListeners {
+		s.logger.Info("starting admin server", "addr", ln.Addr().String())
+		go serve(adminSrv, ln)
+	}
+
+	// Close the metadata database last, after the deferred state save
+	defer func() {
+		if err := cache.CloseMetadata(s.cfg.CacheDir); err != nil {
+			s.logger.Error("failed to close metadata database", "error", err)
+		}
 	}()
 
+	// Tell systemd (Type=notify) that requests are being served
+	if err := sdNotify("READY=1"); err != nil {
+		s.logger.Warn("failed to notify systemd", "error", err)
+	}
+
+	// Sample disk usage for metrics and low-space warnings
+	go s.runDiskMonitor(ctx)
+
+	// Refresh the vulnerable versions deny-list
+	if s.denyList != nil {
+		go s.denyList.Run(ctx, s.cfg.DenyListRefresh)
+	}
+
+	// Persist download statistics
+	if s.stats != nil {
+		go s.stats.Run(ctx)
+		defer func() {
+			if err := s.stats.Close(); err != nil {
+				s.logger.Error("failed to close download stats", "error", err)
+			}
+		}()
+	}
+
+	// Save in-memory state periodically and on shutdown for warm restarts
+	if s.state != nil {
+		go s.runStatePersistence(ctx)
+		defer s.saveState()
+	}
+
+	// Pull the upstream mirror's cache in the background
+	if s.replicator != nil {
+		go s.replicator.Run(ctx)
+	}
+
+	// Seed the cache from the prefetch list in the background
+	if s.prefetcher != nil {
+		go s.prefetcher.Run(ctx)
+	}
+
+	// Hash cached archives that have no h1 hash yet
+	if s.hashWorker != nil {
+		go s.hashWorker.Run(ctx)
+	}
+
 	// Wait for shutdown signal
 	select {
 	case err := <-errCh:
 		return err
 	case <-ctx.Done():
 		s.logger.Info("shutting down server")
+		_ = sdNotify("STOPPING=1")
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		if err := s.jobs.Shutdown(shutdownCtx); err != nil {
+			s.logger.Warn("admin jobs did not stop in time", "error", err)
+		}
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(shutdownCtx); err != nil {
+				s.logger.Error("failed to shut down admin server", "error", err)
+			}
+		}
 		return srv.Shutdown(shutdownCtx)
 	}
 }
-
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// Response headers carrying the signature of index.json and {version}.json
+const (
+	signatureHeader      = "X-Mirror-Signature"
+	signatureKeyIDHeader = "X-Mirror-Signature-Key"
+)
+
+// setSignature adds the detached signature of a response body when signing is enabled
+func (s *Server) setSignature(w http.ResponseWriter, hostname, namespace, name, file string, data []byte) {
+	if s.signer == nil {
+		return
+	}
+	w.Header().Set(signatureHeader, base64.StdEncoding.EncodeToString(s.signer.Sign(signedMessage(hostname, namespace, name, file, data))))
+	w.Header().Set(signatureKeyIDHeader, s.signer.KeyID())
+}
+
+// signedMessage is what a signature covers: the provider address and file of the document
+// (e.g. "registry.terraform.io/hashicorp/aws/5.31.0.json"), a newline and the document,
+// so the signature of one provider's document does not verify for another's
+func signedMessage(hostname, namespace, name, file string, data []byte) []byte {
+	subject := hostname + "/" + namespace + "/" + name + "/" + file + "\n"
+	return append([]byte(subject), data...)
+}
+
+// handleSignature handles GET index.json.sig and {version}.json.sig
+// The raw Ed25519 signature of the document served at the same path without .sig, to the same client
+func (s *Server) handleSignature(ctx context.Context, w http.ResponseWriter, hostname, namespace, name, file string, omit []string) {
+	if s.signer == nil {
+		writeError(w, notFound("response signing is not enabled"))
+		return
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if file == "index.json" {
+		data, err = s.versionsDocument(ctx, namespace, name)
+	} else {
+		version := strings.TrimSuffix(file, ".json")
+		if err := s.checkVersion(namespace, name, version); err != nil {
+			writeError(w, err)
+			return
+		}
+		data, _, err = s.versionDocument(ctx, hostname, namespace, name, version)
+		if err == nil {
+			data = omitHashes(data, omit)
+		}
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch document to sign", "file", file, "error", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(signatureKeyIDHeader, s.signer.KeyID())
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(s.signer.Sign(signedMessage(hostname, namespace, name, file, data)))
+}
+
+// handleSigningKey handles GET /api/signing-key — the public key for verifying signatures
+func (s *Server) handleSigningKey(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set(signatureKeyIDHeader, s.signer.KeyID())
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(s.signer.PublicKeyPEM())
+}
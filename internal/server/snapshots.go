@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// snapshotHeader reports the snapshot a response was served from
+const snapshotHeader = "X-Mirror-Snapshot"
+
+// snapshotsEnabled reports whether version list history is available
+func (s *Server) snapshotsEnabled() bool {
+	return s.cfg.SnapshotsEnabled || s.cfg.Snapshot != ""
+}
+
+// withSnapshot serves version lists from a snapshot selected with ?snapshot=
+// or pinned with TF_MIRROR_SNAPSHOT; the query parameter takes precedence
+func (s *Server) withSnapshot(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		at := s.snapshot
+		if selector := r.URL.Query().Get("snapshot"); selector != "" {
+			if !s.snapshotsEnabled() {
+				writeError(w, badRequest("version snapshots are not enabled"))
+				return
+			}
+			t, err := registry.ParseSnapshot(selector)
+			if err != nil {
+				writeError(w, badRequest(err.Error()))
+				return
+			}
+			at = t
+		}
+
+		if at.IsZero() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(snapshotHeader, at.UTC().Format(time.RFC3339))
+		next.ServeHTTP(w, r.WithContext(registry.WithSnapshot(r.Context(), at)))
+	})
+}
+
+// handleAdminSnapshots handles GET /admin/snapshots/{namespace}/{name} — stored version lists of a provider
+func (s *Server) handleAdminSnapshots(w http.ResponseWriter, r *http.Request) {
+	if err := checkProvider(r.PathValue("namespace"), r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	snapshots, err := s.registry.Snapshots(r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		s.logger.Error("failed to list version snapshots", "error", err)
+		writeError(w, internalError())
+		return
+	}
+
+	resp := map[string]any{"snapshots": snapshots}
+	if !s.snapshot.IsZero() {
+		resp["pinned"] = s.snapshot.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// stateComponent is in-memory state saved across restarts
+type stateComponent struct {
+	save    func() ([]byte, error)
+	restore func([]byte) error
+}
+
+// stateComponents returns the persisted state by key
+func (s *Server) stateComponents() map[string]stateComponent {
+	return map[string]stateComponent{
+		"registry.downloads":    {save: s.registry.DownloadCacheState, restore: s.registry.RestoreDownloadCache},
+		"upstream.hosts":        {save: s.upstream.State, restore: s.upstream.RestoreState},
+		"fetcher.hash_failures": {save: s.fetcher.FailureState, restore: s.fetcher.RestoreFailures},
+	}
+}
+
+// restoreState loads the state saved by the previous process
+// Broken snapshots are skipped: the mirror then starts cold, as before
+func (s *Server) restoreState() {
+	start := time.Now()
+	snapshots, err := s.state.Load()
+	if err != nil {
+		s.logger.Warn("failed to load saved state", "error", err)
+		return
+	}
+
+	restored := 0
+	for key, c := range s.stateComponents() {
+		data, ok := snapshots[key]
+		if !ok {
+			continue
+		}
+		if err := c.restore(data); err != nil {
+			s.logger.Warn("failed to restore saved state", "state", key, "error", err)
+			continue
+		}
+		restored++
+	}
+	if restored > 0 {
+		s.logger.Info("restored saved state", "components", restored, "duration", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// saveState writes a snapshot of the in-memory state
+func (s *Server) saveState() {
+	snapshots := make(map[string][]byte)
+	for key, c := range s.stateComponents() {
+		data, err := c.save()
+		if err != nil {
+			s.logger.Error("failed to snapshot state", "state", key, "error", err)
+			continue
+		}
+		snapshots[key] = data
+	}
+	if err := s.state.Save(snapshots); err != nil {
+		s.logger.Error("failed to save state", "error", err)
+	}
+}
+
+// runStatePersistence saves the state every TF_MIRROR_STATE_INTERVAL until ctx is done
+func (s *Server) runStatePersistence(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.StateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.saveState()
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/policy"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+	"github.com/scinfra-pro/terraform-mirror/internal/stats"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// defaultStatsWindow is used when /admin/stats has no window parameter
+const defaultStatsWindow = 24 * time.Hour
+
+// recordDownload adds a successfully served archive to the download statistics
+// Redirects to the object store count as downloads without bytes
+func (s *Server) recordDownload(r *http.Request, sw *statusWriter, namespace, name, filename string) {
+	if s.stats == nil || (sw.status != http.StatusOK && sw.status != http.StatusFound) {
+		return
+	}
+
+	_, version, _, _, err := registry.ParseZipFilename(filename)
+	if err != nil {
+		return
+	}
+
+	tenantName := ""
+	if t := tenant.FromContext(r.Context()); t != nil {
+		tenantName = t.Name
+	}
+	cli := ""
+	if c, ok := policy.ParseUserAgent(r.UserAgent()); ok {
+		cli = c.String()
+	}
+	s.stats.Record(namespace, name, version, clientIP(r), tenantName, cli, sw.bytes)
+}
+
+// handleAdminStats handles GET /admin/stats?window=7d&provider=ns/name — download statistics
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if s.stats == nil {
+		writeJSON(w, map[string]any{"enabled": false})
+		return
+	}
+
+	window := defaultStatsWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		d, ok := parseWindow(value)
+		if !ok {
+			writeError(w, badRequest("invalid window "+value))
+			return
+		}
+		window = d
+	}
+
+	provider := r.URL.Query().Get("provider")
+	if provider != "" {
+		namespace, name, ok := strings.Cut(provider, "/")
+		if !ok || registry.ValidateProvider(namespace, name) != nil {
+			writeError(w, badRequest("provider must be namespace/name"))
+			return
+		}
+		namespace, name = s.registry.Resolve(namespace, name)
+		provider = namespace + "/" + name
+	}
+
+	report, err := s.stats.Query(window, provider)
+	if err != nil {
+		s.logger.Error("failed to query download stats", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	if s.deprecations == nil {
+		writeJSON(w, report)
+		return
+	}
+	writeJSON(w, struct {
+		*stats.Report
+		Deprecated []deprecatedUsage `json:"deprecated"`
+	}{report, s.deprecatedDownloads(report)})
+}
+
+// parseWindow parses a Go duration or a number of days ("7d")
+func parseWindow(value string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const listenFDsStart = 3
+
+// adminSocketName is the FileDescriptorName of an inherited socket that serves the admin API
+const adminSocketName = "admin"
+
+// systemdSockets are listening sockets inherited from systemd socket activation
+type systemdSockets struct {
+	public []net.Listener
+	admin  []net.Listener
+}
+
+// inheritSystemdSockets takes over the sockets passed in LISTEN_FDS (sd_listen_fds)
+// Sockets named "admin" (FileDescriptorName=admin) serve the admin API, all others the mirror.
+// It returns nil when the process was not socket-activated.
+func inheritSystemdSockets() (*systemdSockets, error) {
+	defer func() {
+		// Not inherited by child processes, like sd_listen_fds(unset_environment=1)
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	raw := os.Getenv("LISTEN_FDS")
+	if raw == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", raw)
+	}
+
+	var names []string
+	if raw := os.Getenv("LISTEN_FDNAMES"); raw != "" {
+		names = strings.Split(raw, ":")
+	}
+
+	sockets := &systemdSockets{}
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener works on a duplicate
+		if err != nil {
+			sockets.close()
+			return nil, fmt.Errorf("inherited socket %d is not a stream socket: %w", fd, err)
+		}
+		if i < len(names) && names[i] == adminSocketName {
+			sockets.admin = append(sockets.admin, ln)
+		} else {
+			sockets.public = append(sockets.public, ln)
+		}
+	}
+	if len(sockets.public) == 0 {
+		sockets.close()
+		return nil, fmt.Errorf("no inherited socket for the mirror, only %q ones", adminSocketName)
+	}
+	return sockets, nil
+}
+
+func (s *systemdSockets) close() {
+	closeListeners(s.public)
+	closeListeners(s.admin)
+}
+
+// sdNotify sends a state change (e.g. "READY=1") to the service manager
+// It does nothing unless the unit has Type=notify (NOTIFY_SOCKET is set)
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
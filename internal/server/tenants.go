@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+)
+
+// withTenant identifies the tenant of each request by bearer token or client certificate identity
+// Health checks, build info, service discovery, the login flow, the public signing key and
+// archives under a download link do not need a tenant; the admin API needs one or the admin token
+func (s *Server) withTenant(next http.Handler) http.Handler {
+	if s.tenants == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path == "/health" || path == "/version" || path == "/.well-known/terraform.json" || path == "/api/signing-key" || strings.HasPrefix(path, "/oauth/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The role of the tenant is checked by the admin routes themselves (see requireRole)
+		if strings.HasPrefix(path, "/admin/") {
+			if _, ok := s.requestTenant(r); !ok && !s.isAdminToken(r) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tf-mirror admin"`)
+				writeError(w, unauthorized())
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		// A download link stands in for the credentials of the tenant that minted it
+		if s.isDownloadLink(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, ok := s.requestTenant(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tf-mirror"`)
+			writeError(w, unauthorized())
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(tenant.NewContext(r.Context(), t)))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		tag := "tenant:" + t.Name
+		s.metrics.Count(metrics.TenantRequests, 1, tag, "status:"+strconv.Itoa(sw.status))
+		s.metrics.Count(metrics.TenantBytes, sw.bytes, tag)
+		s.logger.Info("tenant request", "tenant", t.Name, "client", s.logClient(r), "method", r.Method, "path", r.URL.Path, "status", sw.status, "bytes", sw.bytes)
+	})
+}
+
+// requestTenant returns the tenant of a request: the client certificate identity,
+// then the bearer token (a tenant token or a mirror-issued token)
+func (s *Server) requestTenant(r *http.Request) (*tenant.Tenant, bool) {
+	if identity := clientIdentity(r); identity != "" {
+		if t, ok := s.tenants.ByIdentity(identity); ok {
+			return t, true
+		}
+	}
+	credential, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, false
+	}
+	t, _ := s.credentialTenant(credential)
+	return t, t != nil
+}
+
+// checkTenant refuses providers outside the policy of the request's tenant
+func (s *Server) checkTenant(r *http.Request, namespace, name string) error {
+	t := tenant.FromContext(r.Context())
+	if t == nil || t.Allows(namespace, name) {
+		return nil
+	}
+	s.logger.Warn("provider refused for tenant", "tenant", t.Name, "provider", namespace+"/"+name)
+	return policyDenied("provider " + namespace + "/" + name + " is not available to tenant " + t.Name)
+}
+
+// tenantInfo — a tenant in the /admin/tenants response; tokens are never listed
+type tenantInfo struct {
+	Name       string   `json:"name"`
+	Providers  []string `json:"providers"`
+	Identities []string `json:"identities,omitempty"`
+	Subjects   []string `json:"subjects,omitempty"`
+	Tokens     int      `json:"tokens"`
+	QuotaBytes int64    `json:"quota_bytes,omitempty"`
+	Role       string   `json:"role"`
+	Bytes      int64    `json:"bytes"`
+	Archives   int      `json:"archives"`
+}
+
+// handleAdminTenants handles GET /admin/tenants — tenants, their policy and archive cache usage
+func (s *Server) handleAdminTenants(w http.ResponseWriter, _ *http.Request) {
+	if s.tenants == nil {
+		writeJSON(w, map[string]any{"enabled": false})
+		return
+	}
+
+	usage := make(map[string]cache.TenantUsage)
+	if s.archiveCache != nil {
+		list, err := s.archiveCache.TenantUsage()
+		if err != nil {
+			s.logger.Error("failed to read tenant usage", "error", err)
+			writeError(w, internalError())
+			return
+		}
+		for _, u := range list {
+			usage[u.Tenant] = u
+		}
+	}
+
+	result := make([]tenantInfo, 0, len(s.tenants.Tenants()))
+	for _, t := range s.tenants.Tenants() {
+		providers := t.Providers
+		if len(providers) == 0 {
+			providers = []string{"*"}
+		}
+		result = append(result, tenantInfo{
+			Name:       t.Name,
+			Providers:  providers,
+			Identities: t.Identities,
+			Subjects:   t.Subjects,
+			Tokens:     len(t.Tokens),
+			QuotaBytes: t.QuotaBytes,
+			Role:       parseRole(t.Role).String(),
+			Bytes:      usage[t.Name].Bytes,
+			Archives:   usage[t.Name].Archives,
+		})
+	}
+	writeJSON(w, map[string]any{"enabled": true, "tenants": result})
+}
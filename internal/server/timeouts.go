@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// withTimeouts applies the deadlines of a route
+// Metadata, checksum, admin and API requests get TF_MIRROR_REQUEST_TIMEOUT for the whole request,
+// upstream calls and writing the response included. Archive downloads get TF_MIRROR_DOWNLOAD_TIMEOUT
+// to start; after that their write deadline moves forward with every write, so a transfer fails only
+// when the client accepts no data for TF_MIRROR_DOWNLOAD_IDLE_TIMEOUT. TF_MIRROR_WRITE_TIMEOUT caps both.
+func (s *Server) withTimeouts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limit time.Time
+		if s.cfg.WriteTimeout > 0 {
+			limit = time.Now().Add(s.cfg.WriteTimeout)
+		}
+
+		if isDownload(r) {
+			s.serveDownload(next, w, r, limit)
+			return
+		}
+
+		if s.cfg.RequestTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), s.cfg.RequestTimeout)
+		defer cancel()
+		_ = http.NewResponseController(w).SetWriteDeadline(earliest(time.Now().Add(s.cfg.RequestTimeout), limit))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serveDownload serves an archive download with an idle-based write deadline
+func (s *Server) serveDownload(next http.Handler, w http.ResponseWriter, r *http.Request, limit time.Time) {
+	iw := &idleWriter{ResponseWriter: w, rc: http.NewResponseController(w), idle: s.cfg.DownloadIdleTimeout, limit: limit}
+
+	ctx := r.Context()
+	if s.cfg.DownloadTimeout > 0 {
+		budget := newStartBudget(ctx)
+		defer budget.cancel()
+		timer := time.AfterFunc(s.cfg.DownloadTimeout, func() {
+			if !iw.started.Load() {
+				budget.expire()
+			}
+		})
+		defer timer.Stop()
+		ctx = budget
+	}
+	next.ServeHTTP(iw, r.WithContext(ctx))
+}
+
+// isDownload reports whether a request is for a provider archive
+func isDownload(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, ".zip")
+}
+
+// earliest returns the earlier of a deadline and an optional limit (zero for none)
+func earliest(deadline, limit time.Time) time.Time {
+	if !limit.IsZero() && limit.Before(deadline) {
+		return limit
+	}
+	return deadline
+}
+
+// idleWriter moves the write deadline of a download forward as data is written
+type idleWriter struct {
+	http.ResponseWriter
+	rc    *http.ResponseController
+	idle  time.Duration
+	limit time.Time
+
+	started  atomic.Bool
+	extended time.Time // when the deadline was last moved
+}
+
+func (w *idleWriter) WriteHeader(status int) {
+	w.started.Store(true)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.started.Store(true)
+	w.extend()
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *idleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// extend sets the write deadline to now + idle, at most once a second
+func (w *idleWriter) extend() {
+	now := time.Now()
+	if now.Sub(w.extended) < time.Second {
+		return
+	}
+	w.extended = now
+
+	deadline := w.limit
+	if w.idle > 0 {
+		deadline = earliest(now.Add(w.idle), w.limit)
+	}
+	_ = w.rc.SetWriteDeadline(deadline)
+}
+
+// startBudget is a request context that expires when a download does not start in time
+// Unlike a context deadline it does not end transfers that are already running
+type startBudget struct {
+	context.Context
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func newStartBudget(parent context.Context) *startBudget {
+	ctx, cancel := context.WithCancel(parent)
+	return &startBudget{Context: ctx, cancel: cancel}
+}
+
+// expire cancels the context, which then reports context.DeadlineExceeded like a timeout
+func (b *startBudget) expire() {
+	b.expired.Store(true)
+	b.cancel()
+}
+
+func (b *startBudget) Err() error {
+	if err := b.Context.Err(); err != nil && b.expired.Load() {
+		return context.DeadlineExceeded
+	}
+	return b.Context.Err()
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Client certificate policies (TF_MIRROR_CLIENT_POLICIES)
+const (
+	policyRead    = "read"    // mirror endpoints; the admin API still needs the token
+	policyPublish = "publish" // also tombstones without a token
+	policyAdmin   = "admin"   // also the admin API without a token
+	policyDeny    = "deny"    // nothing
+)
+
+type contextKey int
+
+const (
+	// identityKey holds the client certificate identity in the request context
+	identityKey contextKey = iota
+
+	// clientIPKey holds the client address behind trusted proxies
+	clientIPKey
+)
+
+// tlsConfig builds the listener TLS configuration, nil when TLS is disabled
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.cfg.TLSCert == "" && s.cfg.TLSKey == "" {
+		if s.cfg.TLSClientCA != "" {
+			return nil, fmt.Errorf("TF_MIRROR_TLS_CLIENT_CA requires TF_MIRROR_TLS_CERT and TF_MIRROR_TLS_KEY")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.cfg.TLSCert, s.cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.cfg.TLSClientCA != "" {
+		data, err := os.ReadFile(s.cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", s.cfg.TLSClientCA)
+		}
+		cfg.ClientCAs = pool
+
+		switch s.cfg.TLSClientAuth {
+		case "require", "":
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("unknown TF_MIRROR_TLS_CLIENT_AUTH %q (require or optional)", s.cfg.TLSClientAuth)
+		}
+	}
+
+	return cfg, nil
+}
+
+// certIdentity returns the subject CN of a client certificate, or its first SAN
+func certIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	default:
+		return cert.Subject.String()
+	}
+}
+
+// clientIdentity returns the verified client certificate identity ("" without one)
+func clientIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(identityKey).(string)
+	return identity
+}
+
+// clientPolicy returns the policy mapped to an identity ("*" entry, then read)
+func (s *Server) clientPolicy(identity string) string {
+	if policy, ok := s.cfg.ClientPolicies[identity]; ok {
+		return policy
+	}
+	if policy, ok := s.cfg.ClientPolicies["*"]; ok {
+		return policy
+	}
+	return policyRead
+}
+
+// withClientIdentity records the client certificate identity for audit logs
+// and refuses identities whose policy is deny
+func (s *Server) withClientIdentity(next http.Handler) http.Handler {
+	if s.cfg.TLSClientCA == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := certIdentity(r.TLS.VerifiedChains[0][0])
+		policy := s.clientPolicy(identity)
+		s.logger.Info("client request", "identity", s.logIdentity(identity), "client", s.logClient(r), "policy", policy, "method", r.Method, "path", r.URL.Path)
+
+		if policy == policyDeny {
+			writeError(w, policyDenied("client "+identity+" is not allowed"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey, identity)))
+	})
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/oidc"
+	"github.com/scinfra-pro/terraform-mirror/internal/tenant"
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
+)
+
+// tokenResponse — an issued mirror token
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	Tenant    string    `json:"tenant"`
+	Subject   string    `json:"subject"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// credentialTenant resolves a bearer credential: a mirror-issued token, a tenant token
+// or a JWT of the OIDC issuer whose subject matches a tenant
+// Returns the tenant and the subject the credential stands for
+func (s *Server) credentialTenant(credential string) (*tenant.Tenant, string) {
+	credential = strings.TrimSpace(credential)
+	if s.tokens != nil && strings.HasPrefix(credential, token.Prefix) {
+		claims, err := s.tokens.Verify(credential)
+		if err != nil {
+			return nil, ""
+		}
+		t, ok := s.tenants.ByName(claims.Tenant)
+		if !ok {
+			return nil, ""
+		}
+		return t, claims.Subject
+	}
+	if t, ok := s.tenants.ByToken(credential); ok {
+		return t, t.Name
+	}
+	if s.oidcVerifier != nil && oidc.IsJWT(credential) {
+		claims, err := s.oidcVerifier.Verify(credential)
+		if err != nil {
+			s.logger.Debug("JWT rejected", "error", err)
+			return nil, ""
+		}
+		subject := claims.String(s.cfg.OIDCClaim)
+		t, ok := s.tenants.BySubject(subject)
+		if !ok {
+			s.logger.Debug("JWT subject matches no tenant", "claim", s.cfg.OIDCClaim, "subject", subject)
+			return nil, ""
+		}
+		return t, subject
+	}
+	return nil, ""
+}
+
+// requestSubject returns who a tenant request authenticated as: the certificate identity,
+// the subject of a mirror token or the tenant name for a tenant token
+func (s *Server) requestSubject(r *http.Request, t *tenant.Tenant) string {
+	if identity := clientIdentity(r); identity != "" {
+		if owner, ok := s.tenants.ByIdentity(identity); ok && owner == t {
+			return identity
+		}
+	}
+	credential, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, subject := s.credentialTenant(credential); subject != "" {
+		return subject
+	}
+	return t.Name
+}
+
+// handleTokenRefresh handles POST /api/tokens/refresh — a new mirror token for the caller's tenant
+// The credentials helper calls it before the stored token expires. A mirror token is refreshed
+// up to TF_MIRROR_TOKEN_MAX_AGE after its login; other credentials get a new token
+func (s *Server) handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	t := tenant.FromContext(r.Context())
+	if t == nil {
+		writeError(w, unauthorized())
+		return
+	}
+
+	credential, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	credential = strings.TrimSpace(credential)
+	if clientIdentity(r) != "" || !strings.HasPrefix(credential, token.Prefix) {
+		s.issueToken(w, s.requestSubject(r, t), t.Name, 0)
+		return
+	}
+
+	claims, err := s.tokens.Verify(credential)
+	if err != nil {
+		writeError(w, unauthorized())
+		return
+	}
+	tok, refreshed, err := s.tokens.Refresh(claims)
+	if err != nil {
+		s.logger.Info("token refresh refused, login required", "tenant", t.Name, "subject", s.logIdentity(claims.Subject), "auth_time", claims.AuthTime)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tf-mirror", error="invalid_token"`)
+		writeError(w, unauthorized())
+		return
+	}
+	s.logger.Info("refreshed token", "tenant", t.Name, "subject", s.logIdentity(refreshed.Subject), "expires", refreshed.ExpiresAt)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, tokenResponse{Token: tok, Tenant: t.Name, Subject: refreshed.Subject, ExpiresAt: refreshed.ExpiresAt})
+}
+
+// handleTokenVerify handles GET /api/tokens/verify — the tenant and subject of the caller's credential
+func (s *Server) handleTokenVerify(w http.ResponseWriter, r *http.Request) {
+	t := tenant.FromContext(r.Context())
+	if t == nil {
+		writeError(w, unauthorized())
+		return
+	}
+
+	resp := map[string]any{"tenant": t.Name, "subject": s.requestSubject(r, t)}
+	credential, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if claims, err := token.Parse(strings.TrimSpace(credential)); err == nil {
+		resp["expires_at"] = claims.ExpiresAt
+	}
+	writeJSON(w, resp)
+}
+
+// handleAdminIssueToken handles POST /admin/tokens — issue a mirror token for a tenant
+// Body: {"tenant": "payments", "subject": "alice", "ttl": "24h"}
+func (s *Server) handleAdminIssueToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tenant  string `json:"tenant"`
+		Subject string `json:"subject"`
+		TTL     string `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, badRequest("invalid JSON body"))
+		return
+	}
+	if _, ok := s.tenants.ByName(req.Tenant); !ok {
+		writeError(w, notFound("unknown tenant "+req.Tenant))
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			writeError(w, badRequest("invalid ttl "+req.TTL))
+			return
+		}
+		ttl = d
+	}
+	subject := req.Subject
+	if subject == "" {
+		subject = req.Tenant
+	}
+	s.issueToken(w, subject, req.Tenant, ttl)
+}
+
+func (s *Server) issueToken(w http.ResponseWriter, subject, tenantName string, ttl time.Duration) {
+	tok, claims, err := s.tokens.Issue(subject, tenantName, ttl)
+	if err != nil {
+		s.logger.Error("failed to issue token", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	s.logger.Info("issued token", "tenant", tenantName, "subject", s.logIdentity(subject), "expires", claims.ExpiresAt)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, tokenResponse{Token: tok, Tenant: tenantName, Subject: subject, ExpiresAt: claims.ExpiresAt})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// tombstoneRequest — body of tombstone and restore requests
+type tombstoneRequest struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+}
+
+// handleListTombstones handles GET /admin/tombstones
+func (s *Server) handleListTombstones(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{"tombstones": s.tombstones.List()})
+}
+
+// handleTombstoneHistory handles GET /admin/tombstones/history — audit log
+func (s *Server) handleTombstoneHistory(w http.ResponseWriter, _ *http.Request) {
+	events, err := s.tombstones.History()
+	if err != nil {
+		s.logger.Error("failed to read tombstone history", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	writeJSON(w, map[string]any{"events": events})
+}
+
+// handleAddTombstone handles PUT /admin/tombstones/{namespace}/{name}/{version}
+// The version stays on disk but is no longer listed or served
+func (s *Server) handleAddTombstone(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, req, ok := s.parseTombstoneRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, badRequest("reason is required"))
+		return
+	}
+
+	ts, err := s.tombstones.Add(namespace, name, version, req.Reason, req.Actor)
+	if err != nil {
+		s.logger.Error("failed to tombstone version", "error", err)
+		writeError(w, internalError())
+		return
+	}
+
+	s.logger.Warn("version tombstoned", "provider", namespace+"/"+name, "version", version, "actor", s.logIdentity(req.Actor), "client", s.logClient(r), "reason", req.Reason)
+	writeJSON(w, ts)
+}
+
+// handleRestoreTombstone handles DELETE /admin/tombstones/{namespace}/{name}/{version}
+func (s *Server) handleRestoreTombstone(w http.ResponseWriter, r *http.Request) {
+	namespace, name, version, req, ok := s.parseTombstoneRequest(w, r)
+	if !ok {
+		return
+	}
+
+	restored, err := s.tombstones.Restore(namespace, name, version, req.Reason, req.Actor)
+	if err != nil {
+		s.logger.Error("failed to restore version", "error", err)
+		writeError(w, internalError())
+		return
+	}
+	if !restored {
+		writeError(w, notFound(namespace+"/"+name+" "+version+" is not tombstoned"))
+		return
+	}
+
+	s.logger.Info("version restored", "provider", namespace+"/"+name, "version", version, "actor", s.logIdentity(req.Actor), "client", s.logClient(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTombstoneRequest reads the provider version from the path and the optional JSON body
+func (s *Server) parseTombstoneRequest(w http.ResponseWriter, r *http.Request) (namespace, name, version string, req tombstoneRequest, ok bool) {
+	namespace, name = r.PathValue("namespace"), r.PathValue("name")
+	version = r.PathValue("version")
+
+	err := registry.ValidateProvider(namespace, name)
+	if err == nil {
+		err = registry.ValidateVersion(version)
+	}
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return "", "", "", req, false
+	}
+	namespace, name = s.registry.Resolve(namespace, name)
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeError(w, badRequest("invalid JSON body"))
+			return "", "", "", req, false
+		}
+	}
+
+	req.Actor = adminActor(r, req.Actor)
+	return namespace, name, version, req, true
+}
+
+// adminActor returns who made an admin request: the client certificate identity when there is one,
+// otherwise the actor from the body, the X-Actor header, then "admin"
+func adminActor(r *http.Request, actor string) string {
+	if identity := clientIdentity(r); identity != "" {
+		return identity
+	}
+	if actor == "" {
+		actor = r.Header.Get("X-Actor")
+	}
+	if actor == "" {
+		actor = "admin"
+	}
+	return actor
+}
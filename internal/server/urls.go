@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+)
+
+// baseURL returns the URL clients use to reach the mirror: the configured
+// external URL, or the scheme and host of the request followed by the base path
+func (s *Server) baseURL(r *http.Request) string {
+	if s.cfg.ExternalURL != "" {
+		return s.cfg.ExternalURL
+	}
+	return s.requestBaseURL(r) + s.cfg.BasePath
+}
+
+// pathPrefix is the path part of the external URL ("" when unset)
+func (s *Server) pathPrefix() string {
+	u, err := url.Parse(s.cfg.ExternalURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// withPathPrefix strips the path prefix from requests
+// With TF_MIRROR_BASE_PATH every route lives under the prefix and other paths are 404;
+// the external URL's prefix is optional, for load balancers that strip it themselves
+func (s *Server) withPathPrefix(next http.Handler) http.Handler {
+	prefix, required := s.cfg.BasePath, true
+	if prefix == "" {
+		prefix, required = s.pathPrefix(), false
+	}
+	if prefix == "" {
+		return next
+	}
+
+	stripped := http.StripPrefix(prefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok && strings.HasPrefix(rest, "/") {
+			stripped.ServeHTTP(w, r)
+			return
+		}
+		// Terraform looks for service discovery at the root of the host
+		if required && r.URL.Path != "/.well-known/terraform.json" {
+			writeError(w, notFound("not found"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// archiveURLs rewrites the relative archive URLs of a {version}.json response to absolute
+// URLs under the CDN or external URL, and signs them in origin mode
+func (s *Server) archiveURLs(hostname, namespace, name string, data []byte) []byte {
+	base := s.archiveBaseURL()
+	if base == "" && s.urlSigner == nil {
+		return data
+	}
+
+	var resp providersv1.MirrorVersionResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return data
+	}
+
+	if base != "" {
+		base += providersv1.MirrorPath(hostname, namespace, name, "")
+	}
+	for platform, archive := range resp.Archives {
+		archive.URL = s.signArchiveURL(base+archive.URL, namespace, name, archive.URL)
+		resp.Archives[platform] = archive
+	}
+
+	rewritten, err := json.Marshal(resp)
+	if err != nil {
+		return data
+	}
+	return rewritten
+}
@@ -0,0 +1,68 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Signer signs mirror responses with an Ed25519 key operated by the mirror
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// Load reads a PEM-encoded PKCS#8 Ed25519 private key
+// (e.g. generated with: openssl genpkey -algorithm ed25519 -out signing.pem)
+func Load(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("signing key: expected a PEM \"PRIVATE KEY\" block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key: expected an Ed25519 key, got %T", parsed)
+	}
+	return New(key), nil
+}
+
+// New creates a signer for a private key
+func New(key ed25519.PrivateKey) *Signer {
+	pub := key.Public().(ed25519.PublicKey)
+	sum := sha256.Sum256(pub)
+	return &Signer{key: key, keyID: hex.EncodeToString(sum[:8])}
+}
+
+// KeyID identifies the key: the first 8 bytes of the public key's SHA-256, hex-encoded
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign returns the detached Ed25519 signature of data
+func (s *Signer) Sign(data []byte) []byte {
+	return ed25519.Sign(s.key, data)
+}
+
+// PublicKeyPEM returns the public key as a PEM "PUBLIC KEY" block
+func (s *Signer) PublicKeyPEM() []byte {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		// Ed25519 public keys always marshal
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
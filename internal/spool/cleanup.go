@@ -0,0 +1,80 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/disk"
+)
+
+// ErrInsufficientSpace is returned when the spool directory cannot hold a download
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// Cleanup removes spool files older than maxAge, left behind by crashed processes
+// Returns the number of removed files
+func Cleanup(dir string, maxAge time.Duration) (int, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, FilePattern))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// CheckSpace returns ErrInsufficientSpace if dir cannot hold size bytes and still keep reserve free
+// Platforms without free space information always pass
+func CheckSpace(dir string, size, reserve int64) error {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	space, ok := disk.Stat(dir)
+	if !ok {
+		return nil
+	}
+	if size+reserve > space.Free {
+		return fmt.Errorf("%s: need %d bytes, %d available: %w", dir, size+reserve, space.Free, ErrInsufficientSpace)
+	}
+	return nil
+}
+
+// Usage returns the number and total size of spool files in dir, including those of running downloads
+func Usage(dir string) (int, int64, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, FilePattern))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var files int
+	var size int64
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files++
+		size += info.Size()
+	}
+	return files, size, nil
+}
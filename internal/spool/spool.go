@@ -0,0 +1,107 @@
+package spool
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FilePattern is the name pattern of spool files on disk
+const FilePattern = "provider-*.zip"
+
+// Spool buffers a download in memory up to a limit and spills to a temporary file beyond it
+// Small archives never touch the disk; large ones do not exhaust memory
+type Spool struct {
+	dir   string
+	limit int64
+
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+// New creates an empty spool
+// dir is where spill files are created ("" for the system temp dir)
+// memoryLimit is the number of bytes kept in memory before spilling
+func New(dir string, memoryLimit int64) *Spool {
+	return &Spool{dir: dir, limit: memoryLimit}
+}
+
+// Write appends data to the spool, spilling to disk when the memory limit is exceeded
+func (s *Spool) Write(p []byte) (int, error) {
+	if s.file == nil && int64(s.buf.Len()+len(p)) > s.limit {
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// spill moves buffered data to a temporary file
+func (s *Spool) spill() error {
+	f, err := os.CreateTemp(s.dir, FilePattern)
+	if err != nil {
+		return fmt.Errorf("creating spool file: %w", err)
+	}
+
+	if _, err := f.Write(s.buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("writing spool file: %w", err)
+	}
+
+	s.file = f
+	s.buf = bytes.Buffer{}
+	return nil
+}
+
+// ReadAt implements io.ReaderAt over the spooled data
+func (s *Spool) ReadAt(p []byte, off int64) (int, error) {
+	if s.file != nil {
+		return s.file.ReadAt(p, off)
+	}
+	return bytes.NewReader(s.buf.Bytes()).ReadAt(p, off)
+}
+
+// Reader returns a reader over the whole spooled content
+func (s *Spool) Reader() *io.SectionReader {
+	return io.NewSectionReader(s, 0, s.size)
+}
+
+// Size returns the number of spooled bytes
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// OnDisk reports whether the spool has spilled to a temporary file
+func (s *Spool) OnDisk() bool {
+	return s.file != nil
+}
+
+// Close releases the memory buffer and removes the temporary file
+func (s *Spool) Close() error {
+	s.buf = bytes.Buffer{}
+	if s.file == nil {
+		return nil
+	}
+
+	name := s.file.Name()
+	err := s.file.Close()
+	s.file = nil
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+	return err
+}
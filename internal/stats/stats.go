@@ -0,0 +1,433 @@
+package stats
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// hourFormat names hourly buckets; it sorts chronologically
+const hourFormat = "2006010215"
+
+// flushInterval is how often buffered downloads are written to disk
+const flushInterval = 10 * time.Second
+
+var (
+	hoursBucket = []byte("hours")
+	metaBucket  = []byte("meta")
+	clientKey   = []byte("client_key")
+)
+
+// Usage is an aggregate of archive downloads
+type Usage struct {
+	Downloads     int64    `json:"downloads"`
+	UniqueClients int      `json:"unique_clients"`
+	Bytes         int64    `json:"bytes"`
+	Tenants       []string `json:"tenants,omitempty"` // tenants among the clients
+
+	// Downloads per CLI, e.g. "terraform/1.5.7"; downloads by other user agents are not counted
+	ClientVersions map[string]int64 `json:"client_versions,omitempty"`
+}
+
+// VersionStats is the usage of one provider version
+type VersionStats struct {
+	Version string `json:"version"`
+	Usage
+}
+
+// ProviderStats is the usage of one provider and its versions
+type ProviderStats struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Usage
+	Versions []VersionStats `json:"versions"`
+}
+
+// Report is the usage over a time window
+type Report struct {
+	Window    string          `json:"window"`
+	Since     time.Time       `json:"since"`
+	Total     Usage           `json:"total"`
+	Providers []ProviderStats `json:"providers"`
+}
+
+// counter is the stored usage of one provider version within an hour
+type counter struct {
+	Downloads int64    `json:"downloads"`
+	Bytes     int64    `json:"bytes"`
+	Clients   []string `json:"clients"` // hashed client addresses
+	Tenants   []string `json:"tenants,omitempty"`
+
+	ClientVersions map[string]int64 `json:"client_versions,omitempty"`
+}
+
+// pendingKey identifies a buffered counter
+type pendingKey struct {
+	hour    string
+	version string // "namespace/name/version"
+}
+
+// Store records archive downloads in hourly buckets of a bbolt database
+// Layout: hours/{YYYYMMDDHH}/{namespace}/{name}/{version} -> counter JSON,
+// meta/client_key -> the per-install key client addresses are hashed with
+type Store struct {
+	db        *bolt.DB
+	key       []byte
+	retention time.Duration
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	pending map[pendingKey]*pendingCounter
+	closed  bool
+}
+
+// pendingCounter is a counter not yet written to disk
+type pendingCounter struct {
+	downloads int64
+	bytes     int64
+	clients   map[string]struct{}
+	tenants   map[string]struct{}
+	cliCounts map[string]int64
+}
+
+// Open opens (or creates) the statistics database at path
+// Hours older than retention are pruned on flush (0 keeps everything)
+func Open(path string, retention time.Duration, logger *slog.Logger) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening stats database: %w", err)
+	}
+
+	var key []byte
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(hoursBucket); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if stored := meta.Get(clientKey); stored != nil {
+			key = append([]byte(nil), stored...)
+			return nil
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		return meta.Put(clientKey, key)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing stats database: %w", err)
+	}
+
+	return &Store{
+		db:        db,
+		key:       key,
+		retention: retention,
+		logger:    logger,
+		pending:   make(map[pendingKey]*pendingCounter),
+	}, nil
+}
+
+// Record buffers one archive download
+// client is the client address; only a hash of it is stored. tenant is "" without tenants,
+// cli the CLI product and version ("terraform/1.5.7") or "" for other user agents
+func (s *Store) Record(namespace, name, version, client, tenant, cli string, bytes int64) {
+	key := pendingKey{
+		hour:    time.Now().UTC().Format(hourFormat),
+		version: namespace + "/" + name + "/" + version,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pending[key]
+	if !ok {
+		p = &pendingCounter{clients: make(map[string]struct{}), tenants: make(map[string]struct{}), cliCounts: make(map[string]int64)}
+		s.pending[key] = p
+	}
+	p.downloads++
+	p.bytes += bytes
+	p.clients[s.clientID(client)] = struct{}{}
+	if tenant != "" {
+		p.tenants[tenant] = struct{}{}
+	}
+	if cli != "" {
+		p.cliCounts[cli]++
+	}
+}
+
+// Run periodically writes buffered downloads to disk until ctx is cancelled
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.logger.Error("failed to write download stats", "error", err)
+			}
+		}
+	}
+}
+
+// Flush writes buffered downloads to disk and prunes expired hours
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	return s.flushLocked()
+}
+
+func (s *Store) flushLocked() error {
+	cutoff := ""
+	if s.retention > 0 {
+		cutoff = time.Now().UTC().Add(-s.retention).Format(hourFormat)
+	}
+	if len(s.pending) == 0 && cutoff == "" {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		hours := tx.Bucket(hoursBucket)
+
+		for key, p := range s.pending {
+			hour, err := hours.CreateBucketIfNotExists([]byte(key.hour))
+			if err != nil {
+				return err
+			}
+
+			var c counter
+			if data := hour.Get([]byte(key.version)); data != nil {
+				if err := json.Unmarshal(data, &c); err != nil {
+					return fmt.Errorf("parsing %s/%s: %w", key.hour, key.version, err)
+				}
+			}
+			c.Downloads += p.downloads
+			c.Bytes += p.bytes
+			c.Clients = merge(c.Clients, p.clients)
+			c.Tenants = merge(c.Tenants, p.tenants)
+			c.ClientVersions = addCounts(c.ClientVersions, p.cliCounts)
+
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := hour.Put([]byte(key.version), data); err != nil {
+				return err
+			}
+		}
+
+		if cutoff == "" {
+			return nil
+		}
+		var expired [][]byte
+		cur := hours.Cursor()
+		for k, _ := cur.First(); k != nil && string(k) < cutoff; k, _ = cur.Next() {
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := hours.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.pending = make(map[pendingKey]*pendingCounter)
+	return nil
+}
+
+// Close flushes buffered downloads and closes the database
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	flushErr := s.flushLocked()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// Query aggregates downloads since now-window, optionally for one "namespace/name"
+// Providers are sorted by download count, versions by version string
+func (s *Store) Query(window time.Duration, provider string) (*Report, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+
+	since := time.Now().UTC().Add(-window).Truncate(time.Hour)
+	from := []byte(since.Format(hourFormat))
+
+	type aggregate struct {
+		downloads int64
+		bytes     int64
+		clients   map[string]struct{}
+		tenants   map[string]struct{}
+		cliCounts map[string]int64
+	}
+	newAggregate := func() *aggregate {
+		return &aggregate{clients: make(map[string]struct{}), tenants: make(map[string]struct{}), cliCounts: make(map[string]int64)}
+	}
+
+	total := newAggregate()
+	providers := make(map[string]*aggregate)
+	versions := make(map[string]*aggregate)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(hoursBucket).Cursor()
+		for k, _ := cur.Seek(from); k != nil; k, _ = cur.Next() {
+			hour := tx.Bucket(hoursBucket).Bucket(k)
+			if hour == nil {
+				continue
+			}
+			err := hour.ForEach(func(key, data []byte) error {
+				versionKey := string(key)
+				providerKey := versionKey[:strings.LastIndex(versionKey, "/")]
+				if provider != "" && providerKey != provider {
+					return nil
+				}
+
+				var c counter
+				if err := json.Unmarshal(data, &c); err != nil {
+					return fmt.Errorf("parsing %s/%s: %w", k, key, err)
+				}
+
+				for _, agg := range []*aggregate{total, lookup(providers, providerKey, newAggregate), lookup(versions, versionKey, newAggregate)} {
+					agg.downloads += c.Downloads
+					agg.bytes += c.Bytes
+					for _, id := range c.Clients {
+						agg.clients[id] = struct{}{}
+					}
+					for _, t := range c.Tenants {
+						agg.tenants[t] = struct{}{}
+					}
+					for cli, n := range c.ClientVersions {
+						agg.cliCounts[cli] += n
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage := func(a *aggregate) Usage {
+		return Usage{Downloads: a.downloads, UniqueClients: len(a.clients), Bytes: a.bytes, Tenants: merge(nil, a.tenants), ClientVersions: addCounts(nil, a.cliCounts)}
+	}
+
+	report := &Report{
+		Window:    window.String(),
+		Since:     since,
+		Total:     usage(total),
+		Providers: []ProviderStats{},
+	}
+	byProvider := make(map[string]*ProviderStats)
+	for key, agg := range providers {
+		namespace, name, _ := strings.Cut(key, "/")
+		byProvider[key] = &ProviderStats{Namespace: namespace, Name: name, Usage: usage(agg)}
+	}
+	for key, agg := range versions {
+		i := strings.LastIndex(key, "/")
+		p := byProvider[key[:i]]
+		p.Versions = append(p.Versions, VersionStats{Version: key[i+1:], Usage: usage(agg)})
+	}
+	for _, p := range byProvider {
+		sort.Slice(p.Versions, func(i, j int) bool { return p.Versions[i].Version < p.Versions[j].Version })
+		report.Providers = append(report.Providers, *p)
+	}
+	sort.Slice(report.Providers, func(i, j int) bool {
+		a, b := report.Providers[i], report.Providers[j]
+		if a.Downloads != b.Downloads {
+			return a.Downloads > b.Downloads
+		}
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	return report, nil
+}
+
+// lookup returns m[key], creating it with newValue when missing
+func lookup[T any](m map[string]*T, key string, newValue func() *T) *T {
+	v, ok := m[key]
+	if !ok {
+		v = newValue()
+		m[key] = v
+	}
+	return v
+}
+
+// merge returns the sorted union of stored and buffered values (client IDs or tenants)
+func merge(stored []string, pending map[string]struct{}) []string {
+	set := make(map[string]struct{}, len(stored)+len(pending))
+	for _, id := range stored {
+		set[id] = struct{}{}
+	}
+	for id := range pending {
+		set[id] = struct{}{}
+	}
+
+	result := make([]string, 0, len(set))
+	for id := range set {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// addCounts adds buffered counts to stored ones; the result is nil when both are empty
+func addCounts(stored, pending map[string]int64) map[string]int64 {
+	if len(pending) == 0 {
+		return stored
+	}
+	if stored == nil {
+		stored = make(map[string]int64, len(pending))
+	}
+	for k, n := range pending {
+		stored[k] += n
+	}
+	return stored
+}
+
+// clientID hashes a client address so raw IPs are never stored
+// The hash is keyed with a random per-install key, so the small IPv4 space cannot be
+// enumerated to recover addresses from a copy of the database
+func (s *Store) clientID(client string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(client))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,222 @@
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// namePattern limits tenant names to values that are safe in metric tags and logs
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Tenant is a team sharing the mirror
+type Tenant struct {
+	Name string `json:"name"`
+
+	// Bearer tokens and client certificate identities (CN or first SAN) of the tenant
+	Tokens     []string `json:"tokens"`
+	Identities []string `json:"identities"`
+
+	// Subjects of OIDC JWTs (the TF_MIRROR_OIDC_CLAIM claim) that authenticate as the tenant,
+	// e.g. "repo:acme/infra:*" for a CI workload identity; "*" matches any characters
+	Subjects []string `json:"subjects"`
+
+	// Providers the tenant may use: "namespace/type", "namespace/*" or "*" (empty allows all)
+	Providers []string `json:"providers"`
+
+	// Archive cache bytes the tenant may fill (0 = unlimited)
+	QuotaBytes int64 `json:"quota_bytes"`
+
+	// Role of the tenant's credentials: "read" (default), "publish" or "admin"
+	Role string `json:"role"`
+}
+
+// Allows reports whether the tenant may use a provider
+func (t *Tenant) Allows(namespace, name string) bool {
+	if len(t.Providers) == 0 {
+		return true
+	}
+	for _, p := range t.Providers {
+		ns, n, _ := strings.Cut(p, "/")
+		if p == "*" || (strings.EqualFold(ns, namespace) && (n == "*" || strings.EqualFold(n, name))) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantsFile is the tenants file format
+type tenantsFile struct {
+	Tenants []*Tenant `json:"tenants"`
+}
+
+// Set is the configured tenants
+// Tokens are indexed by their SHA-256 digest so lookups do not compare secrets directly
+type Set struct {
+	tenants    []*Tenant
+	byToken    map[[sha256.Size]byte]*Tenant
+	byIdentity map[string]*Tenant
+}
+
+// Load reads tenants from a JSON file
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file tenantsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return newSet(file.Tenants)
+}
+
+func newSet(tenants []*Tenant) (*Set, error) {
+	s := &Set{
+		byToken:    make(map[[sha256.Size]byte]*Tenant),
+		byIdentity: make(map[string]*Tenant),
+	}
+
+	names := make(map[string]struct{})
+	for _, t := range tenants {
+		if !namePattern.MatchString(t.Name) {
+			return nil, fmt.Errorf("invalid tenant name %q", t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		if len(t.Tokens) == 0 && len(t.Identities) == 0 && len(t.Subjects) == 0 {
+			return nil, fmt.Errorf("tenant %q has no tokens, identities or subjects", t.Name)
+		}
+		for _, token := range t.Tokens {
+			key := sha256.Sum256([]byte(token))
+			if _, ok := s.byToken[key]; ok || token == "" {
+				return nil, fmt.Errorf("tenant %q: empty or duplicate token", t.Name)
+			}
+			s.byToken[key] = t
+		}
+		for _, identity := range t.Identities {
+			if _, ok := s.byIdentity[identity]; ok {
+				return nil, fmt.Errorf("tenant %q: identity %q belongs to another tenant", t.Name, identity)
+			}
+			s.byIdentity[identity] = t
+		}
+		for _, subject := range t.Subjects {
+			if subject == "" || subject == "*" {
+				return nil, fmt.Errorf("tenant %q: subject pattern %q matches any subject", t.Name, subject)
+			}
+		}
+		for _, p := range t.Providers {
+			if ns, n, ok := strings.Cut(p, "/"); p != "*" && (!ok || ns == "" || n == "") {
+				return nil, fmt.Errorf("tenant %q: invalid provider pattern %q", t.Name, p)
+			}
+		}
+		if t.QuotaBytes < 0 {
+			return nil, fmt.Errorf("tenant %q: negative quota", t.Name)
+		}
+		switch t.Role {
+		case "", "read", "publish", "admin":
+		default:
+			return nil, fmt.Errorf("tenant %q: unknown role %q (read, publish or admin)", t.Name, t.Role)
+		}
+
+		s.tenants = append(s.tenants, t)
+	}
+	return s, nil
+}
+
+// Tenants returns the configured tenants in file order
+func (s *Set) Tenants() []*Tenant {
+	return s.tenants
+}
+
+// ByToken returns the tenant a bearer token belongs to
+func (s *Set) ByToken(token string) (*Tenant, bool) {
+	if token == "" {
+		return nil, false
+	}
+	t, ok := s.byToken[sha256.Sum256([]byte(token))]
+	return t, ok
+}
+
+// ByName returns a tenant by name
+func (s *Set) ByName(name string) (*Tenant, bool) {
+	for _, t := range s.tenants {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// ByIdentity returns the tenant a client certificate identity belongs to
+func (s *Set) ByIdentity(identity string) (*Tenant, bool) {
+	t, ok := s.byIdentity[identity]
+	return t, ok
+}
+
+// BySubject returns the first tenant, in file order, with a subject pattern matching an OIDC subject
+func (s *Set) BySubject(subject string) (*Tenant, bool) {
+	if subject == "" {
+		return nil, false
+	}
+	for _, t := range s.tenants {
+		for _, pattern := range t.Subjects {
+			if matchSubject(pattern, subject) {
+				return t, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// matchSubject matches a subject against a pattern in which "*" stands for any characters
+func matchSubject(pattern, subject string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == subject
+	}
+	if !strings.HasPrefix(subject, parts[0]) {
+		return false
+	}
+	subject = subject[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(subject, part)
+		if i < 0 {
+			return false
+		}
+		subject = subject[i+len(part):]
+	}
+	return strings.HasSuffix(subject, parts[len(parts)-1])
+}
+
+// Quotas returns the archive cache quota of every tenant that has one
+func (s *Set) Quotas() map[string]int64 {
+	result := make(map[string]int64)
+	for _, t := range s.tenants {
+		if t.QuotaBytes > 0 {
+			result[t.Name] = t.QuotaBytes
+		}
+	}
+	return result
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tenant of a request
+func NewContext(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant of a request (nil when tenants are not configured)
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
@@ -0,0 +1,121 @@
+package testutil
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS is a fake DNS server answering A queries for fixed names over UDP and DNS-over-HTTPS
+// Other names are answered with NXDOMAIN, other record types with no answers
+type DNS struct {
+	Addr   string // UDP server address, for TF_MIRROR_UPSTREAM_DNS
+	DoHURL string // DNS-over-HTTPS endpoint, for TF_MIRROR_UPSTREAM_DOH
+
+	records map[string]netip.Addr
+
+	mu      sync.Mutex
+	queries map[string]int // A queries by name, without the trailing dot
+}
+
+// NewDNS starts a fake DNS server resolving names to IPv4 addresses ("registry.test" → "127.0.0.1")
+// It is shut down when the test ends
+func NewDNS(t testing.TB, records map[string]string) *DNS {
+	t.Helper()
+
+	d := &DNS{records: make(map[string]netip.Addr), queries: make(map[string]int)}
+	for name, ip := range records {
+		d.records[strings.ToLower(name)+"."] = netip.MustParseAddr(ip)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	d.Addr = conn.LocalAddr().String()
+	go d.serveUDP(conn)
+
+	server := httptest.NewServer(http.HandlerFunc(d.handleDoH))
+	t.Cleanup(server.Close)
+	d.DoHURL = server.URL + "/dns-query"
+	return d
+}
+
+// Queries returns how often a name was looked up
+func (d *DNS) Queries(name string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries[strings.ToLower(name)]
+}
+
+func (d *DNS) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if answer, err := d.answer(buf[:n]); err == nil {
+			_, _ = conn.WriteTo(answer, addr)
+		}
+	}
+}
+
+func (d *DNS) handleDoH(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+		http.Error(w, "expected a POSTed application/dns-message", http.StatusBadRequest)
+		return
+	}
+	query, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	answer, err := d.answer(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	_, _ = w.Write(answer)
+}
+
+// answer builds the response to a DNS query
+func (d *DNS) answer(query []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	msg.Header.Response = true
+	msg.Header.Authoritative = true
+	msg.Header.RCode = dnsmessage.RCodeSuccess
+	for _, q := range msg.Questions {
+		name := strings.ToLower(q.Name.String())
+		addr, ok := d.records[name]
+		if !ok {
+			msg.Header.RCode = dnsmessage.RCodeNameError
+			continue
+		}
+		if q.Type != dnsmessage.TypeA {
+			continue
+		}
+		d.mu.Lock()
+		d.queries[strings.TrimSuffix(name, ".")]++
+		d.mu.Unlock()
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: addr.As4()},
+		})
+	}
+	msg.Additionals = nil
+	return msg.Pack()
+}
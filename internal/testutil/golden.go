@@ -0,0 +1,44 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites golden files with the current output: go test ./... -update
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// Golden compares got with testdata/{name}.golden
+// JSON is indented first so golden files stay readable and diffs small
+func Golden(t testing.TB, name string, got []byte) {
+	t.Helper()
+
+	var indented bytes.Buffer
+	if json.Indent(&indented, got, "", "  ") == nil {
+		indented.WriteByte('\n')
+		got = indented.Bytes()
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file %s (run with -update to accept)\ngot:\n%s\nwant:\n%s", name, path, got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package testutil
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ObjectStore is a fake S3-compatible bucket with path-style URLs
+// It accepts unsigned requests and supports GET, HEAD, PUT and ListObjectsV2
+type ObjectStore struct {
+	URL string // bucket URL, for TF_MIRROR_OBJECT_STORE_URL
+
+	server *httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    map[string]int // GET requests by key
+}
+
+// NewObjectStore starts a fake bucket that is shut down when the test ends
+func NewObjectStore(t testing.TB) *ObjectStore {
+	t.Helper()
+
+	o := &ObjectStore{
+		objects: make(map[string][]byte),
+		gets:    make(map[string]int),
+	}
+	o.server = httptest.NewServer(http.HandlerFunc(o.handle))
+	o.URL = o.server.URL + "/mirror"
+	t.Cleanup(o.server.Close)
+	return o
+}
+
+// Object returns a stored object
+func (o *ObjectStore) Object(key string) ([]byte, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	data, ok := o.objects[key]
+	return data, ok
+}
+
+// Gets returns how often an object was downloaded
+func (o *ObjectStore) Gets(key string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.gets[key]
+}
+
+func (o *ObjectStore) handle(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/mirror/")
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		type content struct {
+			Key string `xml:"Key"`
+		}
+		var result struct {
+			XMLName  xml.Name  `xml:"ListBucketResult"`
+			Contents []content `xml:"Contents"`
+		}
+		prefix := r.URL.Query().Get("prefix")
+		for k := range o.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, content{Key: k})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		o.objects[key] = data
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := o.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			o.gets[key]++
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
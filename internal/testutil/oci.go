@@ -0,0 +1,185 @@
+package testutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// OCIRegistry is a fake OCI registry holding providers in the OpenTofu layout
+// Like public registries such as ghcr.io, it requires anonymous bearer tokens
+type OCIRegistry struct {
+	URL string // base URL, e.g. "http://127.0.0.1:41234"
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string]map[string][]byte // repository -> tag or digest -> manifest
+	blobs     map[string][]byte            // digest -> content
+}
+
+// NewOCIRegistry starts a fake OCI registry that is shut down when the test ends
+func NewOCIRegistry(t testing.TB) *OCIRegistry {
+	t.Helper()
+
+	o := &OCIRegistry{
+		manifests: make(map[string]map[string][]byte),
+		blobs:     make(map[string][]byte),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", o.handleToken)
+	mux.HandleFunc("GET /v2/", o.handleAPI)
+
+	o.server = httptest.NewServer(mux)
+	o.URL = o.server.URL
+	t.Cleanup(o.server.Close)
+	return o
+}
+
+// Repository returns the TF_MIRROR_OCI_PROVIDERS value of a repository
+func (o *OCIRegistry) Repository(repo string) string {
+	return o.URL + "/" + repo
+}
+
+// AddVersion pushes a provider version as a tagged image index with one manifest per platform
+// The archives are the same as those of Registry
+func (o *OCIRegistry) AddVersion(repo, namespace, name, version string, platforms ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.manifests[repo] == nil {
+		o.manifests[repo] = make(map[string][]byte)
+	}
+
+	var entries []map[string]any
+	for _, p := range platforms {
+		archive := Archive(namespace, name, version, p)
+		layer := o.push(archive)
+		manifest := mustJSON(map[string]any{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"artifactType":  "application/vnd.opentofu.provider-target",
+			"config":        map[string]any{"mediaType": "application/vnd.oci.empty.v1+json", "digest": o.push([]byte("{}")), "size": 2},
+			"layers": []map[string]any{{
+				"mediaType":   "archive/zip",
+				"digest":      layer,
+				"size":        len(archive),
+				"annotations": map[string]string{"org.opencontainers.image.title": ArchiveFilename(name, version, p)},
+			}},
+		})
+		digest := digestOf(manifest)
+		o.manifests[repo][digest] = manifest
+
+		osName, arch, _ := strings.Cut(p, "_")
+		entries = append(entries, map[string]any{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest":    digest,
+			"size":      len(manifest),
+			"platform":  map[string]string{"os": osName, "architecture": arch},
+		})
+	}
+
+	o.manifests[repo][version] = mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"artifactType":  "application/vnd.opentofu.provider",
+		"manifests":     entries,
+	})
+}
+
+// push stores a blob and returns its digest; o.mu must be held
+func (o *OCIRegistry) push(data []byte) string {
+	digest := digestOf(data)
+	o.blobs[digest] = data
+	return digest
+}
+
+// handleToken issues a pull token for the requested scope
+func (o *OCIRegistry) handleToken(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{"token": "token-" + r.URL.Query().Get("scope"), "expires_in": 300})
+}
+
+// handleAPI serves /v2/, tags/list, manifests and blobs
+func (o *OCIRegistry) handleAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v2/")
+	repo, kind, ref := "", "", ""
+	for _, marker := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(rest, marker); i > 0 {
+			repo, kind, ref = rest[:i], strings.Trim(marker, "/"), rest[i+len(marker):]
+			break
+		}
+	}
+
+	if r.Header.Get("Authorization") != "Bearer token-repository:"+repo+":pull" || repo == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+o.URL+`/token",service="testutil"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	manifests, ok := o.manifests[repo]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	switch kind {
+	case "tags":
+		var tags []string
+		for ref := range manifests {
+			if !strings.HasPrefix(ref, "sha256:") {
+				tags = append(tags, ref)
+			}
+		}
+		sort.Strings(tags)
+		writeJSON(w, map[string]any{"name": repo, "tags": tags})
+
+	case "manifests":
+		manifest, ok := manifests[ref]
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		_ = json.Unmarshal(manifest, &m)
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Docker-Content-Digest", digestOf(manifest))
+		_, _ = w.Write(manifest)
+
+	case "blobs":
+		blob, ok := o.blobs[ref]
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(blob)
+
+	default:
+		writeNotFound(w)
+	}
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
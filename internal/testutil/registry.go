@@ -0,0 +1,398 @@
+package testutil
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// archiveTime is the modification time of files in fake archives, so their hashes never change
+var archiveTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Registry is a fake upstream registry speaking the provider registry protocol
+// Archives are small generated ZIP files; their SHA256SUMS are served alongside
+type Registry struct {
+	URL string // base URL, for TF_MIRROR_UPSTREAM_URL
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	providers map[string]map[string][]string // "namespace/name" → version → platforms ("os_arch")
+	requests  map[string]int                 // request counts by path
+	conns     int                            // connections accepted
+	failing   bool
+
+	// Requests still to be answered with 429 Too Many Requests and their Retry-After
+	rateLimited int
+	retryAfter  string
+
+	// Versions lists served again until revalidated, as by a CDN (nil when disabled)
+	cachedVersions map[string][]byte
+
+	// Fields merged into every versions list, replacing those of the protocol
+	versionsFields map[string]any
+
+	// Versions whose archives are packed without Unix permissions ("namespace/name/version")
+	windowsPacked map[string]bool
+
+	// Versions whose archives carry extra files next to the provider binary ("namespace/name/version")
+	extraFiles map[string]extraFiles
+}
+
+// extraFiles are files packed next to the provider binary, with their modification time
+type extraFiles struct {
+	names    []string
+	modified time.Time
+}
+
+// NewRegistry starts a fake registry that is shut down when the test ends
+func NewRegistry(t testing.TB) *Registry {
+	t.Helper()
+
+	r := &Registry{
+		providers:     make(map[string]map[string][]string),
+		requests:      make(map[string]int),
+		windowsPacked: make(map[string]bool),
+		extraFiles:    make(map[string]extraFiles),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/terraform.json", r.handleDiscovery)
+	mux.HandleFunc("GET /v1/providers/{namespace}/{name}/versions", r.handleVersions)
+	mux.HandleFunc("GET /v1/providers/{namespace}/{name}/{version}/download/{os}/{arch}", r.handleDownload)
+	mux.HandleFunc("GET /files/{namespace}/{name}/{file}", r.handleFile)
+
+	r.server = httptest.NewUnstartedServer(r.count(mux))
+	r.server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			r.mu.Lock()
+			r.conns++
+			r.mu.Unlock()
+		}
+	}
+	r.server.Start()
+	r.URL = r.server.URL
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// AddVersion publishes a provider version for the given platforms ("linux_amd64")
+func (r *Registry) AddVersion(namespace, name, version string, platforms ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := namespace + "/" + name
+	if r.providers[key] == nil {
+		r.providers[key] = make(map[string][]string)
+	}
+	r.providers[key][version] = platforms
+}
+
+// PackOnWindows serves the archives of a version as packed by Windows tools: without Unix
+// permissions, so the provider binary is not executable once extracted
+func (r *Registry) PackOnWindows(namespace, name, version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.windowsPacked[namespace+"/"+name+"/"+version] = true
+}
+
+// AddExtraFiles packs extra files into the archives of a version, as some release pipelines
+// do; their content names the platform and they are stamped with the time of the call, so
+// calling it again republishes the version as by another build
+func (r *Registry) AddExtraFiles(namespace, name, version string, files ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extraFiles[namespace+"/"+name+"/"+version] = extraFiles{names: files, modified: time.Now()}
+}
+
+// SetFailing makes every request fail with 503 Service Unavailable, as an unreachable upstream
+func (r *Registry) SetFailing(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = failing
+}
+
+// SetRateLimited answers the next n requests with 429 Too Many Requests and Retry-After
+func (r *Registry) SetRateLimited(n int, retryAfter string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rateLimited = n
+	r.retryAfter = retryAfter
+}
+
+// CacheVersions serves the first versions list of every provider again, as a CDN in front of
+// the registry would, until a request carries Cache-Control: no-cache
+func (r *Registry) CacheVersions() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cachedVersions = make(map[string][]byte)
+}
+
+// SetVersionsFields merges fields into every versions list, as a changed registry API would
+// serve them: new fields next to those of the protocol, or protocol fields of another shape
+func (r *Registry) SetVersionsFields(fields map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.versionsFields = fields
+}
+
+// Requests returns how often a path was requested, e.g. "/v1/providers/hashicorp/random/versions"
+func (r *Registry) Requests(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests[path]
+}
+
+// Connections returns how many connections the registry has accepted
+func (r *Registry) Connections() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns
+}
+
+// ArchivePath returns the path an archive is downloaded from
+func ArchivePath(namespace, name, version, platform string) string {
+	return "/files/" + namespace + "/" + name + "/" + ArchiveFilename(name, version, platform)
+}
+
+// ArchiveFilename returns the file name of a provider archive
+func ArchiveFilename(name, version, platform string) string {
+	return fmt.Sprintf("terraform-provider-%s_%s_%s.zip", name, version, platform)
+}
+
+// Archive returns the ZIP archive served for a provider version and platform
+// It holds a single executable provider binary whose content names the archive
+func Archive(namespace, name, version, platform string) []byte {
+	return packArchive(namespace, name, version, platform, true, extraFiles{})
+}
+
+// packArchive builds a fake archive, with or without Unix permissions and with extra files
+func packArchive(namespace, name, version, platform string, unixModes bool, extra extraFiles) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	header := &zip.FileHeader{Name: fmt.Sprintf("terraform-provider-%s_v%s", name, version), Method: zip.Deflate, Modified: archiveTime}
+	if strings.HasPrefix(platform, "windows_") {
+		header.Name += ".exe"
+	}
+	if unixModes {
+		header.SetMode(0755)
+	}
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(fw, "fake provider %s/%s %s %s\n", namespace, name, version, platform)
+	for _, file := range extra.names {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Deflate, Modified: extra.modified})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(fw, "%s for %s\n", file, platform)
+	}
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Shasum returns the hex SHA-256 of an archive, as published in SHA256SUMS
+func Shasum(namespace, name, version, platform string) string {
+	sum := sha256.Sum256(Archive(namespace, name, version, platform))
+	return hex.EncodeToString(sum[:])
+}
+
+// archive returns the archive the registry serves, see PackOnWindows and AddExtraFiles
+func (r *Registry) archive(namespace, name, version, platform string) []byte {
+	r.mu.Lock()
+	windows := r.windowsPacked[namespace+"/"+name+"/"+version]
+	extra := r.extraFiles[namespace+"/"+name+"/"+version]
+	r.mu.Unlock()
+	return packArchive(namespace, name, version, platform, !windows, extra)
+}
+
+// shasum returns the hex SHA-256 of an archive the registry serves
+func (r *Registry) shasum(namespace, name, version, platform string) string {
+	sum := sha256.Sum256(r.archive(namespace, name, version, platform))
+	return hex.EncodeToString(sum[:])
+}
+
+// count records requests and fails them while the registry is failing or rate limiting
+func (r *Registry) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.requests[req.URL.Path]++
+		failing := r.failing
+		limited := r.rateLimited > 0
+		if limited {
+			r.rateLimited--
+		}
+		retryAfter := r.retryAfter
+		r.mu.Unlock()
+
+		if failing {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if limited {
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// platforms returns the platforms of a published version
+func (r *Registry) platforms(namespace, name, version string) ([]string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	platforms, ok := r.providers[namespace+"/"+name][version]
+	return platforms, ok
+}
+
+func (r *Registry) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]string{"providers.v1": "/v1/providers/"})
+}
+
+func (r *Registry) handleVersions(w http.ResponseWriter, req *http.Request) {
+	key := req.PathValue("namespace") + "/" + req.PathValue("name")
+
+	r.mu.Lock()
+	if cached, ok := r.cachedVersions[key]; ok && req.Header.Get("Cache-Control") != "no-cache" {
+		r.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(cached)
+		return
+	}
+	published, ok := r.providers[key]
+	type platform struct {
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+	}
+	type version struct {
+		Version   string     `json:"version"`
+		Protocols []string   `json:"protocols"`
+		Platforms []platform `json:"platforms"`
+	}
+	list := make([]version, 0, len(published))
+	for v, platforms := range published {
+		entry := version{Version: v, Protocols: []string{"5.0"}, Platforms: []platform{}}
+		for _, p := range platforms {
+			osName, arch, _ := strings.Cut(p, "_")
+			entry.Platforms = append(entry.Platforms, platform{OS: osName, Arch: arch})
+		}
+		list = append(list, entry)
+	}
+	doc := map[string]any{}
+	for k, v := range r.versionsFields {
+		doc[k] = v
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	if _, replaced := doc["versions"]; !replaced {
+		doc["versions"] = list
+	}
+	data, _ := json.Marshal(doc)
+
+	r.mu.Lock()
+	if r.cachedVersions != nil {
+		r.cachedVersions[key] = data
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (r *Registry) handleDownload(w http.ResponseWriter, req *http.Request) {
+	namespace, name, version := req.PathValue("namespace"), req.PathValue("name"), req.PathValue("version")
+	platform := req.PathValue("os") + "_" + req.PathValue("arch")
+
+	platforms, ok := r.platforms(namespace, name, version)
+	if !ok || !slices.Contains(platforms, platform) {
+		writeNotFound(w)
+		return
+	}
+
+	files := r.URL + "/files/" + namespace + "/" + name + "/"
+	shasums := fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", name, version)
+	writeJSON(w, map[string]any{
+		"protocols":             []string{"5.0"},
+		"os":                    req.PathValue("os"),
+		"arch":                  req.PathValue("arch"),
+		"filename":              ArchiveFilename(name, version, platform),
+		"download_url":          r.URL + ArchivePath(namespace, name, version, platform),
+		"shasums_url":           files + shasums,
+		"shasums_signature_url": files + shasums + ".sig",
+		"shasum":                r.shasum(namespace, name, version, platform),
+		"signing_keys":          map[string]any{"gpg_public_keys": []any{}},
+	})
+}
+
+func (r *Registry) handleFile(w http.ResponseWriter, req *http.Request) {
+	namespace, name, file := req.PathValue("namespace"), req.PathValue("name"), req.PathValue("file")
+
+	prefix := "terraform-provider-" + name + "_"
+	rest, ok := strings.CutPrefix(file, prefix)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	version, suffix, _ := strings.Cut(rest, "_")
+	platforms, ok := r.platforms(namespace, name, version)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	switch {
+	case suffix == "SHA256SUMS":
+		var sums strings.Builder
+		sorted := append([]string(nil), platforms...)
+		sort.Strings(sorted)
+		for _, p := range sorted {
+			fmt.Fprintf(&sums, "%s  %s\n", r.shasum(namespace, name, version, p), ArchiveFilename(name, version, p))
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(sums.String()))
+
+	case suffix == "SHA256SUMS.sig":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte("fake signature of " + prefix + version + "_SHA256SUMS"))
+
+	case strings.HasSuffix(suffix, ".zip") && slices.Contains(platforms, strings.TrimSuffix(suffix, ".zip")):
+		w.Header().Set("Content-Type", "application/zip")
+		_, _ = w.Write(r.archive(namespace, name, version, strings.TrimSuffix(suffix, ".zip")))
+
+	default:
+		writeNotFound(w)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"errors":["Not Found"]}`))
+}
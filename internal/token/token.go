@@ -0,0 +1,149 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Prefix marks tokens issued by the mirror
+const Prefix = "tfm1."
+
+var (
+	// ErrInvalid is returned for tokens that are malformed or not signed by this mirror
+	ErrInvalid = errors.New("invalid token")
+
+	// ErrExpired is returned for tokens past their expiry
+	ErrExpired = errors.New("token expired")
+)
+
+// Claims are the contents of a mirror token
+type Claims struct {
+	Subject   string    `json:"sub"`
+	Tenant    string    `json:"tenant"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+	AuthTime  time.Time `json:"auth_time"` // of the login or issuance the token was refreshed from
+}
+
+// authTime returns when the subject authenticated; tokens from before
+// auth_time was recorded count from their issuance
+func (c Claims) authTime() time.Time {
+	if c.AuthTime.IsZero() {
+		return c.IssuedAt
+	}
+	return c.AuthTime
+}
+
+// Issuer signs and verifies mirror tokens with an HMAC secret
+// Format: tfm1.{base64url claims}.{base64url HMAC-SHA256}
+type Issuer struct {
+	secret []byte
+	ttl    time.Duration
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewIssuer creates an issuer; ttl is the lifetime of issued tokens and maxAge
+// how long refreshing extends them past the original authentication
+func NewIssuer(secret string, ttl, maxAge time.Duration) *Issuer {
+	return &Issuer{secret: []byte(secret), ttl: ttl, maxAge: maxAge, now: time.Now}
+}
+
+// TTL returns the default lifetime of issued tokens
+func (i *Issuer) TTL() time.Duration {
+	return i.ttl
+}
+
+// Issue returns a signed token for a subject of a tenant
+// A ttl of 0 uses the issuer's default
+func (i *Issuer) Issue(subject, tenant string, ttl time.Duration) (string, Claims, error) {
+	if ttl <= 0 {
+		ttl = i.ttl
+	}
+	now := i.now().UTC().Truncate(time.Second)
+	return i.sign(Claims{Subject: subject, Tenant: tenant, IssuedAt: now, ExpiresAt: now.Add(ttl), AuthTime: now})
+}
+
+// Refresh returns a new token for the claims of a verified token, keeping its authentication time
+// Refreshed tokens expire maxAge after it at the latest; after that the subject has to log in again
+func (i *Issuer) Refresh(claims Claims) (string, Claims, error) {
+	now := i.now().UTC().Truncate(time.Second)
+	authTime := claims.authTime()
+	limit := authTime.Add(i.maxAge)
+	if !now.Before(limit) {
+		return "", Claims{}, ErrExpired
+	}
+	expires := now.Add(i.ttl)
+	if expires.After(limit) {
+		expires = limit
+	}
+	return i.sign(Claims{Subject: claims.Subject, Tenant: claims.Tenant, IssuedAt: now, ExpiresAt: expires, AuthTime: authTime})
+}
+
+// sign encodes and signs claims
+func (i *Issuer) sign(claims Claims) (string, Claims, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	body := Prefix + base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + base64.RawURLEncoding.EncodeToString(i.mac(body)), claims, nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims
+func (i *Issuer) Verify(token string) (Claims, error) {
+	body, sig, ok := cutLast(token)
+	if !ok || !strings.HasPrefix(body, Prefix) {
+		return Claims{}, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, i.mac(body)) {
+		return Claims{}, ErrInvalid
+	}
+
+	claims, err := Parse(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if i.now().After(claims.ExpiresAt) {
+		return claims, ErrExpired
+	}
+	return claims, nil
+}
+
+// Parse returns the claims of a token without verifying it
+// Clients use it to see when their token needs refreshing
+func Parse(token string) (Claims, error) {
+	body, _, ok := cutLast(token)
+	if !ok || !strings.HasPrefix(body, Prefix) {
+		return Claims{}, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(body, Prefix))
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, ErrInvalid
+	}
+	return claims, nil
+}
+
+func (i *Issuer) mac(body string) []byte {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+func cutLast(token string) (string, string, bool) {
+	idx := strings.LastIndex(token, ".")
+	if idx <= 0 || idx == len(token)-1 {
+		return "", "", false
+	}
+	return token[:idx], token[idx+1:], true
+}
@@ -0,0 +1,83 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testIssuer returns an issuer with a 1h token lifetime, refreshable for 3h, and a clock the test moves
+func testIssuer() (*Issuer, *time.Time) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	i := NewIssuer("secret", time.Hour, 3*time.Hour)
+	i.now = func() time.Time { return now }
+	return i, &now
+}
+
+func TestVerifyExpiry(t *testing.T) {
+	i, now := testIssuer()
+	tok, _, err := i.Issue("alice", "payments", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := i.Verify(tok)
+	if err != nil || claims.Subject != "alice" || claims.Tenant != "payments" {
+		t.Fatalf("fresh token: %+v, %v", claims, err)
+	}
+	*now = now.Add(time.Hour + time.Second)
+	if _, err := i.Verify(tok); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired token: got %v, want %v", err, ErrExpired)
+	}
+	if _, err := NewIssuer("other", time.Hour, 3*time.Hour).Verify(tok); !errors.Is(err, ErrInvalid) {
+		t.Errorf("token of another secret: got %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestRefreshKeepsAuthTime(t *testing.T) {
+	i, now := testIssuer()
+	login := *now
+	tok, _, err := i.Issue("alice", "payments", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshing every 40 minutes keeps the token alive until 3h after the login, not beyond
+	limit := login.Add(3 * time.Hour)
+	var claims Claims
+	for *now = now.Add(40 * time.Minute); now.Before(limit); *now = now.Add(40 * time.Minute) {
+		if claims, err = i.Verify(tok); err != nil {
+			t.Fatalf("at %s: %v", now.Sub(login), err)
+		}
+		var refreshed Claims
+		if tok, refreshed, err = i.Refresh(claims); err != nil {
+			t.Fatalf("refresh at %s: %v", now.Sub(login), err)
+		}
+		if !refreshed.AuthTime.Equal(login) || refreshed.ExpiresAt.After(limit) {
+			t.Errorf("refresh at %s: auth time %s, expiry %s", now.Sub(login), refreshed.AuthTime, refreshed.ExpiresAt)
+		}
+	}
+	if _, err := i.Verify(tok); !errors.Is(err, ErrExpired) {
+		t.Errorf("token past the maximum age: got %v, want %v", err, ErrExpired)
+	}
+	if _, _, err := i.Refresh(claims); !errors.Is(err, ErrExpired) {
+		t.Errorf("refresh past the maximum age: got %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestRefreshTokenWithoutAuthTime(t *testing.T) {
+	// Tokens issued before auth_time was recorded count from their issuance
+	i, now := testIssuer()
+	issued := *now
+	claims := Claims{Subject: "alice", Tenant: "payments", IssuedAt: issued, ExpiresAt: issued.Add(time.Hour)}
+
+	*now = issued.Add(30 * time.Minute)
+	_, refreshed, err := i.Refresh(claims)
+	if err != nil || !refreshed.AuthTime.Equal(issued) {
+		t.Errorf("refresh: auth time %s, %v; want %s", refreshed.AuthTime, err, issued)
+	}
+	*now = issued.Add(3 * time.Hour)
+	if _, _, err := i.Refresh(claims); !errors.Is(err, ErrExpired) {
+		t.Errorf("refresh at the maximum age: got %v, want %v", err, ErrExpired)
+	}
+}
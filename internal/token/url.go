@@ -0,0 +1,61 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// URLSigner signs and verifies time-limited URLs with an HMAC secret
+// A signed URL carries ?expires={unix seconds}&signature={base64url HMAC-SHA256 of "{expires}\n{path}"}
+type URLSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewURLSigner creates a URL signer; ttl is the minimum lifetime of signed URLs
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	return &URLSigner{secret: []byte(secret), ttl: ttl}
+}
+
+// Expiry returns the expiry of URLs signed now: ttl rounded up to a whole ttl window, so URLs
+// signed within one window are identical and share an entry in HTTP caches
+// A URL is valid for at least ttl and less than twice ttl
+func (s *URLSigner) Expiry() time.Time {
+	return time.Now().Truncate(s.ttl).Add(2 * s.ttl)
+}
+
+// Sign returns the query string of a path valid until expires
+func (s *URLSigner) Sign(path string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", base64.RawURLEncoding.EncodeToString(s.sign(exp, path)))
+	return q.Encode()
+}
+
+// Verify checks the signature and expiry in the query of a request for path
+func (s *URLSigner) Verify(path string, query url.Values) error {
+	exp := query.Get("expires")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(mac, s.sign(exp, path)) {
+		return ErrInvalid
+	}
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s *URLSigner) sign(expires, path string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(expires + "\n" + path))
+	return mac.Sum(nil)
+}
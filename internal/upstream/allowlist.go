@@ -0,0 +1,101 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned when a URL points outside the download allowlist
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// maxRedirects matches the net/http default
+const maxRedirects = 10
+
+// Allowlist matches hosts that archives and artifacts may be downloaded from
+// Entries are exact hostnames ("github.com") or wildcards ("*.example.com");
+// a single "*" allows any host
+type Allowlist struct {
+	any      bool
+	hosts    map[string]struct{}
+	suffixes []string
+}
+
+// NewAllowlist creates an allowlist from host patterns
+func NewAllowlist(patterns []string) *Allowlist {
+	a := &Allowlist{hosts: make(map[string]struct{})}
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "*":
+			a.any = true
+		case strings.HasPrefix(p, "*."):
+			a.suffixes = append(a.suffixes, p[1:])
+		case p != "":
+			a.hosts[p] = struct{}{}
+		}
+	}
+	return a
+}
+
+// Allows reports whether u may be fetched
+func (a *Allowlist) Allows(u *url.URL) bool {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	if a.any {
+		return true
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if _, ok := a.hosts[host]; ok {
+		return true
+	}
+	for _, suffix := range a.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check validates a raw URL against the allowlist
+func (a *Allowlist) check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parsing URL: %w", err)
+	}
+	if !a.Allows(u) {
+		return fmt.Errorf("%s: %w", u.Host, ErrHostNotAllowed)
+	}
+	return nil
+}
+
+// checkURL validates an absolute URL: the upstream registry host and hosts with an authorizer
+// are always allowed (e.g. Artifactory serves archives itself), other hosts must be in the allowlist
+func (c *Client) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err == nil && c.trusted(u.Host) && (u.Scheme == "https" || u.Scheme == "http") {
+		return nil
+	}
+	return c.allowlist.check(rawURL)
+}
+
+// checkRedirect rejects redirects that leave the upstream registry or the allowlist
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if c.trusted(req.URL.Host) || c.allowlist.Allows(req.URL) {
+		return nil
+	}
+	return fmt.Errorf("redirect to %s: %w", req.URL.Host, ErrHostNotAllowed)
+}
+
+// trusted reports whether host is configured by the operator rather than named in upstream responses
+func (c *Client) trusted(host string) bool {
+	_, ok := c.authorizers[host]
+	return host == c.baseHost || ok
+}
@@ -2,39 +2,185 @@ package upstream
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/proxy"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/metrics"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/token"
+)
+
+const (
+	// defaultDownloadTimeout limits a single archive transfer when none is configured
+	defaultDownloadTimeout = 5 * time.Minute
+
+	// defaultDialTimeout limits establishing a connection when none is configured
+	defaultDialTimeout = 30 * time.Second
 )
 
+// Options configures an upstream client
+type Options struct {
+	// BaseURL is the upstream registry URL (e.g. "https://registry.terraform.io")
+	BaseURL string
+
+	// Timeout limits registry API requests
+	Timeout time.Duration
+
+	// DownloadTimeout limits archive transfers, including reading the body
+	DownloadTimeout time.Duration
+
+	// SOCKS5Addr enables a SOCKS5 proxy (e.g. "127.0.0.1:1080"); empty means direct connection
+	SOCKS5Addr string
+
+	// IPFamily selects the address family of direct connections:
+	// "any" (default), "ipv4", "ipv6", "prefer-ipv4" or "prefer-ipv6"
+	IPFamily string
+
+	// DNSServers ("ip[:port]") or DoHURL (a DNS-over-HTTPS endpoint) replace the system's
+	// DNS servers for direct connections; empty uses the system resolver
+	DNSServers []string
+	DoHURL     string
+
+	// DialTimeout limits establishing a connection (0 uses 30s); FallbackDelay is how long
+	// a connection attempt to one address family runs before the other is raced against it
+	// (happy eyeballs, RFC 6555); 0 disables the race and addresses are tried in turn
+	DialTimeout   time.Duration
+	FallbackDelay time.Duration
+
+	// MaxConns limits connections to each upstream host, including those in use
+	// (0 is unlimited); MaxIdleConns is how many per host are kept open for reuse
+	// (0 uses the Go default of 2)
+	MaxConns     int
+	MaxIdleConns int
+
+	// TLSSessions is the number of TLS sessions kept for resumption (0 disables the cache)
+	TLSSessions int
+
+	// HTTP2 negotiates HTTP/2 with upstream hosts that support it,
+	// multiplexing concurrent requests over a single connection
+	HTTP2 bool
+
+	// DownloadHosts restricts hosts that absolute URLs (archives, shasums) may point to
+	DownloadHosts []string
+
+	// Credentials sent to the upstream registry host only: a bearer token,
+	// or a username and password for basic auth (e.g. Artifactory, Nexus)
+	Token    string
+	Username string
+	Password string
+
+	// UserAgent is sent with every upstream request
+	UserAgent string
+
+	// Headers are extra request headers sent with every upstream request
+	Headers map[string]string
+
+	// BreakerThreshold is the number of consecutive failures that opens a host's
+	// circuit breaker (0 disables it); BreakerCooldown is how long it stays open
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// RateLimit limits requests to the upstream registry host to this many per second
+	// with bursts of RateBurst (0 disables); requests wait for their turn, and for the
+	// Retry-After of any host that answered 429, up to RateLimitWait
+	RateLimit     int
+	RateBurst     int
+	RateLimitWait time.Duration
+
+	// Metrics receives per-host request counts and latency (nil disables)
+	Metrics metrics.Recorder
+
+	// PeerSecret signs peer and shard requests to other mirrors (see PeerHeader)
+	PeerSecret string
+
+	// RecordDir stores every upstream response in a directory;
+	// ReplayDir answers requests from such a recording instead of the network
+	RecordDir string
+	ReplayDir string
+}
+
 // Client represents an HTTP client for requests to upstream registry
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL         string
+	baseHost        string
+	httpClient      *http.Client
+	timeout         time.Duration
+	downloadTimeout time.Duration
+	allowlist       *Allowlist
+	tracker         *tracker
+	limiter         *rateLimiter
+	userAgent       string
+	headers         map[string]string
+	auth            string
+	metrics         metrics.Recorder
+
+	// Signs peer and shard requests (nil without a peer secret)
+	peerSigner *token.URLSigner
+
+	// Hosts whose requests are authorized by a function (e.g. OCI registry tokens)
+	authorizers map[string]Authorizer
 }
 
+// Authorizer returns the Authorization header for a request ("" sends none)
+// It may request a token first; those requests must not be authorized by the same function
+type Authorizer func(ctx context.Context, req *http.Request) (string, error)
+
 // New creates a new upstream client
-// If socks5Addr is empty, direct connection is used
-// If socks5Addr is provided (e.g., "127.0.0.1:1080"), SOCKS5 proxy is used
-func New(baseURL string, timeout time.Duration, socks5Addr string) (*Client, error) {
+func New(opts Options) (*Client, error) {
+	resolver, err := newResolver(opts.DNSServers, opts.DoHURL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:       opts.DialTimeout,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: opts.FallbackDelay,
+		Resolver:      resolver,
+	}
+	if dialer.Timeout <= 0 {
+		dialer.Timeout = defaultDialTimeout
+	}
+	if dialer.FallbackDelay <= 0 {
+		dialer.FallbackDelay = -1 // a negative delay disables the race
+	}
+	dial, err := withIPFamily(dialer.DialContext, opts.IPFamily)
+	if err != nil {
+		return nil, err
+	}
+
 	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		DialContext:           dial,
 		MaxIdleConns:          100,
+		MaxConnsPerHost:       opts.MaxConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConns,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{},
+		// A custom dialer turns off HTTP/2 unless it is requested explicitly
+		ForceAttemptHTTP2: opts.HTTP2,
+	}
+	if opts.TLSSessions > 0 {
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(opts.TLSSessions)
+	}
+	if transport.MaxIdleConns < opts.MaxIdleConns {
+		transport.MaxIdleConns = opts.MaxIdleConns
 	}
 
 	// Configure SOCKS5 proxy if provided
-	if socks5Addr != "" {
-		dialer, err := proxy.SOCKS5("tcp", socks5Addr, nil, proxy.Direct)
+	if opts.SOCKS5Addr != "" {
+		dialer, err := proxy.SOCKS5("tcp", opts.SOCKS5Addr, nil, proxy.Direct)
 		if err != nil {
 			return nil, fmt.Errorf("creating SOCKS5 dialer: %w", err)
 		}
@@ -50,33 +196,300 @@ func New(baseURL string, timeout time.Duration, socks5Addr string) (*Client, err
 		}
 	}
 
-	return &Client{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Transport: transport,
-			Timeout:   timeout,
-		},
-	}, nil
+	var roundTripper http.RoundTripper = transport
+	switch {
+	case opts.ReplayDir != "":
+		if _, err := os.Stat(opts.ReplayDir); err != nil {
+			return nil, fmt.Errorf("opening upstream recording: %w", err)
+		}
+		roundTripper = &replayTransport{dir: opts.ReplayDir}
+	case opts.RecordDir != "":
+		if err := os.MkdirAll(opts.RecordDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating upstream recording directory: %w", err)
+		}
+		roundTripper = &recordTransport{next: transport, dir: opts.RecordDir}
+	}
+
+	u, err := url.Parse(opts.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing upstream URL: %w", err)
+	}
+
+	c := &Client{
+		baseURL:         opts.BaseURL,
+		baseHost:        u.Host,
+		timeout:         opts.Timeout,
+		downloadTimeout: opts.DownloadTimeout,
+		allowlist:       NewAllowlist(opts.DownloadHosts),
+		tracker:         newTracker(opts.BreakerThreshold, opts.BreakerCooldown),
+		userAgent:       opts.UserAgent,
+		headers:         opts.Headers,
+		auth:            authorization(opts.Token, opts.Username, opts.Password),
+		metrics:         opts.Metrics,
+	}
+	c.limiter = newRateLimiter(opts.RateLimit, opts.RateBurst, opts.RateLimitWait, func(host string) bool {
+		return host == c.baseHost
+	})
+	if c.metrics == nil {
+		c.metrics = metrics.Nop()
+	}
+	if opts.PeerSecret != "" {
+		c.peerSigner = token.NewURLSigner(opts.PeerSecret, peerSignatureTTL)
+	}
+	if c.downloadTimeout <= 0 {
+		c.downloadTimeout = defaultDownloadTimeout
+	}
+	// Timeouts are applied per request through the context, so they also
+	// respect the deadline of the client request being served
+	c.httpClient = &http.Client{
+		Transport:     roundTripper,
+		CheckRedirect: c.checkRedirect,
+	}
+
+	return c, nil
+}
+
+// SetAuthorizer authorizes requests to host with fn; the host is trusted for downloads
+// like the upstream registry. It must be called before the client is used.
+func (c *Client) SetAuthorizer(host string, fn Authorizer) {
+	if c.authorizers == nil {
+		c.authorizers = make(map[string]Authorizer)
+	}
+	c.authorizers[host] = fn
 }
 
 // Get performs a GET request to upstream
 func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
-	url := c.baseURL + path
+	return c.get(ctx, c.timeout, c.baseURL+path, "application/json", nil)
+}
+
+// URL returns the absolute upstream URL of a path
+func (c *Client) URL(path string) string {
+	return c.baseURL + path
+}
+
+// GetAdmin performs a GET request to the admin or /api routes of an upstream tf-mirror
+func (c *Client) GetAdmin(ctx context.Context, path, token string) (*http.Response, error) {
+	header := make(http.Header)
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return c.get(ctx, c.timeout, c.baseURL+path, "application/json", header)
+}
+
+// GetURL performs a GET request to an absolute URL (e.g. shasums_url)
+// using the same transport as registry requests
+func (c *Client) GetURL(ctx context.Context, rawURL string) (*http.Response, error) {
+	if err := c.checkURL(rawURL); err != nil {
+		return nil, err
+	}
+	return c.get(ctx, c.timeout, rawURL, "", nil)
+}
+
+// GetTrusted performs a GET request to an absolute URL configured by the operator
+// (e.g. a GitHub API endpoint); the download allowlist does not apply
+func (c *Client) GetTrusted(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	return c.get(ctx, c.timeout, rawURL, "", header)
+}
+
+// Download performs a GET request for a provider archive
+// Archives use a longer timeout than metadata requests
+func (c *Client) Download(ctx context.Context, rawURL string) (*http.Response, error) {
+	if err := c.checkURL(rawURL); err != nil {
+		return nil, err
+	}
+	return c.get(ctx, c.downloadTimeout, rawURL, "", nil)
+}
+
+// DownloadRange requests the rest of a provider archive starting at offset
+// validator (an ETag or Last-Modified value) is sent as If-Range so a changed
+// file is returned whole instead of being spliced
+func (c *Client) DownloadRange(ctx context.Context, rawURL string, offset int64, validator string) (*http.Response, error) {
+	if err := c.checkURL(rawURL); err != nil {
+		return nil, err
+	}
+	header := make(http.Header)
+	header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	if validator != "" {
+		header.Set("If-Range", validator)
+	}
+	return c.get(ctx, c.downloadTimeout, rawURL, "", header)
+}
+
+// PeerHeader marks cache-only requests between sibling mirrors
+// Its value signs the request with the shared peer secret (see PeerSignedPath); mirrors
+// ignore a header that does not verify, so clients cannot use it to skip download checks
+const PeerHeader = "X-Tf-Mirror-Peer"
+
+// peerSignatureTTL is how long the signature of a peer or shard request is valid, allowing for clock skew
+const peerSignatureTTL = 5 * time.Minute
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// PeerSignedPath returns what a peer or shard header signs: its name and the request path
+// from /v1/providers/ on, so mirrors behind a path prefix verify the path they route
+func PeerSignedPath(header, path string) string {
+	if i := strings.Index(path, providersv1.Prefix); i >= 0 {
+		path = path[i:]
+	}
+	return header + " " + path
+}
+
+// peerHeader returns the headers of a peer or shard request to rawURL, signed with the peer secret
+// Without a peer secret it is empty, and the other mirror serves the request like a client download
+func (c *Client) peerHeader(name, rawURL string) http.Header {
+	header := make(http.Header)
+	if c.peerSigner == nil {
+		return header
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		header.Set(name, c.peerSigner.Sign(PeerSignedPath(name, u.Path), time.Now().Add(peerSignatureTTL)))
+	}
+	return header
+}
+
+// Peer performs a cache-only archive request to a sibling mirror
+// Peers are configured explicitly, so the download allowlist does not apply
+func (c *Client) Peer(ctx context.Context, rawURL string) (*http.Response, error) {
+	return c.MirrorArchive(ctx, rawURL, "")
+}
+
+// MirrorArchive performs a cache-only archive request to another tf-mirror,
+// sending token as a bearer token when set (e.g. a tenant token of that mirror)
+func (c *Client) MirrorArchive(ctx context.Context, rawURL, token string) (*http.Response, error) {
+	header := c.peerHeader(PeerHeader, rawURL)
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return c.get(ctx, c.downloadTimeout, rawURL, "", header)
+}
+
+// ShardHeader marks archive requests a replica forwards to the owner of the provider
+// It is signed like PeerHeader; replicas ignore a header that does not verify
+const ShardHeader = "X-Tf-Mirror-Shard"
+
+// Shard requests an archive from the replica owning its provider, which downloads it
+// from upstream when it is not cached; replicas are configured explicitly, so the
+// download allowlist does not apply
+func (c *Client) Shard(ctx context.Context, rawURL string) (*http.Response, error) {
+	return c.get(ctx, c.downloadTimeout, rawURL, "", c.peerHeader(ShardHeader, rawURL))
+}
+
+// get performs a GET request limited by timeout (0 means only the context deadline)
+// The timeout covers reading the body and is released when the body is closed
+func (c *Client) get(ctx context.Context, timeout time.Duration, rawURL, accept string, header http.Header) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
 
-	req.Header.Set("User-Agent", "terraform-mirror/1.0")
-	req.Header.Set("Accept", "application/json")
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.auth != "" && req.URL.Host == c.baseHost {
+		req.Header.Set("Authorization", c.auth)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if ctx.Value(noCacheKey{}) != nil {
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+	if authorize, ok := c.authorizers[req.URL.Host]; ok {
+		auth, err := authorize(ctx, req)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("authorizing request to %s: %w", req.URL.Host, err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("executing request: %w", err)
+	host := req.URL.Host
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx, host); err != nil {
+			cancel()
+			return nil, err
+		}
+		if err := c.tracker.allow(host); err != nil {
+			cancel()
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := c.httpClient.Do(req)
+		latency := time.Since(start)
+		c.tracker.record(host, latency, resp, err)
+
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(resp.StatusCode)
+		}
+		c.metrics.Count(metrics.UpstreamRequests, 1, "host:"+host, "status:"+status)
+		c.metrics.Timing(metrics.UpstreamLatency, latency, "host:"+host)
+
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("executing request: %w", err)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		// Later requests to the host wait for its Retry-After; this one is
+		// sent again after it unless that takes too long
+		delay := retryAfter(resp.Header, time.Now())
+		c.limiter.pause(host, delay)
+		c.metrics.Count(metrics.UpstreamRateLimited, 1, "host:"+host)
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if attempt == maxRateLimitRetries {
+			cancel()
+			return nil, &RateLimitError{Host: host, RetryAfter: delay}
+		}
 	}
+}
+
+// authorization returns the Authorization header for upstream credentials ("" for none)
+func authorization(token, username, password string) string {
+	switch {
+	case token != "":
+		return "Bearer " + token
+	case username != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	return ""
+}
 
-	return resp, nil
+// cancelBody releases a request's timeout when its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// noCacheKey marks requests that must not be answered from intermediate caches
+type noCacheKey struct{}
+
+// NoCache returns a context whose requests ask caches between the mirror and upstream
+// (CDNs, hub mirrors, repository managers) to revalidate (Cache-Control: no-cache)
+func NoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
 }
 
 // GetJSON performs a GET request and returns the response body
@@ -95,3 +508,8 @@ func (c *Client) GetJSON(ctx context.Context, path string) ([]byte, int, error)
 	return body, resp.StatusCode, nil
 }
 
+// Stats returns rolling request statistics per upstream host
+func (c *Client) Stats() []HostStats {
+	return c.tracker.snapshot()
+}
+
@@ -0,0 +1,42 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// dialFunc matches http.Transport.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// withIPFamily restricts or orders the address families dial connects over
+// "any" (or "") keeps the default happy-eyeballs behaviour; "ipv4" and "ipv6" only use that family;
+// "prefer-ipv4" and "prefer-ipv6" try that family first and fall back to the other one
+func withIPFamily(dial dialFunc, family string) (dialFunc, error) {
+	var first, second string
+	switch family {
+	case "", "any":
+		return dial, nil
+	case "ipv4":
+		first = "4"
+	case "ipv6":
+		first = "6"
+	case "prefer-ipv4":
+		first, second = "4", "6"
+	case "prefer-ipv6":
+		first, second = "6", "4"
+	default:
+		return nil, fmt.Errorf("unknown IP family %q", family)
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network != "tcp" {
+			return dial(ctx, network, addr)
+		}
+		conn, err := dial(ctx, network+first, addr)
+		if err == nil || second == "" || ctx.Err() != nil {
+			return conn, err
+		}
+		return dial(ctx, network+second, addr)
+	}, nil
+}
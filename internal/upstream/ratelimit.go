@@ -0,0 +1,161 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request would have to wait longer than allowed
+// for the rate limit or a host's Retry-After
+var ErrRateLimited = errors.New("upstream rate limit")
+
+// defaultRetryAfter is the pause after a 429 response without a usable Retry-After
+const defaultRetryAfter = time.Second
+
+// maxRateLimitRetries is how often a request answered with 429 is sent again
+const maxRateLimitRetries = 2
+
+// RateLimitError reports a request that was not sent, or was refused with 429,
+// because of rate limiting; RetryAfter is how long the host asked clients to wait
+type RateLimitError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %v, retry after %s", e.Host, ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// rateLimiter queues requests per host: a token bucket for the hosts it limits (rate requests
+// per second with burst) and, for every host, a pause after a 429 response until its Retry-After
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	maxWait time.Duration
+	limited func(host string) bool
+
+	mu    sync.Mutex
+	hosts map[string]*bucket
+}
+
+// bucket is the rate limit state of one host
+// tokens may go negative: each waiting request holds a reservation
+type bucket struct {
+	tokens      float64
+	updated     time.Time
+	pausedUntil time.Time
+}
+
+func newRateLimiter(rate, burst int, maxWait time.Duration, limited func(host string) bool) *rateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &rateLimiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		maxWait: maxWait,
+		limited: limited,
+		hosts:   make(map[string]*bucket),
+	}
+}
+
+// wait blocks until a request to host may be sent
+// It fails with a RateLimitError instead when the wait exceeds maxWait or the context deadline
+func (l *rateLimiter) wait(ctx context.Context, host string) error {
+	delay, reserved := l.reserve(host, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	limit := l.maxWait
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < limit {
+		limit = time.Until(deadline)
+	}
+	if delay > limit {
+		l.cancel(host, reserved)
+		return &RateLimitError{Host: host, RetryAfter: delay}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel(host, reserved)
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token for a request to host and returns how long it must wait
+func (l *rateLimiter) reserve(host string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.hosts[host]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.hosts[host] = b
+	}
+
+	var delay time.Duration
+	reserved := l.rate > 0 && l.limited(host)
+	if reserved {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+		b.updated = now
+		b.tokens--
+		if b.tokens < 0 {
+			delay = time.Duration(-b.tokens / l.rate * float64(time.Second))
+		}
+	}
+	if pause := b.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	return delay, reserved
+}
+
+// cancel returns the token of a request that is not sent
+func (l *rateLimiter) cancel(host string, reserved bool) {
+	if !reserved {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hosts[host].tokens++
+}
+
+// pause holds back requests to host for d after it answered 429
+func (l *rateLimiter) pause(host string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.hosts[host]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: time.Now()}
+		l.hosts[host] = b
+	}
+	if until := time.Now().Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// retryAfter parses the Retry-After header of a 429 response (seconds or an HTTP date)
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return max(time.Duration(seconds)*time.Second, defaultRetryAfter)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), defaultRetryAfter)
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,168 @@
+package upstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotRecorded is returned in replay mode for requests missing from the recording
+var ErrNotRecorded = errors.New("no recorded upstream response")
+
+// recording describes a stored upstream response; the body is kept next to it
+// Layout: {dir}/{key}.json and {dir}/{key}.body, key = SHA-256 of method, URL and Range
+type recording struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Range    string      `json:"range,omitempty"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Recorded time.Time   `json:"recorded"`
+}
+
+// recordingKey identifies a request in a recording directory
+// Credentials and other headers are not part of it, so recordings can be replayed elsewhere
+func recordingKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String() + " " + req.Header.Get("Range")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordTransport stores every complete upstream response in dir
+// Recording is best effort: a response that cannot be stored is still returned
+type recordTransport struct {
+	next http.RoundTripper
+	dir  string
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(t.dir, ".tmp-*")
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body = &recordBody{
+		ReadCloser: resp.Body,
+		tmp:        tmp,
+		length:     resp.ContentLength,
+		path:       filepath.Join(t.dir, recordingKey(req)),
+		rec: recording{
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Range:    req.Header.Get("Range"),
+			Status:   resp.StatusCode,
+			Header:   resp.Header.Clone(),
+			Recorded: time.Now().UTC(),
+		},
+	}
+	return resp, nil
+}
+
+// recordBody copies a response body to a temporary file while it is read
+// and stores the recording on Close if the whole body was read
+type recordBody struct {
+	io.ReadCloser
+	tmp     *os.File
+	length  int64 // Content-Length, -1 when unknown
+	written int64
+	eof     bool
+	failed  bool
+	path    string // without extension
+	rec     recording
+}
+
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.failed {
+		if _, werr := b.tmp.Write(p[:n]); werr != nil {
+			b.failed = true
+		}
+		b.written += int64(n)
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *recordBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	complete := !b.failed && (b.eof || (b.length >= 0 && b.written == b.length))
+	if closeErr := b.tmp.Close(); closeErr != nil {
+		complete = false
+	}
+	if !complete || b.store() != nil {
+		os.Remove(b.tmp.Name())
+	}
+	return err
+}
+
+// store moves the body into place and writes its description
+func (b *recordBody) store() error {
+	if err := os.Rename(b.tmp.Name(), b.path+".body"); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b.rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".json.tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path+".json")
+}
+
+// replayTransport answers requests from a recording directory without network access
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(t.dir, recordingKey(req))
+
+	data, err := os.ReadFile(path + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("reading recording of %s: %w", req.URL, err)
+	}
+
+	body, err := os.Open(path + ".body")
+	if err != nil {
+		return nil, fmt.Errorf("reading recording of %s: %w", req.URL, err)
+	}
+	info, err := body.Stat()
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header,
+		Body:          body,
+		ContentLength: info.Size(),
+		Request:       req,
+	}, nil
+}
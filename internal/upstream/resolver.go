@@ -0,0 +1,202 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// dohTimeout limits a single DNS-over-HTTPS exchange
+const dohTimeout = 10 * time.Second
+
+// maxDNSMessageSize is the largest DNS message
+const maxDNSMessageSize = 65535
+
+// newResolver returns a resolver for upstream connections that queries the given DNS servers
+// or DNS-over-HTTPS endpoint instead of the system's; nil when neither is configured
+// /etc/hosts is still consulted first, as by the system resolver
+func newResolver(servers []string, dohURL string) (*net.Resolver, error) {
+	switch {
+	case dohURL != "":
+		client := &http.Client{Timeout: dohTimeout}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				conn := &dohConn{ctx: ctx, client: client, url: dohURL, stream: network == "tcp"}
+				if conn.stream {
+					return conn, nil
+				}
+				return dohPacketConn{conn}, nil
+			},
+		}, nil
+	case len(servers) > 0:
+		addrs := make([]string, len(servers))
+		for i, server := range servers {
+			addr, err := dnsServerAddr(server)
+			if err != nil {
+				return nil, err
+			}
+			addrs[i] = addr
+		}
+		// Each query, and each retry after a timeout, goes to the next server
+		var next atomic.Uint32
+		var dialer net.Dialer
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				addr := addrs[int(next.Add(1)-1)%len(addrs)]
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// dnsServerAddr returns "ip:port" of a DNS server given as an IP address with an optional port (default 53)
+func dnsServerAddr(server string) (string, error) {
+	if addrPort, err := netip.ParseAddrPort(server); err == nil {
+		return addrPort.String(), nil
+	}
+	addr, err := netip.ParseAddr(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid DNS server %q: expected an IP address with an optional port", server)
+	}
+	return netip.AddrPortFrom(addr, 53).String(), nil
+}
+
+// dohConn carries the DNS queries of the Go resolver over HTTPS (RFC 8484)
+// Each written query is POSTed as application/dns-message and the answer is returned by the next reads
+// With stream set it speaks the TCP framing (2-byte length prefixes); otherwise it behaves as a
+// packet connection, whose answers too large for the reader are truncated with the TC bit set
+// so the resolver retries over "TCP"
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	stream bool
+
+	pending  bytes.Buffer // written, not yet complete query (stream mode)
+	answer   []byte
+	deadline time.Time
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	query := b
+	if c.stream {
+		c.pending.Write(b)
+		data := c.pending.Bytes()
+		if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+			return len(b), nil
+		}
+		query = data[2 : 2+int(binary.BigEndian.Uint16(data))]
+	}
+
+	answer, err := c.exchange(query)
+	c.pending.Reset()
+	if err != nil {
+		return 0, err
+	}
+	if c.stream {
+		answer = append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...)
+	}
+	c.answer = answer
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.answer == nil {
+		return 0, io.EOF
+	}
+	if c.stream {
+		n := copy(b, c.answer)
+		c.answer = c.answer[n:]
+		if len(c.answer) == 0 {
+			c.answer = nil
+		}
+		return n, nil
+	}
+
+	n := copy(b, c.answer)
+	if n < len(c.answer) && n >= 3 {
+		b[2] |= 0x02 // TC: truncated
+	}
+	c.answer = nil
+	return n, nil
+}
+
+// exchange POSTs a DNS query to the DoH endpoint and returns the answer
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DNS-over-HTTPS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS: %s answered %d", c.url, resp.StatusCode)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+	if err != nil {
+		return nil, fmt.Errorf("DNS-over-HTTPS: %w", err)
+	}
+	return answer, nil
+}
+
+func (c *dohConn) Close() error { return nil }
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(time.Time) error { return nil }
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr  { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr { return dohAddr{c.url} }
+
+// dohPacketConn is a dohConn in packet mode; the Go resolver treats net.PacketConn
+// connections as UDP, reading each answer with a single Read
+type dohPacketConn struct {
+	*dohConn
+}
+
+func (c dohPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c dohPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+// dohAddr is the address of a DoH endpoint
+type dohAddr struct {
+	url string
+}
+
+func (dohAddr) Network() string  { return "doh" }
+func (a dohAddr) String() string { return a.url }
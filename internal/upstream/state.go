@@ -0,0 +1,92 @@
+package upstream
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// hostState is the saved form of hostStats
+type hostState struct {
+	Samples             []sampleState `json:"samples"` // oldest first
+	Requests            int64         `json:"requests"`
+	Failures            int64         `json:"failures"`
+	LastError           string        `json:"last_error,omitempty"`
+	LastErrorAt         time.Time     `json:"last_error_at,omitempty"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	OpenedAt            time.Time     `json:"opened_at,omitempty"`
+}
+
+type sampleState struct {
+	Latency time.Duration `json:"latency"`
+	OK      bool          `json:"ok"`
+}
+
+// State returns per-host statistics and circuit breaker state as JSON,
+// so a restarted mirror does not retry a host that is known to be failing
+func (c *Client) State() ([]byte, error) {
+	c.tracker.mu.Lock()
+	hosts := make(map[string]*hostStats, len(c.tracker.hosts))
+	for name, h := range c.tracker.hosts {
+		hosts[name] = h
+	}
+	c.tracker.mu.Unlock()
+
+	state := make(map[string]hostState, len(hosts))
+	for name, h := range hosts {
+		h.mu.Lock()
+		s := hostState{
+			Requests:            h.requests,
+			Failures:            h.failures,
+			LastError:           h.lastError,
+			LastErrorAt:         h.lastErrorAt,
+			ConsecutiveFailures: h.consecutiveFailures,
+			OpenedAt:            h.openedAt,
+		}
+		start, n := 0, h.next
+		if h.filled {
+			start, n = h.next, statsWindow
+		}
+		for i := 0; i < n; i++ {
+			sm := h.samples[(start+i)%statsWindow]
+			s.Samples = append(s.Samples, sampleState{Latency: sm.latency, OK: sm.ok})
+		}
+		h.mu.Unlock()
+		state[name] = s
+	}
+	return json.Marshal(state)
+}
+
+// RestoreState loads statistics saved by State, replacing hosts already tracked
+func (c *Client) RestoreState(data []byte) error {
+	var state map[string]hostState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	for name, s := range state {
+		h := &hostStats{
+			requests:            s.Requests,
+			failures:            s.Failures,
+			lastError:           s.LastError,
+			lastErrorAt:         s.LastErrorAt,
+			consecutiveFailures: s.ConsecutiveFailures,
+			openedAt:            s.OpenedAt,
+		}
+		samples := s.Samples
+		if len(samples) > statsWindow {
+			samples = samples[len(samples)-statsWindow:]
+		}
+		for _, sm := range samples {
+			h.samples[h.next] = sample{latency: sm.Latency, ok: sm.OK}
+			h.next = (h.next + 1) % statsWindow
+			if h.next == 0 {
+				h.filled = true
+			}
+		}
+
+		c.tracker.mu.Lock()
+		c.tracker.hosts[name] = h
+		c.tracker.mu.Unlock()
+	}
+	return nil
+}
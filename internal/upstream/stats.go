@@ -0,0 +1,221 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while requests to a failing host are short-circuited
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// statsWindow is the number of recent requests used for rolling statistics
+const statsWindow = 200
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// HostStats is a snapshot of rolling statistics for one upstream host
+type HostStats struct {
+	Host         string     `json:"host"`
+	Requests     int64      `json:"requests"`
+	Failures     int64      `json:"failures"`
+	SuccessRate  float64    `json:"success_rate"`
+	LatencyP50Ms float64    `json:"latency_p50_ms"`
+	LatencyP95Ms float64    `json:"latency_p95_ms"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+	CircuitState string     `json:"circuit_state"`
+}
+
+type sample struct {
+	latency time.Duration
+	ok      bool
+}
+
+// hostStats tracks recent requests and circuit breaker state for a host
+type hostStats struct {
+	mu sync.Mutex
+
+	samples  [statsWindow]sample
+	next     int
+	filled   bool
+	requests int64
+	failures int64
+
+	lastError   string
+	lastErrorAt time.Time
+
+	consecutiveFailures int
+	openedAt            time.Time
+	halfOpenTrial       bool
+}
+
+// tracker keeps per-host statistics and circuit breakers
+type tracker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+}
+
+func newTracker(threshold int, cooldown time.Duration) *tracker {
+	return &tracker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		hosts:     make(map[string]*hostStats),
+	}
+}
+
+func (t *tracker) host(host string) *hostStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostStats{}
+		t.hosts[host] = h
+	}
+	return h
+}
+
+// allow reports whether a request to host may proceed
+// After the cooldown a single trial request is let through (half-open)
+func (t *tracker) allow(host string) error {
+	if t.threshold <= 0 {
+		return nil
+	}
+
+	h := t.host(host)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.consecutiveFailures < t.threshold {
+		return nil
+	}
+	if time.Since(h.openedAt) >= t.cooldown && !h.halfOpenTrial {
+		h.halfOpenTrial = true
+		return nil
+	}
+	return fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+}
+
+// record stores the outcome of a request
+// Transport errors, 5xx and 429 responses count as failures;
+// requests canceled by the client say nothing about the host and are ignored
+func (t *tracker) record(host string, latency time.Duration, resp *http.Response, err error) {
+	h := t.host(host)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		h.halfOpenTrial = false
+		return
+	}
+
+	ok := err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
+
+	h.samples[h.next] = sample{latency: latency, ok: ok}
+	h.next = (h.next + 1) % statsWindow
+	if h.next == 0 {
+		h.filled = true
+	}
+	h.requests++
+	h.halfOpenTrial = false
+
+	if ok {
+		h.consecutiveFailures = 0
+		return
+	}
+
+	h.failures++
+	h.consecutiveFailures++
+	if t.threshold > 0 && h.consecutiveFailures >= t.threshold {
+		h.openedAt = time.Now()
+	}
+
+	if err != nil {
+		h.lastError = err.Error()
+	} else {
+		h.lastError = fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	h.lastErrorAt = time.Now()
+}
+
+// snapshot returns statistics for all hosts sorted by name
+func (t *tracker) snapshot() []HostStats {
+	t.mu.Lock()
+	hosts := make(map[string]*hostStats, len(t.hosts))
+	for name, h := range t.hosts {
+		hosts[name] = h
+	}
+	t.mu.Unlock()
+
+	result := make([]HostStats, 0, len(hosts))
+	for name, h := range hosts {
+		result = append(result, t.hostSnapshot(name, h))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Host < result[j].Host })
+	return result
+}
+
+func (t *tracker) hostSnapshot(name string, h *hostStats) HostStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.next
+	if h.filled {
+		n = statsWindow
+	}
+
+	latencies := make([]time.Duration, 0, n)
+	okCount := 0
+	for _, s := range h.samples[:n] {
+		latencies = append(latencies, s.latency)
+		if s.ok {
+			okCount++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats := HostStats{
+		Host:         name,
+		Requests:     h.requests,
+		Failures:     h.failures,
+		LastError:    h.lastError,
+		CircuitState: CircuitClosed,
+	}
+	if !h.lastErrorAt.IsZero() {
+		lastErrorAt := h.lastErrorAt
+		stats.LastErrorAt = &lastErrorAt
+	}
+	if n > 0 {
+		stats.SuccessRate = float64(okCount) / float64(n)
+		stats.LatencyP50Ms = percentile(latencies, 0.50)
+		stats.LatencyP95Ms = percentile(latencies, 0.95)
+	}
+
+	if t.threshold > 0 && h.consecutiveFailures >= t.threshold {
+		stats.CircuitState = CircuitOpen
+		if time.Since(h.openedAt) >= t.cooldown {
+			stats.CircuitState = CircuitHalfOpen
+		}
+	}
+
+	return stats
+}
+
+// percentile returns the p-th percentile of sorted latencies in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	idx := int(float64(len(sorted)-1) * p)
+	return float64(sorted[idx].Microseconds()) / 1000
+}
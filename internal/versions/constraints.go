@@ -0,0 +1,143 @@
+package versions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// Constraints is a comma-separated list of Terraform-style version constraints
+// (=, !=, >, >=, <, <=, ~>); a version must match all of them
+type Constraints []constraint
+
+type constraint struct {
+	op      string
+	version string // "v"-prefixed canonical semver
+	upper   string // exclusive upper bound for ~>
+}
+
+// ParseConstraints parses constraints such as ">= 5.0, < 6.0" or "~> 1.2"
+func ParseConstraints(s string) (Constraints, error) {
+	var cs Constraints
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		c, err := parseConstraint(part)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func parseConstraint(s string) (constraint, error) {
+	var c constraint
+
+	c.op = "="
+	for _, op := range []string{"~>", ">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(s, op) {
+			c.op = op
+			s = strings.TrimSpace(strings.TrimPrefix(s, op))
+			break
+		}
+	}
+
+	raw := strings.TrimPrefix(s, "v")
+	c.version = semver.Canonical("v" + raw)
+	if c.version == "" {
+		return c, fmt.Errorf("invalid version %q", s)
+	}
+
+	if c.op == "~>" {
+		// Only the right-most given component may increase
+		segments := strings.Split(strings.SplitN(raw, "-", 2)[0], ".")
+		major, minor := part(c.version, 0), part(c.version, 1)
+		switch len(segments) {
+		case 1, 2:
+			c.upper = fmt.Sprintf("v%d.0.0", major+1)
+		case 3:
+			c.upper = fmt.Sprintf("v%d.%d.0", major, minor+1)
+		}
+	}
+
+	return c, nil
+}
+
+// part returns the i-th numeric component of a canonical version
+func part(v string, i int) int {
+	core := strings.SplitN(strings.TrimPrefix(v, "v"), "-", 2)[0]
+	parts := strings.Split(core, ".")
+	n, _ := strconv.Atoi(parts[i])
+	return n
+}
+
+func (c constraint) matches(v string) bool {
+	cmp := semver.Compare(v, c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~>":
+		return cmp >= 0 && (c.upper == "" || semver.Compare(v, c.upper) < 0)
+	}
+	return false
+}
+
+// Check reports whether a version (without "v" prefix) satisfies the constraints
+// Prereleases only match an exact "=" constraint, as in Terraform
+func (cs Constraints) Check(version string) bool {
+	v := semver.Canonical("v" + version)
+	if v == "" {
+		return false
+	}
+	if semver.Prerelease(v) != "" && !cs.pinned(v) {
+		return false
+	}
+	return cs.Matches(version)
+}
+
+// Matches reports whether a version (without "v" prefix) is within all constraints,
+// including prereleases
+func (cs Constraints) Matches(version string) bool {
+	v := semver.Canonical("v" + version)
+	if v == "" {
+		return false
+	}
+
+	for _, c := range cs {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// pinned reports whether a version is selected by an exact constraint
+func (cs Constraints) pinned(v string) bool {
+	for _, c := range cs {
+		if c.op == "=" && semver.Compare(v, c.version) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare compares two versions (without "v" prefix) by semver precedence
+func Compare(a, b string) int {
+	return semver.Compare("v"+a, "v"+b)
+}
@@ -0,0 +1,35 @@
+package versions
+
+import "testing"
+
+func TestPessimisticConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"~> 1", "1.0.0", true},
+		{"~> 1", "1.9.3", true},
+		{"~> 1", "2.0.0", false},
+		{"~> 1", "0.9.0", false},
+		{"~> 1.2", "1.2.0", true},
+		{"~> 1.2", "1.9.0", true},
+		{"~> 1.2", "2.0.0", false},
+		{"~> 1.2", "1.1.9", false},
+		{"~> 1.2.3", "1.2.3", true},
+		{"~> 1.2.3", "1.2.9", true},
+		{"~> 1.2.3", "1.3.0", false},
+		{"~> 1.2.3", "1.2.2", false},
+		{"~> 1.2.0-beta", "1.2.0", true},
+		{"~> 1.2.0-beta", "1.3.0", false},
+	}
+	for _, tt := range tests {
+		cs, err := ParseConstraints(tt.constraint)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.constraint, err)
+		}
+		if got := cs.Check(tt.version); got != tt.want {
+			t.Errorf("%q matches %s: got %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
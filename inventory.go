@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/config"
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+)
+
+// runInventory implements `tf-mirror inventory`:
+// exports every provider archive known to the cache directory
+func runInventory(args []string) int {
+	fs := flag.NewFlagSet("inventory", flag.ContinueOnError)
+	cacheDir := fs.String("cache-dir", "./cache", "cache directory to read")
+	format := fs.String("format", inventory.FormatJSON, "output format: json, csv or cyclonedx")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tf-mirror inventory [flags]")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	switch *format {
+	case inventory.FormatJSON, inventory.FormatCSV, inventory.FormatCycloneDX:
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown format %q\n", *format)
+		return 2
+	}
+	if dir := os.Getenv("TF_MIRROR_CACHE_DIR"); dir != "" && !isFlagSet(fs, "cache-dir") {
+		*cacheDir = dir
+	}
+
+	defer cache.CloseMetadata(*cacheDir)
+	items, err := inventory.Collect(
+		cache.NewHashCache(*cacheDir),
+		cache.NewArchiveCache(*cacheDir),
+		cache.NewArtifactCache(*cacheDir),
+		config.Load().NormalizeArchives,
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := inventory.Write(out, *format, items); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/protocol/providersv1"
+	"github.com/scinfra-pro/terraform-mirror/internal/registry"
+)
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// providerRef is a provider address with an optional version
+// [hostname/]namespace/type[@version]
+type providerRef struct {
+	Hostname  string
+	Namespace string
+	Name      string
+	Version   string
+}
+
+func parseProviderRef(s, defaultHostname string) (providerRef, error) {
+	ref := providerRef{Hostname: defaultHostname}
+
+	addr := s
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		addr, ref.Version = s[:i], strings.TrimPrefix(s[i+1:], "v")
+	}
+
+	parts := strings.Split(addr, "/")
+	switch len(parts) {
+	case 2:
+		ref.Namespace, ref.Name = parts[0], parts[1]
+	case 3:
+		ref.Hostname, ref.Namespace, ref.Name = parts[0], parts[1], parts[2]
+	default:
+		return ref, fmt.Errorf("invalid provider address %q", s)
+	}
+
+	if ref.Namespace == "" || ref.Name == "" {
+		return ref, fmt.Errorf("invalid provider address %q", s)
+	}
+	return ref, nil
+}
+
+// runLock implements `tf-mirror lock`:
+// prints .terraform.lock.hcl provider blocks with the hashes known to the mirror
+func runLock(args []string) int {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	mirrorURL := fs.String("mirror", "http://localhost:8080/v1/providers/", "mirror base URL (network_mirror url)")
+	cacheDir := fs.String("cache-dir", "", "read hashes from this cache directory instead of querying the mirror")
+	hostname := fs.String("hostname", "registry.terraform.io", "default registry hostname for short provider addresses")
+	var platforms stringList
+	fs.Var(&platforms, "platform", "target platform, e.g. linux_amd64 (repeatable; default: all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tf-mirror lock [flags] [hostname/]namespace/type[@version]...")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var src lockSource
+	if *cacheDir != "" {
+		defer cache.CloseMetadata(*cacheDir)
+		src = &cacheLockSource{
+			hashes:    cache.NewHashCache(*cacheDir),
+			artifacts: cache.NewArtifactCache(*cacheDir),
+		}
+	} else {
+		src = &mirrorLockSource{
+			baseURL: strings.TrimSuffix(*mirrorURL, "/") + "/",
+			client:  &http.Client{Timeout: 60 * time.Second},
+		}
+	}
+
+	failed := false
+	for _, arg := range fs.Args() {
+		ref, err := parseProviderRef(arg, *hostname)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			failed = true
+			continue
+		}
+
+		if err := writeLockBlock(os.Stdout, src, ref, platforms); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", arg, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// writeLockBlock prints a single provider block
+func writeLockBlock(w io.Writer, src lockSource, ref providerRef, platforms []string) error {
+	if ref.Version == "" {
+		latest, err := src.Latest(ref)
+		if err != nil {
+			return err
+		}
+		ref.Version = latest
+	}
+
+	archives, err := src.Archives(ref)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(platforms))
+	for _, p := range platforms {
+		wanted[p] = true
+	}
+
+	seen := make(map[string]bool)
+	var hashes []string
+	for platform, archive := range archives {
+		if len(wanted) > 0 && !wanted[platform] {
+			continue
+		}
+		for _, h := range archive.Hashes {
+			if !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	if len(hashes) == 0 {
+		return errors.New("no hashes known for the requested platforms")
+	}
+	sort.Strings(hashes)
+
+	fmt.Fprintf(w, "provider %q {\n", ref.Hostname+"/"+ref.Namespace+"/"+ref.Name)
+	fmt.Fprintf(w, "  version = %q\n", ref.Version)
+	fmt.Fprintln(w, "  hashes = [")
+	for _, h := range hashes {
+		fmt.Fprintf(w, "    %q,\n", h)
+	}
+	fmt.Fprintln(w, "  ]")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	return nil
+}
+
+// lockSource provides version and hash data for lock entries
+type lockSource interface {
+	Latest(ref providerRef) (string, error)
+	Archives(ref providerRef) (map[string]providersv1.MirrorArchive, error)
+}
+
+// mirrorLockSource queries a running mirror over the network mirror protocol
+type mirrorLockSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (m *mirrorLockSource) getJSON(path string, v any) error {
+	resp, err := m.client.Get(m.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mirror returned status %d for %s", resp.StatusCode, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (m *mirrorLockSource) Latest(ref providerRef) (string, error) {
+	var index providersv1.MirrorVersionsResponse
+	if err := m.getJSON(ref.Hostname+"/"+ref.Namespace+"/"+ref.Name+"/index.json", &index); err != nil {
+		return "", err
+	}
+
+	latest := ""
+	for v := range index.Versions {
+		if semver.Prerelease("v"+v) != "" {
+			continue
+		}
+		if latest == "" || semver.Compare("v"+v, "v"+latest) > 0 {
+			latest = v
+		}
+	}
+	if latest == "" {
+		return "", errors.New("no released versions")
+	}
+	return latest, nil
+}
+
+func (m *mirrorLockSource) Archives(ref providerRef) (map[string]providersv1.MirrorArchive, error) {
+	var resp providersv1.MirrorVersionResponse
+	if err := m.getJSON(ref.Hostname+"/"+ref.Namespace+"/"+ref.Name+"/"+ref.Version+".json", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Archives, nil
+}
+
+// cacheLockSource reads hashes straight from a cache directory
+type cacheLockSource struct {
+	hashes    *cache.HashCache
+	artifacts *cache.ArtifactCache
+}
+
+func (c *cacheLockSource) Latest(ref providerRef) (string, error) {
+	return "", errors.New("a version is required when reading from the cache")
+}
+
+func (c *cacheLockSource) Archives(ref providerRef) (map[string]providersv1.MirrorArchive, error) {
+	archives := make(map[string]providersv1.MirrorArchive)
+	add := func(platform, h string) {
+		osName, arch := platformParts(platform)
+		a := archives[platform]
+		a.URL = registry.ZipFilename(ref.Name, ref.Version, osName, arch)
+		a.Hashes = append(a.Hashes, h)
+		archives[platform] = a
+	}
+
+	for platform, h1 := range c.hashes.GetAll(ref.Namespace, ref.Name, ref.Version) {
+		add(platform, h1)
+	}
+
+	if data, ok := c.artifacts.Get(ref.Namespace, ref.Name, ref.Version, registry.ShasumsFilename(ref.Name, ref.Version)); ok {
+		prefix := "terraform-provider-" + ref.Name + "_" + ref.Version + "_"
+		for filename, sum := range registry.ParseShasums(data) {
+			if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ".zip") {
+				continue
+			}
+			platform := strings.TrimSuffix(strings.TrimPrefix(filename, prefix), ".zip")
+			add(platform, "zh:"+sum)
+		}
+	}
+
+	return archives, nil
+}
+
+// platformParts splits "linux_amd64" into os and arch
+func platformParts(platform string) (string, string) {
+	osName, arch, _ := strings.Cut(platform, "_")
+	return osName, arch
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/scinfra-pro/terraform-mirror/internal/config"
@@ -12,15 +14,36 @@ import (
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lock":
+			os.Exit(runLock(os.Args[2:]))
+		case "fetch":
+			os.Exit(runFetch(os.Args[2:]))
+		case "inventory":
+			os.Exit(runInventory(os.Args[2:]))
+		case "sync":
+			os.Exit(runSync(os.Args[2:]))
+		case "--print-config", "-print-config":
+			os.Exit(runPrintConfig())
+		}
+	}
+
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		reportConfigErrors(err)
+		os.Exit(2)
+	}
 
 	// Setup logger
-	logger := setupLogger(cfg.LogLevel)
+	logger, logLevel := setupLogger(cfg.LogLevel)
 	slog.SetDefault(logger)
 
 	// Create and start server
 	srv := server.New(cfg, logger)
+	srv.SetLogLevel(logLevel)
 
 	// Graceful shutdown
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -32,22 +55,46 @@ func main() {
 	}
 }
 
-func setupLogger(level string) *slog.Logger {
-	var logLevel slog.Level
+// runPrintConfig implements `tf-mirror --print-config`:
+// prints the effective configuration with secrets redacted and reports invalid settings
+func runPrintConfig() int {
+	cfg := config.Load()
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		reportConfigErrors(err)
+		return 2
+	}
+	return 0
+}
+
+// reportConfigErrors prints every configuration problem to stderr
+func reportConfigErrors(err error) {
+	fmt.Fprintln(os.Stderr, "invalid configuration:")
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintln(os.Stderr, "  "+line)
+	}
+}
+
+// setupLogger returns the logger and its level, which PUT /admin/loglevel changes at runtime
+func setupLogger(level string) (*slog.Logger, *slog.LevelVar) {
+	logLevel := new(slog.LevelVar)
 	switch level {
 	case "debug":
-		logLevel = slog.LevelDebug
+		logLevel.Set(slog.LevelDebug)
 	case "warn":
-		logLevel = slog.LevelWarn
+		logLevel.Set(slog.LevelWarn)
 	case "error":
-		logLevel = slog.LevelError
+		logLevel.Set(slog.LevelError)
 	default:
-		logLevel = slog.LevelInfo
+		logLevel.Set(slog.LevelInfo)
 	}
 
 	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: logLevel,
 	})
-	return slog.New(handler)
+	return slog.New(handler), logLevel
 }
 
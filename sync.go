@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/scinfra-pro/terraform-mirror/internal/cache"
+	"github.com/scinfra-pro/terraform-mirror/internal/config"
+	"github.com/scinfra-pro/terraform-mirror/internal/hash"
+	"github.com/scinfra-pro/terraform-mirror/internal/inventory"
+	"github.com/scinfra-pro/terraform-mirror/internal/replica"
+	"github.com/scinfra-pro/terraform-mirror/internal/upstream"
+)
+
+// runSync implements `tf-mirror sync`:
+// copies the archives and hashes another mirror has and a cache directory lacks
+func runSync(args []string) int {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	from := fs.String("from", "", "base URL of the source mirror, e.g. https://mirror-a.example.com")
+	to := fs.String("to", cfg.CacheDir, "cache directory to fill")
+	token := fs.String("token", cfg.ReplicateToken, "read credential of the source mirror, e.g. a tenant token (for its inventory)")
+	archiveToken := fs.String("archive-token", "", "bearer token for archive downloads, e.g. a tenant token (default: -token)")
+	hostname := fs.String("hostname", "registry.terraform.io", "registry hostname the source mirror serves")
+	concurrency := fs.Int("concurrency", cfg.FetchConcurrency, "parallel downloads")
+	dryRun := fs.Bool("dry-run", false, "only list what would be transferred")
+	var providers stringList
+	fs.Var(&providers, "provider", "only sync this provider, namespace/type (repeatable; default: all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tf-mirror sync -from URL [flags]")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *from == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	if *archiveToken == "" {
+		*archiveToken = *token
+	}
+
+	client, err := upstream.New(upstream.Options{
+		BaseURL:         *from,
+		Timeout:         cfg.UpstreamTimeout,
+		DownloadTimeout: cfg.DownloadTimeout,
+		SOCKS5Addr:      cfg.SOCKS5Addr,
+		IPFamily:        cfg.UpstreamIPFamily,
+		DialTimeout:     cfg.UpstreamDialTimeout,
+		FallbackDelay:   cfg.UpstreamFallback,
+		MaxConns:        cfg.UpstreamMaxConns,
+		MaxIdleConns:    cfg.UpstreamMaxIdle,
+		TLSSessions:     cfg.UpstreamTLSSessions,
+		HTTP2:           cfg.UpstreamHTTP2,
+		UserAgent:       cfg.UserAgent,
+		RateLimitWait:   cfg.UpstreamRateWait,
+		RecordDir:       cfg.UpstreamRecordDir,
+		ReplayDir:       cfg.UpstreamReplayDir,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source, err := replica.FetchInventory(ctx, client, *token)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	source = filterProviders(source, providers)
+
+	cache.SetFsync(cfg.CacheFsync)
+	hashCache := cache.NewHashCache(*to)
+	defer cache.CloseMetadata(*to)
+	archiveCache := cache.NewArchiveCache(*to)
+	archiveCache.SetLimits(cfg.CacheMaxSize, cfg.NamespaceQuotas)
+	archiveCache.SetMinFree(cfg.CacheMinFree)
+	if cfg.CacheEncryptionKey != "" || cfg.CacheKeyCommand != "" {
+		key, err := cache.LoadEncryptionKey(cfg.CacheEncryptionKey, cfg.CacheKeyCommand)
+		if err == nil {
+			err = archiveCache.SetEncryption(key, cfg.CacheEncryption, nil)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+	}
+	dest, err := inventory.Collect(hashCache, archiveCache, cache.NewArtifactCache(*to), cfg.NormalizeArchives)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	diff := replica.Compare(source, dest)
+	fmt.Fprintf(os.Stderr, "source has %d platforms: %d archives and %d hashes missing locally\n",
+		len(source), len(diff.Archives), len(diff.Hashes))
+
+	if *dryRun {
+		for _, it := range diff.Archives {
+			fmt.Printf("missing  %s/%s %s %s (%d bytes)\n", it.Namespace, it.Name, it.Version, it.Platform, it.Size)
+		}
+		return 0
+	}
+
+	syncer := replica.NewSyncer(client, *from, *hostname, *archiveToken, hashCache, archiveCache)
+	syncer.SpoolDir = cfg.TmpDir
+	syncer.SpoolMemoryLimit = cfg.SpoolMemoryLimit
+	syncer.CheckArchives = cfg.CheckArchives
+	syncer.Normalize = cfg.NormalizeArchives
+	hash.SetConcurrency(cfg.HashConcurrency)
+
+	hashes, err := syncer.StoreHashes(diff.Hashes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	start := time.Now()
+	var (
+		copied, failed int
+		bytes          int64
+	)
+	syncer.Transfer(ctx, diff.Archives, *concurrency, func(it inventory.Item, size int64, err error) {
+		job := fmt.Sprintf("%s/%s %s %s", it.Namespace, it.Name, it.Version, it.Platform)
+		if err != nil {
+			failed++
+			fmt.Printf("failed   %s: %v\n", job, err)
+			return
+		}
+		copied++
+		bytes += size
+		fmt.Printf("copied   %s\n", job)
+	})
+
+	if _, err := archiveCache.Enforce(); err != nil {
+		fmt.Fprintln(os.Stderr, "error: enforcing cache limits:", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d archives: %d copied (%d bytes), %d failed; %d hashes copied in %s\n",
+		len(diff.Archives), copied, bytes, failed, hashes, time.Since(start).Round(time.Second))
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// filterProviders keeps the items of the given providers (namespace/type); all items when none are given
+func filterProviders(items []inventory.Item, providers []string) []inventory.Item {
+	if len(providers) == 0 {
+		return items
+	}
+	wanted := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		wanted[p] = true
+	}
+
+	var result []inventory.Item
+	for _, it := range items {
+		if wanted[it.Namespace+"/"+it.Name] {
+			result = append(result, it)
+		}
+	}
+	return result
+}